@@ -0,0 +1,433 @@
+// Package v1 defines the JSON contract of version 1 of the API. Handlers only
+// ever encode and decode these types, never models or maps, so every field
+// name and type clients see is declared here.
+package v1
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jacob-tech-challenge/api/models"
+	"github.com/jacob-tech-challenge/api/services"
+)
+
+// Course is a course in responses, DeletedAt is only set on soft deleted
+// courses listed with include_deleted
+type Course struct {
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+// CourseRequest is the body of course create and update requests
+type CourseRequest struct {
+	Name string `json:"name"`
+}
+
+// Person is a person in responses, Courses is never null. DeletedAt is only
+// set on soft deleted people listed with include_deleted.
+type Person struct {
+	ID        int        `json:"id"`
+	FirstName string     `json:"firstName"`
+	LastName  string     `json:"lastName"`
+	Type      string     `json:"type"`
+	Age       int        `json:"age"`
+	Courses   []int      `json:"courses"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+// PersonRequest is the body of person create and update requests
+type PersonRequest struct {
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Type      string `json:"type"`
+	Age       int    `json:"age"`
+	Courses   []int  `json:"courses"`
+}
+
+// Page is one page of a list, Next is the cursor of the following page or null on the last one
+type Page[T any] struct {
+	Data T       `json:"data"`
+	Next *string `json:"next"`
+}
+
+// Roster is the people enrolled in a course grouped by type
+type Roster struct {
+	Professors []Person     `json:"professors"`
+	Students   []Person     `json:"students"`
+	Counts     RosterCounts `json:"counts"`
+}
+
+// RosterCounts counts a course's people over the whole course, not one page
+type RosterCounts struct {
+	Professors int `json:"professors"`
+	Students   int `json:"students"`
+	Total      int `json:"total"`
+}
+
+// PersonCourses is the list of courses a person is enrolled in
+type PersonCourses struct {
+	Courses []Course `json:"courses"`
+}
+
+// EnrollmentRequest is the body of enrollment changes, Courses is required
+type EnrollmentRequest struct {
+	Courses *[]int `json:"courses"`
+}
+
+// Validate checks the course list was sent at all, an empty list is up to the route
+func (e EnrollmentRequest) Validate() error {
+	if e.Courses == nil {
+		return &services.Error{Kind: services.ErrValidation, Detail: "courses is required"}
+	}
+	return nil
+}
+
+// EnrollmentResults is the outcome of an enrollment change, one result per course
+type EnrollmentResults struct {
+	Results []EnrollmentResult `json:"results"`
+}
+
+// EnrollmentResult is what an enrollment change did to one course
+type EnrollmentResult struct {
+	CourseID int    `json:"courseId"`
+	Status   string `json:"status"`
+}
+
+// Enrollment is one person enrolled in one course
+type Enrollment struct {
+	PersonID int `json:"personId"`
+	CourseID int `json:"courseId"`
+}
+
+// CourseDeleted is the body of a course delete, affected counts the enrollments
+// taken off the course and moved the ones reassign added to the other course
+type CourseDeleted struct {
+	Mode     string `json:"mode"`
+	Affected int    `json:"affected"`
+	Moved    int    `json:"moved"`
+}
+
+// PersonRevision is one version in a person's history, the person as they were
+// from validFrom until validTo, which is null for the current revision
+type PersonRevision struct {
+	Version   int        `json:"version"`
+	ValidFrom time.Time  `json:"validFrom"`
+	ValidTo   *time.Time `json:"validTo"`
+	Person    Person     `json:"person"`
+}
+
+// AuditEntry is one change in the audit log. Before and After are the entity
+// as it was on either side of the change, null where it did not exist.
+type AuditEntry struct {
+	ID        int             `json:"id"`
+	At        time.Time       `json:"at"`
+	Actor     string          `json:"actor"`
+	RequestID string          `json:"requestId"`
+	Entity    string          `json:"entity"`
+	EntityID  int             `json:"entityId"`
+	Action    string          `json:"action"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+}
+
+// BatchRequest is the body of a batch, its operations run in order and all or nothing
+type BatchRequest struct {
+	Operations []BatchOperation `json:"operations"`
+}
+
+// BatchOperation is one operation of a batch. Course and Person are the body
+// of a create or update, Courses the courses of a person, Mode and To the
+// parameters of a course delete. Ref names what a create makes, so later
+// operations can use it where they take an id.
+type BatchOperation struct {
+	Op      string         `json:"op"`
+	Type    string         `json:"type"`
+	Ref     string         `json:"ref"`
+	ID      BatchID        `json:"id"`
+	Version int            `json:"version"`
+	Course  *CourseRequest `json:"course"`
+	Person  *BatchPerson   `json:"person"`
+	Courses []BatchID      `json:"courses"`
+	Mode    string         `json:"mode"`
+	To      BatchID        `json:"to"`
+}
+
+// BatchPerson is a person to create or update in a batch, their courses are
+// the operation's courses
+type BatchPerson struct {
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Type      string `json:"type"`
+	Age       int    `json:"age"`
+}
+
+// BatchID is an id in a batch operation, a number or the ref of an earlier create
+type BatchID struct {
+	ID  int
+	Ref string
+}
+
+// UnmarshalJSON reads a number as an id and a string as a ref
+func (b *BatchID) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &b.Ref)
+	}
+	return json.Unmarshal(data, &b.ID)
+}
+
+// BatchResults is the outcome of a batch, one result per operation in order
+type BatchResults struct {
+	Results []BatchResult `json:"results"`
+}
+
+// BatchResult is what one operation did. ID is the row it acted on, version
+// its new version when the operation returns the row.
+type BatchResult struct {
+	Op          string             `json:"op"`
+	Type        string             `json:"type"`
+	ID          int                `json:"id"`
+	Version     int                `json:"version,omitempty"`
+	Course      *Course            `json:"course,omitempty"`
+	Person      *Person            `json:"person,omitempty"`
+	Deleted     *CourseDeleted     `json:"deleted,omitempty"`
+	Enrollments []EnrollmentResult `json:"enrollments,omitempty"`
+}
+
+// Problem is an RFC 7807 problem details body. Field is the path of the body
+// member a request failed on, Errors lists every invalid member when there are
+// several, Candidates is only set when a name lookup matches more than one person,
+// Enrollments when a course cannot be deleted for the people enrolled in it.
+// Operation is the index of the operation that failed a batch.
+type Problem struct {
+	Type        string       `json:"type"`
+	Title       string       `json:"title"`
+	Status      int          `json:"status"`
+	Detail      string       `json:"detail,omitempty"`
+	Instance    string       `json:"instance,omitempty"`
+	Field       string       `json:"field,omitempty"`
+	Errors      []FieldError `json:"errors,omitempty"`
+	Candidates  []Person     `json:"candidates,omitempty"`
+	Enrollments []Enrollment `json:"enrollments,omitempty"`
+	Operation   *int         `json:"operation,omitempty"`
+}
+
+// FieldError is one invalid member of a request body
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// FromCourse converts a course to its response form
+func FromCourse(course models.Course) Course {
+	return Course{ID: course.ID, Name: course.Name, DeletedAt: course.DeletedAt}
+}
+
+// CourseRequestFrom returns the request that would write course as it is, the
+// document a PATCH of the course applies to
+func CourseRequestFrom(course models.Course) CourseRequest {
+	return CourseRequest{Name: course.Name}
+}
+
+// FromCourses converts a list of courses, an empty list stays an empty array
+func FromCourses(courses []models.Course) []Course {
+	out := make([]Course, len(courses))
+	for i, course := range courses {
+		out[i] = FromCourse(course)
+	}
+	return out
+}
+
+// Model returns the course the request describes
+func (c CourseRequest) Model() models.Course {
+	return models.Course{Name: c.Name}
+}
+
+// PersonRequestFrom returns the request that would write person as it is, the
+// document a PATCH of the person applies to
+func PersonRequestFrom(person models.Person) PersonRequest {
+	courses := person.Courses
+	if courses == nil {
+		courses = []int{}
+	}
+	return PersonRequest{
+		FirstName: person.FirstName,
+		LastName:  person.LastName,
+		Type:      person.Type,
+		Age:       person.Age,
+		Courses:   courses,
+	}
+}
+
+// FromPerson converts a person to its response form
+func FromPerson(person models.Person) Person {
+	courses := person.Courses
+	if courses == nil {
+		courses = []int{}
+	}
+	return Person{
+		ID:        person.ID,
+		FirstName: person.FirstName,
+		LastName:  person.LastName,
+		Type:      person.Type,
+		Age:       person.Age,
+		Courses:   courses,
+		DeletedAt: person.DeletedAt,
+	}
+}
+
+// FromPeople converts a list of people, an empty list stays an empty array
+func FromPeople(people []models.Person) []Person {
+	out := make([]Person, len(people))
+	for i, person := range people {
+		out[i] = FromPerson(person)
+	}
+	return out
+}
+
+// Model returns the person the request describes
+func (p PersonRequest) Model() models.Person {
+	return models.Person{
+		FirstName: p.FirstName,
+		LastName:  p.LastName,
+		Type:      p.Type,
+		Age:       p.Age,
+		Courses:   p.Courses,
+	}
+}
+
+// FromRoster splits a page of a course roster into professors and students
+func FromRoster(roster services.Roster) Roster {
+	out := Roster{
+		Professors: []Person{},
+		Students:   []Person{},
+		Counts: RosterCounts{
+			Professors: roster.Professors,
+			Students:   roster.Students,
+			Total:      roster.Professors + roster.Students,
+		},
+	}
+	for _, person := range roster.People {
+		if person.Type == "professor" {
+			out.Professors = append(out.Professors, FromPerson(person))
+		} else {
+			out.Students = append(out.Students, FromPerson(person))
+		}
+	}
+	return out
+}
+
+// FromEnrollmentResults converts the outcome of an enrollment change
+func FromEnrollmentResults(results []services.EnrollmentResult) EnrollmentResults {
+	out := EnrollmentResults{Results: make([]EnrollmentResult, len(results))}
+	for i, result := range results {
+		out.Results[i] = EnrollmentResult{CourseID: result.CourseID, Status: string(result.Status)}
+	}
+	return out
+}
+
+// FromAuditEntries converts a list of audit entries, an empty list stays an empty array
+func FromAuditEntries(entries []services.AuditEntry) []AuditEntry {
+	out := make([]AuditEntry, len(entries))
+	for i, entry := range entries {
+		out[i] = AuditEntry{
+			ID:        entry.ID,
+			At:        entry.At,
+			Actor:     entry.Actor,
+			RequestID: entry.RequestID,
+			Entity:    entry.Entity,
+			EntityID:  entry.EntityID,
+			Action:    entry.Action,
+			Before:    entry.Before,
+			After:     entry.After,
+		}
+	}
+	return out
+}
+
+// FromPersonRevisions converts a person's history, an empty list stays an empty array
+func FromPersonRevisions(revisions []services.PersonRevision) []PersonRevision {
+	out := make([]PersonRevision, len(revisions))
+	for i, revision := range revisions {
+		out[i] = PersonRevision{
+			Version:   revision.Person.Version,
+			ValidFrom: revision.ValidFrom,
+			ValidTo:   revision.ValidTo,
+			Person:    FromPerson(revision.Person),
+		}
+	}
+	return out
+}
+
+// FromEnrolled lists the enrollments that kept a course from being deleted
+func FromEnrolled(err *services.EnrolledError) []Enrollment {
+	out := make([]Enrollment, len(err.PersonIDs))
+	for i, personID := range err.PersonIDs {
+		out[i] = Enrollment{PersonID: personID, CourseID: err.CourseID}
+	}
+	return out
+}
+
+// FromCourseDeleted converts what a course delete did, a delete without a mode restricts
+func FromCourseDeleted(deletion services.CourseDeletion, deleted services.CourseDeleted) CourseDeleted {
+	mode := deletion.Mode
+	if mode == "" {
+		mode = services.DeleteRestrict
+	}
+	return CourseDeleted{Mode: string(mode), Affected: deleted.Affected, Moved: deleted.Moved}
+}
+
+// Model returns the operation for the store
+func (o BatchOperation) Model() services.BatchOp {
+	op := services.BatchOp{
+		Action:  services.BatchAction(o.Op),
+		Entity:  o.Type,
+		Ref:     o.Ref,
+		ID:      services.BatchID(o.ID),
+		Version: o.Version,
+		Mode:    services.DeleteMode(o.Mode),
+		To:      services.BatchID(o.To),
+	}
+	if o.Course != nil {
+		course := o.Course.Model()
+		op.Course = &course
+	}
+	if o.Person != nil {
+		person := o.Person.Model()
+		op.Person = &person
+	}
+	for _, course := range o.Courses {
+		op.Courses = append(op.Courses, services.BatchID(course))
+	}
+	return op
+}
+
+// Model returns the person the request describes, without courses
+func (p BatchPerson) Model() models.Person {
+	return models.Person{FirstName: p.FirstName, LastName: p.LastName, Type: p.Type, Age: p.Age}
+}
+
+// FromBatchResults converts the results of the operations of a batch
+func FromBatchResults(ops []BatchOperation, results []services.BatchResult) BatchResults {
+	out := BatchResults{Results: make([]BatchResult, len(results))}
+	for i, result := range results {
+		converted := BatchResult{Op: ops[i].Op, Type: ops[i].Type, ID: result.ID}
+		if result.Course != nil {
+			course := FromCourse(*result.Course)
+			converted.Course, converted.Version = &course, result.Course.Version
+		}
+		if result.Person != nil {
+			person := FromPerson(*result.Person)
+			converted.Person, converted.Version = &person, result.Person.Version
+		}
+		if result.Deleted != nil {
+			deleted := FromCourseDeleted(services.CourseDeletion{Mode: services.DeleteMode(ops[i].Mode)}, *result.Deleted)
+			converted.Deleted = &deleted
+		}
+		if result.Enrollments != nil {
+			converted.Enrollments = FromEnrollmentResults(result.Enrollments).Results
+		}
+		out.Results[i] = converted
+	}
+	return out
+}
@@ -0,0 +1,149 @@
+package v1
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jacob-tech-challenge/api/models"
+	"github.com/jacob-tech-challenge/api/services"
+)
+
+func TestFromPerson(t *testing.T) {
+	tests := map[string]struct {
+		person   models.Person
+		expected string
+	}{
+		"with courses": {
+			person:   models.Person{ID: 1, FirstName: "John", LastName: "Doe", Type: "student", Age: 25, Courses: []int{1, 2}},
+			expected: `{"id":1,"firstName":"John","lastName":"Doe","type":"student","age":25,"courses":[1,2]}`,
+		},
+		"without courses": {
+			person:   models.Person{ID: 2, FirstName: "Ada", LastName: "Lovelace", Type: "professor", Age: 36},
+			expected: `{"id":2,"firstName":"Ada","lastName":"Lovelace","type":"professor","age":36,"courses":[]}`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := json.Marshal(FromPerson(tc.person))
+			assert.NoError(t, err)
+			assert.JSONEq(t, tc.expected, string(got))
+		})
+	}
+}
+
+func TestFromCourses(t *testing.T) {
+	got, err := json.Marshal(FromCourses(nil))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[]`, string(got))
+
+	got, err = json.Marshal(FromCourses([]models.Course{{ID: 1, Name: "Math"}}))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"id":1,"name":"Math"}]`, string(got))
+}
+
+func TestRequestModels(t *testing.T) {
+	var person PersonRequest
+	assert.NoError(t, json.Unmarshal([]byte(`{"firstName":"John","lastName":"Doe","type":"student","age":25,"courses":[1]}`), &person))
+	assert.Equal(t, models.Person{FirstName: "John", LastName: "Doe", Type: "student", Age: 25, Courses: []int{1}}, person.Model())
+
+	var course CourseRequest
+	assert.NoError(t, json.Unmarshal([]byte(`{"name":"Math"}`), &course))
+	assert.Equal(t, models.Course{Name: "Math"}, course.Model())
+}
+
+func TestFromRoster(t *testing.T) {
+	roster := services.Roster{
+		People: []models.Person{
+			{ID: 2, FirstName: "Ada", LastName: "Lovelace", Type: "professor", Age: 36},
+			{ID: 1, FirstName: "John", LastName: "Doe", Type: "student", Age: 25, Courses: []int{1}},
+		},
+		Professors: 1,
+		Students:   3,
+	}
+
+	got := FromRoster(roster)
+	assert.Equal(t, []Person{FromPerson(roster.People[0])}, got.Professors)
+	assert.Equal(t, []Person{FromPerson(roster.People[1])}, got.Students)
+	assert.Equal(t, RosterCounts{Professors: 1, Students: 3, Total: 4}, got.Counts)
+}
+
+func TestFromEnrollmentResults(t *testing.T) {
+	got, err := json.Marshal(FromEnrollmentResults([]services.EnrollmentResult{
+		{CourseID: 1, Status: services.EnrollmentAdded},
+		{CourseID: 9, Status: services.EnrollmentCourseNotFound},
+	}))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"results":[{"courseId":1,"status":"added"},{"courseId":9,"status":"course_not_found"}]}`, string(got))
+}
+
+func TestFromAuditEntries(t *testing.T) {
+	got, err := json.Marshal(FromAuditEntries([]services.AuditEntry{
+		{ID: 1, At: time.Date(2024, 9, 1, 8, 30, 0, 0, time.UTC), Actor: "alice", RequestID: "req-1", Entity: "course", EntityID: 4, Action: "create", After: json.RawMessage(`{"id":4}`)},
+	}))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"id":1,"at":"2024-09-01T08:30:00Z","actor":"alice","requestId":"req-1","entity":"course","entityId":4,"action":"create","before":null,"after":{"id":4}}]`, string(got))
+
+	got, err = json.Marshal(FromAuditEntries(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, `[]`, string(got))
+}
+
+func TestFromPersonRevisions(t *testing.T) {
+	from := time.Date(2024, 9, 1, 8, 30, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+	got, err := json.Marshal(FromPersonRevisions([]services.PersonRevision{
+		{Person: models.Person{ID: 3, FirstName: "Ada", LastName: "Lovelace", Type: "professor", Age: 36, Version: 1}, ValidFrom: from, ValidTo: &to},
+		{Person: models.Person{ID: 3, FirstName: "Ada", LastName: "Lovelace", Type: "professor", Age: 36, Courses: []int{1}, Version: 2}, ValidFrom: to},
+	}))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[
+		{"version":1,"validFrom":"2024-09-01T08:30:00Z","validTo":"2024-09-01T09:30:00Z","person":{"id":3,"firstName":"Ada","lastName":"Lovelace","type":"professor","age":36,"courses":[]}},
+		{"version":2,"validFrom":"2024-09-01T09:30:00Z","validTo":null,"person":{"id":3,"firstName":"Ada","lastName":"Lovelace","type":"professor","age":36,"courses":[1]}}
+	]`, string(got))
+}
+
+func TestFromCourseDeleted(t *testing.T) {
+	got, err := json.Marshal(FromCourseDeleted(services.CourseDeletion{}, services.CourseDeleted{}))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"mode":"restrict","affected":0,"moved":0}`, string(got))
+
+	got, err = json.Marshal(FromCourseDeleted(services.CourseDeletion{Mode: services.DeleteReassign, To: 2}, services.CourseDeleted{Affected: 3, Moved: 2}))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"mode":"reassign","affected":3,"moved":2}`, string(got))
+
+	assert.Equal(t, []Enrollment{{PersonID: 5, CourseID: 1}, {PersonID: 6, CourseID: 1}},
+		FromEnrolled(&services.EnrolledError{CourseID: 1, PersonIDs: []int{5, 6}}))
+}
+
+func TestBatchOperationModel(t *testing.T) {
+	var operation BatchOperation
+	assert.NoError(t, json.Unmarshal([]byte(`{"op": "enroll", "type": "person", "id": "ada", "courses": [1, "art"]}`), &operation))
+	assert.Equal(t, services.BatchOp{
+		Action:  services.BatchEnroll,
+		Entity:  "person",
+		ID:      services.BatchID{Ref: "ada"},
+		Courses: []services.BatchID{{ID: 1}, {Ref: "art"}},
+	}, operation.Model())
+
+	assert.Error(t, json.Unmarshal([]byte(`{"id": true}`), &operation))
+	assert.Error(t, json.Unmarshal([]byte(`{"id": 1.5}`), &operation))
+}
+
+func TestFromBatchResults(t *testing.T) {
+	ops := []BatchOperation{{Op: "create", Type: "course"}, {Op: "delete", Type: "course", Mode: "cascade"}, {Op: "delete", Type: "person"}}
+	got, err := json.Marshal(FromBatchResults(ops, []services.BatchResult{
+		{ID: 4, Course: &models.Course{ID: 4, Name: "Art", Version: 1}},
+		{ID: 2, Deleted: &services.CourseDeleted{Affected: 3}},
+		{ID: 7},
+	}))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"results": [
+		{"op": "create", "type": "course", "id": 4, "version": 1, "course": {"id": 4, "name": "Art"}},
+		{"op": "delete", "type": "course", "id": 2, "deleted": {"mode": "cascade", "affected": 3, "moved": 0}},
+		{"op": "delete", "type": "person", "id": 7}
+	]}`, string(got))
+}
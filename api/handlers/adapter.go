@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+)
+
+// empty is the In of handlers that take no body
+type empty struct{}
+
+// validator is implemented by request bodies that can check themselves before
+// the handler runs
+type validator interface {
+	Validate() error
+}
+
+// headerSetter is implemented by responses that carry headers besides the
+// body, like the Link header of a page
+type headerSetter interface {
+	setHeaders(h http.Header)
+}
+
+// JSON adapts fn to an http.HandlerFunc. The body is decoded into In, unless In
+// is empty, and validated when In has a Validate method. fn reads path and
+// query parameters from the request. Its result is written with status, or
+// without a body when status is 204, and any error goes through writeError.
+// Successful GETs are written by writeCacheable and may be answered 304.
+func JSON[In, Out any](status int, fn func(r *http.Request, in In) (Out, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var in In
+		if _, noBody := any(in).(empty); !noBody {
+			if err := decodeBody(r, &in); err != nil {
+				writeError(w, r, err)
+				return
+			}
+		}
+		if v, ok := any(in).(validator); ok {
+			if err := v.Validate(); err != nil {
+				writeError(w, r, err)
+				return
+			}
+		}
+
+		out, err := fn(r, in)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		if h, ok := any(out).(headerSetter); ok {
+			h.setHeaders(w.Header())
+		}
+		if status == http.StatusNoContent {
+			w.WriteHeader(status)
+			return
+		}
+		if r.Method == http.MethodGet && status == http.StatusOK {
+			writeCacheable(w, r, status, out)
+			return
+		}
+		writeJSON(w, status, out)
+	}
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jacob-tech-challenge/api/services"
+)
+
+type echoRequest struct {
+	Name string `json:"name"`
+}
+
+func (e echoRequest) Validate() error {
+	if e.Name == "" {
+		return &services.Error{Kind: services.ErrValidation, Detail: "name is required"}
+	}
+	return nil
+}
+
+type echoResponse struct {
+	Greeting string `json:"greeting"`
+}
+
+func TestJSON(t *testing.T) {
+	echo := func(r *http.Request, in echoRequest) (echoResponse, error) {
+		if in.Name == "nobody" {
+			return echoResponse{}, &services.Error{Kind: services.ErrNotFound, Detail: "nobody does not exist"}
+		}
+		return echoResponse{Greeting: "hello " + in.Name}, nil
+	}
+
+	tests := map[string]struct {
+		handler     http.HandlerFunc
+		body        string
+		wantStatus  int
+		wantType    string
+		wantBody    string
+		wantContain string
+	}{
+		"created": {
+			handler:    JSON(http.StatusCreated, echo),
+			body:       `{"name":"ada"}`,
+			wantStatus: http.StatusCreated,
+			wantType:   "application/json",
+			wantBody:   `{"greeting":"hello ada"}`,
+		},
+		"malformed body": {
+			handler:     JSON(http.StatusOK, echo),
+			body:        `{"name":`,
+			wantStatus:  http.StatusBadRequest,
+			wantType:    problemContentType,
+			wantContain: "not valid JSON",
+		},
+		"invalid body": {
+			handler:     JSON(http.StatusOK, echo),
+			body:        `{}`,
+			wantStatus:  http.StatusBadRequest,
+			wantType:    problemContentType,
+			wantContain: "name is required",
+		},
+		"handler error": {
+			handler:     JSON(http.StatusOK, echo),
+			body:        `{"name":"nobody"}`,
+			wantStatus:  http.StatusNotFound,
+			wantType:    problemContentType,
+			wantContain: "nobody does not exist",
+		},
+		"no content": {
+			handler: JSON(http.StatusNoContent, func(r *http.Request, _ empty) (empty, error) {
+				return empty{}, nil
+			}),
+			wantStatus: http.StatusNoContent,
+		},
+		"no content failing": {
+			handler: JSON(http.StatusNoContent, func(r *http.Request, _ empty) (empty, error) {
+				return empty{}, errors.New("boom")
+			}),
+			wantStatus: http.StatusInternalServerError,
+			wantType:   problemContentType,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := jsonRequest(http.MethodPost, "/echo", tc.body)
+			rr := httptest.NewRecorder()
+			tc.handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.wantStatus, rr.Code)
+			assert.Equal(t, tc.wantType, rr.Header().Get("Content-Type"))
+			switch {
+			case tc.wantBody != "":
+				assert.JSONEq(t, tc.wantBody, rr.Body.String())
+			case tc.wantContain != "":
+				assert.Contains(t, rr.Body.String(), tc.wantContain)
+			case tc.wantStatus == http.StatusNoContent:
+				assert.Empty(t, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestJSONPageLink(t *testing.T) {
+	handler := JSON(http.StatusOK, func(r *http.Request, _ empty) (page[[]int], error) {
+		return newPage(r, []int{1, 2}, 2, &services.Cursor{ID: 2}), nil
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/numbers?limit=2", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	next := (&services.Cursor{ID: 2}).Encode()
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Header().Get("Link"), `rel="next"`)
+	assert.JSONEq(t, `{"data":[1,2],"next":"`+next+`"}`, rr.Body.String())
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/http"
+
+	v1 "github.com/jacob-tech-challenge/api/dto/v1"
+	"github.com/jacob-tech-challenge/api/services"
+)
+
+// HandleGetAuditLog returns a page of the audit log, oldest first, filtered by
+// the entity, id and since query parameters
+func HandleGetAuditLog(audit services.AuditStore, limits PageLimits) http.HandlerFunc {
+	return JSON(http.StatusOK, func(r *http.Request, _ empty) (page[[]v1.AuditEntry], error) {
+		filter, err := parseAuditFilter(r)
+		if err != nil {
+			return page[[]v1.AuditEntry]{}, err
+		}
+		p, err := parsePage(r, limits)
+		if err != nil {
+			return page[[]v1.AuditEntry]{}, err
+		}
+		entries, next, err := audit.GetAuditLog(r.Context(), filter, p)
+		if err != nil {
+			return page[[]v1.AuditEntry]{}, err
+		}
+		// entries are never changed or removed, so the latest one on the page is its Last-Modified
+		list := newPage(r, v1.FromAuditEntries(entries), p.Limit, next)
+		for _, entry := range entries {
+			list.lastModified(entry.At)
+		}
+		return list, nil
+	})
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	v1 "github.com/jacob-tech-challenge/api/dto/v1"
+	"github.com/jacob-tech-challenge/api/services"
+	"github.com/jacob-tech-challenge/api/validation"
+)
+
+// HandleBatch runs the operations of the body in order in one transaction and
+// answers with the result of each. Every course and person written is checked
+// like the single endpoints check them before anything runs. The first
+// operation to fail fails the whole batch, its index is in the problem.
+func HandleBatch(batches services.Batcher) http.HandlerFunc {
+	return JSON(http.StatusOK, func(r *http.Request, body v1.BatchRequest) (v1.BatchResults, error) {
+		ops := make([]services.BatchOp, len(body.Operations))
+		for i, operation := range body.Operations {
+			ops[i] = operation.Model()
+			if err := validateBatchOp(r.Context(), ops[i]); err != nil {
+				return v1.BatchResults{}, &services.BatchError{Index: i, Err: err}
+			}
+		}
+		results, err := batches.RunBatch(r.Context(), ops)
+		if err != nil {
+			return v1.BatchResults{}, err
+		}
+		return v1.FromBatchResults(body.Operations, results), nil
+	})
+}
+
+// validateBatchOp checks the course or person an operation writes. A person's
+// courses are left to the store, refs only stand for ids once the batch runs.
+func validateBatchOp(ctx context.Context, op services.BatchOp) error {
+	switch {
+	case op.Course != nil:
+		return validation.Course(*op.Course)
+	case op.Person != nil:
+		return validation.Person(ctx, nil, *op.Person)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jacob-tech-challenge/api/models"
+	"github.com/jacob-tech-challenge/api/services"
+)
+
+func TestHandleBatch(t *testing.T) {
+	ctx := context.Background()
+	store := services.NewMemoryStore()
+	_, err := store.CreateCourse(ctx, models.Course{Name: "Math"})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		body          string
+		wantStatus    int
+		wantBody      string
+		wantOperation interface{} // the index of the failed operation, nil when none failed
+	}{
+		{
+			name: "runs every operation",
+			body: `{"operations": [
+				{"op": "create", "type": "course", "ref": "art", "course": {"name": "Art"}},
+				{"op": "create", "type": "person", "ref": "ada", "person": {"firstName": "Ada", "lastName": "Lovelace", "type": "professor", "age": 36}, "courses": [1]},
+				{"op": "enroll", "type": "person", "id": "ada", "courses": ["art"]},
+				{"op": "update", "type": "course", "id": 1, "version": 1, "course": {"name": "Algebra"}}
+			]}`,
+			wantStatus: http.StatusOK,
+			wantBody: `{"results": [
+				{"op": "create", "type": "course", "id": 2, "version": 1, "course": {"id": 2, "name": "Art"}},
+				{"op": "create", "type": "person", "id": 1, "version": 1, "person": {"id": 1, "firstName": "Ada", "lastName": "Lovelace", "type": "professor", "age": 36, "courses": [1]}},
+				{"op": "enroll", "type": "person", "id": 1, "enrollments": [{"courseId": 2, "status": "added"}]},
+				{"op": "update", "type": "course", "id": 1, "version": 2, "course": {"id": 1, "name": "Algebra"}}
+			]}`,
+		},
+		{
+			name: "invalid person",
+			body: `{"operations": [
+				{"op": "create", "type": "course", "course": {"name": "Geology"}},
+				{"op": "create", "type": "person", "person": {"firstName": "", "lastName": "Lovelace", "type": "professor", "age": 36}}
+			]}`,
+			wantStatus:    http.StatusBadRequest,
+			wantOperation: float64(1),
+		},
+		{
+			name: "unknown ref",
+			body: `{"operations": [
+				{"op": "delete", "type": "person", "id": "ada"}
+			]}`,
+			wantStatus:    http.StatusBadRequest,
+			wantOperation: float64(0),
+		},
+		{
+			name: "stale version",
+			body: `{"operations": [
+				{"op": "create", "type": "course", "course": {"name": "Geology"}},
+				{"op": "delete", "type": "course", "id": 1, "version": 1}
+			]}`,
+			wantStatus:    http.StatusPreconditionFailed,
+			wantOperation: float64(1),
+		},
+		{
+			name:       "no operations",
+			body:       `{"operations": []}`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			HandleBatch(store).ServeHTTP(w, jsonRequest(http.MethodPost, "/api/batch", tt.body))
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantBody != "" {
+				assert.JSONEq(t, tt.wantBody, w.Body.String())
+				return
+			}
+			var problem map[string]interface{}
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&problem))
+			assert.Equal(t, tt.wantOperation, problem["operation"])
+		})
+	}
+
+	// the failed batches changed nothing
+	courses, _, err := store.GetAllCourses(ctx, services.CourseFilter{IncludeDeleted: true}, services.Page{})
+	assert.NoError(t, err)
+	assert.Len(t, courses, 2)
+}
@@ -0,0 +1,15 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/jacob-tech-challenge/api/services"
+)
+
+// HandleCacheStats returns the counters of the server-side cache. It is only
+// meant for the internal listener, see AdminRoutes.
+func HandleCacheStats(cache *services.CachedStore) http.HandlerFunc {
+	return JSON(http.StatusOK, func(r *http.Request, _ empty) (services.CacheStats, error) {
+		return cache.Stats(), nil
+	})
+}
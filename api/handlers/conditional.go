@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// writeCacheable writes the body of a successful GET. A body without an ETag
+// of its own, like a page of a list, is tagged with a hash of its bytes. When
+// If-None-Match or If-Modified-Since show the client already holds this
+// representation, it is answered 304 without a body.
+func writeCacheable(w http.ResponseWriter, r *http.Request, status int, body any) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		log.Printf("Error encoding response: %v", err)
+		writeError(w, r, err)
+		return
+	}
+	b = append(b, '\n')
+
+	h := w.Header()
+	if h.Get("ETag") == "" {
+		h.Set("ETag", contentETag(b))
+	}
+	if notModified(r, h) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	h.Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(b); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// contentETag returns a strong entity tag derived from the bytes of a body
+func contentETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + base64.RawURLEncoding.EncodeToString(sum[:12]) + `"`
+}
+
+// notModified evaluates the If-None-Match and If-Modified-Since headers of r
+// against the ETag and Last-Modified of the response. If-None-Match compares
+// weakly and, when sent, is the only one consulted.
+func notModified(r *http.Request, h http.Header) bool {
+	if values := r.Header.Values("If-None-Match"); len(values) > 0 {
+		current := strings.TrimPrefix(h.Get("ETag"), "W/")
+		for _, tag := range entityTags(values) {
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == current {
+				return true
+			}
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(h.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	return !modified.After(since)
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotModified(t *testing.T) {
+	const modified = "Sun, 01 Sep 2024 08:30:15 GMT"
+
+	tests := map[string]struct {
+		ifNoneMatch     string
+		ifModifiedSince string
+		want            bool
+	}{
+		"no conditions":          {want: false},
+		"same tag":               {ifNoneMatch: `"3"`, want: true},
+		"weak same tag":          {ifNoneMatch: `W/"3"`, want: true},
+		"one of several":         {ifNoneMatch: `"1", "3"`, want: true},
+		"other tag":              {ifNoneMatch: `"2"`, want: false},
+		"any tag":                {ifNoneMatch: "*", want: true},
+		"modified since":         {ifModifiedSince: "Sun, 01 Sep 2024 08:30:14 GMT", want: false},
+		"not modified since":     {ifModifiedSince: modified, want: true},
+		"later date":             {ifModifiedSince: "Mon, 02 Sep 2024 00:00:00 GMT", want: true},
+		"unparsable date":        {ifModifiedSince: "yesterday", want: false},
+		"tag wins over the date": {ifNoneMatch: `"2"`, ifModifiedSince: modified, want: false},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tc.ifNoneMatch)
+			}
+			if tc.ifModifiedSince != "" {
+				req.Header.Set("If-Modified-Since", tc.ifModifiedSince)
+			}
+			h := http.Header{"Etag": {`"3"`}, "Last-Modified": {modified}}
+
+			assert.Equal(t, tc.want, notModified(req, h))
+		})
+	}
+}
+
+func TestJSONConditionalGet(t *testing.T) {
+	updatedAt := time.Date(2024, 9, 1, 8, 30, 0, 0, time.UTC)
+	handler := JSON(http.StatusOK, func(r *http.Request, _ empty) (page[[]int], error) {
+		list := newPage(r, []int{1, 2}, 2, nil)
+		list.lastModified(updatedAt)
+		list.lastModified(updatedAt.Add(-time.Hour))
+		return list, nil
+	})
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/numbers", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "Sun, 01 Sep 2024 08:30:00 GMT", rr.Header().Get("Last-Modified"))
+	tag := rr.Header().Get("ETag")
+	assert.Regexp(t, `^"[\w-]+"$`, tag)
+
+	tests := map[string]struct {
+		header     string
+		value      string
+		wantStatus int
+	}{
+		"same list":          {header: "If-None-Match", value: tag, wantStatus: http.StatusNotModified},
+		"changed list":       {header: "If-None-Match", value: `"stale"`, wantStatus: http.StatusOK},
+		"not modified since": {header: "If-Modified-Since", value: "Sun, 01 Sep 2024 09:00:00 GMT", wantStatus: http.StatusNotModified},
+		"modified since":     {header: "If-Modified-Since", value: "Sun, 01 Sep 2024 08:00:00 GMT", wantStatus: http.StatusOK},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/numbers", nil)
+			req.Header.Set(tc.header, tc.value)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.wantStatus, rr.Code)
+			assert.Equal(t, tag, rr.Header().Get("ETag"))
+			if tc.wantStatus == http.StatusNotModified {
+				assert.Empty(t, rr.Body.String())
+			} else {
+				assert.JSONEq(t, `{"data":[1,2],"next":null}`, rr.Body.String())
+			}
+		})
+	}
+}
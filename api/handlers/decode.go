@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// decodeBody strictly decodes the JSON request body into v. The body must be
+// sent as JSON, hold exactly one value and only members v knows about. Its
+// size is capped by the maxBodyBytes middleware. Failures are problemErrors
+// with the status to answer and, where it is known, the path of the member.
+func decodeBody(r *http.Request, v interface{}) error {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !isJSON(mediaType) {
+		return &problemError{status: http.StatusUnsupportedMediaType, detail: "request body must be sent as application/json"}
+	}
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return bodyError(err)
+	}
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return bodyError(err)
+		}
+		return &problemError{status: http.StatusBadRequest, detail: "request body must hold a single JSON value"}
+	}
+	return nil
+}
+
+// isJSON reports whether mediaType is application/json or a JSON based type like application/merge-patch+json
+func isJSON(mediaType string) bool {
+	return mediaType == "application/json" || (strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json"))
+}
+
+// bodyError turns an error from decoding the request body into a problemError
+func bodyError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return &problemError{
+			status: http.StatusRequestEntityTooLarge,
+			detail: fmt.Sprintf("request body must not be larger than %d bytes", maxBytesErr.Limit),
+		}
+	case errors.Is(err, io.EOF):
+		return &problemError{status: http.StatusBadRequest, detail: "request body is required"}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return &problemError{status: http.StatusBadRequest, detail: "request body is not valid JSON: unexpected end of input"}
+	case errors.As(err, &syntaxErr):
+		return &problemError{
+			status: http.StatusBadRequest,
+			detail: fmt.Sprintf("request body is not valid JSON at offset %d", syntaxErr.Offset),
+		}
+	case errors.As(err, &typeErr):
+		return &problemError{
+			status: http.StatusBadRequest,
+			detail: fmt.Sprintf("%s must be %s, not %s", typeErr.Field, jsonType(typeErr.Type.Kind().String()), typeErr.Value),
+			field:  typeErr.Field,
+		}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// the decoder has no error type for unknown fields, only this message
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return &problemError{status: http.StatusBadRequest, detail: fmt.Sprintf("unknown field %s", field), field: field}
+	}
+	return &problemError{status: http.StatusBadRequest, detail: "request body is not valid"}
+}
+
+// jsonType names a Go kind the way a client sending JSON thinks of it
+func jsonType(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"), strings.HasPrefix(kind, "float"):
+		return "a number"
+	case kind == "slice", kind == "array":
+		return "an array"
+	case kind == "struct", kind == "map":
+		return "an object"
+	case kind == "bool":
+		return "a boolean"
+	}
+	return "a " + kind
+}
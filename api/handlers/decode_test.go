@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	v1 "github.com/jacob-tech-challenge/api/dto/v1"
+)
+
+func TestDecodeBody(t *testing.T) {
+	tests := map[string]struct {
+		contentType string
+		body        string
+		maxBytes    int64
+		wantStatus  int
+		wantField   string
+		wantDetail  string
+	}{
+		"valid": {
+			contentType: "application/json; charset=utf-8",
+			body:        `{"firstName":"John","courses":[1]}`,
+			wantStatus:  http.StatusOK,
+		},
+		"json based media type": {
+			contentType: "application/merge-patch+json",
+			body:        `{"firstName":"John"}`,
+			wantStatus:  http.StatusOK,
+		},
+		"missing content type": {
+			body:       `{"firstName":"John"}`,
+			wantStatus: http.StatusUnsupportedMediaType,
+		},
+		"wrong content type": {
+			contentType: "text/plain",
+			body:        `{"firstName":"John"}`,
+			wantStatus:  http.StatusUnsupportedMediaType,
+		},
+		"unknown field": {
+			contentType: "application/json",
+			body:        `{"firstName":"John","first_name":"John"}`,
+			wantStatus:  http.StatusBadRequest,
+			wantField:   "first_name",
+			wantDetail:  "unknown field first_name",
+		},
+		"wrong type": {
+			contentType: "application/json",
+			body:        `{"courses":["math"]}`,
+			wantStatus:  http.StatusBadRequest,
+			wantField:   "courses.0",
+			wantDetail:  "courses.0 must be a number, not string",
+		},
+		"trailing data": {
+			contentType: "application/json",
+			body:        `{"firstName":"John"} {"firstName":"Jane"}`,
+			wantStatus:  http.StatusBadRequest,
+			wantDetail:  "request body must hold a single JSON value",
+		},
+		"syntax error": {
+			contentType: "application/json",
+			body:        `{"firstName" "John"}`,
+			wantStatus:  http.StatusBadRequest,
+			wantDetail:  "request body is not valid JSON at offset 14",
+		},
+		"empty": {
+			contentType: "application/json",
+			wantStatus:  http.StatusBadRequest,
+			wantDetail:  "request body is required",
+		},
+		"too large": {
+			contentType: "application/json",
+			body:        `{"firstName":"` + strings.Repeat("a", 64) + `"}`,
+			maxBytes:    32,
+			wantStatus:  http.StatusRequestEntityTooLarge,
+			wantDetail:  "request body must not be larger than 32 bytes",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			handler := JSON(http.StatusOK, func(r *http.Request, in v1.PersonRequest) (v1.PersonRequest, error) {
+				return in, nil
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/person", strings.NewReader(tc.body))
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			rr := httptest.NewRecorder()
+			if tc.maxBytes > 0 {
+				req.Body = http.MaxBytesReader(rr, req.Body, tc.maxBytes)
+			}
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.wantStatus, rr.Code)
+			if tc.wantStatus == http.StatusOK {
+				return
+			}
+			var problem v1.Problem
+			assert.NoError(t, json.NewDecoder(rr.Body).Decode(&problem))
+			assert.Equal(t, tc.wantField, problem.Field)
+			if tc.wantDetail != "" {
+				assert.Equal(t, tc.wantDetail, problem.Detail)
+			}
+		})
+	}
+}
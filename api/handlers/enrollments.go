@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	v1 "github.com/jacob-tech-challenge/api/dto/v1"
+	"github.com/jacob-tech-challenge/api/services"
+)
+
+// enrollmentChange is one of the EnrollmentStore methods that change enrollments
+type enrollmentChange func(ctx context.Context, personID, version int, courseIDs []int) ([]services.EnrollmentResult, error)
+
+// HandleGetPersonCourses lists the courses a person is enrolled in
+func HandleGetPersonCourses(enrollments services.EnrollmentStore) http.HandlerFunc {
+	return JSON(http.StatusOK, func(r *http.Request, _ empty) (v1.PersonCourses, error) {
+		id, err := pathID(r)
+		if err != nil {
+			return v1.PersonCourses{}, err
+		}
+		courses, err := enrollments.GetPersonCourses(r.Context(), id)
+		if err != nil {
+			return v1.PersonCourses{}, err
+		}
+		return v1.PersonCourses{Courses: v1.FromCourses(courses)}, nil
+	})
+}
+
+// HandleAddPersonCourses enrolls a person in the courses listed in the body
+func HandleAddPersonCourses(enrollments services.EnrollmentStore) http.HandlerFunc {
+	return handleEnrollmentChange(enrollments.AddPersonToCourse, false)
+}
+
+// HandleRemovePersonCourses unenrolls a person from the courses listed in the body
+func HandleRemovePersonCourses(enrollments services.EnrollmentStore) http.HandlerFunc {
+	return handleEnrollmentChange(enrollments.RemovePersonFromCourses, false)
+}
+
+// HandleSetPersonCourses replaces a person's enrollments with the courses listed
+// in the body, an empty list unenrolls them from everything
+func HandleSetPersonCourses(enrollments services.EnrollmentStore) http.HandlerFunc {
+	return handleEnrollmentChange(enrollments.SetPersonCourses, true)
+}
+
+// handleEnrollmentChange applies change to the course list of the body, if the
+// person is still at the version named by If-Match, and answers with the
+// result for every course. allowEmpty is only set for replacement, where an
+// empty list is meaningful.
+func handleEnrollmentChange(change enrollmentChange, allowEmpty bool) http.HandlerFunc {
+	return JSON(http.StatusOK, func(r *http.Request, body v1.EnrollmentRequest) (v1.EnrollmentResults, error) {
+		id, err := pathID(r)
+		if err != nil {
+			return v1.EnrollmentResults{}, err
+		}
+		if !allowEmpty && len(*body.Courses) == 0 {
+			return v1.EnrollmentResults{}, &services.Error{Kind: services.ErrValidation, Detail: "courses must not be empty"}
+		}
+		version, err := ifMatch(r)
+		if err != nil {
+			return v1.EnrollmentResults{}, err
+		}
+
+		results, err := change(r.Context(), id, version, *body.Courses)
+		if err != nil {
+			return v1.EnrollmentResults{}, err
+		}
+		return v1.FromEnrollmentResults(results), nil
+	})
+}
+
+// HandleGetCourseRoster lists a page of the people enrolled in a course grouped
+// into professors and students, with counts over the whole course
+func HandleGetCourseRoster(enrollments services.EnrollmentStore, limits PageLimits) http.HandlerFunc {
+	return JSON(http.StatusOK, func(r *http.Request, _ empty) (page[v1.Roster], error) {
+		id, err := pathID(r)
+		if err != nil {
+			return page[v1.Roster]{}, err
+		}
+		p, err := parsePage(r, limits)
+		if err != nil {
+			return page[v1.Roster]{}, err
+		}
+
+		roster, next, err := enrollments.GetCourseRoster(r.Context(), id, r.URL.Query().Get("type"), p)
+		if err != nil {
+			return page[v1.Roster]{}, err
+		}
+		return newPage(r, v1.FromRoster(roster), p.Limit, next), nil
+	})
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	v1 "github.com/jacob-tech-challenge/api/dto/v1"
+	"github.com/jacob-tech-challenge/api/services"
+	"github.com/jacob-tech-challenge/api/validation"
+)
+
+// problemContentType is the media type of RFC 7807 problem details
+const problemContentType = "application/problem+json"
+
+// errorStatus returns the status code for an error coming back from a store.
+// The request context is checked too because the driver does not always wrap
+// the context error when it cancels a running query.
+func errorStatus(r *http.Request, err error) int {
+	switch {
+	case errors.Is(err, services.ErrValidation):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrPreconditionFailed):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, services.ErrKeyReused):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(r.Context().Err(), context.DeadlineExceeded):
+		// the query ran past its deadline
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled), errors.Is(r.Context().Err(), context.Canceled):
+		// the client went away or the server is shutting down
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeError answers with err as a problem. Errors of a known kind carry a
+// detail written for clients. Anything else is logged and answered without a
+// detail, so driver messages never reach the client.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	writeProblemBody(w, errorProblem(r, err))
+}
+
+// errorProblem returns the problem writeError answers err with. The problem
+// of a failed batch is the one of its failed operation, with the index added.
+func errorProblem(r *http.Request, err error) v1.Problem {
+	var batchErr *services.BatchError
+	if errors.As(err, &batchErr) {
+		problem := errorProblem(r, batchErr.Err)
+		problem.Operation = &batchErr.Index
+		return problem
+	}
+
+	var problemErr *problemError
+	if errors.As(err, &problemErr) {
+		problem := newProblem(r, problemErr.status, problemErr.detail)
+		problem.Field = problemErr.field
+		problem.Candidates = problemErr.candidates
+		problem.Enrollments = problemErr.enrollments
+		return problem
+	}
+
+	var fieldErrs validation.Errors
+	if errors.As(err, &fieldErrs) {
+		problem := newProblem(r, http.StatusBadRequest, "request body has invalid fields")
+		for _, fieldErr := range fieldErrs {
+			problem.Errors = append(problem.Errors, v1.FieldError{Field: fieldErr.Field, Message: fieldErr.Message})
+		}
+		return problem
+	}
+
+	status := errorStatus(r, err)
+
+	var detail string
+	var storeErr *services.Error
+	var filterErr *services.FilterError
+	switch {
+	case errors.As(err, &filterErr):
+		detail = filterErr.Error()
+	case errors.As(err, &storeErr) && status < http.StatusInternalServerError:
+		detail = storeErr.Detail
+	default:
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+	}
+	return newProblem(r, status, detail)
+}
+
+// problemError is an error a handler answers with a problem of its own making,
+// for problems that carry extension members
+type problemError struct {
+	status      int
+	detail      string
+	field       string
+	candidates  []v1.Person
+	enrollments []v1.Enrollment
+}
+
+func (e *problemError) Error() string {
+	return e.detail
+}
+
+// newProblem returns an RFC 7807 problem for the request. The type is left as
+// about:blank so the title is the standard status text.
+func newProblem(r *http.Request, status int, detail string) v1.Problem {
+	return v1.Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+	}
+}
+
+// writeProblemBody writes a problem built by newProblem
+func writeProblemBody(w http.ResponseWriter, problem v1.Problem) {
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(problem.Status)
+	if err := json.NewEncoder(w).Encode(problem); err != nil {
+		log.Printf("Error encoding problem: %v", err)
+	}
+}
+
+// writeJSON writes body as a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
+// pathID reads the id URL parameter
+func pathID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		return 0, &services.Error{Kind: services.ErrValidation, Detail: "id must be a positive integer"}
+	}
+	return id, nil
+}
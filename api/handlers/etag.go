@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// etag returns the strong entity tag of a row version
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// tagged is a response body sent with the ETag and Last-Modified of the row it was read from
+type tagged[T any] struct {
+	body     T
+	etag     string
+	modified time.Time
+}
+
+// withETag tags body with the entity tag of version and the time the row was last updated
+func withETag[T any](body T, version int, updatedAt time.Time) tagged[T] {
+	return tagged[T]{body: body, etag: etag(version), modified: updatedAt}
+}
+
+// MarshalJSON writes the body alone, the tag travels in the header
+func (t tagged[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.body)
+}
+
+func (t tagged[T]) setHeaders(h http.Header) {
+	h.Set("ETag", t.etag)
+	setLastModified(h, t.modified)
+}
+
+// setLastModified sets the Last-Modified header, unless modified is unknown
+func setLastModified(h http.Header, modified time.Time) {
+	if !modified.IsZero() {
+		h.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// entityTags splits the values of an If-Match or If-None-Match header into their entity tags
+func entityTags(values []string) []string {
+	var tags []string
+	for _, value := range values {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// ifMatch returns the version a write expects from its If-Match header. Writes
+// must be conditional, so a missing header is answered 428. "*" matches any
+// version and comes back as 0. A tag this API never sends, weak tags included
+// since If-Match compares strongly, comes back as -1 and matches no version.
+func ifMatch(r *http.Request) (int, error) {
+	values := r.Header.Values("If-Match")
+	if len(values) == 0 {
+		return 0, &problemError{
+			status: http.StatusPreconditionRequired,
+			detail: "send If-Match with the ETag of the version to change, or * to change any version",
+		}
+	}
+
+	tags := entityTags(values)
+	if len(tags) != 1 {
+		return 0, &problemError{status: http.StatusBadRequest, detail: "If-Match must hold a single entity tag or *"}
+	}
+
+	tag := tags[0]
+	switch {
+	case tag == "*":
+		return 0, nil
+	case strings.HasPrefix(tag, `W/"`) && strings.HasSuffix(tag, `"`) && len(tag) > 3:
+		return -1, nil
+	case !strings.HasPrefix(tag, `"`) || !strings.HasSuffix(tag, `"`) || len(tag) < 2:
+		return 0, &problemError{status: http.StatusBadRequest, detail: "If-Match must hold a quoted entity tag or *"}
+	}
+	version, err := strconv.Atoi(tag[1 : len(tag)-1])
+	if err != nil || version < 1 {
+		return -1, nil
+	}
+	return version, nil
+}
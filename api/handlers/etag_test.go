@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIfMatch(t *testing.T) {
+	tests := map[string]struct {
+		header      []string
+		wantVersion int
+		wantStatus  int
+	}{
+		"missing":          {wantStatus: http.StatusPreconditionRequired},
+		"any version":      {header: []string{"*"}, wantVersion: 0},
+		"our tag":          {header: []string{`"3"`}, wantVersion: 3},
+		"padded":           {header: []string{` "3" `}, wantVersion: 3},
+		"weak tag":         {header: []string{`W/"3"`}, wantVersion: -1},
+		"foreign tag":      {header: []string{`"abc"`}, wantVersion: -1},
+		"zero is not ours": {header: []string{`"0"`}, wantVersion: -1},
+		"unquoted":         {header: []string{"3"}, wantStatus: http.StatusBadRequest},
+		"list":             {header: []string{`"3", "4"`}, wantStatus: http.StatusBadRequest},
+		"repeated header":  {header: []string{`"3"`, `"4"`}, wantStatus: http.StatusBadRequest},
+		"empty header":     {header: []string{""}, wantStatus: http.StatusBadRequest},
+		"lone quote":       {header: []string{`"`}, wantStatus: http.StatusBadRequest},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/", nil)
+			for _, value := range tc.header {
+				req.Header.Add("If-Match", value)
+			}
+
+			version, err := ifMatch(req)
+			if tc.wantStatus != 0 {
+				var problemErr *problemError
+				assert.True(t, errors.As(err, &problemErr))
+				assert.Equal(t, tc.wantStatus, problemErr.status)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantVersion, version)
+		})
+	}
+}
+
+func TestWithETag(t *testing.T) {
+	w := httptest.NewRecorder()
+	JSON(http.StatusOK, func(r *http.Request, _ empty) (tagged[map[string]int], error) {
+		return withETag(map[string]int{"id": 1}, 4, time.Date(2024, 9, 1, 8, 30, 15, 500, time.UTC)), nil
+	}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, `"4"`, w.Header().Get("ETag"))
+	assert.Equal(t, "Sun, 01 Sep 2024 08:30:15 GMT", w.Header().Get("Last-Modified"))
+	assert.JSONEq(t, `{"id":1}`, w.Body.String())
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jacob-tech-challenge/api/services"
+)
+
+// parsePersonFilter reads the filter and sort query parameters of the person list.
+// Errors are *services.FilterError naming the offending parameter.
+func parsePersonFilter(r *http.Request) (services.PersonFilter, error) {
+	query := r.URL.Query()
+	filter := services.PersonFilter{
+		// name is the original spelling of first_name and is kept for existing clients
+		FirstName:       query.Get("name"),
+		LastName:        query.Get("last_name"),
+		FirstNamePrefix: query.Get("first_name_prefix"),
+		LastNamePrefix:  query.Get("last_name_prefix"),
+		Type:            query.Get("type"),
+	}
+	if firstName := query.Get("first_name"); firstName != "" {
+		if filter.FirstName != "" && filter.FirstName != firstName {
+			return services.PersonFilter{}, &services.FilterError{Param: "first_name", Reason: "conflicts with name"}
+		}
+		filter.FirstName = firstName
+	}
+
+	includeDeleted, err := parseIncludeDeleted(r)
+	if err != nil {
+		return services.PersonFilter{}, err
+	}
+	filter.IncludeDeleted = includeDeleted
+
+	if ignoreCase := query.Get("ignore_case"); ignoreCase != "" {
+		value, err := strconv.ParseBool(ignoreCase)
+		if err != nil {
+			return services.PersonFilter{}, &services.FilterError{Param: "ignore_case", Reason: "must be true or false"}
+		}
+		filter.IgnoreCase = value
+	}
+
+	for param, target := range map[string]**int{"age": &filter.Age, "age_gte": &filter.AgeGTE, "age_lte": &filter.AgeLTE} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		age, err := strconv.Atoi(value)
+		if err != nil {
+			return services.PersonFilter{}, &services.FilterError{Param: param, Reason: "must be an integer"}
+		}
+		*target = &age
+	}
+
+	if course := query.Get("course"); course != "" {
+		id, err := strconv.Atoi(course)
+		if err != nil || id < 1 {
+			return services.PersonFilter{}, &services.FilterError{Param: "course", Reason: "must be a positive integer"}
+		}
+		filter.CourseID = id
+	}
+
+	sort, err := services.ParseSort(query.Get("sort"))
+	if err != nil {
+		return services.PersonFilter{}, err
+	}
+	filter.Sort = sort
+
+	return filter, filter.Validate()
+}
+
+// parseIncludeDeleted reads the include_deleted query parameter of the lists,
+// which adds soft deleted rows to them
+func parseIncludeDeleted(r *http.Request) (bool, error) {
+	value := r.URL.Query().Get("include_deleted")
+	if value == "" {
+		return false, nil
+	}
+	includeDeleted, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, &services.FilterError{Param: "include_deleted", Reason: "must be true or false"}
+	}
+	return includeDeleted, nil
+}
+
+// parseCourseDeletion reads the mode and to query parameters of a course delete
+func parseCourseDeletion(r *http.Request) (services.CourseDeletion, error) {
+	query := r.URL.Query()
+	deletion := services.CourseDeletion{Mode: services.DeleteMode(query.Get("mode"))}
+	if value := query.Get("to"); value != "" {
+		to, err := strconv.Atoi(value)
+		if err != nil || to < 1 {
+			return services.CourseDeletion{}, &services.FilterError{Param: "to", Reason: "must be a positive integer"}
+		}
+		deletion.To = to
+	}
+	return deletion, nil
+}
+
+// parseAuditFilter reads the entity, id and since query parameters of the audit log
+func parseAuditFilter(r *http.Request) (services.AuditFilter, error) {
+	query := r.URL.Query()
+	filter := services.AuditFilter{Entity: query.Get("entity")}
+	if value := query.Get("id"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil || id < 1 {
+			return services.AuditFilter{}, &services.FilterError{Param: "id", Reason: "must be a positive integer"}
+		}
+		filter.EntityID = id
+	}
+	since, err := parseTime(r, "since")
+	if err != nil {
+		return services.AuditFilter{}, err
+	}
+	filter.Since = since
+	return filter, filter.Validate()
+}
+
+// parseTime reads an RFC 3339 timestamp query parameter, the zero time when it is not set
+func parseTime(r *http.Request, param string) (time.Time, error) {
+	value := r.URL.Query().Get(param)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, &services.FilterError{Param: param, Reason: "must be an RFC 3339 timestamp"}
+	}
+	return t, nil
+}
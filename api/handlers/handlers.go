@@ -1,334 +1,389 @@
 package handlers
 
 import (
-	"database/sql"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
 	"net/http"
-	"strconv"
+	"slices"
 
 	"github.com/go-chi/chi/v5"
+	v1 "github.com/jacob-tech-challenge/api/dto/v1"
 	"github.com/jacob-tech-challenge/api/models"
 	"github.com/jacob-tech-challenge/api/services"
+	"github.com/jacob-tech-challenge/api/validation"
 )
 
-func HandleGetAllCourses(db *sql.DB) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		courses, err := services.GetAllCourses(db)
-
+// HandleGetAllCourses lists a page of courses, soft deleted ones too with include_deleted=true
+func HandleGetAllCourses(courses services.CourseStore, limits PageLimits) http.HandlerFunc {
+	return JSON(http.StatusOK, func(r *http.Request, _ empty) (page[[]v1.Course], error) {
+		includeDeleted, err := parseIncludeDeleted(r)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			return page[[]v1.Course]{}, err
 		}
-
-		coursesOut := make([]map[string]interface{}, len(courses))
-		for i, course := range courses {
-			coursesOut[i] = map[string]interface{}{
-				"id":   course.ID,
-				"name": course.Name,
-			}
+		p, err := parsePage(r, limits)
+		if err != nil {
+			return page[[]v1.Course]{}, err
+		}
+		// read before the page, so a write in between leaves the page newer than its date rather than older
+		modified, err := courses.CoursesModified(r.Context())
+		if err != nil {
+			return page[[]v1.Course]{}, err
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		if err := json.NewEncoder(w).Encode(coursesOut); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		allCourses, next, err := courses.GetAllCourses(r.Context(), services.CourseFilter{IncludeDeleted: includeDeleted}, p)
+		if err != nil {
+			return page[[]v1.Course]{}, err
 		}
+		list := newPage(r, v1.FromCourses(allCourses), p.Limit, next)
+		list.lastModified(modified)
+		return list, nil
 	})
 }
 
 // HandleGetCourseByID handles the get course by id request
-func HandleGetCourseByID(db *sql.DB) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		idString := chi.URLParam(r, "id")
-		id, err := strconv.Atoi(idString)
+func HandleGetCourseByID(courses services.CourseStore) http.HandlerFunc {
+	return JSON(http.StatusOK, func(r *http.Request, _ empty) (tagged[v1.Course], error) {
+		id, err := pathID(r)
+		if err != nil {
+			return tagged[v1.Course]{}, err
+		}
+		course, err := courses.GetCourseByID(r.Context(), id)
+		if err != nil {
+			return tagged[v1.Course]{}, err
+		}
+		return withETag(v1.FromCourse(course), course.Version, course.UpdatedAt), nil
+	})
+}
+
+// HandleUpdateCourse renames the course version named by If-Match
+func HandleUpdateCourse(courses services.CourseStore) http.HandlerFunc {
+	return JSON(http.StatusOK, func(r *http.Request, body v1.CourseRequest) (tagged[v1.Course], error) {
+		id, err := pathID(r)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+			return tagged[v1.Course]{}, err
 		}
-		course, err := services.GetCourseByID(db, id)
+		version, err := ifMatch(r)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			return tagged[v1.Course]{}, err
 		}
-		courseOut := map[string]interface{}{
-			"id":   course.ID,
-			"name": course.Name,
+		if err := validation.Course(body.Model()); err != nil {
+			return tagged[v1.Course]{}, err
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		if err := json.NewEncoder(w).Encode(courseOut); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		course := body.Model()
+		course.Version = version
+		course, err = courses.UpdateCourse(r.Context(), id, course)
+		if err != nil {
+			return tagged[v1.Course]{}, err
 		}
+		return withETag(v1.FromCourse(course), course.Version, course.UpdatedAt), nil
 	})
-	
 }
 
-func HandleUpdateCourse(db *sql.DB) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		idString := chi.URLParam(r, "id")
-		id, err := strconv.Atoi(idString)
+// HandlePatchCourse changes the members of a course sent in a merge patch or
+// JSON patch, if it is still at the version named by If-Match
+func HandlePatchCourse(courses services.CourseStore) http.HandlerFunc {
+	return JSON(http.StatusOK, func(r *http.Request, body json.RawMessage) (tagged[v1.Course], error) {
+		id, err := pathID(r)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+			return tagged[v1.Course]{}, err
 		}
-		var course models.Course
-		if err := json.NewDecoder(r.Body).Decode(&course); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		course.ID = id
-		if _, err := services.UpdateCourse(db, id, course); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		version, err := ifMatch(r)
+		if err != nil {
+			return tagged[v1.Course]{}, err
 		}
-		courseOut := map[string]interface{}{
-			"id":   course.ID,
-			"name": course.Name,
+		patch, err := parsePatch(r, body)
+		if err != nil {
+			return tagged[v1.Course]{}, err
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		if err := json.NewEncoder(w).Encode(courseOut); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		course, err := courses.PatchCourse(r.Context(), id, version, func(current models.Course) (models.Course, error) {
+			var patched v1.CourseRequest
+			if err := applyPatch(patch, v1.CourseRequestFrom(current), &patched); err != nil {
+				return models.Course{}, err
+			}
+			return patched.Model(), validation.Course(patched.Model())
+		})
+		if err != nil {
+			return tagged[v1.Course]{}, err
 		}
+		return withETag(v1.FromCourse(course), course.Version, course.UpdatedAt), nil
 	})
 }
 
-func HandleCreateCourse(db *sql.DB) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var course models.Course
-		if err := json.NewDecoder(r.Body).Decode(&course); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		if _, err := services.CreateCourse(db, course); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		courseOut := map[string]interface{}{
-			"id":   course.ID,
-			"name": course.Name,
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusCreated)
-		if err := json.NewEncoder(w).Encode(courseOut); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+// HandleCreateCourse creates a course
+func HandleCreateCourse(courses services.CourseStore) http.HandlerFunc {
+	return JSON(http.StatusCreated, func(r *http.Request, body v1.CourseRequest) (tagged[v1.Course], error) {
+		if err := validation.Course(body.Model()); err != nil {
+			return tagged[v1.Course]{}, err
 		}
+		course, err := courses.CreateCourse(r.Context(), body.Model())
+		if err != nil {
+			return tagged[v1.Course]{}, err
+		}
+		return withETag(v1.FromCourse(course), course.Version, course.UpdatedAt), nil
 	})
 }
 
-func HandleDeleteCourse(db *sql.DB) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		idString := chi.URLParam(r, "id")
-		id, err := strconv.Atoi(idString)
+// HandleDeleteCourse soft deletes a course if it is still at the version named
+// by If-Match. The mode parameter says what happens to the enrollments in it,
+// restrict answers 409 listing them while there are any.
+func HandleDeleteCourse(courses services.CourseStore) http.HandlerFunc {
+	return JSON(http.StatusOK, func(r *http.Request, _ empty) (v1.CourseDeleted, error) {
+		id, err := pathID(r)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+			return v1.CourseDeleted{}, err
 		}
-		if err := services.DeleteCourse(db, id); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		deletion, err := parseCourseDeletion(r)
+		if err != nil {
+			return v1.CourseDeleted{}, err
 		}
-		w.WriteHeader(http.StatusNoContent)
-		// json formatted string representing deletion confirmation
-		w.Write([]byte(`{"message": "Course deleted"}`))
+		version, err := ifMatch(r)
+		if err != nil {
+			return v1.CourseDeleted{}, err
+		}
+		deleted, err := courses.DeleteCourse(r.Context(), id, version, deletion)
+		var enrolledErr *services.EnrolledError
+		if errors.As(err, &enrolledErr) {
+			return v1.CourseDeleted{}, &problemError{
+				status:      http.StatusConflict,
+				detail:      enrolledErr.Error() + `, delete with mode cascade or reassign`,
+				enrollments: v1.FromEnrolled(enrolledErr),
+			}
+		}
+		if err != nil {
+			return v1.CourseDeleted{}, err
+		}
+		return v1.FromCourseDeleted(deletion, deleted), nil
 	})
 }
 
-func HandleGetAllPeople(db *sql.DB) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// chi parameters, if any
-		name := r.URL.Query().Get("name")
-		age := 0
-		ageString := r.URL.Query().Get("age")
-
-		log.Println("name:", name)
-		log.Println("ageString:", ageString)
-
-
-		if ageString != "" {
-			var err error
-			age, err = strconv.Atoi(ageString)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
-		}	
-
-		people, err := services.GetAllPeople(db, name, age)
-
+// HandleRestoreCourse brings back a soft deleted course with its enrollments
+func HandleRestoreCourse(courses services.CourseStore) http.HandlerFunc {
+	return JSON(http.StatusOK, func(r *http.Request, _ empty) (tagged[v1.Course], error) {
+		id, err := pathID(r)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			return tagged[v1.Course]{}, err
 		}
+		course, err := courses.RestoreCourse(r.Context(), id)
+		if err != nil {
+			return tagged[v1.Course]{}, err
+		}
+		return withETag(v1.FromCourse(course), course.Version, course.UpdatedAt), nil
+	})
+}
 
-		peopleOut := make([]map[string]interface{}, len(people))
-		for i, person := range people {
-			peopleOut[i] = map[string]interface{}{
-				"id":        person.ID,
-				"firstName": person.FirstName,
-				"lastName":  person.LastName,
-				"type":      person.Type,
-				"age":       person.Age,
-				"courses":   person.Courses,
-			}
+// HandleGetAllPeople lists a page of the people matching the filter query parameters
+func HandleGetAllPeople(people services.PersonStore, limits PageLimits) http.HandlerFunc {
+	return JSON(http.StatusOK, func(r *http.Request, _ empty) (page[[]v1.Person], error) {
+		filter, err := parsePersonFilter(r)
+		if err != nil {
+			return page[[]v1.Person]{}, err
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		if err := json.NewEncoder(w).Encode(peopleOut); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		p, err := parsePage(r, limits)
+		if err != nil {
+			return page[[]v1.Person]{}, err
 		}
+		// read before the page like HandleGetAllCourses does
+		modified, err := people.PeopleModified(r.Context())
+		if err != nil {
+			return page[[]v1.Person]{}, err
+		}
+		allPeople, next, err := people.GetAllPeople(r.Context(), filter, p)
+		if err != nil {
+			return page[[]v1.Person]{}, err
+		}
+		list := newPage(r, v1.FromPeople(allPeople), p.Limit, next)
+		list.lastModified(modified)
+		return list, nil
 	})
 }
 
-func HandleGetPersonByName(db *sql.DB) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		name := chi.URLParam(r, "name")
-		person, err := services.GetPersonByName(db, name)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		personOut := map[string]interface{}{
-			"id":        person.ID,
-			"firstName": person.FirstName,
-			"lastName":  person.LastName,
-			"type":      person.Type,
-			"age":       person.Age,
-			"courses":   person.Courses,
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		if err := json.NewEncoder(w).Encode(personOut); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+// HandleGetPersonByID handles the get person by id request. With as_of it
+// answers with the person as they were at that time instead.
+func HandleGetPersonByID(people services.PersonStore) http.HandlerFunc {
+	return JSON(http.StatusOK, func(r *http.Request, _ empty) (tagged[v1.Person], error) {
+		id, err := pathID(r)
+		if err != nil {
+			return tagged[v1.Person]{}, err
 		}
+		asOf, err := parseTime(r, "as_of")
+		if err != nil {
+			return tagged[v1.Person]{}, err
+		}
+		var person models.Person
+		if asOf.IsZero() {
+			person, err = people.GetPersonByID(r.Context(), id)
+		} else {
+			person, err = people.GetPersonAsOf(r.Context(), id, asOf)
+		}
+		if err != nil {
+			return tagged[v1.Person]{}, err
+		}
+		return withETag(v1.FromPerson(person), person.Version, person.UpdatedAt), nil
 	})
 }
 
-func HandleUpdatePersonByName(db *sql.DB) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		name := chi.URLParam(r, "name")
-		if name == "" {
-			http.Error(w, "name parameter is required", http.StatusBadRequest)
-			return
+// HandleGetPersonByName looks a person up by first and last name. Names are not
+// unique, so when more than one person matches it answers 409 with every
+// candidate and the client picks one by id.
+func HandleGetPersonByName(people services.PersonStore) http.HandlerFunc {
+	return JSON(http.StatusOK, func(r *http.Request, _ empty) (tagged[v1.Person], error) {
+		filter := services.PersonFilter{FirstName: chi.URLParam(r, "first"), LastName: chi.URLParam(r, "last")}
+		matches, _, err := people.GetAllPeople(r.Context(), filter, services.Page{})
+		if err != nil {
+			return tagged[v1.Person]{}, err
 		}
 
-		var person models.Person
-		if err := json.NewDecoder(r.Body).Decode(&person); err != nil {
-			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
-			return
+		if len(matches) == 0 {
+			return tagged[v1.Person]{}, &services.Error{
+				Kind:   services.ErrNotFound,
+				Detail: fmt.Sprintf("nobody is named %s %s", filter.FirstName, filter.LastName),
+			}
 		}
-
-		// Check if person exists first
-		var existingPerson models.Person
-		err := db.QueryRow("SELECT id, first_name, last_name, type, age FROM person WHERE first_name = $1", name).
-			Scan(&existingPerson.ID, &existingPerson.FirstName, &existingPerson.LastName, &existingPerson.Type, &existingPerson.Age)
-		if err == sql.ErrNoRows {
-			http.Error(w, "Person not found", http.StatusNotFound)
-			return
+		if len(matches) > 1 {
+			return tagged[v1.Person]{}, &problemError{
+				status:     http.StatusConflict,
+				detail:     "more than one person has this name, address them by id",
+				candidates: v1.FromPeople(matches),
+			}
 		}
+		return withETag(v1.FromPerson(matches[0]), matches[0].Version, matches[0].UpdatedAt), nil
+	})
+}
+
+// HandleUpdatePerson updates the person version named by If-Match and enrolls
+// them in any courses sent along, all in one change. The store checks that the
+// courses exist in the transaction that enrolls the person.
+func HandleUpdatePerson(people services.PersonStore) http.HandlerFunc {
+	return JSON(http.StatusOK, func(r *http.Request, body v1.PersonRequest) (tagged[v1.Person], error) {
+		id, err := pathID(r)
 		if err != nil {
-			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
-			return
+			return tagged[v1.Person]{}, err
 		}
-
-		// Update person
-		_, err = db.Exec(`
-			UPDATE person 
-			SET first_name = $1, last_name = $2, type = $3, age = $4 
-			WHERE first_name = $5`,
-			person.FirstName, person.LastName, person.Type, person.Age, name)
+		version, err := ifMatch(r)
 		if err != nil {
-			http.Error(w, "Failed to update person: "+err.Error(), http.StatusInternalServerError)
-			return
+			return tagged[v1.Person]{}, err
+		}
+		person := body.Model()
+		if err := validation.Person(r.Context(), nil, person); err != nil {
+			return tagged[v1.Person]{}, err
 		}
 
-		// Handle courses
-		if len(person.Courses) > 0 {
+		// a missing person comes back as ErrNotFound and a changed one as ErrPreconditionFailed
+		updated, err := people.PatchPerson(r.Context(), id, version, func(current models.Person) (models.Person, error) {
+			patched := person
+			patched.Courses = current.Courses
 			for _, courseID := range person.Courses {
-				_, err = db.Exec(`
-					INSERT INTO person_course (person_id, course_id) 
-					VALUES ($1, $2)
-					ON CONFLICT (person_id, course_id) DO NOTHING`,
-					existingPerson.ID, courseID)
-				if err != nil {
-					http.Error(w, "Failed to update courses: "+err.Error(), http.StatusInternalServerError)
-					return
+				if !slices.Contains(patched.Courses, courseID) {
+					patched.Courses = append(patched.Courses, courseID)
 				}
 			}
+			return patched, nil
+		})
+		if err != nil {
+			return tagged[v1.Person]{}, err
 		}
+		return withETag(v1.FromPerson(updated), updated.Version, updated.UpdatedAt), nil
+	})
+}
 
-		// Prepare response
-		person.ID = existingPerson.ID // Make sure we include the correct ID
-		personOutMap := map[string]interface{}{
-			"id":        float64(person.ID),
-			"firstName": person.FirstName,
-			"lastName":  person.LastName,
-			"type":      person.Type,
-			"age":       float64(person.Age),
-			"courses":   person.Courses,
+// HandlePatchPerson changes the members of a person sent in a merge patch or
+// JSON patch, if they are still at the version named by If-Match. The patched
+// person is validated as a whole, and a patched course list replaces the
+// person's enrollments. Whether the courses exist is checked by the store, in
+// the transaction that holds the person's lock.
+func HandlePatchPerson(people services.PersonStore) http.HandlerFunc {
+	return JSON(http.StatusOK, func(r *http.Request, body json.RawMessage) (tagged[v1.Person], error) {
+		id, err := pathID(r)
+		if err != nil {
+			return tagged[v1.Person]{}, err
+		}
+		version, err := ifMatch(r)
+		if err != nil {
+			return tagged[v1.Person]{}, err
+		}
+		patch, err := parsePatch(r, body)
+		if err != nil {
+			return tagged[v1.Person]{}, err
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		if err := json.NewEncoder(w).Encode(personOutMap); err != nil {
-			log.Printf("Error encoding response: %v", err)
+		person, err := people.PatchPerson(r.Context(), id, version, func(current models.Person) (models.Person, error) {
+			var patched v1.PersonRequest
+			if err := applyPatch(patch, v1.PersonRequestFrom(current), &patched); err != nil {
+				return models.Person{}, err
+			}
+			return patched.Model(), validation.Person(r.Context(), nil, patched.Model())
+		})
+		if err != nil {
+			return tagged[v1.Person]{}, err
 		}
+		return withETag(v1.FromPerson(person), person.Version, person.UpdatedAt), nil
 	})
 }
 
-func HandleCreatePerson(db *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		var person models.Person
-		if err := json.NewDecoder(r.Body).Decode(&person); err != nil {
-			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
-			return
+// HandleCreatePerson creates a person enrolled in the courses sent along
+func HandleCreatePerson(people services.PersonStore, courses services.CourseStore) http.HandlerFunc {
+	return JSON(http.StatusCreated, func(r *http.Request, body v1.PersonRequest) (tagged[v1.Person], error) {
+		if err := validation.Person(r.Context(), courses, body.Model()); err != nil {
+			return tagged[v1.Person]{}, err
 		}
 
-		// Validate person
-		if person.FirstName == "" || person.LastName == "" {
-			http.Error(w, "FirstName and LastName are required", http.StatusBadRequest)
-			return
+		person, err := people.CreatePerson(r.Context(), body.Model())
+		if err != nil {
+			return tagged[v1.Person]{}, err
 		}
+		return withETag(v1.FromPerson(person), person.Version, person.UpdatedAt), nil
+	})
+}
 
-		// Create person
-		personOut, err := services.CreatePerson(db, person)
+// HandleDeletePerson soft deletes a person, if they are still at the version
+// named by If-Match. Their enrollments are kept for a restore.
+func HandleDeletePerson(people services.PersonStore) http.HandlerFunc {
+	return JSON(http.StatusNoContent, func(r *http.Request, _ empty) (empty, error) {
+		id, err := pathID(r)
 		if err != nil {
-			log.Printf("Error creating person: %v", err) // Add logging
-			http.Error(w, "Failed to create person: "+err.Error(), http.StatusInternalServerError)
-			return
+			return empty{}, err
 		}
-
-		// Update personOut with the courses from the input
-		personOut.Courses = person.Courses
-
-		personOutMap := map[string]interface{}{
-			"id":        personOut.ID,
-			"firstName": personOut.FirstName,
-			"lastName":  personOut.LastName,
-			"type":      personOut.Type,
-			"age":       personOut.Age,
-			"courses":   personOut.Courses,
+		version, err := ifMatch(r)
+		if err != nil {
+			return empty{}, err
 		}
+		return empty{}, people.DeletePerson(r.Context(), id, version)
+	})
+}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusCreated)
-		if err := json.NewEncoder(w).Encode(personOutMap); err != nil {
-			log.Printf("Error encoding response: %v", err)
+// HandleRestorePerson brings back a soft deleted person with their enrollments
+// in courses that are not deleted themselves
+func HandleRestorePerson(people services.PersonStore) http.HandlerFunc {
+	return JSON(http.StatusOK, func(r *http.Request, _ empty) (tagged[v1.Person], error) {
+		id, err := pathID(r)
+		if err != nil {
+			return tagged[v1.Person]{}, err
 		}
-	}
+		person, err := people.RestorePerson(r.Context(), id)
+		if err != nil {
+			return tagged[v1.Person]{}, err
+		}
+		return withETag(v1.FromPerson(person), person.Version, person.UpdatedAt), nil
+	})
 }
 
-func HandleDeletePersonByName(db *sql.DB) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		name := chi.URLParam(r, "name")
-		if err := services.DeletePersonByName(db, name); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		w.WriteHeader(http.StatusNoContent)
-		// json formatted string representing deletion confirmation
-		w.Write([]byte(`{"message": "Person deleted"}`))
+// HandleGetPersonHistory lists the revisions of a person, oldest first. The
+// history outlives the person, so it is there after a delete too.
+func HandleGetPersonHistory(people services.PersonStore, limits PageLimits) http.HandlerFunc {
+	return JSON(http.StatusOK, func(r *http.Request, _ empty) (page[[]v1.PersonRevision], error) {
+		id, err := pathID(r)
+		if err != nil {
+			return page[[]v1.PersonRevision]{}, err
+		}
+		p, err := parsePage(r, limits)
+		if err != nil {
+			return page[[]v1.PersonRevision]{}, err
+		}
+		revisions, next, err := people.GetPersonHistory(r.Context(), id, p)
+		if err != nil {
+			return page[[]v1.PersonRevision]{}, err
+		}
+		return newPage(r, v1.FromPersonRevisions(revisions), p.Limit, next), nil
 	})
 }
-
-
@@ -1,19 +1,25 @@
 package handlers
 
 import (
+	"context"
 	"bytes"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/go-chi/chi/v5"
+	v1 "github.com/jacob-tech-challenge/api/dto/v1"
 	"github.com/jacob-tech-challenge/api/models"
+	"github.com/jacob-tech-challenge/api/services"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -34,10 +40,11 @@ func TestHandleGetAllCourses(t *testing.T) {
 		{
 			name: "successful retrieval",
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				rows := sqlmock.NewRows([]string{"id", "name"}).
-					AddRow(1, "Math").
-					AddRow(2, "Science")
-				mock.ExpectQuery(`SELECT \* FROM "course"`).WillReturnRows(rows)
+				expectLastModified(mock, "course")
+				rows := sqlmock.NewRows(courseListColumns).
+					AddRow(1, "Math", 1, testUpdatedAt, nil).
+					AddRow(2, "Science", 1, testUpdatedAt, nil)
+				mock.ExpectQuery(`SELECT id, name, version, updated_at, deleted_at FROM "course"`).WillReturnRows(rows)
 			},
 			expectedCode: http.StatusOK,
 			expectedBody: []map[string]interface{}{
@@ -48,7 +55,8 @@ func TestHandleGetAllCourses(t *testing.T) {
 		{
 			name: "database error",
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery(`SELECT \* FROM "course"`).WillReturnError(sql.ErrConnDone)
+				expectLastModified(mock, "course")
+				mock.ExpectQuery(`SELECT id, name, version, updated_at FROM "course"`).WillReturnError(sql.ErrConnDone)
 			},
 			expectedCode: http.StatusInternalServerError,
 		},
@@ -64,7 +72,7 @@ func TestHandleGetAllCourses(t *testing.T) {
 			rr := httptest.NewRecorder()
 
 			// Call the handler
-			handler := HandleGetAllCourses(db)
+			handler := HandleGetAllCourses(services.NewPostgresStore(db), PageLimits{})
 			handler.ServeHTTP(rr, req)
 
 			// Assert status code
@@ -72,10 +80,15 @@ func TestHandleGetAllCourses(t *testing.T) {
 
 			// For successful cases, verify the response body
 			if tt.expectedCode == http.StatusOK {
-				var got []map[string]interface{}
+				var got struct {
+					Data []map[string]interface{} `json:"data"`
+					Next *string                  `json:"next"`
+				}
 				err := json.NewDecoder(rr.Body).Decode(&got)
 				assert.NoError(t, err)
-				assert.Equal(t, tt.expectedBody, got)
+				assert.Equal(t, tt.expectedBody, got.Data)
+				assert.Nil(t, got.Next)
+				assert.Equal(t, "Sun, 01 Sep 2024 08:30:00 GMT", rr.Header().Get("Last-Modified"))
 			}
 		})
 	}
@@ -91,6 +104,7 @@ func TestHandleGetCourseByID(t *testing.T) {
 	tests := []struct {
 		name          string
 		courseID      string
+		ifNoneMatch   string
 		mockSetup     func(sqlmock.Sqlmock)
 		expectedCode  int
 		expectedBody  map[string]interface{}
@@ -99,10 +113,10 @@ func TestHandleGetCourseByID(t *testing.T) {
 			name:     "successful retrieval",
 			courseID: "1",
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				rows := sqlmock.NewRows([]string{"id", "name"}).
-					AddRow(1, "Math")
+				rows := sqlmock.NewRows(courseColumns).
+					AddRow(1, "Math", 3, testUpdatedAt)
 				// Note: The regex pattern is updated to match the actual query
-				mock.ExpectQuery(`SELECT \* FROM "course" WHERE id = \$1`).
+				mock.ExpectQuery(`SELECT id, name, version, updated_at FROM "course" WHERE id = \$1`).
 					WithArgs(1).
 					WillReturnRows(rows)
 			},
@@ -112,6 +126,17 @@ func TestHandleGetCourseByID(t *testing.T) {
 				"name": "Math",
 			},
 		},
+		{
+			name:        "unchanged since last read",
+			courseID:    "1",
+			ifNoneMatch: `"3"`,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT id, name, version, updated_at FROM "course" WHERE id = \$1`).
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows(courseColumns).AddRow(1, "Math", 3, testUpdatedAt))
+			},
+			expectedCode: http.StatusNotModified,
+		},
 		{
 			name:     "invalid id",
 			courseID: "invalid",
@@ -127,10 +152,13 @@ func TestHandleGetCourseByID(t *testing.T) {
 
 			// Create a new router to properly handle URL parameters
 			router := chi.NewRouter()
-			router.Get("/{id}", HandleGetCourseByID(db))
+			router.Get("/{id}", HandleGetCourseByID(services.NewPostgresStore(db)))
 
 			// Create request
 			req := httptest.NewRequest("GET", "/"+tt.courseID, nil)
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
 			rr := httptest.NewRecorder()
 
 			// Serve the request
@@ -139,17 +167,43 @@ func TestHandleGetCourseByID(t *testing.T) {
 			// Assert status code
 			assert.Equal(t, tt.expectedCode, rr.Code)
 
-			// For successful cases, verify the response body
+			// For successful cases, verify the response body and its tag
 			if tt.expectedCode == http.StatusOK {
 				var got map[string]interface{}
 				err := json.NewDecoder(rr.Body).Decode(&got)
 				assert.NoError(t, err)
 				assert.Equal(t, tt.expectedBody, got)
+				assert.Equal(t, `"3"`, rr.Header().Get("ETag"))
+				assert.Equal(t, testUpdatedAt.Format(http.TimeFormat), rr.Header().Get("Last-Modified"))
+			}
+			if tt.expectedCode == http.StatusNotModified {
+				assert.Empty(t, rr.Body.String())
 			}
 		})
 	}
 }
 
+// expectAudit expects the audit entry a write adds to its transaction
+func expectAudit(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(`INSERT INTO audit_log`).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// expectEviction expects the cache eviction a write announces from its transaction
+func expectEviction(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(`SELECT pg_notify`).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// expectLastModified expects the read of when an entity was last written, which lists are dated with
+func expectLastModified(mock sqlmock.Sqlmock, entity string) {
+	mock.ExpectQuery(`SELECT max\(at\) FROM audit_log`).WithArgs(entity).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(testUpdatedAt))
+}
+
+// expectHistory expects the revision a write to a person adds to their history
+func expectHistory(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(`INSERT INTO person_history`).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
 func TestHandleCreateCourse(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -159,36 +213,42 @@ func TestHandleCreateCourse(t *testing.T) {
 
 	tests := []struct {
 		name          string
-		course        models.Course
+		course        v1.CourseRequest
 		mockSetup     func(sqlmock.Sqlmock)
 		expectedCode  int
 		expectedBody  map[string]interface{}
 	}{
 		{
 			name: "successful creation",
-			course: models.Course{
+			course: v1.CourseRequest{
 				Name: "New Course",
 			},
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery(`INSERT INTO "course" \(name\) VALUES \(\$1\) RETURNING id`).
+				mock.ExpectBegin()
+				mock.ExpectQuery(`INSERT INTO "course" \(name\) VALUES \(\$1\) RETURNING id, version, updated_at`).
 					WithArgs("New Course").
-					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+					WillReturnRows(sqlmock.NewRows([]string{"id", "version", "updated_at"}).AddRow(1, 1, testUpdatedAt))
+				expectAudit(mock)
+				expectEviction(mock)
+				mock.ExpectCommit()
 			},
 			expectedCode: http.StatusCreated,
 			expectedBody: map[string]interface{}{
-				"id":   float64(0),
+				"id":   float64(1),
 				"name": "New Course",
 			},
 		},
 		{
 			name: "database error",
-			course: models.Course{
+			course: v1.CourseRequest{
 				Name: "New Course",
 			},
 			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
 				mock.ExpectQuery(`INSERT INTO "course" \(name\) VALUES \(\$1\) RETURNING id`).
 					WithArgs("New Course").
 					WillReturnError(sql.ErrConnDone)
+				mock.ExpectRollback()
 			},
 			expectedCode: http.StatusInternalServerError,
 		},
@@ -204,10 +264,11 @@ func TestHandleCreateCourse(t *testing.T) {
 			assert.NoError(t, err)
 
 			req := httptest.NewRequest("POST", "/courses", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
 			rr := httptest.NewRecorder()
 
 			// Call the handler
-			handler := HandleCreateCourse(db)
+			handler := HandleCreateCourse(services.NewPostgresStore(db))
 			handler.ServeHTTP(rr, req)
 
 			// Assert status code
@@ -219,6 +280,7 @@ func TestHandleCreateCourse(t *testing.T) {
 				err := json.NewDecoder(rr.Body).Decode(&got)
 				assert.NoError(t, err)
 				assert.Equal(t, tt.expectedBody, got)
+				assert.Equal(t, `"1"`, rr.Header().Get("ETag"))
 			}
 		})
 	}
@@ -231,10 +293,17 @@ func TestHandleUpdateCourse(t *testing.T) {
 	}
 	defer db.Close()
 
+	lockQuery := `SELECT id, name, version, updated_at FROM course WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`
+	updateQuery := `UPDATE course SET name = \$1, version = version \+ 1, updated_at = now\(\) WHERE id = \$2`
+	courseRow := func(version int) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "name", "version", "updated_at"}).AddRow(1, "Course", version, testUpdatedAt)
+	}
+
 	tests := []struct {
 		name          string
 		courseID      string
-		course        models.Course
+		ifMatch       string
+		course        v1.CourseRequest
 		mockSetup     func(sqlmock.Sqlmock)
 		expectedCode  int
 		expectedBody  map[string]interface{}
@@ -242,14 +311,19 @@ func TestHandleUpdateCourse(t *testing.T) {
 		{
 			name:     "successful update",
 			courseID: "1",
-			course: models.Course{
-				ID:   1,
+			ifMatch:  `"2"`,
+			course: v1.CourseRequest{
 				Name: "Updated Course",
 			},
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectExec(`UPDATE "course" SET name = \$1 WHERE id = \$2`).
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnRows(courseRow(2))
+				mock.ExpectQuery(updateQuery).
 					WithArgs("Updated Course", 1).
-					WillReturnResult(sqlmock.NewResult(1, 1))
+					WillReturnRows(sqlmock.NewRows([]string{"version", "updated_at"}).AddRow(3, testUpdatedAt))
+				expectAudit(mock)
+				expectEviction(mock)
+				mock.ExpectCommit()
 			},
 			expectedCode: http.StatusOK,
 			expectedBody: map[string]interface{}{
@@ -257,10 +331,34 @@ func TestHandleUpdateCourse(t *testing.T) {
 				"name": "Updated Course",
 			},
 		},
+		{
+			name:     "stale version",
+			courseID: "1",
+			ifMatch:  `"1"`,
+			course: v1.CourseRequest{
+				Name: "Updated Course",
+			},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnRows(courseRow(2))
+				mock.ExpectRollback()
+			},
+			expectedCode: http.StatusPreconditionFailed,
+		},
+		{
+			name:     "missing If-Match",
+			courseID: "1",
+			course: v1.CourseRequest{
+				Name: "Updated Course",
+			},
+			mockSetup:    func(mock sqlmock.Sqlmock) {},
+			expectedCode: http.StatusPreconditionRequired,
+		},
 		{
 			name:     "invalid id",
 			courseID: "invalid",
-			course: models.Course{
+			ifMatch:  "*",
+			course: v1.CourseRequest{
 				Name: "Updated Course",
 			},
 			mockSetup:     func(mock sqlmock.Sqlmock) {},
@@ -269,14 +367,17 @@ func TestHandleUpdateCourse(t *testing.T) {
 		{
 			name:     "database error",
 			courseID: "1",
-			course: models.Course{
-				ID:   1,
+			ifMatch:  "*",
+			course: v1.CourseRequest{
 				Name: "Updated Course",
 			},
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectExec(`UPDATE "course" SET name = \$1 WHERE id = \$2`).
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnRows(courseRow(2))
+				mock.ExpectQuery(updateQuery).
 					WithArgs("Updated Course", 1).
 					WillReturnError(sql.ErrConnDone)
+				mock.ExpectRollback()
 			},
 			expectedCode: http.StatusInternalServerError,
 		},
@@ -289,7 +390,7 @@ func TestHandleUpdateCourse(t *testing.T) {
 
 			// Create a new router to properly handle URL parameters
 			router := chi.NewRouter()
-			router.Put("/{id}", HandleUpdateCourse(db))
+			router.Put("/{id}", HandleUpdateCourse(services.NewPostgresStore(db)))
 
 			// Create request body
 			body, err := json.Marshal(tt.course)
@@ -298,6 +399,9 @@ func TestHandleUpdateCourse(t *testing.T) {
 			// Create request
 			req := httptest.NewRequest(http.MethodPut, "/"+tt.courseID, bytes.NewBuffer(body))
 			req.Header.Set("Content-Type", "application/json")
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
 			rr := httptest.NewRecorder()
 
 			// Serve the request
@@ -305,13 +409,15 @@ func TestHandleUpdateCourse(t *testing.T) {
 
 			// Assert status code
 			assert.Equal(t, tt.expectedCode, rr.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
 
-			// For successful cases, verify the response body
+			// For successful cases, verify the response body and the new tag
 			if tt.expectedCode == http.StatusOK {
 				var got map[string]interface{}
 				err := json.NewDecoder(rr.Body).Decode(&got)
 				assert.NoError(t, err)
 				assert.Equal(t, tt.expectedBody, got)
+				assert.Equal(t, `"3"`, rr.Header().Get("ETag"))
 			}
 		})
 	}
@@ -327,32 +433,76 @@ func TestHandleDeleteCourse(t *testing.T) {
 	tests := []struct {
 		name          string
 		courseID      string
+		ifMatch       string
 		mockSetup     func(sqlmock.Sqlmock)
 		expectedCode  int
+		expectedBody  map[string]interface{}
 	}{
 		{
 			name:     "successful deletion",
 			courseID: "1",
+			ifMatch:  `"1"`,
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectExec(`DELETE FROM "course" WHERE id = \$1`).
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT id, name, version, updated_at FROM course WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`).
 					WithArgs(1).
-					WillReturnResult(sqlmock.NewResult(1, 1))
+					WillReturnRows(sqlmock.NewRows([]string{"id", "name", "version", "updated_at"}).AddRow(1, "Course", 1, testUpdatedAt))
+				mock.ExpectExec(`SELECT p.id FROM person p JOIN person_course pc`).
+					WithArgs(1).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(`SELECT p.id, p.first_name`).
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "first_name", "last_name", "type", "age", "courses", "version", "updated_at", "deleted_at"}))
+				mock.ExpectQuery(`UPDATE course SET deleted_at = now\(\)`).
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"version", "updated_at", "deleted_at"}).AddRow(2, testUpdatedAt, testUpdatedAt))
+				expectAudit(mock)
+				expectEviction(mock)
+				mock.ExpectCommit()
 			},
-			expectedCode: http.StatusNoContent,
+			expectedCode: http.StatusOK,
+			expectedBody: map[string]interface{}{"mode": "restrict", "affected": float64(0), "moved": float64(0)},
+		},
+		{
+			name:         "unknown mode",
+			courseID:     "1?mode=orphan",
+			ifMatch:      "*",
+			mockSetup:    func(mock sqlmock.Sqlmock) {},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "reassign without a course",
+			courseID:     "1?mode=reassign",
+			ifMatch:      "*",
+			mockSetup:    func(mock sqlmock.Sqlmock) {},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "invalid course to reassign to",
+			courseID:     "1?mode=reassign&to=abc",
+			ifMatch:      "*",
+			mockSetup:    func(mock sqlmock.Sqlmock) {},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "missing If-Match",
+			courseID:     "1",
+			mockSetup:    func(mock sqlmock.Sqlmock) {},
+			expectedCode: http.StatusPreconditionRequired,
 		},
 		{
 			name:     "invalid id",
 			courseID: "invalid",
+			ifMatch:  "*",
 			mockSetup: func(mock sqlmock.Sqlmock) {},
 			expectedCode: http.StatusBadRequest,
 		},
 		{
 			name:     "database error",
 			courseID: "1",
+			ifMatch:  "*",
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectExec(`DELETE FROM "course" WHERE id = \$1`).
-					WithArgs(1).
-					WillReturnError(sql.ErrConnDone)
+				mock.ExpectBegin().WillReturnError(sql.ErrConnDone)
 			},
 			expectedCode: http.StatusInternalServerError,
 		},
@@ -365,10 +515,13 @@ func TestHandleDeleteCourse(t *testing.T) {
 
 			// Create a new router to properly handle URL parameters
 			router := chi.NewRouter()
-			router.Delete("/{id}", HandleDeleteCourse(db))
+			router.Delete("/{id}", HandleDeleteCourse(services.NewPostgresStore(db)))
 
 			// Create request
 			req := httptest.NewRequest(http.MethodDelete, "/"+tt.courseID, nil)
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
 			rr := httptest.NewRecorder()
 
 			// Serve the request
@@ -377,18 +530,61 @@ func TestHandleDeleteCourse(t *testing.T) {
 			// Assert status code
 			assert.Equal(t, tt.expectedCode, rr.Code)
 
-			// For successful deletion, verify response body contains success message
-			if tt.expectedCode == http.StatusNoContent {
+			if tt.expectedBody != nil {
 				var got map[string]interface{}
-				err := json.NewDecoder(rr.Body).Decode(&got)
-				if err == nil {
-					assert.Equal(t, "Course deleted", got["message"])
-				}
+				assert.NoError(t, json.NewDecoder(rr.Body).Decode(&got))
+				assert.Equal(t, tt.expectedBody, got)
 			}
+			assert.NoError(t, mock.ExpectationsWereMet())
 		})
 	}
 }
 
+func TestHandleDeleteCourseModes(t *testing.T) {
+	ctx := context.Background()
+	store := services.NewMemoryStore()
+	for _, name := range []string{"Math", "Science"} {
+		_, err := store.CreateCourse(ctx, models.Course{Name: name})
+		assert.NoError(t, err)
+	}
+	_, err := store.CreatePerson(ctx, models.Person{FirstName: "Ada", LastName: "Lovelace", Type: "professor", Age: 36, Courses: []int{1}})
+	assert.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Delete("/course/{id}", HandleDeleteCourse(store))
+	serve := func(url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodDelete, url, nil)
+		req.Header.Set("If-Match", "*")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	decode := func(rr *httptest.ResponseRecorder) map[string]interface{} {
+		var got map[string]interface{}
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&got))
+		return got
+	}
+
+	// the conflict lists the enrollments that are in the way
+	rr := serve("/course/1")
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Equal(t, []interface{}{map[string]interface{}{"personId": float64(1), "courseId": float64(1)}}, decode(rr)["enrollments"])
+
+	rr = serve("/course/1?mode=reassign&to=9")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = serve("/course/1?mode=reassign&to=2")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, map[string]interface{}{"mode": "reassign", "affected": float64(1), "moved": float64(1)}, decode(rr))
+
+	rr = serve("/course/2?mode=cascade")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, map[string]interface{}{"mode": "cascade", "affected": float64(1), "moved": float64(0)}, decode(rr))
+	person, err := store.GetPersonByID(ctx, 1)
+	assert.NoError(t, err)
+	assert.Empty(t, person.Courses)
+}
+
 func TestHandleGetAllPeople(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -409,17 +605,12 @@ func TestHandleGetAllPeople(t *testing.T) {
 			queryName: "",
 			queryAge:  "",
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "type", "age"}).
-					AddRow(1, "John", "Doe", "student", 20)
-				mock.ExpectQuery("SELECT \\* FROM person").
-					WithArgs("", 0).
+				expectLastModified(mock, "person")
+				rows := sqlmock.NewRows(personColumns).
+					AddRow(1, "John", "Doe", "student", 20, "{1}", 1, testUpdatedAt, nil)
+				mock.ExpectQuery("SELECT p.id, p.first_name, p.last_name, p.type, p.age").
+					WithArgs(nil).
 					WillReturnRows(rows)
-				
-				courseRows := sqlmock.NewRows([]string{"id", "name"}).
-					AddRow(1, "Math")
-				mock.ExpectQuery("SELECT c.id, c.name FROM").
-					WithArgs(1).
-					WillReturnRows(courseRows)
 			},
 			wantStatus: http.StatusOK,
 			wantBody: []models.Person{
@@ -434,17 +625,12 @@ func TestHandleGetAllPeople(t *testing.T) {
 			queryName: "John",
 			queryAge:  "",
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "type", "age"}).
-					AddRow(1, "John", "Doe", "student", 20)
-				mock.ExpectQuery("SELECT \\* FROM person").
-					WithArgs("John", 0).
+				expectLastModified(mock, "person")
+				rows := sqlmock.NewRows(personColumns).
+					AddRow(1, "John", "Doe", "student", 20, "{1}", 1, testUpdatedAt, nil)
+				mock.ExpectQuery("SELECT p.id, p.first_name, p.last_name, p.type, p.age").
+					WithArgs("John", nil).
 					WillReturnRows(rows)
-				
-				courseRows := sqlmock.NewRows([]string{"id", "name"}).
-					AddRow(1, "Math")
-				mock.ExpectQuery("SELECT c.id, c.name FROM").
-					WithArgs(1).
-					WillReturnRows(courseRows)
 			},
 			wantStatus: http.StatusOK,
 			wantBody: []models.Person{
@@ -463,15 +649,18 @@ func TestHandleGetAllPeople(t *testing.T) {
 			req := httptest.NewRequest("GET", "/people?name="+tt.queryName+"&age="+tt.queryAge, nil)
 			w := httptest.NewRecorder()
 
-			handler := HandleGetAllPeople(db)
+			handler := HandleGetAllPeople(services.NewPostgresStore(db), PageLimits{})
 			handler.ServeHTTP(w, req)
 
 			assert.Equal(t, tt.wantStatus, w.Code)
 
 			if tt.wantStatus == http.StatusOK {
-				var got []map[string]interface{}
-				err := json.NewDecoder(w.Body).Decode(&got)
+				var page struct {
+					Data []map[string]interface{} `json:"data"`
+				}
+				err := json.NewDecoder(w.Body).Decode(&page)
 				assert.NoError(t, err)
+				got := page.Data
 				
 				// Convert wantBody to same format as response
 				want := make([]map[string]interface{}, len(tt.wantBody))
@@ -504,7 +693,7 @@ func TestHandleGetAllPeople(t *testing.T) {
 	}
 }
 
-func TestHandleGetPersonByName(t *testing.T) {
+func TestHandleGetPersonByID(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("Failed to create mock DB: %v", err)
@@ -513,26 +702,18 @@ func TestHandleGetPersonByName(t *testing.T) {
 
 	tests := []struct {
 		name       string
-		personName string
+		personID   string
 		mockSetup  func(sqlmock.Sqlmock)
 		wantStatus int
 		wantBody   *models.Person
 	}{
 		{
-			name:       "Success",
-			personName: "John",
+			name:     "Success",
+			personID: "1",
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "type", "age"}).
-					AddRow(1, "John", "Doe", "student", 20)
-				mock.ExpectQuery("SELECT \\* FROM person WHERE").
-					WithArgs("John").
-					WillReturnRows(rows)
-				
-				courseRows := sqlmock.NewRows([]string{"id", "name"}).
-					AddRow(1, "Math")
-				mock.ExpectQuery("SELECT c.id, c.name FROM").
+				mock.ExpectQuery("SELECT p.id, p.first_name").
 					WithArgs(1).
-					WillReturnRows(courseRows)
+					WillReturnRows(sqlmock.NewRows(personColumns).AddRow(1, "John", "Doe", "student", 20, "{1}", 1, testUpdatedAt, nil))
 			},
 			wantStatus: http.StatusOK,
 			wantBody: &models.Person{
@@ -541,15 +722,20 @@ func TestHandleGetPersonByName(t *testing.T) {
 			},
 		},
 		{
-			name:       "Person Not Found",
-			personName: "NonExistent",
+			name:     "Person Not Found",
+			personID: "99",
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery("SELECT \\* FROM person WHERE").
-					WithArgs("NonExistent").
-					WillReturnError(sql.ErrNoRows)
+				mock.ExpectQuery("SELECT p.id, p.first_name").
+					WithArgs(99).
+					WillReturnRows(sqlmock.NewRows(personColumns))
 			},
-			wantStatus: http.StatusOK,
-			wantBody:   nil,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "Invalid ID",
+			personID:   "John",
+			mockSetup:  func(mock sqlmock.Sqlmock) {},
+			wantStatus: http.StatusBadRequest,
 		},
 	}
 
@@ -558,91 +744,98 @@ func TestHandleGetPersonByName(t *testing.T) {
 			tt.mockSetup(mock)
 
 			router := chi.NewRouter()
-			router.Get("/people/{name}", HandleGetPersonByName(db))
+			router.Get("/people/{id}", HandleGetPersonByID(services.NewPostgresStore(db)))
 
-			req := httptest.NewRequest("GET", "/people/"+tt.personName, nil)
+			req := httptest.NewRequest("GET", "/people/"+tt.personID, nil)
 			w := httptest.NewRecorder()
 
 			router.ServeHTTP(w, req)
 
 			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
 
-			if tt.wantStatus == http.StatusOK && tt.wantBody != nil {
-				var got map[string]interface{}
-				err := json.NewDecoder(w.Body).Decode(&got)
-				assert.NoError(t, err)
+			if tt.wantBody != nil {
+				var got models.Person
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&got))
+				assert.Equal(t, *tt.wantBody, got)
+			}
+		})
+	}
+}
 
-				// Convert numeric values to float64 in the expected output
-				want := map[string]interface{}{
-					"id":        float64(tt.wantBody.ID),
-					"firstName": tt.wantBody.FirstName,
-					"lastName":  tt.wantBody.LastName,
-					"type":      tt.wantBody.Type,
-					"age":       float64(tt.wantBody.Age),
-					"courses":   tt.wantBody.Courses,
-				}
+func TestHandleGetPersonByName(t *testing.T) {
+	ctx := context.Background()
+	store := services.NewMemoryStore()
+	for _, person := range []models.Person{
+		{FirstName: "Steve", LastName: "Jobs", Type: "professor", Age: 56},
+		{FirstName: "Steve", LastName: "Jobs", Type: "student", Age: 19},
+		{FirstName: "Steve", LastName: "Wozniak", Type: "professor", Age: 74},
+	} {
+		_, err := store.CreatePerson(ctx, person)
+		assert.NoError(t, err)
+	}
 
-				// Convert courses to []int if present
-				if courses, ok := got["courses"].([]interface{}); ok {
-					intCourses := make([]int, len(courses))
-					for i, c := range courses {
-						if fNum, ok := c.(float64); ok {
-							intCourses[i] = int(fNum)
-						}
-					}
-					got["courses"] = intCourses
-				}
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantIDs    []int
+	}{
+		{name: "Unique", path: "/Steve/Wozniak", wantStatus: http.StatusOK, wantIDs: []int{3}},
+		{name: "Ambiguous", path: "/Steve/Jobs", wantStatus: http.StatusConflict, wantIDs: []int{1, 2}},
+		{name: "Not Found", path: "/Steve/Ballmer", wantStatus: http.StatusNotFound},
+	}
 
-				assert.Equal(t, want, got)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := chi.NewRouter()
+			router.Get("/people/by-name/{first}/{last}", HandleGetPersonByName(store))
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/people/by-name"+tt.path, nil))
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			switch tt.wantStatus {
+			case http.StatusOK:
+				var got models.Person
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&got))
+				assert.Equal(t, tt.wantIDs, []int{got.ID})
+			case http.StatusConflict:
+				var got struct {
+					Candidates []models.Person `json:"candidates"`
+				}
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&got))
+				var ids []int
+				for _, candidate := range got.Candidates {
+					ids = append(ids, candidate.ID)
+				}
+				assert.Equal(t, tt.wantIDs, ids)
 			}
 		})
 	}
 }
 
-func TestHandleUpdatePersonByName(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("Failed to create mock DB: %v", err)
-	}
-	defer db.Close()
-
+func TestHandleUpdatePerson(t *testing.T) {
 	tests := []struct {
 		name       string
-		urlName    string
-		person     models.Person
-		mockSetup  func(sqlmock.Sqlmock)
+		urlID      string
+		ifMatch    string
+		person     v1.PersonRequest
 		wantStatus int
 		wantBody   map[string]interface{}
+		wantStored *models.Person
 	}{
 		{
 			name:    "Success",
-			urlName: "John",
-			person: models.Person{
+			urlID:   "1",
+			ifMatch: `"1"`,
+			person: v1.PersonRequest{
 				FirstName: "John",
 				LastName:  "Smith",
 				Type:     "student",
 				Age:      21,
 				Courses:  []int{1, 2},
 			},
-			mockSetup: func(mock sqlmock.Sqlmock) {
-				// First query to find the person
-				mock.ExpectQuery(`SELECT (.+) FROM person WHERE first_name = \$1`).
-					WithArgs("John").
-					WillReturnRows(sqlmock.NewRows([]string{"id", "first_name", "last_name", "type", "age"}).
-						AddRow(1, "John", "Doe", "student", 20))
-
-				// Then expect the update
-				mock.ExpectExec(`UPDATE person SET first_name = \$1, last_name = \$2, type = \$3, age = \$4 WHERE first_name = \$5`).
-					WithArgs("John", "Smith", "student", 21, "John").
-					WillReturnResult(sqlmock.NewResult(1, 1))
-
-				// Expect queries for adding courses
-				for _, courseID := range []int{1, 2} {
-					mock.ExpectExec(`INSERT INTO person_course \(person_id, course_id\) VALUES \(\$1, \$2\)`).
-						WithArgs(1, courseID).
-						WillReturnResult(sqlmock.NewResult(1, 1))
-				}
-			},
 			wantStatus: http.StatusOK,
 			wantBody: map[string]interface{}{
 				"id":        float64(1),
@@ -652,45 +845,94 @@ func TestHandleUpdatePersonByName(t *testing.T) {
 				"age":       float64(21),
 				"courses":   []interface{}{float64(1), float64(2)},
 			},
+			// the update and the new enrollment are one change
+			wantStored: &models.Person{
+				ID: 1, FirstName: "John", LastName: "Smith",
+				Type: "student", Age: 21, Courses: []int{1, 2}, Version: 2,
+			},
+		},
+		{
+			name:    "Stale Version",
+			urlID:   "1",
+			ifMatch: `"7"`,
+			person: v1.PersonRequest{
+				FirstName: "John",
+				LastName:  "Smith",
+				Type:     "student",
+				Age:      21,
+			},
+			wantStatus: http.StatusPreconditionFailed,
+			wantStored: &models.Person{
+				ID: 1, FirstName: "John", LastName: "Doe",
+				Type: "student", Age: 20, Courses: []int{1}, Version: 1,
+			},
+		},
+		{
+			name:  "Missing If-Match",
+			urlID: "1",
+			person: v1.PersonRequest{
+				FirstName: "John",
+				LastName:  "Smith",
+				Type:     "student",
+				Age:      21,
+			},
+			wantStatus: http.StatusPreconditionRequired,
 		},
 		{
 			name:    "Person Not Found",
-			urlName: "NonExistent",
-			person: models.Person{
+			urlID:   "99",
+			ifMatch: "*",
+			person: v1.PersonRequest{
 				FirstName: "NonExistent",
 				LastName:  "Person",
 				Type:     "student",
 				Age:      20,
 			},
-			mockSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery(`SELECT (.+) FROM person WHERE first_name = \$1`).
-					WithArgs("NonExistent").
-					WillReturnError(sql.ErrNoRows)
-			},
 			wantStatus: http.StatusNotFound,
 			wantBody:   nil,
 		},
 		{
-			name:    "Invalid Input - Empty Name",
-			urlName: "",
-			person: models.Person{
-				FirstName: "",
+			name:    "Unknown Course",
+			urlID:   "1",
+			ifMatch: "*",
+			person: v1.PersonRequest{
+				FirstName: "John",
+				LastName:  "Doe",
+				Type:     "student",
+				Age:      20,
+				Courses:  []int{9},
+			},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   nil,
+		},
+		{
+			name:    "Invalid Input - Not An ID",
+			urlID:   "John",
+			ifMatch: "*",
+			person: v1.PersonRequest{
+				FirstName: "John",
 				LastName:  "Smith",
 				Type:     "student",
 				Age:      21,
 			},
-			mockSetup: func(mock sqlmock.Sqlmock) {
-				// No DB expectations needed for invalid input
-			},
-			wantStatus: http.StatusNotFound,
+			wantStatus: http.StatusBadRequest,
 			wantBody:   nil,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// Setup mock
-			tt.mockSetup(mock)
+			// Seed a store with one person already enrolled in course 1
+			ctx := context.Background()
+			store := services.NewMemoryStore()
+			for _, name := range []string{"Math", "Science"} {
+				_, err := store.CreateCourse(ctx, models.Course{Name: name})
+				assert.NoError(t, err)
+			}
+			_, err := store.CreatePerson(ctx, models.Person{
+				FirstName: "John", LastName: "Doe", Type: "student", Age: 20, Courses: []int{1},
+			})
+			assert.NoError(t, err)
 
 			// Create request body
 			jsonBody, err := json.Marshal(tt.person)
@@ -699,24 +941,28 @@ func TestHandleUpdatePersonByName(t *testing.T) {
 			}
 
 			// Create request with chi context
-			req := httptest.NewRequest("PUT", "/person/"+tt.urlName, bytes.NewBuffer(jsonBody))
+			req := httptest.NewRequest("PUT", "/person/"+tt.urlID, bytes.NewBuffer(jsonBody))
 			req.Header.Set("Content-Type", "application/json")
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
 			w := httptest.NewRecorder()
 
 			// Create chi router and context
 			r := chi.NewRouter()
-			r.Put("/person/{name}", HandleUpdatePersonByName(db))
+			r.Put("/person/{id}", HandleUpdatePerson(store))
 			r.ServeHTTP(w, req)
 
 			// Check status code
 			if w.Code != tt.wantStatus {
-				t.Errorf("HandleUpdatePersonByName() status = %v, want %v", w.Code, tt.wantStatus)
+				t.Errorf("HandleUpdatePerson() status = %v, want %v", w.Code, tt.wantStatus)
 				t.Errorf("Response body: %s", w.Body.String())
 				return
 			}
 
-			// For successful cases, check response body
+			// For successful cases, check response body and that its tag is the stored version
 			if tt.wantStatus == http.StatusOK {
+				assert.Equal(t, fmt.Sprintf(`"%d"`, tt.wantStored.Version), w.Header().Get("ETag"))
 				var gotBody map[string]interface{}
 				if err := json.NewDecoder(w.Body).Decode(&gotBody); err != nil {
 					t.Fatalf("Failed to decode response body: %v", err)
@@ -737,9 +983,12 @@ func TestHandleUpdatePersonByName(t *testing.T) {
 				}
 			}
 
-			// Ensure all expectations were met
-			if err := mock.ExpectationsWereMet(); err != nil {
-				t.Errorf("there were unfulfilled expectations: %s", err)
+			// Check what actually ended up in the store
+			if tt.wantStored != nil {
+				stored, err := store.GetPersonByID(ctx, tt.wantStored.ID)
+				assert.NoError(t, err)
+				stored.UpdatedAt = time.Time{}
+				assert.Equal(t, *tt.wantStored, stored)
 			}
 		})
 	}
@@ -754,14 +1003,14 @@ func TestHandleCreatePerson(t *testing.T) {
 
 	tests := []struct {
 		name       string
-		person     models.Person
+		person     v1.PersonRequest
 		mockSetup  func(sqlmock.Sqlmock)
 		wantStatus int
 		wantBody   map[string]interface{}
 	}{
 		{
 			name: "Success",
-			person: models.Person{
+			person: v1.PersonRequest{
 				FirstName: "John",
 				LastName:  "Doe",
 				Type:     "student",
@@ -769,14 +1018,23 @@ func TestHandleCreatePerson(t *testing.T) {
 				Courses:  []int{1},
 			},
 			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT DISTINCT ids\.id\s+FROM unnest`).
+					WithArgs(pq.Int64Array{1}).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
 				mock.ExpectBegin()
-				rows := sqlmock.NewRows([]string{"id"}).AddRow(1)
+				mock.ExpectQuery(`SELECT DISTINCT ids\.id\s+FROM unnest`).
+					WithArgs(pq.Int64Array{1}).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				rows := sqlmock.NewRows([]string{"id", "version", "updated_at"}).AddRow(1, 1, testUpdatedAt)
 				mock.ExpectQuery("INSERT INTO person").
 					WithArgs("John", "Doe", "student", 20).
 					WillReturnRows(rows)
 				mock.ExpectExec("INSERT INTO person_course").
 					WithArgs(1, 1).
 					WillReturnResult(sqlmock.NewResult(1, 1))
+				expectHistory(mock)
+				expectAudit(mock)
+				expectEviction(mock)
 				mock.ExpectCommit()
 			},
 			wantStatus: http.StatusCreated,
@@ -808,7 +1066,7 @@ func TestHandleCreatePerson(t *testing.T) {
 			w := httptest.NewRecorder()
 
 			// Handle request
-			handler := HandleCreatePerson(db)
+			handler := HandleCreatePerson(services.NewPostgresStore(db), services.NewPostgresStore(db))
 			handler.ServeHTTP(w, r)
 
 			// Check status code
@@ -867,7 +1125,7 @@ func spewTypes(m map[string]interface{}) map[string]string {
 	}
 	return result
 }
-func TestHandleDeletePersonByName(t *testing.T) {
+func TestHandleDeletePerson(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("Failed to create mock DB: %v", err)
@@ -876,43 +1134,50 @@ func TestHandleDeletePersonByName(t *testing.T) {
 
 	tests := []struct {
 		name       string
-		personName string
+		personID   string
 		mockSetup  func(sqlmock.Sqlmock)
 		wantStatus int
 		wantBody   string
 	}{
 		{
-			name:       "Success",
-			personName: "John",
+			name:     "Success",
+			personID: "1",
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				// Expect query to get person ID
-				mock.ExpectQuery("SELECT id FROM person WHERE").
-					WithArgs("John").
+				// the person is soft deleted, their enrollments stay
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT id FROM person WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`).
+					WithArgs(1).
 					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
-				
-				// Expect delete from person_course
-				mock.ExpectExec("DELETE FROM person_course").
+				mock.ExpectQuery("SELECT p.id, p.first_name").
 					WithArgs(1).
-					WillReturnResult(sqlmock.NewResult(1, 1))
-				
-				// Expect delete from person
-				mock.ExpectExec("DELETE FROM person WHERE").
-					WithArgs("John").
-					WillReturnResult(sqlmock.NewResult(1, 1))
+					WillReturnRows(sqlmock.NewRows(personColumns).AddRow(1, "John", "Doe", "student", 20, "{1}", 1, testUpdatedAt, nil))
+				mock.ExpectQuery(`UPDATE person SET deleted_at = now\(\)`).
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"version", "updated_at", "deleted_at"}).AddRow(2, testUpdatedAt, testUpdatedAt))
+				expectHistory(mock)
+				expectAudit(mock)
+				expectEviction(mock)
+				mock.ExpectCommit()
 			},
 			wantStatus: http.StatusNoContent,
-			wantBody:   `{"message": "Person deleted"}`,
 		},
 		{
-			name:       "Person Not Found",
-			personName: "NonExistent",
+			name:     "Person Not Found",
+			personID: "99",
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery("SELECT id FROM person WHERE").
-					WithArgs("NonExistent").
-					WillReturnError(sql.ErrNoRows)
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT id FROM person WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`).
+					WithArgs(99).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectRollback()
 			},
-			wantStatus: http.StatusInternalServerError,
-			wantBody:   "",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "Invalid ID",
+			personID:   "John",
+			mockSetup:  func(mock sqlmock.Sqlmock) {},
+			wantStatus: http.StatusBadRequest,
 		},
 	}
 
@@ -921,21 +1186,641 @@ func TestHandleDeletePersonByName(t *testing.T) {
 			tt.mockSetup(mock)
 
 			router := chi.NewRouter()
-			router.Delete("/people/{name}", HandleDeletePersonByName(db))
+			router.Delete("/people/{id}", HandleDeletePerson(services.NewPostgresStore(db)))
 
-			req := httptest.NewRequest("DELETE", "/people/"+tt.personName, nil)
+			req := httptest.NewRequest("DELETE", "/people/"+tt.personID, nil)
+			req.Header.Set("If-Match", "*")
 			w := httptest.NewRecorder()
 
 			router.ServeHTTP(w, req)
 
 			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
 
 			if tt.wantStatus == http.StatusNoContent {
-				// Trim any whitespace from the response body for comparison
-				gotBody := strings.TrimSpace(w.Body.String())
-				wantBody := strings.TrimSpace(tt.wantBody)
-				assert.Equal(t, wantBody, gotBody)
+				// a 204 has no body
+				assert.Empty(t, w.Body.String())
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestHandleSoftDelete(t *testing.T) {
+	ctx := context.Background()
+	store := services.NewMemoryStore()
+	course, err := store.CreateCourse(ctx, models.Course{Name: "Math"})
+	assert.NoError(t, err)
+	person, err := store.CreatePerson(ctx, models.Person{FirstName: "Ada", LastName: "Lovelace", Type: "professor", Age: 36, Courses: []int{course.ID}})
+	assert.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Get("/person", HandleGetAllPeople(store, PageLimits{Default: 10, Max: 10}))
+	router.Delete("/person/{id}", HandleDeletePerson(store))
+	router.Post("/person/{id}/restore", HandleRestorePerson(store))
+	router.Get("/course", HandleGetAllCourses(store, PageLimits{Default: 10, Max: 10}))
+	router.Delete("/course/{id}", HandleDeleteCourse(store))
+	router.Post("/course/{id}/restore", HandleRestoreCourse(store))
+	serve := func(method, url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		req.Header.Set("If-Match", "*")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	type list struct {
+		Data []map[string]interface{} `json:"data"`
+	}
+	decode := func(rr *httptest.ResponseRecorder) list {
+		var got list
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&got))
+		return got
+	}
+
+	assert.Equal(t, http.StatusNoContent, serve(http.MethodDelete, "/person/1").Code)
+	assert.Equal(t, http.StatusOK, serve(http.MethodDelete, "/course/1").Code)
+
+	// deleted rows are hidden unless asked for, and then carry their deletion time
+	assert.Empty(t, decode(serve(http.MethodGet, "/person")).Data)
+	people := decode(serve(http.MethodGet, "/person?include_deleted=true")).Data
+	if assert.Len(t, people, 1) {
+		assert.Contains(t, people[0], "deletedAt")
+		assert.Equal(t, []interface{}{}, people[0]["courses"])
+	}
+	assert.Empty(t, decode(serve(http.MethodGet, "/course")).Data)
+	assert.Len(t, decode(serve(http.MethodGet, "/course?include_deleted=true")).Data, 1)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodGet, "/course?include_deleted=maybe").Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodGet, "/person?include_deleted=maybe").Code)
+
+	// restoring both brings the enrollment back
+	rr := serve(http.MethodPost, "/course/1/restore")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("ETag"))
+	rr = serve(http.MethodPost, "/person/1/restore")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var restored v1.Person
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&restored))
+	assert.Equal(t, v1.Person{ID: person.ID, FirstName: "Ada", LastName: "Lovelace", Type: "professor", Age: 36, Courses: []int{course.ID}}, restored)
+
+	// only deleted rows can be restored
+	assert.Equal(t, http.StatusConflict, serve(http.MethodPost, "/person/1/restore").Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodPost, "/course/9/restore").Code)
+}
+
+func TestHandleGetAllCoursesContextDone(t *testing.T) {
+	tests := map[string]struct {
+		ctx          func() (context.Context, context.CancelFunc)
+		expectedCode int
+	}{
+		"deadline exceeded": {
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), -time.Second)
+			},
+			expectedCode: http.StatusGatewayTimeout,
+		},
+		"client cancelled": {
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx, cancel
+			},
+			expectedCode: http.StatusServiceUnavailable,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := tc.ctx()
+			defer cancel()
+
+			req := httptest.NewRequest("GET", "/courses", nil).WithContext(ctx)
+			rr := httptest.NewRecorder()
+
+			HandleGetAllCourses(services.NewMemoryStore(), PageLimits{}).ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.expectedCode, rr.Code)
+		})
+	}
+}
+
+func TestHandleGetAllCoursesPagination(t *testing.T) {
+	ctx := context.Background()
+	store := services.NewMemoryStore()
+	for _, name := range []string{"Programming", "Databases", "UI Design"} {
+		_, err := store.CreateCourse(ctx, models.Course{Name: name})
+		assert.NoError(t, err)
+	}
+
+	type page struct {
+		Data []map[string]interface{} `json:"data"`
+		Next *string                  `json:"next"`
+	}
+	get := func(url string) (*httptest.ResponseRecorder, page) {
+		rr := httptest.NewRecorder()
+		HandleGetAllCourses(store, PageLimits{Default: 2, Max: 2}).ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+		var got page
+		if rr.Code == http.StatusOK {
+			assert.NoError(t, json.NewDecoder(rr.Body).Decode(&got))
+		}
+		return rr, got
+	}
+
+	// the default page size applies without a limit
+	rr, first := get("/api/course")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Len(t, first.Data, 2)
+	if assert.NotNil(t, first.Next) {
+		assert.Equal(t, `</api/course?cursor=`+*first.Next+`&limit=2>; rel="next"`, rr.Header().Get("Link"))
+	}
+
+	// following the cursor gives the rest, with no further page
+	rr, second := get("/api/course?cursor=" + *first.Next)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, []map[string]interface{}{{"id": float64(3), "name": "UI Design"}}, second.Data)
+	assert.Nil(t, second.Next)
+	assert.Empty(t, rr.Header().Get("Link"))
+
+	// limits above the maximum are capped
+	_, capped := get("/api/course?limit=1000")
+	assert.Len(t, capped.Data, 2)
+
+	for _, url := range []string{"/api/course?limit=0", "/api/course?limit=abc", "/api/course?cursor=garbage"} {
+		rr, _ := get(url)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, url)
+	}
+}
+
+func TestHandleGetAllPeopleFilters(t *testing.T) {
+	ctx := context.Background()
+	store := services.NewMemoryStore()
+	_, err := store.CreateCourse(ctx, models.Course{Name: "Programming"})
+	assert.NoError(t, err)
+	for _, person := range []models.Person{
+		{FirstName: "Steve", LastName: "Jobs", Type: "professor", Age: 56, Courses: []int{1}},
+		{FirstName: "Larry", LastName: "Page", Type: "student", Age: 51},
+		{FirstName: "Bill", LastName: "Gates", Type: "student", Age: 67, Courses: []int{1}},
+	} {
+		_, err := store.CreatePerson(ctx, person)
+		assert.NoError(t, err)
+	}
+
+	tests := map[string]struct {
+		query        string
+		expectedCode int
+		expectedIDs  []float64
+		expectedBody string
+	}{
+		"type and course":   {query: "?type=student&course=1", expectedCode: http.StatusOK, expectedIDs: []float64{3}},
+		"age range sorted":  {query: "?age_gte=50&age_lte=60&sort=-age", expectedCode: http.StatusOK, expectedIDs: []float64{1, 2}},
+		"ignore case":       {query: "?last_name_prefix=g&ignore_case=true", expectedCode: http.StatusOK, expectedIDs: []float64{3}},
+		"legacy name":       {query: "?name=Larry", expectedCode: http.StatusOK, expectedIDs: []float64{2}},
+		"bad age":           {query: "?age_gte=old", expectedCode: http.StatusBadRequest, expectedBody: "invalid age_gte: must be an integer"},
+		"bad type":          {query: "?type=teacher", expectedCode: http.StatusBadRequest, expectedBody: "invalid type"},
+		"bad sort":          {query: "?sort=salary", expectedCode: http.StatusBadRequest, expectedBody: "invalid sort"},
+		"bad ignore case":   {query: "?ignore_case=maybe", expectedCode: http.StatusBadRequest, expectedBody: "invalid ignore_case"},
+		"conflicting names": {query: "?name=Bill&first_name=Steve", expectedCode: http.StatusBadRequest, expectedBody: "invalid first_name"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			HandleGetAllPeople(store, PageLimits{}).ServeHTTP(rr, httptest.NewRequest("GET", "/api/person"+tc.query, nil))
+
+			assert.Equal(t, tc.expectedCode, rr.Code)
+			if tc.expectedCode != http.StatusOK {
+				assert.Contains(t, rr.Body.String(), tc.expectedBody)
+				return
+			}
+
+			var page struct {
+				Data []map[string]interface{} `json:"data"`
+			}
+			assert.NoError(t, json.NewDecoder(rr.Body).Decode(&page))
+			var ids []float64
+			for _, person := range page.Data {
+				ids = append(ids, person["id"].(float64))
+			}
+			assert.Equal(t, tc.expectedIDs, ids)
+		})
+	}
+}
+
+func TestHandlePersonCourses(t *testing.T) {
+	ctx := context.Background()
+	store := services.NewMemoryStore()
+	for _, name := range []string{"Math", "Science", "History"} {
+		_, err := store.CreateCourse(ctx, models.Course{Name: name})
+		assert.NoError(t, err)
+	}
+	_, err := store.CreatePerson(ctx, models.Person{FirstName: "John", LastName: "Doe", Type: "student", Age: 20, Courses: []int{1}})
+	assert.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Get("/person/{id}/courses", HandleGetPersonCourses(store))
+	router.Post("/person/{id}/courses", HandleAddPersonCourses(store))
+	router.Put("/person/{id}/courses", HandleSetPersonCourses(store))
+	router.Delete("/person/{id}/courses", HandleRemovePersonCourses(store))
+
+	// the steps run in order against the same store
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		ifMatch    string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name: "List", method: "GET", path: "/person/1/courses",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"courses":[{"id":1,"name":"Math"}]}`,
+		},
+		{
+			name: "Add", method: "POST", path: "/person/1/courses", body: `{"courses":[1,2,9]}`, ifMatch: `"1"`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"results":[{"courseId":1,"status":"already_enrolled"},{"courseId":2,"status":"added"},{"courseId":9,"status":"course_not_found"}]}`,
+		},
+		{
+			name: "Remove", method: "DELETE", path: "/person/1/courses", body: `{"courses":[1,3]}`, ifMatch: `"2"`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"results":[{"courseId":1,"status":"removed"},{"courseId":3,"status":"not_enrolled"}]}`,
+		},
+		{
+			name: "Missing If-Match", method: "PUT", path: "/person/1/courses", body: `{"courses":[3]}`,
+			wantStatus: http.StatusPreconditionRequired,
+		},
+		{
+			name: "Stale If-Match", method: "PUT", path: "/person/1/courses", body: `{"courses":[3]}`, ifMatch: `"2"`,
+			wantStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name: "Replace", method: "PUT", path: "/person/1/courses", body: `{"courses":[3]}`, ifMatch: `"3"`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"results":[{"courseId":2,"status":"removed"},{"courseId":3,"status":"added"}]}`,
+		},
+		{
+			name: "List After Replace", method: "GET", path: "/person/1/courses",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"courses":[{"id":3,"name":"History"}]}`,
+		},
+		{
+			name: "Replace With Nothing", method: "PUT", path: "/person/1/courses", body: `{"courses":[]}`, ifMatch: "*",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"results":[{"courseId":3,"status":"removed"}]}`,
+		},
+		{
+			name: "Add Nothing", method: "POST", path: "/person/1/courses", body: `{"courses":[]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Missing Courses", method: "PUT", path: "/person/1/courses", body: `{}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Person Not Found", method: "POST", path: "/person/42/courses", body: `{"courses":[1]}`, ifMatch: "*",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "List Person Not Found", method: "GET", path: "/person/42/courses",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := jsonRequest(tt.method, tt.path, tt.body)
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantBody != "" {
+				assert.JSONEq(t, tt.wantBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandleGetCourseRoster(t *testing.T) {
+	ctx := context.Background()
+	store := services.NewMemoryStore()
+	for _, name := range []string{"Programming", "Databases"} {
+		_, err := store.CreateCourse(ctx, models.Course{Name: name})
+		assert.NoError(t, err)
+	}
+	for _, person := range []models.Person{
+		{FirstName: "Steve", LastName: "Jobs", Type: "professor", Age: 56, Courses: []int{1}},
+		{FirstName: "Larry", LastName: "Page", Type: "student", Age: 51, Courses: []int{1}},
+		{FirstName: "Bill", LastName: "Gates", Type: "student", Age: 67, Courses: []int{1}},
+	} {
+		_, err := store.CreatePerson(ctx, person)
+		assert.NoError(t, err)
+	}
+
+	router := chi.NewRouter()
+	router.Get("/course/{id}/people", HandleGetCourseRoster(store, PageLimits{}))
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantBody   string
+		wantNext   bool
+	}{
+		{
+			name: "Grouped", query: "/course/1/people",
+			wantStatus: http.StatusOK,
+			wantBody: `{"data":{
+				"professors":[{"id":1,"firstName":"Steve","lastName":"Jobs","type":"professor","age":56,"courses":[1]}],
+				"students":[{"id":2,"firstName":"Larry","lastName":"Page","type":"student","age":51,"courses":[1]},
+					{"id":3,"firstName":"Bill","lastName":"Gates","type":"student","age":67,"courses":[1]}],
+				"counts":{"professors":1,"students":2,"total":3}},"next":null}`,
+		},
+		{
+			name: "Students Only Paged", query: "/course/1/people?type=student&limit=1",
+			wantStatus: http.StatusOK,
+			wantBody: `{"data":{
+				"professors":[],
+				"students":[{"id":2,"firstName":"Larry","lastName":"Page","type":"student","age":51,"courses":[1]}],
+				"counts":{"professors":1,"students":2,"total":3}}}`,
+			wantNext: true,
+		},
+		{
+			name: "Empty Course", query: "/course/2/people",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"data":{"professors":[],"students":[],"counts":{"professors":0,"students":0,"total":0}},"next":null}`,
+		},
+		{name: "Course Not Found", query: "/course/9/people", wantStatus: http.StatusNotFound},
+		{name: "Invalid Type", query: "/course/1/people?type=teacher", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", tt.query, nil))
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if tt.wantNext {
+				var got map[string]interface{}
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+				assert.NotNil(t, got["next"])
+				assert.NotEmpty(t, w.Header().Get("Link"))
+				delete(got, "next")
+				body, _ := json.Marshal(got)
+				assert.JSONEq(t, tt.wantBody, string(body))
+				return
+			}
+			assert.JSONEq(t, tt.wantBody, w.Body.String())
+		})
+	}
+}
+
+func TestErrorProblems(t *testing.T) {
+	ctx := context.Background()
+	store := services.NewMemoryStore()
+	_, err := store.CreateCourse(ctx, models.Course{Name: "Math"})
+	assert.NoError(t, err)
+	_, err = store.CreatePerson(ctx, models.Person{FirstName: "John", LastName: "Doe", Type: "student", Age: 20, Courses: []int{1}})
+	assert.NoError(t, err)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+	mock.ExpectQuery(`SELECT id, name, version, updated_at FROM "course"`).WillReturnError(errors.New(`pq: relation "course" does not exist`))
+
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		method     string
+		path       string
+		body       string
+		ifMatch    string
+		wantStatus int
+		wantDetail string
+	}{
+		{
+			name: "Not Found", handler: HandleGetCourseByID(store), method: "GET", path: "/course/9",
+			wantStatus: http.StatusNotFound, wantDetail: "course 9 does not exist",
+		},
+		{
+			name: "Conflict", handler: HandleDeleteCourse(store), method: "DELETE", path: "/course/1", ifMatch: "*",
+			wantStatus: http.StatusConflict, wantDetail: "course 1 still has people enrolled, delete with mode cascade or reassign",
+		},
+		{
+			name: "Precondition Failed", handler: HandleDeleteCourse(store), method: "DELETE", path: "/course/1", ifMatch: `"5"`,
+			wantStatus: http.StatusPreconditionFailed, wantDetail: "course 1 was changed since it was read",
+		},
+		{
+			name: "Precondition Required", handler: HandleDeleteCourse(store), method: "DELETE", path: "/course/1",
+			wantStatus: http.StatusPreconditionRequired,
+			wantDetail: "send If-Match with the ETag of the version to change, or * to change any version",
+		},
+		{
+			name: "Validation", handler: HandleCreatePerson(store, store), method: "POST", path: "/person",
+			body:       `{"firstName":"Jane","lastName":"Doe","type":"teacher","age":30}`,
+			wantStatus: http.StatusBadRequest, wantDetail: "request body has invalid fields",
+		},
+		{
+			name: "Bad ID", handler: HandleGetCourseByID(store), method: "GET", path: "/course/abc",
+			wantStatus: http.StatusBadRequest, wantDetail: "id must be a positive integer",
+		},
+		{
+			name: "Driver Error Is Not Shown", handler: HandleGetCourseByID(services.NewPostgresStore(db)), method: "GET", path: "/course/1",
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := chi.NewRouter()
+			router.MethodFunc(tt.method, "/{kind}/{id}", tt.handler)
+			router.MethodFunc(tt.method, "/{kind}", tt.handler)
+
+			req := jsonRequest(tt.method, tt.path, tt.body)
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+
+			var problem map[string]interface{}
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&problem))
+			assert.Equal(t, "about:blank", problem["type"])
+			assert.Equal(t, http.StatusText(tt.wantStatus), problem["title"])
+			assert.Equal(t, float64(tt.wantStatus), problem["status"])
+			assert.Equal(t, tt.path, problem["instance"])
+			if tt.wantDetail == "" {
+				assert.NotContains(t, problem, "detail")
+			} else {
+				assert.Equal(t, tt.wantDetail, problem["detail"])
+			}
+		})
+	}
+}
+
+func TestHandleCreatePersonValidation(t *testing.T) {
+	store := services.NewMemoryStore()
+	_, err := store.CreateCourse(context.Background(), models.Course{Name: "Math"})
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	HandleCreatePerson(store, store).ServeHTTP(w, jsonRequest("POST", "/person",
+		`{"firstName":"","lastName":"D0e","type":"teacher","age":-1,"courses":[1,9,1]}`))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var problem v1.Problem
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&problem))
+	assert.Equal(t, []v1.FieldError{
+		{Field: "firstName", Message: "is required"},
+		{Field: "lastName", Message: "must only contain letters, spaces, hyphens, apostrophes and periods"},
+		{Field: "type", Message: "must be professor or student"},
+		{Field: "age", Message: "must be between 1 and 150"},
+		{Field: "courses.2", Message: "repeats course 1"},
+		{Field: "courses.1", Message: "course 9 does not exist"},
+	}, problem.Errors)
+
+	// nothing was created
+	people, _, err := store.GetAllPeople(context.Background(), services.PersonFilter{}, services.Page{})
+	assert.NoError(t, err)
+	assert.Empty(t, people)
+}
+
+// jsonRequest builds a request carrying body as JSON, or no body when it is empty
+func jsonRequest(method, path, body string) *http.Request {
+	if body == "" {
+		return httptest.NewRequest(method, path, nil)
+	}
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+// the columns the course and person queries select, and the updated_at their mocked rows hold
+var (
+	courseColumns     = []string{"id", "name", "version", "updated_at"}
+	courseListColumns = []string{"id", "name", "version", "updated_at", "deleted_at"}
+	personColumns     = []string{"id", "first_name", "last_name", "type", "age", "courses", "version", "updated_at", "deleted_at"}
+	testUpdatedAt     = time.Date(2024, 9, 1, 8, 30, 0, 0, time.UTC)
+)
+
+func TestHandleGetAuditLog(t *testing.T) {
+	ctx := services.WithActor(context.Background(), services.Actor{Name: "alice", RequestID: "req-1"})
+	store := services.NewMemoryStore()
+	course, err := store.CreateCourse(ctx, models.Course{Name: "Math"})
+	assert.NoError(t, err)
+	person, err := store.CreatePerson(ctx, models.Person{FirstName: "Ada", LastName: "Lovelace", Type: "professor", Age: 36})
+	assert.NoError(t, err)
+	_, err = store.AddPersonToCourse(ctx, person.ID, 0, []int{course.ID})
+	assert.NoError(t, err)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		HandleGetAuditLog(store, PageLimits{Default: 1, Max: 10}).ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+		return rr
+	}
+
+	rr := get(fmt.Sprintf("/api/audit?entity=person&id=%d&limit=10", person.ID))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var got struct {
+		Data []v1.AuditEntry `json:"data"`
+		Next *string         `json:"next"`
+	}
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&got))
+	if assert.Len(t, got.Data, 2) {
+		assert.Equal(t, []string{"create", "enrollment"}, []string{got.Data[0].Action, got.Data[1].Action})
+		assert.Equal(t, "alice", got.Data[1].Actor)
+		assert.Equal(t, "req-1", got.Data[1].RequestID)
+		assert.Equal(t, "null", string(got.Data[0].Before))
+	}
+	assert.Nil(t, got.Next)
+	assert.NotEmpty(t, rr.Header().Get("Last-Modified"))
+
+	// the default page size applies, with a link to the rest
+	rr = get("/api/audit")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Header().Get("Link"), `rel="next"`)
+
+	rr = get("/api/audit?since=" + time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"data":[],"next":null}`, rr.Body.String())
+
+	for _, url := range []string{"/api/audit?entity=enrollment", "/api/audit?id=3", "/api/audit?entity=person&id=abc", "/api/audit?since=yesterday"} {
+		assert.Equal(t, http.StatusBadRequest, get(url).Code, url)
+	}
+}
+
+func TestHandlePersonHistory(t *testing.T) {
+	ctx := context.Background()
+	store := services.NewMemoryStore()
+	course, err := store.CreateCourse(ctx, models.Course{Name: "Math"})
+	assert.NoError(t, err)
+	person, err := store.CreatePerson(ctx, models.Person{FirstName: "Ada", LastName: "Lovelace", Type: "professor", Age: 36})
+	assert.NoError(t, err)
+	created := time.Now().UTC()
+	time.Sleep(time.Millisecond)
+	_, err = store.AddPersonToCourse(ctx, person.ID, 0, []int{course.ID})
+	assert.NoError(t, err)
+	assert.NoError(t, store.DeletePerson(ctx, person.ID, 0))
+
+	router := chi.NewRouter()
+	router.Get("/person/{id}", HandleGetPersonByID(store))
+	router.Get("/person/{id}/history", HandleGetPersonHistory(store, PageLimits{Default: 10, Max: 10}))
+	get := func(url string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+		return rr
+	}
+
+	// the history is there after the delete
+	rr := get(fmt.Sprintf("/person/%d/history", person.ID))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var history struct {
+		Data []v1.PersonRevision `json:"data"`
+	}
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&history))
+	if assert.Len(t, history.Data, 3) {
+		assert.Equal(t, []int{}, history.Data[0].Person.Courses)
+		assert.Equal(t, []int{course.ID}, history.Data[1].Person.Courses)
+		assert.NotNil(t, history.Data[2].Person.DeletedAt)
+		assert.Nil(t, history.Data[2].ValidTo)
+	}
+
+	// before the enrollment the person had no courses, before the create there was no person
+	rr = get(fmt.Sprintf("/person/%d?as_of=%s", person.ID, created.Format(time.RFC3339Nano)))
+	if assert.Equal(t, http.StatusOK, rr.Code) {
+		assert.JSONEq(t, `{"id":1,"firstName":"Ada","lastName":"Lovelace","type":"professor","age":36,"courses":[]}`, rr.Body.String())
+		assert.Equal(t, `"1"`, rr.Header().Get("ETag"))
+	}
+	rr = get(fmt.Sprintf("/person/%d?as_of=%s", person.ID, created.Add(-time.Hour).Format(time.RFC3339)))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	assert.Equal(t, http.StatusNotFound, get(fmt.Sprintf("/person/%d", person.ID)).Code)
+	assert.Equal(t, http.StatusNotFound, get("/person/99/history").Code)
+	assert.Equal(t, http.StatusBadRequest, get(fmt.Sprintf("/person/%d?as_of=yesterday", person.ID)).Code)
+}
+
+func TestHandleCacheStats(t *testing.T) {
+	ctx := context.Background()
+	store := services.NewCachedStore(services.NewMemoryStore(), services.NewCache(time.Minute, 10))
+	course, err := store.CreateCourse(ctx, models.Course{Name: "Math"})
+	assert.NoError(t, err)
+	for range 2 {
+		_, err = store.GetCourseByID(ctx, course.ID)
+		assert.NoError(t, err)
+	}
+
+	rr := httptest.NewRecorder()
+	HandleCacheStats(store).ServeHTTP(rr, httptest.NewRequest("GET", "/debug/cache", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"hits": 1, "misses": 1, "loads": 1, "entries": 1}`, rr.Body.String())
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jacob-tech-challenge/api/services"
+)
+
+// idempotencyKeyHeader names the header a client sends to make a POST safe to retry
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength caps the keys clients may send, a UUID is 36 characters
+const maxIdempotencyKeyLength = 255
+
+// replayedHeaders are the response headers stored with a response and sent
+// again when it is replayed, the others belong to the request that is answered
+var replayedHeaders = []string{"Content-Type", "ETag", "Last-Modified", "Location"}
+
+// Idempotent lets clients retry the requests next handles without running them
+// twice. A request sent with an Idempotency-Key header claims the key of its
+// actor, and its response is stored under it for ttl. A retry with the same
+// key and the same method, path and body is answered with the stored response,
+// marked by an Idempotent-Replayed header. The same key with another request
+// is answered 422, and 409 while the first request is still running, for at
+// most lease: a claim that old without a response belongs to a request that
+// died, and the retry runs the request again. Server errors are not stored,
+// the key is released so the request can be run again.
+func Idempotent(keys services.IdempotencyStore, ttl, lease time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(idempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				writeError(w, r, &problemError{
+					status: http.StatusBadRequest,
+					detail: fmt.Sprintf("%s must not be longer than %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength),
+				})
+				return
+			}
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				writeError(w, r, bodyError(err))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			stored, err := keys.ClaimIdempotencyKey(r.Context(), key, fingerprint(r, body), ttl, lease)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			if stored != nil {
+				replay(w, *stored)
+				return
+			}
+
+			// the claim is settled even when the request timed out or the client went away
+			ctx := context.WithoutCancel(r.Context())
+			recorder := &recordingWriter{ResponseWriter: w}
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					releaseIdempotencyKey(ctx, keys, key)
+					panic(recovered)
+				}
+				if recorder.status == 0 || recorder.status >= http.StatusInternalServerError {
+					releaseIdempotencyKey(ctx, keys, key)
+					return
+				}
+				if err := keys.SaveIdempotentResponse(ctx, key, recorder.response()); err != nil {
+					log.Printf("Failed to store the response for idempotency key %q. err: %v", key, err)
+				}
+			}()
+			next.ServeHTTP(recorder, r)
+		})
+	}
+}
+
+// fingerprint identifies a request by its method, path and body
+func fingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// replay writes a stored response again
+func replay(w http.ResponseWriter, response services.IdempotentResponse) {
+	for name, values := range response.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(response.Status)
+	if _, err := w.Write(response.Body); err != nil {
+		log.Printf("Error writing replayed response: %v", err)
+	}
+}
+
+// releaseIdempotencyKey releases key, a failure is only logged as the key expires anyway
+func releaseIdempotencyKey(ctx context.Context, keys services.IdempotencyStore, key string) {
+	if err := keys.ReleaseIdempotencyKey(ctx, key); err != nil {
+		log.Printf("Failed to release idempotency key %q. err: %v", key, err)
+	}
+}
+
+// recordingWriter keeps a copy of the response it writes
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+		w.header = http.Header{}
+		for _, name := range replayedHeaders {
+			for _, value := range w.Header().Values(name) {
+				w.header.Add(name, value)
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *recordingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// response returns the recorded response
+func (w *recordingWriter) response() services.IdempotentResponse {
+	return services.IdempotentResponse{Status: w.status, Header: w.header, Body: w.body.Bytes()}
+}
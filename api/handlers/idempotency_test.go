@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jacob-tech-challenge/api/services"
+)
+
+func TestIdempotent(t *testing.T) {
+	ctx := context.Background()
+	store := services.NewMemoryStore()
+	create := Idempotent(store, time.Hour, time.Minute)(HandleCreateCourse(store))
+	sendAs := func(actor, key, body string) *httptest.ResponseRecorder {
+		req := jsonRequest(http.MethodPost, "/api/course", body)
+		req = req.WithContext(services.WithActor(req.Context(), services.Actor{Name: actor}))
+		if key != "" {
+			req.Header.Set(idempotencyKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		create.ServeHTTP(w, req)
+		return w
+	}
+	send := func(key, body string) *httptest.ResponseRecorder {
+		return sendAs("alice", key, body)
+	}
+
+	first := send("key-1", `{"name": "Math"}`)
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+
+	// a retry is answered with the first response and creates nothing
+	retry := send("key-1", `{"name": "Math"}`)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, first.Header().Get("ETag"), retry.Header().Get("ETag"))
+	assert.Equal(t, first.Header().Get("Content-Type"), retry.Header().Get("Content-Type"))
+	assert.JSONEq(t, first.Body.String(), retry.Body.String())
+
+	// the same key with another body
+	reused := send("key-1", `{"name": "Art"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, reused.Code)
+	assert.Equal(t, problemContentType, reused.Header().Get("Content-Type"))
+
+	// errors a client makes are replayed too
+	invalid := send("key-2", `{"name": ""}`)
+	assert.Equal(t, http.StatusBadRequest, invalid.Code)
+	assert.Equal(t, http.StatusBadRequest, send("key-2", `{"name": ""}`).Code)
+
+	// another actor's key of the same name is another key
+	other := sendAs("bob", "key-1", `{"name": "Art"}`)
+	assert.Equal(t, http.StatusCreated, other.Code)
+	assert.Empty(t, other.Header().Get("Idempotent-Replayed"))
+
+	// requests without a key are not deduplicated
+	assert.Equal(t, http.StatusCreated, send("", `{"name": "Math"}`).Code)
+	assert.Equal(t, http.StatusCreated, send("", `{"name": "Math"}`).Code)
+
+	tooLong := make([]byte, maxIdempotencyKeyLength+1)
+	for i := range tooLong {
+		tooLong[i] = 'k'
+	}
+	assert.Equal(t, http.StatusBadRequest, send(string(tooLong), `{"name": "Math"}`).Code)
+
+	courses, _, err := store.GetAllCourses(ctx, services.CourseFilter{}, services.Page{})
+	assert.NoError(t, err)
+	assert.Len(t, courses, 4)
+}
+
+func TestIdempotentAnonymous(t *testing.T) {
+	store := services.NewMemoryStore()
+	create := Idempotent(store, time.Hour, time.Minute)(HandleCreateCourse(store))
+	sendFrom := func(remote, body string) *httptest.ResponseRecorder {
+		req := jsonRequest(http.MethodPost, "/api/course", body)
+		req = req.WithContext(services.WithActor(req.Context(), services.Actor{Remote: remote}))
+		req.Header.Set(idempotencyKeyHeader, "key-1")
+		w := httptest.NewRecorder()
+		create.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusCreated, sendFrom("203.0.113.5", `{"name": "Math"}`).Code)
+	retry := sendFrom("203.0.113.5", `{"name": "Math"}`)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+
+	// another anonymous client with the same key neither sees the response nor is refused
+	other := sendFrom("198.51.100.7", `{"name": "Art"}`)
+	assert.Equal(t, http.StatusCreated, other.Code)
+	assert.Empty(t, other.Header().Get("Idempotent-Replayed"))
+	var course struct{ Name string }
+	assert.NoError(t, json.Unmarshal(other.Body.Bytes(), &course))
+	assert.Equal(t, "Art", course.Name)
+}
+
+func TestIdempotentServerError(t *testing.T) {
+	store := services.NewMemoryStore()
+	failures := 1
+	handler := Idempotent(store, time.Hour, time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	send := func() int {
+		req := jsonRequest(http.MethodPost, "/api/course", `{"name": "Math"}`)
+		req.Header.Set(idempotencyKeyHeader, "key-1")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// a server error releases the key, so the retry runs the request again
+	assert.Equal(t, http.StatusInternalServerError, send())
+	assert.Equal(t, http.StatusCreated, send())
+	assert.Equal(t, http.StatusCreated, send())
+	assert.Equal(t, 0, failures)
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	v1 "github.com/jacob-tech-challenge/api/dto/v1"
+	"github.com/jacob-tech-challenge/api/services"
+)
+
+// PageLimits bounds the page size of list endpoints. Default is used when the
+// client does not send a limit, and larger limits are capped at Max.
+type PageLimits struct {
+	Default int
+	Max     int
+}
+
+// parsePage reads the limit and cursor query parameters
+func parsePage(r *http.Request, limits PageLimits) (services.Page, error) {
+	page := services.Page{Limit: limits.Default}
+
+	if limitString := r.URL.Query().Get("limit"); limitString != "" {
+		limit, err := strconv.Atoi(limitString)
+		if err != nil || limit < 1 {
+			return services.Page{}, &services.FilterError{Param: "limit", Reason: "must be a positive integer"}
+		}
+		page.Limit = limit
+	}
+	if limits.Max > 0 && page.Limit > limits.Max {
+		page.Limit = limits.Max
+	}
+
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		after, err := services.DecodeCursor(cursor)
+		if err != nil {
+			return services.Page{}, err
+		}
+		page.After = after
+	}
+	return page, nil
+}
+
+// page is one page of a list as handlers return it. Its Link header points at
+// the next page, keeping the other query parameters of the request. Its
+// Last-Modified must never go back while the page changes, so it is the last
+// write to the whole list, or the latest item of a list nothing leaves.
+type page[T any] struct {
+	v1.Page[T]
+	link     string
+	modified time.Time
+}
+
+// newPage builds the page holding data, next is the position after its last item or nil on the last page
+func newPage[T any](r *http.Request, data T, limit int, next *services.Cursor) page[T] {
+	p := page[T]{Page: v1.Page[T]{Data: data}}
+	if next != nil {
+		cursor := next.Encode()
+		p.Next = &cursor
+
+		query := r.URL.Query()
+		query.Set("cursor", cursor)
+		query.Set("limit", strconv.Itoa(limit))
+		p.link = fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, query.Encode())
+	}
+	return p
+}
+
+// lastModified moves the Last-Modified of the page up to updatedAt, if it is later
+func (p *page[T]) lastModified(updatedAt time.Time) {
+	if updatedAt.After(p.modified) {
+		p.modified = updatedAt
+	}
+}
+
+func (p page[T]) setHeaders(h http.Header) {
+	if p.link != "" {
+		h.Set("Link", p.link)
+	}
+	setLastModified(h, p.modified)
+}
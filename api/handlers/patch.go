@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// media types a PATCH body can be sent as
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// patcher applies a PATCH body to the generic JSON form of a resource
+type patcher func(doc interface{}) (interface{}, error)
+
+// parsePatch returns the patcher for the body of a PATCH request, chosen by its
+// content type: an RFC 7396 merge patch or an RFC 6902 JSON patch
+func parsePatch(r *http.Request, body json.RawMessage) (patcher, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case mergePatchType:
+		var patch interface{}
+		if err := json.Unmarshal(body, &patch); err != nil {
+			return nil, bodyError(err)
+		}
+		return func(doc interface{}) (interface{}, error) {
+			return mergePatch(doc, patch), nil
+		}, nil
+	case jsonPatchType:
+		var ops []patchOperation
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&ops); err != nil {
+			return nil, bodyError(err)
+		}
+		return func(doc interface{}) (interface{}, error) {
+			for i, op := range ops {
+				var err error
+				if doc, err = op.apply(doc); err != nil {
+					var problemErr *problemError
+					if errors.As(err, &problemErr) {
+						problemErr.detail = fmt.Sprintf("operation %d: %s", i, problemErr.detail)
+					}
+					return nil, err
+				}
+			}
+			return doc, nil
+		}, nil
+	}
+	return nil, &problemError{
+		status: http.StatusUnsupportedMediaType,
+		detail: "PATCH bodies must be sent as " + mergePatchType + " or " + jsonPatchType,
+	}
+}
+
+// applyPatch patches the JSON form of current and strictly decodes the result
+// into target, so a patch cannot add members the resource does not have
+func applyPatch(patch patcher, current, target interface{}) error {
+	raw, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return err
+	}
+
+	patched, err := patch(doc)
+	if err != nil {
+		return err
+	}
+
+	if raw, err = json.Marshal(patched); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(target); err != nil {
+		return bodyError(err)
+	}
+	return nil
+}
+
+// mergePatch applies an RFC 7396 merge patch: objects are merged member by
+// member, null removes a member and anything else replaces the target
+func mergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+		} else {
+			targetObject[name] = mergePatch(targetObject[name], value)
+		}
+	}
+	return targetObject
+}
+
+// patchOperation is one operation of an RFC 6902 JSON patch
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// patchConflict is answered when an operation cannot be applied to the
+// resource as it is, like a missing path or a failed test
+func patchConflict(format string, args ...any) error {
+	return &problemError{status: http.StatusConflict, detail: fmt.Sprintf(format, args...)}
+}
+
+// invalidPatch is answered when the patch document itself is malformed
+func invalidPatch(format string, args ...any) error {
+	return &problemError{status: http.StatusBadRequest, detail: fmt.Sprintf(format, args...)}
+}
+
+// apply runs the operation on doc and returns the new document
+func (op patchOperation) apply(doc interface{}) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if len(op.Value) == 0 {
+			return nil, invalidPatch("%s at %q needs a value", op.Op, op.Path)
+		}
+		var value interface{}
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, invalidPatch("%s at %q has an invalid value", op.Op, op.Path)
+		}
+		switch op.Op {
+		case "add":
+			return addValue(doc, path, value)
+		case "replace":
+			if len(path) == 0 {
+				return value, nil
+			}
+			if doc, _, err = removeValue(doc, path); err != nil {
+				return nil, err
+			}
+			return addValue(doc, path, value)
+		default:
+			current, err := getValue(doc, path)
+			if err != nil {
+				return nil, err
+			}
+			if !reflect.DeepEqual(current, value) {
+				return nil, patchConflict("test at %q failed", op.Path)
+			}
+			return doc, nil
+		}
+	case "remove":
+		doc, _, err = removeValue(doc, path)
+		return doc, err
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		var value interface{}
+		if op.Op == "move" {
+			if strings.HasPrefix(op.Path+"/", op.From+"/") && op.Path != op.From {
+				return nil, invalidPatch("cannot move %q into itself", op.From)
+			}
+			if doc, value, err = removeValue(doc, from); err != nil {
+				return nil, err
+			}
+		} else {
+			if value, err = getValue(doc, from); err != nil {
+				return nil, err
+			}
+			value = deepCopy(value)
+		}
+		return addValue(doc, path, value)
+	}
+	return nil, invalidPatch("unknown operation %q", op.Op)
+}
+
+// parsePointer splits an RFC 6901 JSON pointer into its unescaped tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, invalidPatch("path %q must start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// arrayIndex resolves an array index token, "-" is one past the end and only allowed when appending
+func arrayIndex(token string, length int, appending bool) (int, error) {
+	if token == "-" && appending {
+		return length, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, patchConflict("%q is not an array index", token)
+	}
+	if i > length || (i == length && !appending) {
+		return 0, patchConflict("index %d is out of range", i)
+	}
+	return i, nil
+}
+
+// getValue returns the value at path
+func getValue(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, patchConflict("member %q does not exist", token)
+			}
+			doc = value
+		case []interface{}:
+			i, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, patchConflict("cannot descend into %q", token)
+		}
+	}
+	return doc, nil
+}
+
+// addValue adds value at path, replacing an object member or inserting into an array
+func addValue(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	token, rest := path[0], path[1:]
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		if len(rest) == 0 {
+			node[token] = value
+			return node, nil
+		}
+		child, ok := node[token]
+		if !ok {
+			return nil, patchConflict("member %q does not exist", token)
+		}
+		child, err := addValue(child, rest, value)
+		node[token] = child
+		return node, err
+	case []interface{}:
+		i, err := arrayIndex(token, len(node), len(rest) == 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) == 0 {
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = value
+			return node, nil
+		}
+		node[i], err = addValue(node[i], rest, value)
+		return node, err
+	}
+	return nil, patchConflict("cannot add to %q", token)
+}
+
+// removeValue removes the value at path and returns the new document and the removed value
+func removeValue(doc interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, nil, invalidPatch("cannot remove the whole document")
+	}
+	token, rest := path[0], path[1:]
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		child, ok := node[token]
+		if !ok {
+			return nil, nil, patchConflict("member %q does not exist", token)
+		}
+		if len(rest) == 0 {
+			delete(node, token)
+			return node, child, nil
+		}
+		child, removed, err := removeValue(child, rest)
+		node[token] = child
+		return node, removed, err
+	case []interface{}:
+		i, err := arrayIndex(token, len(node), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(rest) == 0 {
+			removed := node[i]
+			return append(node[:i], node[i+1:]...), removed, nil
+		}
+		child, removed, err := removeValue(node[i], rest)
+		node[i] = child
+		return node, removed, err
+	}
+	return nil, nil, patchConflict("cannot remove from %q", token)
+}
+
+// deepCopy copies a decoded JSON value so a copied member does not share maps or slices with its source
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for name, member := range v {
+			out[name] = deepCopy(member)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, element := range v {
+			out[i] = deepCopy(element)
+		}
+		return out
+	}
+	return value
+}
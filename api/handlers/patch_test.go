@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+
+	v1 "github.com/jacob-tech-challenge/api/dto/v1"
+	"github.com/jacob-tech-challenge/api/models"
+	"github.com/jacob-tech-challenge/api/services"
+)
+
+func TestJSONPatchOperations(t *testing.T) {
+	doc := `{"name":"Ada","tags":["a","b"],"meta":{"age":36}}`
+
+	tests := map[string]struct {
+		ops        string
+		want       string
+		wantStatus int
+	}{
+		"add member":      {ops: `[{"op":"add","path":"/city","value":"London"}]`, want: `{"name":"Ada","tags":["a","b"],"meta":{"age":36},"city":"London"}`},
+		"insert element":  {ops: `[{"op":"add","path":"/tags/1","value":"x"}]`, want: `{"name":"Ada","tags":["a","x","b"],"meta":{"age":36}}`},
+		"append element":  {ops: `[{"op":"add","path":"/tags/-","value":"c"}]`, want: `{"name":"Ada","tags":["a","b","c"],"meta":{"age":36}}`},
+		"remove element":  {ops: `[{"op":"remove","path":"/tags/0"}]`, want: `{"name":"Ada","tags":["b"],"meta":{"age":36}}`},
+		"replace nested":  {ops: `[{"op":"replace","path":"/meta/age","value":37}]`, want: `{"name":"Ada","tags":["a","b"],"meta":{"age":37}}`},
+		"move":            {ops: `[{"op":"move","from":"/meta/age","path":"/age"}]`, want: `{"name":"Ada","tags":["a","b"],"meta":{},"age":36}`},
+		"copy":            {ops: `[{"op":"copy","from":"/tags","path":"/more"}]`, want: `{"name":"Ada","tags":["a","b"],"meta":{"age":36},"more":["a","b"]}`},
+		"test then apply": {ops: `[{"op":"test","path":"/name","value":"Ada"},{"op":"remove","path":"/meta"}]`, want: `{"name":"Ada","tags":["a","b"]}`},
+		"escaped pointer": {ops: `[{"op":"add","path":"/a~1b~0c","value":1}]`, want: `{"name":"Ada","tags":["a","b"],"meta":{"age":36},"a/b~c":1}`},
+		"failed test":     {ops: `[{"op":"test","path":"/name","value":"Grace"}]`, wantStatus: http.StatusConflict},
+		"missing path":    {ops: `[{"op":"replace","path":"/city","value":"Paris"}]`, wantStatus: http.StatusConflict},
+		"out of range":    {ops: `[{"op":"remove","path":"/tags/2"}]`, wantStatus: http.StatusConflict},
+		"leading zero":    {ops: `[{"op":"remove","path":"/tags/01"}]`, wantStatus: http.StatusConflict},
+		"move into child": {ops: `[{"op":"move","from":"/meta","path":"/meta/inner"}]`, wantStatus: http.StatusBadRequest},
+		"missing value":   {ops: `[{"op":"add","path":"/city"}]`, wantStatus: http.StatusBadRequest},
+		"unknown op":      {ops: `[{"op":"merge","path":"/city"}]`, wantStatus: http.StatusBadRequest},
+		"bad pointer":     {ops: `[{"op":"remove","path":"name"}]`, wantStatus: http.StatusBadRequest},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPatch, "/", nil)
+			req.Header.Set("Content-Type", jsonPatchType)
+			patch, err := parsePatch(req, json.RawMessage(tc.ops))
+			assert.NoError(t, err)
+
+			var current interface{}
+			assert.NoError(t, json.Unmarshal([]byte(doc), &current))
+			var got map[string]interface{}
+			err = applyPatch(patch, current, &got)
+
+			if tc.wantStatus != 0 {
+				var problemErr *problemError
+				if assert.ErrorAs(t, err, &problemErr) {
+					assert.Equal(t, tc.wantStatus, problemErr.status)
+					assert.True(t, strings.HasPrefix(problemErr.detail, "operation 0: "), problemErr.detail)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			raw, _ := json.Marshal(got)
+			assert.JSONEq(t, tc.want, string(raw))
+		})
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	var target, patch interface{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"a":"b","c":{"d":"e","f":"g"}}`), &target))
+	assert.NoError(t, json.Unmarshal([]byte(`{"a":"z","c":{"f":null},"h":[1]}`), &patch))
+
+	got, _ := json.Marshal(mergePatch(target, patch))
+	assert.JSONEq(t, `{"a":"z","c":{"d":"e"},"h":[1]}`, string(got))
+}
+
+func TestHandlePatchPerson(t *testing.T) {
+	tests := map[string]struct {
+		contentType string
+		body        string
+		ifMatch     string // the seeded version when empty
+		wantStatus  int
+		wantBody    string
+		wantErrors  []v1.FieldError
+	}{
+		"merge patch keeps other members": {
+			contentType: mergePatchType,
+			body:        `{"age":30}`,
+			wantStatus:  http.StatusOK,
+			wantBody:    `{"id":1,"firstName":"John","lastName":"Doe","type":"student","age":30,"courses":[1]}`,
+		},
+		"merge patch replaces courses": {
+			contentType: mergePatchType,
+			body:        `{"courses":[2]}`,
+			wantStatus:  http.StatusOK,
+			wantBody:    `{"id":1,"firstName":"John","lastName":"Doe","type":"student","age":20,"courses":[2]}`,
+		},
+		"json patch": {
+			contentType: jsonPatchType,
+			body:        `[{"op":"test","path":"/age","value":20},{"op":"add","path":"/courses/-","value":2},{"op":"replace","path":"/type","value":"professor"}]`,
+			wantStatus:  http.StatusOK,
+			wantBody:    `{"id":1,"firstName":"John","lastName":"Doe","type":"professor","age":20,"courses":[1,2]}`,
+		},
+		"merged result is validated": {
+			contentType: mergePatchType,
+			body:        `{"firstName":null,"courses":[1,1]}`,
+			wantStatus:  http.StatusBadRequest,
+			wantErrors: []v1.FieldError{
+				{Field: "firstName", Message: "is required"},
+				{Field: "courses.1", Message: "repeats course 1"},
+			},
+		},
+		"unknown course": {
+			contentType: mergePatchType,
+			body:        `{"courses":[9]}`,
+			wantStatus:  http.StatusBadRequest,
+		},
+		"unknown member": {
+			contentType: mergePatchType,
+			body:        `{"nickname":"JD"}`,
+			wantStatus:  http.StatusBadRequest,
+		},
+		"failed test": {
+			contentType: jsonPatchType,
+			body:        `[{"op":"test","path":"/age","value":21}]`,
+			wantStatus:  http.StatusConflict,
+		},
+		"plain json": {
+			contentType: "application/json",
+			body:        `{"age":30}`,
+			wantStatus:  http.StatusUnsupportedMediaType,
+		},
+		"stale version": {
+			contentType: mergePatchType,
+			body:        `{"age":30}`,
+			ifMatch:     `"2"`,
+			wantStatus:  http.StatusPreconditionFailed,
+		},
+		"weak tag never matches": {
+			contentType: mergePatchType,
+			body:        `{"age":30}`,
+			ifMatch:     `W/"1"`,
+			wantStatus:  http.StatusPreconditionFailed,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store := services.NewMemoryStore()
+			for _, name := range []string{"Math", "Science"} {
+				_, err := store.CreateCourse(ctx, models.Course{Name: name})
+				assert.NoError(t, err)
+			}
+			_, err := store.CreatePerson(ctx, models.Person{FirstName: "John", LastName: "Doe", Type: "student", Age: 20, Courses: []int{1}})
+			assert.NoError(t, err)
+
+			router := chi.NewRouter()
+			router.Patch("/person/{id}", HandlePatchPerson(store))
+			req := httptest.NewRequest(http.MethodPatch, "/person/1", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			req.Header.Set("If-Match", `"1"`)
+			if tc.ifMatch != "" {
+				req.Header.Set("If-Match", tc.ifMatch)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.wantStatus, w.Code, w.Body.String())
+			if tc.wantBody != "" {
+				assert.JSONEq(t, tc.wantBody, w.Body.String())
+				assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+				return
+			}
+			var problem v1.Problem
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&problem))
+			assert.Equal(t, tc.wantErrors, problem.Errors)
+
+			// a rejected patch leaves the person as they were
+			stored, err := store.GetPersonByID(ctx, 1)
+			assert.NoError(t, err)
+			stored.UpdatedAt = time.Time{}
+			assert.Equal(t, models.Person{ID: 1, FirstName: "John", LastName: "Doe", Type: "student", Age: 20, Courses: []int{1}, Version: 1}, stored)
+		})
+	}
+}
+
+func TestHandlePatchCourse(t *testing.T) {
+	store := services.NewMemoryStore()
+	_, err := store.CreateCourse(context.Background(), models.Course{Name: "Math"})
+	assert.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Patch("/course/{id}", HandlePatchCourse(store))
+
+	for _, tc := range []struct {
+		body, ifMatch string
+		wantStatus    int
+	}{
+		{`{"name":""}`, `"1"`, http.StatusBadRequest},
+		{`{"name":"Algebra"}`, "", http.StatusPreconditionRequired},
+		{`{"name":"Algebra"}`, `"1", "2"`, http.StatusBadRequest},
+		{`{"name":"Algebra"}`, `"1"`, http.StatusOK},
+		{`{"name":"Art"}`, `"1"`, http.StatusPreconditionFailed},
+	} {
+		req := httptest.NewRequest(http.MethodPatch, "/course/1", strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", mergePatchType)
+		if tc.ifMatch != "" {
+			req.Header.Set("If-Match", tc.ifMatch)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, tc.wantStatus, w.Code, tc.body)
+	}
+
+	course, err := store.GetCourseByID(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "Algebra", course.Name)
+	assert.Equal(t, 2, course.Version)
+
+	req := httptest.NewRequest(http.MethodPatch, "/course/9", strings.NewReader(`{"name":"Art"}`))
+	req.Header.Set("Content-Type", mergePatchType)
+	req.Header.Set("If-Match", "*")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jacob-tech-challenge/api/services"
+)
+
+// queryDeadline bounds how long the store calls of a request may run. The
+// deadline is set on the request context, which every store call receives, so
+// a slow query is cancelled instead of holding on to a connection.
+func queryDeadline(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if timeout <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// maxBodyBytes caps the size of request bodies. Reading past the limit fails
+// with *http.MaxBytesError, which the handlers answer with 413.
+func maxBodyBytes(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if limit > 0 && r.Body != nil {
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// auditActor puts who a request is made for and its request id on the request
+// context, where the stores pick them up for the audit log. The actor is read
+// from header, which the proxy in front of the API is trusted to set, and the
+// request id is the one middleware.RequestID assigned, echoed back to the client.
+// The remote address goes along, it scopes the idempotency keys of anonymous requests.
+func auditActor(header string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			actor := services.Actor{RequestID: middleware.GetReqID(r.Context()), Remote: remoteHost(r)}
+			if actor.RequestID != "" {
+				w.Header().Set(middleware.RequestIDHeader, actor.RequestID)
+			}
+			if header != "" {
+				actor.Name = r.Header.Get(header)
+			}
+			next.ServeHTTP(w, r.WithContext(services.WithActor(r.Context(), actor)))
+		})
+	}
+}
+
+// remoteHost returns the host of the address a request came from, without the
+// port that changes with every connection
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// cacheControl sets the Cache-Control header of successful GET responses, 304s
+// included. policies is keyed by route pattern, like /api/course/{id}, and
+// routes without a policy get fallback. The route is only known once chi has
+// routed the request, so the header is set as the response is written.
+func cacheControl(policies map[string]string, fallback string) func(http.Handler) http.Handler {
+	byRoute := make(map[string]string, len(policies))
+	for route, policy := range policies {
+		byRoute[routeKey(route)] = policy
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				next.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(&cacheControlWriter{ResponseWriter: w, r: r, policies: byRoute, fallback: fallback}, r)
+		})
+	}
+}
+
+// routeKey drops the trailing slash chi leaves on the root of mounted routers
+func routeKey(pattern string) string {
+	if len(pattern) > 1 {
+		return strings.TrimSuffix(pattern, "/")
+	}
+	return pattern
+}
+
+// cacheControlWriter adds the Cache-Control header of the request's route before the status is written
+type cacheControlWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	policies    map[string]string
+	fallback    string
+	wroteHeader bool
+}
+
+func (w *cacheControlWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if status == http.StatusOK || status == http.StatusNotModified {
+			policy, ok := w.policies[routeKey(chi.RouteContext(w.r.Context()).RoutePattern())]
+			if !ok {
+				policy = w.fallback
+			}
+			if policy != "" && w.Header().Get("Cache-Control") == "" {
+				w.Header().Set("Cache-Control", policy)
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheControlWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *cacheControlWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jacob-tech-challenge/api/models"
+	"github.com/jacob-tech-challenge/api/services"
+)
+
+func TestQueryDeadline(t *testing.T) {
+	tests := map[string]struct {
+		timeout      time.Duration
+		wantDeadline bool
+	}{
+		"sets deadline": {timeout: time.Second, wantDeadline: true},
+		"disabled":      {timeout: 0, wantDeadline: false},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var hasDeadline bool
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, hasDeadline = r.Context().Deadline()
+			})
+
+			queryDeadline(tc.timeout)(next).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+			assert.Equal(t, tc.wantDeadline, hasDeadline)
+		})
+	}
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	var readErr error
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, readErr = io.ReadAll(r.Body)
+	})
+
+	maxBodyBytes(4)(next).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader("12345")))
+	var maxBytesErr *http.MaxBytesError
+	assert.ErrorAs(t, readErr, &maxBytesErr)
+
+	maxBodyBytes(0)(next).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader("12345")))
+	assert.NoError(t, readErr)
+}
+
+func TestCacheControl(t *testing.T) {
+	courses := chi.NewRouter()
+	courses.Get("/", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("[]")) })
+	courses.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if chi.URLParam(r, "id") == "0" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("{}"))
+	})
+	courses.Put("/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+	r := chi.NewRouter()
+	r.Use(cacheControl(map[string]string{"/api/course": "max-age=60", "/api/course/{id}/": "private"}, "no-cache"))
+	r.Route("/api", func(r chi.Router) {
+		r.Mount("/course", courses)
+		r.Get("/other", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotModified)
+		})
+	})
+
+	tests := map[string]struct {
+		method string
+		target string
+		want   string
+	}{
+		"list":        {method: http.MethodGet, target: "/api/course", want: "max-age=60"},
+		"item":        {method: http.MethodGet, target: "/api/course/1", want: "private"},
+		"fallback":    {method: http.MethodGet, target: "/api/other", want: "no-cache"},
+		"failed read": {method: http.MethodGet, target: "/api/course/0", want: ""},
+		"not a read":  {method: http.MethodPut, target: "/api/course/1", want: ""},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.target, nil))
+
+			assert.Equal(t, tc.want, rr.Header().Get("Cache-Control"))
+		})
+	}
+}
+
+func TestRemoteHost(t *testing.T) {
+	req := httptest.NewRequest("POST", "/", nil)
+	req.RemoteAddr = "203.0.113.5:52114"
+	assert.Equal(t, "203.0.113.5", remoteHost(req))
+	req.RemoteAddr = "[2001:db8::1]:52114"
+	assert.Equal(t, "2001:db8::1", remoteHost(req))
+	// an address without a port is kept whole
+	req.RemoteAddr = "@"
+	assert.Equal(t, "@", remoteHost(req))
+}
+
+func TestAuditActor(t *testing.T) {
+	store := services.NewMemoryStore()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := store.CreateCourse(r.Context(), models.Course{Name: "Math"})
+		assert.NoError(t, err)
+	})
+	handler := middleware.RequestID(auditActor("X-Actor")(next))
+
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set(middleware.RequestIDHeader, "req-1")
+	req.Header.Set("X-Actor", "alice")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, "req-1", rec.Header().Get(middleware.RequestIDHeader))
+
+	// without the header the write is anonymous but still has a generated request id
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/", nil))
+	generated := rec.Header().Get(middleware.RequestIDHeader)
+	assert.NotEmpty(t, generated)
+
+	entries, _, err := store.GetAuditLog(context.Background(), services.AuditFilter{}, services.Page{})
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "alice", entries[0].Actor)
+		assert.Equal(t, "req-1", entries[0].RequestID)
+		assert.Equal(t, "", entries[1].Actor)
+		assert.Equal(t, generated, entries[1].RequestID)
+	}
+}
@@ -1,8 +1,17 @@
 package models
 
+import "time"
+
+// Course and Person carry the Version of their row, which starts at 1 and goes
+// up with every write. Passed to a store write, a non-zero Version is the
+// version the caller expects to overwrite. DeletedAt is nil on live rows and
+// the deletion time on soft deleted ones.
 type Course struct {
 	ID int
 	Name string
+	Version int
+	UpdatedAt time.Time
+	DeletedAt *time.Time
 }
 
 type Person struct {
@@ -12,4 +21,7 @@ type Person struct {
 	Type 		string
 	Age 		int
 	Courses 	[]int
-}
\ No newline at end of file
+	Version 	int
+	UpdatedAt 	time.Time
+	DeletedAt 	*time.Time
+}
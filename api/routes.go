@@ -1,54 +1,87 @@
 package api
 
 import (
-	"database/sql"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/jacob-tech-challenge/api/handlers"
+	"github.com/jacob-tech-challenge/api/services"
+	"github.com/jacob-tech-challenge/config"
 	// Import the handler functions from the course package
 )
 
 // SetupRoutes sets up the API routes using the Chi router.
-func SetupRoutes(db *sql.DB) (http.Handler) {
+func SetupRoutes(cfg config.Config, store services.Store) (http.Handler) {
 	r := chi.NewRouter()
 
 	// Middleware
+	r.Use(middleware.RequestID)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
+	r.Use(auditActor(cfg.HTTP_ActorHeader))
+	r.Use(queryDeadline(cfg.DB_QueryTimeout))
+	r.Use(maxBodyBytes(cfg.HTTP_MaxBodyBytes))
+	r.Use(cacheControl(cfg.HTTP_CacheControl, cfg.HTTP_DefaultCacheControl))
+
+	limits := handlers.PageLimits{Default: cfg.HTTP_DefaultPageSize, Max: cfg.HTTP_MaxPageSize}
+	// POST requests sent with an Idempotency-Key can be retried safely
+	idempotent := handlers.Idempotent(store, cfg.HTTP_IdempotencyTTL, cfg.HTTP_IdempotencyLease)
 
 	// API routes
 	r.Route("/api", func(r chi.Router) {
-		r.Mount("/course", courseRoutes(db));
-		r.Mount("/person", personRoutes(db));
+		r.Mount("/course", courseRoutes(store, limits, idempotent));
+		r.Mount("/person", personRoutes(store, limits, idempotent));
+		r.Get("/audit", handlers.HandleGetAuditLog(store, limits))
+		r.With(idempotent).Post("/batch", handlers.HandleBatch(store))
 	})
 
 	return r
 }
 
+// AdminRoutes sets up the routes of the internal listener, which only serves
+// the cache counters. It must not be reachable from the public network.
+func AdminRoutes(cache *services.CachedStore) http.Handler {
+	r := chi.NewRouter()
+	r.Use(middleware.Recoverer)
+	r.Get("/debug/cache", handlers.HandleCacheStats(cache))
+	return r
+}
+
 // courseRoutes defines the routes for the /api/course endpoint.
-func courseRoutes(db *sql.DB) http.Handler {
+func courseRoutes(store services.Store, limits handlers.PageLimits, idempotent func(http.Handler) http.Handler) http.Handler {
 	r := chi.NewRouter()
 
-	r.Get("/", handlers.HandleGetAllCourses(db))
-	r.Get("/{id}", handlers.HandleGetCourseByID(db))
-	r.Put("/{id}", handlers.HandleUpdateCourse(db))
-	r.Post("/", handlers.HandleCreateCourse(db))
-	r.Delete("/{id}", handlers.HandleDeleteCourse(db))
+	r.Get("/", handlers.HandleGetAllCourses(store, limits))
+	r.Get("/{id}", handlers.HandleGetCourseByID(store))
+	r.Put("/{id}", handlers.HandleUpdateCourse(store))
+	r.Patch("/{id}", handlers.HandlePatchCourse(store))
+	r.With(idempotent).Post("/", handlers.HandleCreateCourse(store))
+	r.Delete("/{id}", handlers.HandleDeleteCourse(store))
+	r.Post("/{id}/restore", handlers.HandleRestoreCourse(store))
+	r.Get("/{id}/people", handlers.HandleGetCourseRoster(store, limits))
 
 	return r
 }
 
 // personRoutes defines the routes for the /api/person endpoint.
-func personRoutes(db *sql.DB) http.Handler {
+func personRoutes(store services.Store, limits handlers.PageLimits, idempotent func(http.Handler) http.Handler) http.Handler {
 	r := chi.NewRouter()
 
-	r.Get("/", handlers.HandleGetAllPeople(db))
-	r.Get("/{name}", handlers.HandleGetPersonByName(db))
-	r.Put("/{name}", handlers.HandleUpdatePersonByName(db))
-	r.Post("/", handlers.HandleCreatePerson(db))
-	r.Delete("/{name}", handlers.HandleDeletePersonByName(db))
+	r.Get("/", handlers.HandleGetAllPeople(store, limits))
+	r.Get("/by-name/{first}/{last}", handlers.HandleGetPersonByName(store))
+	r.Get("/{id}", handlers.HandleGetPersonByID(store))
+	r.Put("/{id}", handlers.HandleUpdatePerson(store))
+	r.Patch("/{id}", handlers.HandlePatchPerson(store))
+	r.With(idempotent).Post("/", handlers.HandleCreatePerson(store, store))
+	r.Delete("/{id}", handlers.HandleDeletePerson(store))
+	r.Post("/{id}/restore", handlers.HandleRestorePerson(store))
+	r.Get("/{id}/history", handlers.HandleGetPersonHistory(store, limits))
+
+	r.Get("/{id}/courses", handlers.HandleGetPersonCourses(store))
+	r.With(idempotent).Post("/{id}/courses", handlers.HandleAddPersonCourses(store))
+	r.Put("/{id}/courses", handlers.HandleSetPersonCourses(store))
+	r.Delete("/{id}/courses", handlers.HandleRemovePersonCourses(store))
 
 	return r
 }
\ No newline at end of file
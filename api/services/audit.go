@@ -0,0 +1,236 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/jacob-tech-challenge/api/models"
+)
+
+// The actions an audit entry records. Enrollment changes are recorded on the
+// person, whose course list they change.
+const (
+	AuditCreate     = "create"
+	AuditUpdate     = "update"
+	AuditDelete     = "delete"
+	AuditRestore    = "restore"
+	AuditPurge      = "purge"
+	AuditEnrollment = "enrollment"
+)
+
+// Actor is who a write is made for and the request it came in with, every
+// audit entry written under a context carrying it records both. Remote is the
+// address the request came from, it is not audited but keeps the idempotency
+// keys of anonymous requests apart.
+type Actor struct {
+	Name      string
+	RequestID string
+	Remote    string
+}
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx whose writes are audited under actor
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// actorFrom returns the actor of ctx, the zero Actor when there is none
+func actorFrom(ctx context.Context) Actor {
+	actor, _ := ctx.Value(actorKey{}).(Actor)
+	return actor
+}
+
+// AuditEntry is one change to a person or course. Before and After hold the
+// entity as JSON, and are nil when it did not exist before or after the change.
+type AuditEntry struct {
+	ID        int
+	At        time.Time
+	Actor     string
+	RequestID string
+	Entity    string
+	EntityID  int
+	Action    string
+	Before    json.RawMessage
+	After     json.RawMessage
+}
+
+// AuditFilter selects the entries returned by GetAuditLog, zero fields do not filter
+type AuditFilter struct {
+	Entity   string
+	EntityID int
+	// Since keeps the entries made at or after it
+	Since time.Time
+}
+
+// Validate checks the values of the filter
+func (f AuditFilter) Validate() error {
+	if f.Entity != "" && f.Entity != "person" && f.Entity != "course" {
+		return &FilterError{Param: "entity", Reason: `must be "person" or "course"`}
+	}
+	if f.EntityID < 0 {
+		return &FilterError{Param: "id", Reason: "must be a positive integer"}
+	}
+	if f.EntityID > 0 && f.Entity == "" {
+		return &FilterError{Param: "id", Reason: "needs entity"}
+	}
+	return nil
+}
+
+// matches reports whether an entry passes the filter, the memory store's WHERE clause
+func (f AuditFilter) matches(entry AuditEntry) bool {
+	return (f.Entity == "" || entry.Entity == f.Entity) &&
+		(f.EntityID == 0 || entry.EntityID == f.EntityID) &&
+		!entry.At.Before(f.Since)
+}
+
+// courseState and personState are the JSON the audit log keeps of a course or
+// person, named like the API names their fields
+type courseState struct {
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	Version   int        `json:"version"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+type personState struct {
+	ID        int        `json:"id"`
+	FirstName string     `json:"firstName"`
+	LastName  string     `json:"lastName"`
+	Type      string     `json:"type"`
+	Age       int        `json:"age"`
+	Courses   []int      `json:"courses"`
+	Version   int        `json:"version"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+// courseChange returns the audit entry of a change to a course by the actor of
+// ctx, before or after is nil when the course did not exist on that side
+func courseChange(ctx context.Context, action string, before, after *models.Course) AuditEntry {
+	var id int
+	var states [2]json.RawMessage
+	for i, course := range []*models.Course{before, after} {
+		if course != nil {
+			id = course.ID
+			states[i], _ = json.Marshal(courseState{ID: course.ID, Name: course.Name, Version: course.Version, UpdatedAt: course.UpdatedAt, DeletedAt: course.DeletedAt})
+		}
+	}
+	return newAuditEntry(ctx, "course", id, action, states[0], states[1])
+}
+
+// personChange is the person counterpart of courseChange, the course list is never null
+func personChange(ctx context.Context, action string, before, after *models.Person) AuditEntry {
+	var id int
+	var states [2]json.RawMessage
+	for i, person := range []*models.Person{before, after} {
+		if person != nil {
+			courses := person.Courses
+			if courses == nil {
+				courses = []int{}
+			}
+			id = person.ID
+			states[i], _ = json.Marshal(personState{
+				ID:        person.ID,
+				FirstName: person.FirstName,
+				LastName:  person.LastName,
+				Type:      person.Type,
+				Age:       person.Age,
+				Courses:   courses,
+				Version:   person.Version,
+				UpdatedAt: person.UpdatedAt,
+				DeletedAt: person.DeletedAt,
+			})
+		}
+	}
+	return newAuditEntry(ctx, "person", id, action, states[0], states[1])
+}
+
+// newAuditEntry returns an entry made by the actor of ctx
+func newAuditEntry(ctx context.Context, entity string, id int, action string, before, after json.RawMessage) AuditEntry {
+	actor := actorFrom(ctx)
+	return AuditEntry{Actor: actor.Name, RequestID: actor.RequestID, Entity: entity, EntityID: id, Action: action, Before: before, After: after}
+}
+
+// writeAudit appends entries to the audit log in one statement. Called with the
+// transaction of the change, the entries are kept exactly when the change is.
+func writeAudit(ctx context.Context, q execer, entries ...AuditEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	var actors, requestIDs, entities, actions, befores, afters pq.StringArray
+	var ids pq.Int64Array
+	for _, entry := range entries {
+		actors = append(actors, entry.Actor)
+		requestIDs = append(requestIDs, entry.RequestID)
+		entities = append(entities, entry.Entity)
+		ids = append(ids, int64(entry.EntityID))
+		actions = append(actions, entry.Action)
+		// an empty string stands for SQL NULL, no JSON document is empty
+		befores = append(befores, string(entry.Before))
+		afters = append(afters, string(entry.After))
+	}
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO audit_log (actor, request_id, entity, entity_id, action, before, after)
+		SELECT e.actor, e.request_id, e.entity, e.entity_id, e.action, nullif(e.before, '')::jsonb, nullif(e.after, '')::jsonb
+		FROM unnest($1::text[], $2::text[], $3::text[], $4::int[], $5::text[], $6::text[], $7::text[])
+			AS e(actor, request_id, entity, entity_id, action, before, after)`,
+		actors, requestIDs, entities, ids, actions, befores, afters)
+	return err
+}
+
+// LastModified returns the time of the latest audit entry of an entity, zero
+// before the first write. Every write is audited, purges too, so unlike the
+// latest updated_at among the rows it never goes back when rows go away.
+func LastModified(ctx context.Context, db *sql.DB, entity string) (time.Time, error) {
+	var at sql.NullTime
+	if err := db.QueryRowContext(ctx, `SELECT max(at) FROM audit_log WHERE entity = $1`, entity).Scan(&at); err != nil {
+		return time.Time{}, err
+	}
+	return at.Time, nil
+}
+
+// GetAuditLog returns a page of the audit entries matching the filter, oldest first
+func GetAuditLog(ctx context.Context, db *sql.DB, filter AuditFilter, page Page) ([]AuditEntry, *Cursor, error) {
+	if err := filter.Validate(); err != nil {
+		return []AuditEntry{}, nil, err
+	}
+	since := sql.NullTime{Time: filter.Since, Valid: !filter.Since.IsZero()}
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, at, actor, request_id, entity, entity_id, action, before, after
+		FROM audit_log
+		WHERE id > $1 AND ($2 = '' OR entity = $2) AND ($3 = 0 OR entity_id = $3) AND ($4::timestamptz IS NULL OR at >= $4)
+		ORDER BY id
+		LIMIT $5`,
+		page.afterID(), filter.Entity, filter.EntityID, since, page.limitArg())
+	if err != nil {
+		return []AuditEntry{}, nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		var before, after []byte
+		if err := rows.Scan(&entry.ID, &entry.At, &entry.Actor, &entry.RequestID, &entry.Entity, &entry.EntityID, &entry.Action, &before, &after); err != nil {
+			return nil, nil, err
+		}
+		if before != nil {
+			entry.Before = json.RawMessage(before)
+		}
+		if after != nil {
+			entry.After = json.RawMessage(after)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	entries, next := nextCursor(entries, page.Limit, func(e AuditEntry) Cursor { return Cursor{ID: e.ID} })
+	return entries, next, nil
+}
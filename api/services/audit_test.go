@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jacob-tech-challenge/api/models"
+)
+
+// auditQuery matches the statement writeAudit runs
+var auditQuery = regexp.QuoteMeta(`INSERT INTO audit_log (actor, request_id, entity, entity_id, action, before, after)`)
+
+// expectAudit expects the audit entry of one change to an entity
+func expectAudit(mock sqlmock.Sqlmock, entity string, id int, action string) {
+	mock.ExpectExec(auditQuery).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), pq.StringArray{entity}, pq.Int64Array{int64(id)}, pq.StringArray{action}, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+var auditColumns = []string{"id", "at", "actor", "request_id", "entity", "entity_id", "action", "before", "after"}
+
+func TestAuditFilterValidate(t *testing.T) {
+	tests := map[string]struct {
+		filter        AuditFilter
+		expectedParam string
+	}{
+		"empty":          {},
+		"entity and id":  {filter: AuditFilter{Entity: "person", EntityID: 3}},
+		"unknown entity": {filter: AuditFilter{Entity: "enrollment"}, expectedParam: "entity"},
+		"id alone":       {filter: AuditFilter{EntityID: 3}, expectedParam: "id"},
+		"negative id":    {filter: AuditFilter{Entity: "course", EntityID: -1}, expectedParam: "id"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.filter.Validate()
+			if tc.expectedParam == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var filterErr *FilterError
+			if assert.ErrorAs(t, err, &filterErr) {
+				assert.Equal(t, tc.expectedParam, filterErr.Param)
+			}
+		})
+	}
+}
+
+func TestPersonChange(t *testing.T) {
+	ctx := WithActor(context.Background(), Actor{Name: "alice", RequestID: "req-1"})
+	deletedAt := testUpdatedAt.Add(time.Hour)
+	before := models.Person{ID: 3, FirstName: "Ada", LastName: "Lovelace", Type: "professor", Age: 36, Version: 1, UpdatedAt: testUpdatedAt}
+	after := before
+	after.Version, after.DeletedAt = 2, &deletedAt
+
+	entry := personChange(ctx, AuditDelete, &before, &after)
+	assert.Equal(t, "alice", entry.Actor)
+	assert.Equal(t, "req-1", entry.RequestID)
+	assert.Equal(t, "person", entry.Entity)
+	assert.Equal(t, 3, entry.EntityID)
+	assert.Equal(t, AuditDelete, entry.Action)
+	assert.JSONEq(t, `{"id":3,"firstName":"Ada","lastName":"Lovelace","type":"professor","age":36,"courses":[],"version":1,"updatedAt":"2024-09-01T08:30:00Z"}`, string(entry.Before))
+	assert.JSONEq(t, `{"id":3,"firstName":"Ada","lastName":"Lovelace","type":"professor","age":36,"courses":[],"version":2,"updatedAt":"2024-09-01T08:30:00Z","deletedAt":"2024-09-01T09:30:00Z"}`, string(entry.After))
+
+	created := courseChange(context.Background(), AuditCreate, nil, &models.Course{ID: 7, Name: "Math", Version: 1, UpdatedAt: testUpdatedAt})
+	assert.Equal(t, Actor{}, actorFrom(context.Background()))
+	assert.Equal(t, 7, created.EntityID)
+	assert.Nil(t, created.Before)
+	assert.JSONEq(t, `{"id":7,"name":"Math","version":1,"updatedAt":"2024-09-01T08:30:00Z"}`, string(created.After))
+}
+
+func TestWriteAudit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	ctx := WithActor(context.Background(), Actor{Name: "purge"})
+	person := models.Person{ID: 3, Courses: []int{1}}
+	course := models.Course{ID: 1, Name: "Math"}
+	mock.ExpectExec(auditQuery+`\s+SELECT .+ nullif\(e\.before, ''\)::jsonb, nullif\(e\.after, ''\)::jsonb\s+FROM unnest`).
+		WithArgs(
+			pq.StringArray{"purge", "purge"}, pq.StringArray{"", ""},
+			pq.StringArray{"person", "course"}, pq.Int64Array{3, 1}, pq.StringArray{AuditPurge, AuditPurge},
+			pq.StringArray{
+				`{"id":3,"firstName":"","lastName":"","type":"","age":0,"courses":[1],"version":0,"updatedAt":"0001-01-01T00:00:00Z"}`,
+				`{"id":1,"name":"Math","version":0,"updatedAt":"0001-01-01T00:00:00Z"}`,
+			},
+			pq.StringArray{"", ""}).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	assert.NoError(t, writeAudit(ctx, db, personChange(ctx, AuditPurge, &person, nil), courseChange(ctx, AuditPurge, &course, nil)))
+	// nothing to write is no statement at all
+	assert.NoError(t, writeAudit(ctx, db))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetAuditLog(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	const query = `SELECT id, at, actor, request_id, entity, entity_id, action, before, after\s+FROM audit_log\s+WHERE id > \$1 AND \(\$2 = '' OR entity = \$2\) AND \(\$3 = 0 OR entity_id = \$3\) AND \(\$4::timestamptz IS NULL OR at >= \$4\)\s+ORDER BY id\s+LIMIT \$5`
+
+	t.Run("filtered page", func(t *testing.T) {
+		mock.ExpectQuery(query).
+			WithArgs(4, "person", 3, testUpdatedAt, 3).
+			WillReturnRows(sqlmock.NewRows(auditColumns).
+				AddRow(5, testUpdatedAt, "alice", "req-1", "person", 3, "create", nil, []byte(`{"id":3}`)).
+				AddRow(6, testUpdatedAt, "", "", "person", 3, "delete", []byte(`{"id":3}`), []byte(`{"id":3,"deletedAt":"2024-09-01T08:30:00Z"}`)).
+				AddRow(7, testUpdatedAt, "", "", "person", 3, "restore", []byte(`{"id":3}`), []byte(`{"id":3}`)))
+
+		entries, next, err := GetAuditLog(context.Background(), db, AuditFilter{Entity: "person", EntityID: 3, Since: testUpdatedAt}, Page{Limit: 2, After: &Cursor{ID: 4}})
+		assert.NoError(t, err)
+		assert.Equal(t, []AuditEntry{
+			{ID: 5, At: testUpdatedAt, Actor: "alice", RequestID: "req-1", Entity: "person", EntityID: 3, Action: "create", After: json.RawMessage(`{"id":3}`)},
+			{ID: 6, At: testUpdatedAt, Entity: "person", EntityID: 3, Action: "delete", Before: json.RawMessage(`{"id":3}`), After: json.RawMessage(`{"id":3,"deletedAt":"2024-09-01T08:30:00Z"}`)},
+		}, entries)
+		assert.Equal(t, &Cursor{ID: 6}, next)
+	})
+
+	t.Run("no filter", func(t *testing.T) {
+		mock.ExpectQuery(query).
+			WithArgs(0, "", 0, nil, nil).
+			WillReturnRows(sqlmock.NewRows(auditColumns))
+
+		entries, next, err := GetAuditLog(context.Background(), db, AuditFilter{}, Page{})
+		assert.NoError(t, err)
+		assert.Empty(t, entries)
+		assert.Nil(t, next)
+	})
+
+	t.Run("invalid filter", func(t *testing.T) {
+		_, _, err := GetAuditLog(context.Background(), db, AuditFilter{EntityID: 3}, Page{})
+		assert.ErrorIs(t, err, ErrValidation)
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLastModified(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	const query = `SELECT max\(at\) FROM audit_log WHERE entity = \$1`
+	mock.ExpectQuery(query).WithArgs("course").WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(testUpdatedAt))
+	mock.ExpectQuery(query).WithArgs("person").WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(nil))
+
+	modified, err := NewPostgresStore(db).CoursesModified(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, testUpdatedAt, modified)
+	// nobody was ever written
+	modified, err = NewPostgresStore(db).PeopleModified(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, modified.IsZero())
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMemoryStoreLastModified(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	modified, err := store.CoursesModified(ctx)
+	assert.NoError(t, err)
+	assert.True(t, modified.IsZero())
+
+	course, err := store.CreateCourse(ctx, models.Course{Name: "Math"})
+	assert.NoError(t, err)
+	created, err := store.CoursesModified(ctx)
+	assert.NoError(t, err)
+	assert.False(t, created.Before(course.UpdatedAt))
+
+	// writes to people leave the courses alone
+	_, err = store.CreatePerson(ctx, models.Person{FirstName: "Ada", LastName: "Lovelace", Type: "professor", Age: 36})
+	assert.NoError(t, err)
+	modified, err = store.CoursesModified(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, created, modified)
+
+	// the purge takes the course away for good, the list date still moves on
+	assert.NoError(t, restrictDeleteCourse(ctx, store, course.ID, 0))
+	deleted, err := store.CoursesModified(ctx)
+	assert.NoError(t, err)
+	assert.False(t, deleted.Before(created))
+	_, err = store.PurgeDeleted(ctx, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	modified, err = store.CoursesModified(ctx)
+	assert.NoError(t, err)
+	assert.False(t, modified.Before(deleted))
+}
+
+func TestMemoryStoreAudit(t *testing.T) {
+	ctx := WithActor(context.Background(), Actor{Name: "alice", RequestID: "req-1"})
+	store := NewMemoryStore()
+
+	course, err := store.CreateCourse(ctx, models.Course{Name: "Math"})
+	assert.NoError(t, err)
+	person, err := store.CreatePerson(ctx, models.Person{FirstName: "Ada", LastName: "Lovelace", Type: "professor", Age: 36})
+	assert.NoError(t, err)
+	_, err = store.AddPersonToCourse(ctx, person.ID, 0, []int{course.ID})
+	assert.NoError(t, err)
+	// an enrollment change that changes nothing is not audited
+	_, err = store.AddPersonToCourse(ctx, person.ID, 0, []int{course.ID})
+	assert.NoError(t, err)
+	assert.NoError(t, store.DeletePerson(ctx, person.ID, 0))
+
+	entries, next, err := store.GetAuditLog(ctx, AuditFilter{Entity: "person", EntityID: person.ID}, Page{Limit: 2})
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, []int{2, 3}, []int{entries[0].ID, entries[1].ID})
+		assert.Equal(t, AuditCreate, entries[0].Action)
+		assert.Equal(t, AuditEnrollment, entries[1].Action)
+		assert.Equal(t, "alice", entries[1].Actor)
+		assert.Equal(t, "req-1", entries[1].RequestID)
+		assert.JSONEq(t, `[]`, jsonField(t, entries[1].Before, "courses"))
+		assert.JSONEq(t, `[1]`, jsonField(t, entries[1].After, "courses"))
+	}
+	entries, next, err = store.GetAuditLog(ctx, AuditFilter{Entity: "person", EntityID: person.ID}, Page{Limit: 2, After: next})
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, AuditDelete, entries[0].Action)
+	}
+	assert.Nil(t, next)
+
+	entries, _, err = store.GetAuditLog(ctx, AuditFilter{Since: time.Now().Add(time.Hour)}, Page{})
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestMemoryStoreAuditCourseRestore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	course, err := store.CreateCourse(ctx, models.Course{Name: "Math"})
+	assert.NoError(t, err)
+	person, err := store.CreatePerson(ctx, models.Person{FirstName: "Ada", LastName: "Lovelace", Type: "professor", Age: 36, Courses: []int{course.ID}})
+	assert.NoError(t, err)
+	// the enrollment is kept while both are deleted, the person comes back without the course
+	assert.NoError(t, store.DeletePerson(ctx, person.ID, 0))
+	assert.NoError(t, restrictDeleteCourse(ctx, store, course.ID, 0))
+	_, err = store.RestorePerson(ctx, person.ID)
+	assert.NoError(t, err)
+	_, err = store.RestoreCourse(ctx, course.ID)
+	assert.NoError(t, err)
+
+	// restoring the course grows the person's course list, which is audited on the person
+	entries, _, err := store.GetAuditLog(ctx, AuditFilter{Entity: "person", EntityID: person.ID}, Page{})
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 4) {
+		last := entries[3]
+		assert.Equal(t, AuditEnrollment, last.Action)
+		assert.JSONEq(t, `[]`, jsonField(t, last.Before, "courses"))
+		assert.JSONEq(t, `[1]`, jsonField(t, last.After, "courses"))
+	}
+}
+
+// jsonField returns one member of a JSON object as JSON
+func jsonField(t *testing.T, doc json.RawMessage, name string) string {
+	t.Helper()
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &fields); err != nil {
+		t.Fatalf("invalid JSON %s: %v", doc, err)
+	}
+	return string(fields[name])
+}
@@ -0,0 +1,374 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+
+	"github.com/jacob-tech-challenge/api/models"
+)
+
+// BatchAction is what a batch operation does to its entity
+type BatchAction string
+
+const (
+	BatchCreate   BatchAction = "create"
+	BatchUpdate   BatchAction = "update"
+	BatchDelete   BatchAction = "delete"
+	BatchEnroll   BatchAction = "enroll"
+	BatchUnenroll BatchAction = "unenroll"
+)
+
+// BatchID names the row a batch operation acts on: its ID, or the Ref an
+// earlier create in the same batch gave the row it made
+type BatchID struct {
+	ID  int
+	Ref string
+}
+
+// isZero reports whether the id was left out
+func (b BatchID) isZero() bool {
+	return b.ID == 0 && b.Ref == ""
+}
+
+// BatchOp is one operation of a batch. Entity is "course" or "person", enroll
+// and unenroll change the courses of a person. Ref names the row a create
+// makes for the operations after it. A non-zero Version must be the current
+// version of the row an update or delete changes, like If-Match.
+type BatchOp struct {
+	Action  BatchAction
+	Entity  string
+	Ref     string
+	ID      BatchID
+	Version int
+	// Course is the course to create, or what to update it to
+	Course *models.Course
+	// Person is the person to create, or what to update them to. Their
+	// Courses are left out, those are in Courses.
+	Person *models.Person
+	// Courses are the courses a created person is enrolled in, an updated one
+	// is added to, or enroll and unenroll change
+	Courses []BatchID
+	// Mode and To say what deleting a course does with its enrollments, see CourseDeletion
+	Mode DeleteMode
+	To   BatchID
+}
+
+// BatchResult is what one operation of a batch did. ID is the row it acted
+// on, the other fields are set by the operations they belong to.
+type BatchResult struct {
+	ID          int
+	Course      *models.Course     // course create and update
+	Person      *models.Person     // person create and update
+	Deleted     *CourseDeleted     // course delete
+	Enrollments []EnrollmentResult // enroll and unenroll
+}
+
+// BatchError is the error of the operation at Index that failed a batch, none
+// of the batch was applied. It is of the kind of Err.
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("operation %d: %v", e.Index, e.Err)
+}
+
+// Unwrap makes a BatchError match what its operation failed with
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// batchStore is what a batch runs its operations on, the postgres functions
+// in the batch transaction or a copy of the memory store
+type batchStore interface {
+	CreateCourse(ctx context.Context, course models.Course) (models.Course, error)
+	PatchCourse(ctx context.Context, id, version int, patch CoursePatch) (models.Course, error)
+	DeleteCourse(ctx context.Context, id, version int, deletion CourseDeletion) (CourseDeleted, error)
+	MissingCourseIDs(ctx context.Context, ids []int) ([]int, error)
+	CreatePerson(ctx context.Context, person models.Person) (models.Person, error)
+	PatchPerson(ctx context.Context, id, version int, patch PersonPatch) (models.Person, error)
+	DeletePerson(ctx context.Context, id, version int) error
+	AddPersonToCourse(ctx context.Context, personID, version int, courseIDs []int) ([]EnrollmentResult, error)
+	RemovePersonFromCourses(ctx context.Context, personID, version int, courseIDs []int) ([]EnrollmentResult, error)
+}
+
+// RunBatch runs ops in order in one transaction, all or nothing, and returns
+// the result of each. The first operation to fail rolls the batch back and is
+// returned as a *BatchError.
+func RunBatch(ctx context.Context, db *sql.DB, ops []BatchOp) ([]BatchResult, error) {
+	if err := validateBatch(ops); err != nil {
+		return nil, err
+	}
+	return inTx(ctx, db, func(tx *sql.Tx) ([]BatchResult, error) {
+		return runBatch(ctx, txStore{tx: tx}, ops)
+	})
+}
+
+// validateBatch checks every operation before any of them runs, refs included:
+// each must be made by an earlier create of the entity it is used as
+func validateBatch(ops []BatchOp) error {
+	if len(ops) == 0 {
+		return invalid(nil, "a batch needs at least one operation")
+	}
+	refs := map[string]string{} // ref -> entity
+	for i, op := range ops {
+		if err := op.validate(refs); err != nil {
+			return &BatchError{Index: i, Err: err}
+		}
+		if op.Ref != "" {
+			refs[op.Ref] = op.Entity
+		}
+	}
+	return nil
+}
+
+// validate checks an operation, refs are the ones made before it
+func (op BatchOp) validate(refs map[string]string) error {
+	if op.Entity != "course" && op.Entity != "person" {
+		return invalid(nil, `type must be "course" or "person"`)
+	}
+	switch op.Action {
+	case BatchCreate:
+		if !op.ID.isZero() || op.Version != 0 {
+			return invalid(nil, "id and version are not for create")
+		}
+		if _, ok := refs[op.Ref]; ok {
+			return invalid(nil, "ref %q is already taken", op.Ref)
+		}
+	case BatchUpdate, BatchDelete, BatchEnroll, BatchUnenroll:
+		if op.Ref != "" {
+			return invalid(nil, "ref is only for create")
+		}
+		if err := checkRef(refs, op.Entity, "id", op.ID); err != nil {
+			return err
+		}
+	default:
+		return invalid(nil, "op must be create, update, delete, enroll or unenroll")
+	}
+
+	writes := op.Action == BatchCreate || op.Action == BatchUpdate
+	enrollment := op.Action == BatchEnroll || op.Action == BatchUnenroll
+	switch {
+	case enrollment && op.Entity != "person":
+		return invalid(nil, "%s is only for people", op.Action)
+	case writes && op.Entity == "course" && op.Course == nil:
+		return invalid(nil, "course is required")
+	case writes && op.Entity == "person" && op.Person == nil:
+		return invalid(nil, "person is required")
+	case op.Course != nil && !(writes && op.Entity == "course"):
+		return invalid(nil, "course is only for creating or updating a course")
+	case op.Person != nil && !(writes && op.Entity == "person"):
+		return invalid(nil, "person is only for creating or updating a person")
+	case enrollment && len(op.Courses) == 0:
+		return invalid(nil, "courses must not be empty")
+	case len(op.Courses) > 0 && op.Entity != "person":
+		return invalid(nil, "courses are only for people")
+	case (op.Mode != "" || !op.To.isZero()) && !(op.Action == BatchDelete && op.Entity == "course"):
+		return invalid(nil, "mode and to are only for deleting a course")
+	}
+	for _, course := range op.Courses {
+		if err := checkRef(refs, "course", "courses", course); err != nil {
+			return err
+		}
+	}
+	if !op.To.isZero() {
+		return checkRef(refs, "course", "to", op.To)
+	}
+	return nil
+}
+
+// checkRef checks an id of an operation is either positive or a ref made
+// earlier for the entity it is used as
+func checkRef(refs map[string]string, entity, field string, id BatchID) error {
+	if id.Ref == "" {
+		if id.ID < 1 {
+			return invalid(nil, "%s must be a positive integer or the ref of an earlier create", field)
+		}
+		return nil
+	}
+	made, ok := refs[id.Ref]
+	if !ok {
+		return invalid(nil, "%s names ref %q, which no earlier create makes", field, id.Ref)
+	}
+	if made != entity {
+		return invalid(nil, "%s names ref %q, which is a %s and not a %s", field, id.Ref, made, entity)
+	}
+	return nil
+}
+
+// runBatch runs validated ops one after another on store, refs are replaced
+// with the ids of the rows their creates made
+func runBatch(ctx context.Context, store batchStore, ops []BatchOp) ([]BatchResult, error) {
+	made := map[string]int{}
+	results := make([]BatchResult, len(ops))
+	for i, op := range ops {
+		result, err := runBatchOp(ctx, store, op, made)
+		if err != nil {
+			return nil, &BatchError{Index: i, Err: err}
+		}
+		if op.Ref != "" {
+			made[op.Ref] = result.ID
+		}
+		results[i] = result
+	}
+	return results, nil
+}
+
+// runBatchOp runs one operation, made maps the refs made so far to their ids
+func runBatchOp(ctx context.Context, store batchStore, op BatchOp, made map[string]int) (BatchResult, error) {
+	resolve := func(id BatchID) int {
+		if id.Ref != "" {
+			return made[id.Ref]
+		}
+		return id.ID
+	}
+	id := resolve(op.ID)
+	courseIDs := make([]int, len(op.Courses))
+	for i, course := range op.Courses {
+		courseIDs[i] = resolve(course)
+	}
+
+	switch {
+	case op.Entity == "course" && op.Action == BatchCreate:
+		course, err := store.CreateCourse(ctx, models.Course{Name: op.Course.Name})
+		if err != nil {
+			return BatchResult{}, err
+		}
+		return BatchResult{ID: course.ID, Course: &course}, nil
+	case op.Entity == "course" && op.Action == BatchUpdate:
+		course, err := store.PatchCourse(ctx, id, op.Version, func(current models.Course) (models.Course, error) {
+			current.Name = op.Course.Name
+			return current, nil
+		})
+		if err != nil {
+			return BatchResult{}, err
+		}
+		return BatchResult{ID: id, Course: &course}, nil
+	case op.Entity == "course" && op.Action == BatchDelete:
+		deletion := CourseDeletion{Mode: op.Mode, To: resolve(op.To)}
+		deleted, err := store.DeleteCourse(ctx, id, op.Version, deletion)
+		if err != nil {
+			return BatchResult{}, err
+		}
+		return BatchResult{ID: id, Deleted: &deleted}, nil
+	case op.Action == BatchCreate:
+		if err := checkCourses(ctx, store, courseIDs); err != nil {
+			return BatchResult{}, err
+		}
+		person := *op.Person
+		person.Courses = courseIDs
+		person, err := store.CreatePerson(ctx, person)
+		if err != nil {
+			return BatchResult{}, err
+		}
+		return BatchResult{ID: person.ID, Person: &person}, nil
+	case op.Action == BatchUpdate:
+		// like a PUT, the courses listed are added to the ones the person has
+		if err := checkCourses(ctx, store, courseIDs); err != nil {
+			return BatchResult{}, err
+		}
+		person, err := store.PatchPerson(ctx, id, op.Version, func(current models.Person) (models.Person, error) {
+			patched := *op.Person
+			patched.Courses = current.Courses
+			for _, courseID := range courseIDs {
+				if !slices.Contains(patched.Courses, courseID) {
+					patched.Courses = append(patched.Courses, courseID)
+				}
+			}
+			return patched, nil
+		})
+		if err != nil {
+			return BatchResult{}, err
+		}
+		return BatchResult{ID: id, Person: &person}, nil
+	case op.Action == BatchDelete:
+		return BatchResult{ID: id}, store.DeletePerson(ctx, id, op.Version)
+	case op.Action == BatchEnroll:
+		if err := checkCourses(ctx, store, courseIDs); err != nil {
+			return BatchResult{}, err
+		}
+		results, err := store.AddPersonToCourse(ctx, id, op.Version, courseIDs)
+		if err != nil {
+			return BatchResult{}, err
+		}
+		return BatchResult{ID: id, Enrollments: results}, nil
+	default:
+		results, err := store.RemovePersonFromCourses(ctx, id, op.Version, courseIDs)
+		if err != nil {
+			return BatchResult{}, err
+		}
+		return BatchResult{ID: id, Enrollments: results}, nil
+	}
+}
+
+// checkCourses fails an operation with ErrValidation unless ids are live
+// courses, each named once. The single endpoints leave this to the handlers,
+// which cannot know the ids refs stand for.
+func checkCourses(ctx context.Context, store batchStore, ids []int) error {
+	seen := make(map[int]struct{}, len(ids))
+	for _, id := range ids {
+		if _, ok := seen[id]; ok {
+			return invalid(nil, "course %d is named more than once", id)
+		}
+		seen[id] = struct{}{}
+	}
+	missing, err := store.MissingCourseIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return invalid(nil, "course %d does not exist", missing[0])
+	}
+	return nil
+}
+
+// txStore is the batchStore of the postgres store, every call runs in tx
+type txStore struct {
+	tx *sql.Tx
+}
+
+func (s txStore) CreateCourse(ctx context.Context, course models.Course) (models.Course, error) {
+	return createCourse(ctx, s.tx, course)
+}
+
+func (s txStore) PatchCourse(ctx context.Context, id, version int, patch CoursePatch) (models.Course, error) {
+	return patchCourse(ctx, s.tx, id, version, patch)
+}
+
+func (s txStore) DeleteCourse(ctx context.Context, id, version int, deletion CourseDeletion) (CourseDeleted, error) {
+	if err := deletion.Validate(id); err != nil {
+		return CourseDeleted{}, err
+	}
+	return deleteCourse(ctx, s.tx, id, version, deletion)
+}
+
+func (s txStore) MissingCourseIDs(ctx context.Context, ids []int) ([]int, error) {
+	return missingCourseIDs(ctx, s.tx, ids)
+}
+
+func (s txStore) CreatePerson(ctx context.Context, person models.Person) (models.Person, error) {
+	return createPerson(ctx, s.tx, person)
+}
+
+func (s txStore) PatchPerson(ctx context.Context, id, version int, patch PersonPatch) (models.Person, error) {
+	return patchPerson(ctx, s.tx, id, version, patch)
+}
+
+func (s txStore) DeletePerson(ctx context.Context, id, version int) error {
+	return deletePerson(ctx, s.tx, id, version)
+}
+
+func (s txStore) AddPersonToCourse(ctx context.Context, personID, version int, courseIDs []int) ([]EnrollmentResult, error) {
+	return changeLocked(ctx, s.tx, personID, version, func(tx *sql.Tx) ([]EnrollmentResult, error) {
+		return changeEnrollments(ctx, tx, enrollQuery, personID, courseIDs, EnrollmentAdded, EnrollmentAlreadyEnrolled)
+	})
+}
+
+func (s txStore) RemovePersonFromCourses(ctx context.Context, personID, version int, courseIDs []int) ([]EnrollmentResult, error) {
+	return changeLocked(ctx, s.tx, personID, version, func(tx *sql.Tx) ([]EnrollmentResult, error) {
+		return changeEnrollments(ctx, tx, unenrollQuery, personID, courseIDs, EnrollmentRemoved, EnrollmentNotEnrolled)
+	})
+}
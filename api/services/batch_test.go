@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jacob-tech-challenge/api/models"
+)
+
+func TestValidateBatch(t *testing.T) {
+	math := BatchOp{Action: BatchCreate, Entity: "course", Ref: "math", Course: &models.Course{Name: "Math"}}
+	ada := BatchOp{Action: BatchCreate, Entity: "person", Ref: "ada", Person: &models.Person{FirstName: "Ada"}}
+
+	tests := map[string]struct {
+		ops           []BatchOp
+		expectedIndex int // -1 when the batch is valid
+	}{
+		"refs to earlier creates": {
+			ops: []BatchOp{math, ada,
+				{Action: BatchEnroll, Entity: "person", ID: BatchID{Ref: "ada"}, Courses: []BatchID{{Ref: "math"}, {ID: 2}}},
+				{Action: BatchDelete, Entity: "course", ID: BatchID{ID: 3}, Mode: DeleteReassign, To: BatchID{Ref: "math"}},
+			},
+			expectedIndex: -1,
+		},
+		"unknown type":             {ops: []BatchOp{{Action: BatchCreate, Entity: "enrollment"}}},
+		"unknown op":               {ops: []BatchOp{{Action: "upsert", Entity: "course"}}},
+		"create with an id":        {ops: []BatchOp{{Action: BatchCreate, Entity: "course", ID: BatchID{ID: 1}, Course: &models.Course{}}}},
+		"create without a body":    {ops: []BatchOp{{Action: BatchCreate, Entity: "person"}}},
+		"update without an id":     {ops: []BatchOp{{Action: BatchUpdate, Entity: "course", Course: &models.Course{}}}},
+		"body of the wrong entity": {ops: []BatchOp{{Action: BatchCreate, Entity: "course", Course: &models.Course{}, Person: &models.Person{}}}},
+		"enroll a course":          {ops: []BatchOp{{Action: BatchEnroll, Entity: "course", ID: BatchID{ID: 1}, Courses: []BatchID{{ID: 2}}}}},
+		"enroll in nothing":        {ops: []BatchOp{{Action: BatchEnroll, Entity: "person", ID: BatchID{ID: 1}}}},
+		"mode on a person":         {ops: []BatchOp{{Action: BatchDelete, Entity: "person", ID: BatchID{ID: 1}, Mode: DeleteCascade}}},
+		"ref taken twice":          {ops: []BatchOp{math, math}, expectedIndex: 1},
+		"ref made later":           {ops: []BatchOp{{Action: BatchDelete, Entity: "course", ID: BatchID{Ref: "math"}}, math}},
+		"ref of the wrong entity":  {ops: []BatchOp{ada, {Action: BatchDelete, Entity: "course", ID: BatchID{Ref: "ada"}}}, expectedIndex: 1},
+		"course ref of a person":   {ops: []BatchOp{ada, {Action: BatchEnroll, Entity: "person", ID: BatchID{ID: 1}, Courses: []BatchID{{Ref: "ada"}}}}, expectedIndex: 1},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := validateBatch(tc.ops)
+			if tc.expectedIndex < 0 {
+				assert.NoError(t, err)
+				return
+			}
+			var batchErr *BatchError
+			if assert.ErrorAs(t, err, &batchErr) {
+				assert.Equal(t, tc.expectedIndex, batchErr.Index)
+			}
+			assert.ErrorIs(t, err, ErrValidation)
+		})
+	}
+
+	assert.ErrorIs(t, validateBatch(nil), ErrValidation)
+}
+
+func TestRunBatch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	const createCourseQuery = `INSERT INTO "course" \(name\) VALUES \(\$1\) RETURNING id, version, updated_at`
+	ops := []BatchOp{
+		{Action: BatchCreate, Entity: "course", Ref: "math", Course: &models.Course{Name: "Math"}},
+		{Action: BatchCreate, Entity: "person", Ref: "ada", Person: &models.Person{FirstName: "Ada", LastName: "Lovelace", Type: "professor", Age: 36}, Courses: []BatchID{{Ref: "math"}}},
+		{Action: BatchDelete, Entity: "person", ID: BatchID{ID: 9}},
+	}
+
+	t.Run("later operations use the ids of earlier creates", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(createCourseQuery).WithArgs("Math").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "version", "updated_at"}).AddRow(4, 1, testUpdatedAt))
+		expectAudit(mock, "course", 4, AuditCreate)
+		expectEviction(mock, courseEviction(0))
+		// once for the batch and once more by the create itself
+		mock.ExpectQuery(missingCoursesQuery).WithArgs(pq.Int64Array{4}).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(missingCoursesQuery).WithArgs(pq.Int64Array{4}).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(`INSERT INTO person`).WithArgs("Ada", "Lovelace", "professor", 36).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "version", "updated_at"}).AddRow(7, 1, testUpdatedAt))
+		mock.ExpectExec(`INSERT INTO person_course`).WithArgs(7, 4).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectHistory(mock, 7)
+		expectAudit(mock, "person", 7, AuditCreate)
+		expectEviction(mock, personEviction(7))
+		mock.ExpectCommit()
+
+		results, err := RunBatch(context.Background(), db, ops[:2])
+		assert.NoError(t, err)
+		if assert.Len(t, results, 2) {
+			assert.Equal(t, 4, results[0].ID)
+			assert.Equal(t, &models.Course{ID: 4, Name: "Math", Version: 1, UpdatedAt: testUpdatedAt}, results[0].Course)
+			assert.Equal(t, 7, results[1].ID)
+			assert.Equal(t, []int{4}, results[1].Person.Courses)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("a failed operation rolls back the batch", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(createCourseQuery).WithArgs("Math").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "version", "updated_at"}).AddRow(4, 1, testUpdatedAt))
+		expectAudit(mock, "course", 4, AuditCreate)
+		expectEviction(mock, courseEviction(0))
+		// once for the batch and once more by the create itself
+		mock.ExpectQuery(missingCoursesQuery).WithArgs(pq.Int64Array{4}).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(missingCoursesQuery).WithArgs(pq.Int64Array{4}).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(`INSERT INTO person`).WithArgs("Ada", "Lovelace", "professor", 36).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "version", "updated_at"}).AddRow(7, 1, testUpdatedAt))
+		mock.ExpectExec(`INSERT INTO person_course`).WithArgs(7, 4).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectHistory(mock, 7)
+		expectAudit(mock, "person", 7, AuditCreate)
+		expectEviction(mock, personEviction(7))
+		mock.ExpectQuery(lockPersonQuery).WithArgs(9).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		_, err := RunBatch(context.Background(), db, ops)
+		var batchErr *BatchError
+		if assert.ErrorAs(t, err, &batchErr) {
+			assert.Equal(t, 2, batchErr.Index)
+		}
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("a missing course fails the person", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(missingCoursesQuery).WithArgs(pq.Int64Array{5}).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+		mock.ExpectRollback()
+
+		_, err := RunBatch(context.Background(), db, []BatchOp{
+			{Action: BatchEnroll, Entity: "person", ID: BatchID{ID: 1}, Courses: []BatchID{{ID: 5}}},
+		})
+		assert.ErrorIs(t, err, ErrValidation)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestMemoryStoreRunBatch(t *testing.T) {
+	ctx := context.Background()
+	store := newSeededMemoryStore(t)
+
+	results, err := store.RunBatch(ctx, []BatchOp{
+		{Action: BatchCreate, Entity: "course", Ref: "art", Course: &models.Course{Name: "Art"}},
+		{Action: BatchCreate, Entity: "person", Ref: "ada", Person: &models.Person{FirstName: "Ada", LastName: "Lovelace", Type: "professor", Age: 36}, Courses: []BatchID{{Ref: "art"}}},
+		{Action: BatchEnroll, Entity: "person", ID: BatchID{Ref: "ada"}, Courses: []BatchID{{ID: 1}, {Ref: "art"}}},
+		{Action: BatchUpdate, Entity: "person", ID: BatchID{ID: 2}, Version: 1, Person: &models.Person{FirstName: "Jane", LastName: "Smith", Type: "professor", Age: 31}, Courses: []BatchID{{Ref: "art"}}},
+		{Action: BatchDelete, Entity: "course", ID: BatchID{ID: 2}, Mode: DeleteCascade},
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, results, 5) {
+		assert.Equal(t, 3, results[0].ID)
+		assert.Equal(t, []int{3}, results[1].Person.Courses)
+		assert.Equal(t, []EnrollmentResult{{CourseID: 1, Status: EnrollmentAdded}, {CourseID: 3, Status: EnrollmentAlreadyEnrolled}}, results[2].Enrollments)
+		assert.Equal(t, []int{2, 3}, results[3].Person.Courses)
+		assert.Equal(t, &CourseDeleted{Affected: 2}, results[4].Deleted)
+	}
+	jane, err := store.GetPersonByID(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{3}, jane.Courses)
+
+	// a failure leaves everything as it was, the audit log included
+	entries, _, err := store.GetAuditLog(ctx, AuditFilter{}, Page{})
+	assert.NoError(t, err)
+	_, err = store.RunBatch(ctx, []BatchOp{
+		{Action: BatchCreate, Entity: "course", Course: &models.Course{Name: "Geology"}},
+		{Action: BatchUpdate, Entity: "course", ID: BatchID{ID: 1}, Version: 7, Course: &models.Course{Name: "Algebra"}},
+	})
+	var batchErr *BatchError
+	if assert.ErrorAs(t, err, &batchErr) {
+		assert.Equal(t, 1, batchErr.Index)
+	}
+	assert.ErrorIs(t, err, ErrPreconditionFailed)
+	courses, _, err := store.GetAllCourses(ctx, CourseFilter{IncludeDeleted: true}, Page{})
+	assert.NoError(t, err)
+	assert.Len(t, courses, 3)
+	after, _, err := store.GetAuditLog(ctx, AuditFilter{}, Page{})
+	assert.NoError(t, err)
+	assert.Equal(t, entries, after)
+
+	// and the ids it used are handed out again
+	course, err := store.CreateCourse(ctx, models.Course{Name: "Geology"})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, course.ID)
+}
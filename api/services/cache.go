@@ -0,0 +1,213 @@
+package services
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Cache is an in-process cache of store reads, bounded in age by a TTL and in
+// size by a number of entries, evicting the least recently used entry first. Concurrent
+// misses on the same key share a single load. Only successful reads are
+// cached, errors like ErrNotFound always go to the store.
+type Cache struct {
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mu         sync.Mutex
+	entries    map[string]*list.Element
+	lru        *list.List // most recently used at the front
+	generation uint64     // bumped by every eviction, see cached
+	loading    map[string]int
+
+	loads singleflight.Group
+
+	hits, misses, loaded atomic.Int64
+}
+
+// CacheStats counts how a Cache has been used since it was created. Loads is
+// the number of reads that reached the store, fewer than Misses when misses on
+// the same key shared a load.
+type CacheStats struct {
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	Loads   int64 `json:"loads"`
+	Entries int   `json:"entries"`
+}
+
+// cacheEntry is the value of an lru element
+type cacheEntry struct {
+	key     string
+	value   any
+	expires time.Time
+}
+
+// NewCache returns an empty Cache. Entries live for ttl and at most
+// maxEntries are kept, a zero maxEntries leaves the size unbounded.
+func NewCache(ttl time.Duration, maxEntries int) *Cache {
+	return &Cache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		entries:    map[string]*list.Element{},
+		lru:        list.New(),
+		loading:    map[string]int{},
+	}
+}
+
+// Stats returns the hit and miss counters and the current number of entries
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	entries := c.lru.Len()
+	c.mu.Unlock()
+	return CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load(), Loads: c.loaded.Load(), Entries: entries}
+}
+
+// cached returns the value cached under key, or loads it. A value loaded while
+// the cache was evicting may predate the write that caused the eviction, so it
+// is returned to its callers but not kept.
+func cached[V any](ctx context.Context, c *Cache, key string, load func(ctx context.Context) (V, error)) (V, error) {
+	if value, ok := c.lookup(key); ok {
+		c.hits.Add(1)
+		return value.(V), nil
+	}
+	c.misses.Add(1)
+
+	for {
+		c.mu.Lock()
+		generation := c.generation
+		c.mu.Unlock()
+
+		results := c.loads.DoChan(key, func() (any, error) {
+			c.loaded.Add(1)
+			c.setLoading(key, 1)
+			defer c.setLoading(key, -1)
+			value, err := load(ctx)
+			if err == nil {
+				c.store(key, value, generation)
+			}
+			return value, err
+		})
+
+		select {
+		case <-ctx.Done():
+			var zero V
+			return zero, ctx.Err()
+		case result := <-results:
+			// a load run for a caller who gave up fails with their context
+			// error, which says nothing about this caller, so load again
+			if result.Err != nil && isContextErr(result.Err) && ctx.Err() == nil {
+				continue
+			}
+			if result.Err != nil {
+				var zero V
+				return zero, result.Err
+			}
+			return result.Val.(V), nil
+		}
+	}
+}
+
+// lookup returns the live entry for key and marks it recently used
+func (c *Cache) lookup(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !c.now().Before(entry.expires) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry.value, true
+}
+
+// store keeps value under key, unless something was evicted since generation
+func (c *Cache) store(key string, value any, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, value: value, expires: c.now().Add(c.ttl)})
+	for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+// evict drops the entries under keys and every entry whose key starts with one
+// of prefixes. Loads already running for those keys are not joined by later
+// callers, and their results are not kept.
+func (c *Cache) evict(keys []string, prefixes []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for _, key := range keys {
+		if elem, ok := c.entries[key]; ok {
+			c.remove(elem)
+		}
+		c.loads.Forget(key)
+	}
+	for _, prefix := range prefixes {
+		for key, elem := range c.entries {
+			if strings.HasPrefix(key, prefix) {
+				c.remove(elem)
+			}
+		}
+		for key := range c.loading {
+			if strings.HasPrefix(key, prefix) {
+				c.loads.Forget(key)
+			}
+		}
+	}
+}
+
+// evictAll drops every entry and keeps no result of the loads already running
+func (c *Cache) evictAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.entries = map[string]*list.Element{}
+	c.lru.Init()
+	for key := range c.loading {
+		c.loads.Forget(key)
+	}
+}
+
+// setLoading counts the loads running for key, so evict can find them by prefix
+func (c *Cache) setLoading(key string, delta int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.loading[key] += delta; c.loading[key] == 0 {
+		delete(c.loading, key)
+	}
+}
+
+// remove drops an entry, the caller holds mu
+func (c *Cache) remove(elem *list.Element) {
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+	c.lru.Remove(elem)
+}
+
+// isContextErr reports whether err comes from a cancelled or expired context
+func isContextErr(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
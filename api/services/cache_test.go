@@ -0,0 +1,270 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jacob-tech-challenge/api/models"
+)
+
+// countingStore counts the reads that reach the store a CachedStore wraps
+type countingStore struct {
+	Store
+	courseReads, coursesReads, personReads atomic.Int64
+}
+
+func (s *countingStore) GetCourseByID(ctx context.Context, id int) (models.Course, error) {
+	s.courseReads.Add(1)
+	return s.Store.GetCourseByID(ctx, id)
+}
+
+func (s *countingStore) GetAllCourses(ctx context.Context, filter CourseFilter, page Page) ([]models.Course, *Cursor, error) {
+	s.coursesReads.Add(1)
+	return s.Store.GetAllCourses(ctx, filter, page)
+}
+
+func (s *countingStore) GetPersonByID(ctx context.Context, id int) (models.Person, error) {
+	s.personReads.Add(1)
+	return s.Store.GetPersonByID(ctx, id)
+}
+
+func TestCacheExpiry(t *testing.T) {
+	clock := time.Date(2024, 9, 1, 8, 30, 0, 0, time.UTC)
+	cache := NewCache(time.Minute, 0)
+	cache.now = func() time.Time { return clock }
+
+	var loads int
+	load := func(ctx context.Context) (int, error) {
+		loads++
+		return loads, nil
+	}
+
+	got, _ := cached(context.Background(), cache, "k", load)
+	assert.Equal(t, 1, got)
+	clock = clock.Add(59 * time.Second)
+	got, _ = cached(context.Background(), cache, "k", load)
+	assert.Equal(t, 1, got, "served from the cache within the ttl")
+	clock = clock.Add(time.Second)
+	got, _ = cached(context.Background(), cache, "k", load)
+	assert.Equal(t, 2, got, "loaded again once the ttl is over")
+
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 2, Loads: 2, Entries: 1}, cache.Stats())
+}
+
+func TestCacheSizeBound(t *testing.T) {
+	cache := NewCache(time.Minute, 2)
+	load := func(value string) func(context.Context) (string, error) {
+		return func(context.Context) (string, error) { return value, nil }
+	}
+	ctx := context.Background()
+
+	cached(ctx, cache, "a", load("a"))
+	cached(ctx, cache, "b", load("b"))
+	cached(ctx, cache, "a", load("a")) // a is now the most recently used
+	cached(ctx, cache, "c", load("c")) // so b goes
+
+	_, aCached := cache.lookup("a")
+	_, bCached := cache.lookup("b")
+	_, cCached := cache.lookup("c")
+	assert.True(t, aCached)
+	assert.False(t, bCached)
+	assert.True(t, cCached)
+	assert.Equal(t, 2, cache.Stats().Entries)
+}
+
+func TestCacheErrorsAreNotCached(t *testing.T) {
+	cache := NewCache(time.Minute, 0)
+	var loads int
+	load := func(ctx context.Context) (int, error) {
+		loads++
+		return 0, notFound("thing 1")
+	}
+
+	for range 2 {
+		_, err := cached(context.Background(), cache, "k", load)
+		assert.ErrorIs(t, err, ErrNotFound)
+	}
+	assert.Equal(t, 2, loads)
+}
+
+func TestCacheSharesConcurrentMisses(t *testing.T) {
+	cache := NewCache(time.Minute, 0)
+	release := make(chan struct{})
+	var loads atomic.Int64
+	load := func(ctx context.Context) (int, error) {
+		loads.Add(1)
+		<-release
+		return 7, nil
+	}
+
+	const callers = 10
+	var wg sync.WaitGroup
+	results := make(chan int, callers)
+	for range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, _ := cached(context.Background(), cache, "k", load)
+			results <- value
+		}()
+	}
+	// every caller has missed once all of them are counted
+	assert.Eventually(t, func() bool { return cache.Stats().Misses == callers }, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	for value := range results {
+		assert.Equal(t, 7, value)
+	}
+	assert.Equal(t, int64(1), loads.Load())
+	assert.Equal(t, int64(1), cache.Stats().Loads)
+}
+
+func TestCacheEvictDuringLoad(t *testing.T) {
+	cache := NewCache(time.Minute, 0)
+	loading := make(chan struct{})
+	release := make(chan struct{})
+
+	done := make(chan string)
+	go func() {
+		value, _ := cached(context.Background(), cache, "course:1", func(context.Context) (string, error) {
+			close(loading)
+			<-release
+			return "before the write", nil
+		})
+		done <- value
+	}()
+
+	<-loading
+	cache.evict([]string{"course:1"}, nil)
+	close(release)
+
+	assert.Equal(t, "before the write", <-done, "the caller still gets what was loaded")
+	_, ok := cache.lookup("course:1")
+	assert.False(t, ok, "a load that raced an eviction is not kept")
+}
+
+func TestCacheEvictPrefix(t *testing.T) {
+	cache := NewCache(time.Minute, 0)
+	ctx := context.Background()
+	for _, key := range []string{"courses:10:", "courses:10:abc", "course:1"} {
+		cached(ctx, cache, key, func(context.Context) (int, error) { return 1, nil })
+	}
+
+	cache.evict(nil, []string{"courses:"})
+
+	_, ok := cache.lookup("course:1")
+	assert.True(t, ok)
+	assert.Equal(t, 1, cache.Stats().Entries)
+}
+
+func TestCacheRetriesAfterAnotherCallersCancellation(t *testing.T) {
+	cache := NewCache(time.Minute, 0)
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	loading := make(chan struct{})
+
+	leaderDone := make(chan error)
+	go func() {
+		_, err := cached(leaderCtx, cache, "k", func(ctx context.Context) (int, error) {
+			close(loading)
+			<-ctx.Done()
+			return 0, ctx.Err()
+		})
+		leaderDone <- err
+	}()
+	<-loading
+
+	followerDone := make(chan int)
+	go func() {
+		value, _ := cached(context.Background(), cache, "k", func(context.Context) (int, error) { return 2, nil })
+		followerDone <- value
+	}()
+	assert.Eventually(t, func() bool { return cache.Stats().Misses == 2 }, time.Second, time.Millisecond)
+	cancel()
+
+	assert.True(t, errors.Is(<-leaderDone, context.Canceled))
+	assert.Equal(t, 2, <-followerDone)
+}
+
+func TestCachedStore(t *testing.T) {
+	ctx := context.Background()
+	counting := &countingStore{Store: newSeededMemoryStore(t)}
+	store := NewCachedStore(counting, NewCache(time.Minute, 100))
+
+	// repeated reads reach the store once
+	for range 3 {
+		course, err := store.GetCourseByID(ctx, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, "Math", course.Name)
+		courses, _, err := store.GetAllCourses(ctx, CourseFilter{}, Page{Limit: 10})
+		assert.NoError(t, err)
+		assert.Len(t, courses, 2)
+		person, err := store.GetPersonByID(ctx, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, []int{1, 2}, person.Courses)
+	}
+	assert.Equal(t, int64(1), counting.courseReads.Load())
+	assert.Equal(t, int64(1), counting.coursesReads.Load())
+	assert.Equal(t, int64(1), counting.personReads.Load())
+
+	// callers cannot change what is cached
+	person, _ := store.GetPersonByID(ctx, 1)
+	person.Courses[0] = 99
+	person, _ = store.GetPersonByID(ctx, 1)
+	assert.Equal(t, []int{1, 2}, person.Courses)
+
+	// a course write evicts the course and every page of courses
+	_, err := store.UpdateCourse(ctx, 1, models.Course{Name: "Algebra"})
+	assert.NoError(t, err)
+	course, _ := store.GetCourseByID(ctx, 1)
+	assert.Equal(t, "Algebra", course.Name)
+	courses, _, _ := store.GetAllCourses(ctx, CourseFilter{}, Page{Limit: 10})
+	assert.Equal(t, "Algebra", courses[0].Name)
+
+	_, err = store.CreateCourse(ctx, models.Course{Name: "History"})
+	assert.NoError(t, err)
+	courses, _, _ = store.GetAllCourses(ctx, CourseFilter{}, Page{Limit: 10})
+	assert.Len(t, courses, 3)
+
+	assert.NoError(t, restrictDeleteCourse(ctx, store, 3, 0))
+	_, err = store.GetCourseByID(ctx, 3)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// enrollment changes evict the person, whose version went up
+	before, _ := store.GetPersonByID(ctx, 2)
+	_, err = store.AddPersonToCourse(ctx, 2, 0, []int{1})
+	assert.NoError(t, err)
+	after, _ := store.GetPersonByID(ctx, 2)
+	assert.Equal(t, []int{1, 2}, after.Courses)
+	assert.Greater(t, after.Version, before.Version)
+
+	assert.NoError(t, store.DeletePerson(ctx, 2, 0))
+	_, err = store.GetPersonByID(ctx, 2)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = store.RestorePerson(ctx, 2)
+	assert.NoError(t, err)
+	_, err = store.GetPersonByID(ctx, 2)
+	assert.NoError(t, err)
+
+	// a batch may change anything, so everything goes
+	_, err = store.RunBatch(ctx, []BatchOp{
+		{Action: BatchUpdate, Entity: "course", ID: BatchID{ID: 1}, Course: &models.Course{Name: "Geometry"}},
+		{Action: BatchUnenroll, Entity: "person", ID: BatchID{ID: 2}, Courses: []BatchID{{ID: 1}}},
+	})
+	assert.NoError(t, err)
+	course, _ = store.GetCourseByID(ctx, 1)
+	assert.Equal(t, "Geometry", course.Name)
+	after, _ = store.GetPersonByID(ctx, 2)
+	assert.Equal(t, []int{2}, after.Courses)
+
+	stats := store.Stats()
+	assert.Positive(t, stats.Hits)
+	assert.Positive(t, stats.Misses)
+}
@@ -0,0 +1,182 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/jacob-tech-challenge/api/models"
+)
+
+// cache keys, course lists are keyed by page and evicted together
+const (
+	coursesKeyPrefix = "courses:"
+	courseKeyPrefix  = "course:"
+	personKeyPrefix  = "person:"
+)
+
+// CachedStore is a Store that answers course reads and person lookups by id
+// from a Cache. Every other method goes straight to the wrapped store, and the
+// writes among them evict the entries they change here. The other instances
+// sharing a postgres database hear of the write from the write itself, see
+// ListenForEvictions.
+type CachedStore struct {
+	Store
+	cache *Cache
+}
+
+// NewCachedStore returns store with reads cached in cache
+func NewCachedStore(store Store, cache *Cache) *CachedStore {
+	return &CachedStore{Store: store, cache: cache}
+}
+
+// Stats returns the counters of the underlying cache
+func (s *CachedStore) Stats() CacheStats {
+	return s.cache.Stats()
+}
+
+// coursePage is a cached page of courses
+type coursePage struct {
+	courses []models.Course
+	next    *Cursor
+}
+
+// GetAllCourses returns a page of the courses matching the filter
+func (s *CachedStore) GetAllCourses(ctx context.Context, filter CourseFilter, page Page) ([]models.Course, *Cursor, error) {
+	key := coursesKeyPrefix + strconv.Itoa(page.Limit) + ":" + strconv.FormatBool(filter.IncludeDeleted) + ":"
+	if page.After != nil {
+		key += page.After.Encode()
+	}
+	p, err := cached(ctx, s.cache, key, func(ctx context.Context) (coursePage, error) {
+		courses, next, err := s.Store.GetAllCourses(ctx, filter, page)
+		return coursePage{courses: courses, next: next}, err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	// callers own what they are handed, the cached page stays as loaded
+	return slices.Clone(p.courses), p.next, nil
+}
+
+// GetCourseByID returns a course by id
+func (s *CachedStore) GetCourseByID(ctx context.Context, id int) (models.Course, error) {
+	return cached(ctx, s.cache, courseKey(id), func(ctx context.Context) (models.Course, error) {
+		return s.Store.GetCourseByID(ctx, id)
+	})
+}
+
+// UpdateCourse updates a course
+func (s *CachedStore) UpdateCourse(ctx context.Context, id int, course models.Course) (models.Course, error) {
+	defer s.evict(courseEviction(id))
+	return s.Store.UpdateCourse(ctx, id, course)
+}
+
+// CreateCourse creates a course
+func (s *CachedStore) CreateCourse(ctx context.Context, course models.Course) (models.Course, error) {
+	defer s.evict(courseEviction(0))
+	return s.Store.CreateCourse(ctx, course)
+}
+
+// DeleteCourse soft deletes a course. Cascade and reassign change the people
+// enrolled, so every cached person goes too.
+func (s *CachedStore) DeleteCourse(ctx context.Context, id, version int, deletion CourseDeletion) (CourseDeleted, error) {
+	if !deletion.restricts() {
+		defer s.evict(enrolledEviction(id))
+	} else {
+		defer s.evict(courseEviction(id))
+	}
+	return s.Store.DeleteCourse(ctx, id, version, deletion)
+}
+
+// RestoreCourse undoes the soft delete of a course. The enrollments that come
+// back with it change the people enrolled, so every cached person goes too.
+func (s *CachedStore) RestoreCourse(ctx context.Context, id int) (models.Course, error) {
+	defer s.evict(enrolledEviction(id))
+	return s.Store.RestoreCourse(ctx, id)
+}
+
+// PatchCourse changes a course to what patch makes of it
+func (s *CachedStore) PatchCourse(ctx context.Context, id, version int, patch CoursePatch) (models.Course, error) {
+	defer s.evict(courseEviction(id))
+	return s.Store.PatchCourse(ctx, id, version, patch)
+}
+
+// GetPersonByID returns a person by id
+func (s *CachedStore) GetPersonByID(ctx context.Context, id int) (models.Person, error) {
+	person, err := cached(ctx, s.cache, personKey(id), func(ctx context.Context) (models.Person, error) {
+		return s.Store.GetPersonByID(ctx, id)
+	})
+	person.Courses = slices.Clone(person.Courses)
+	return person, err
+}
+
+// UpdatePerson updates a person by id
+func (s *CachedStore) UpdatePerson(ctx context.Context, id int, person models.Person) (models.Person, error) {
+	defer s.evict(personEviction(id))
+	return s.Store.UpdatePerson(ctx, id, person)
+}
+
+// PatchPerson changes a person and their courses to what patch makes of them
+func (s *CachedStore) PatchPerson(ctx context.Context, id, version int, patch PersonPatch) (models.Person, error) {
+	defer s.evict(personEviction(id))
+	return s.Store.PatchPerson(ctx, id, version, patch)
+}
+
+// DeletePerson soft deletes a person by id
+func (s *CachedStore) DeletePerson(ctx context.Context, id, version int) error {
+	defer s.evict(personEviction(id))
+	return s.Store.DeletePerson(ctx, id, version)
+}
+
+// RestorePerson undoes the soft delete of a person
+func (s *CachedStore) RestorePerson(ctx context.Context, id int) (models.Person, error) {
+	defer s.evict(personEviction(id))
+	return s.Store.RestorePerson(ctx, id)
+}
+
+// PurgeDeleted removes for good what was soft deleted before the cutoff, which
+// only the course lists that include deleted courses may hold
+func (s *CachedStore) PurgeDeleted(ctx context.Context, before time.Time) (Purged, error) {
+	defer s.evict(Eviction{Prefixes: []string{coursesKeyPrefix}})
+	return s.Store.PurgeDeleted(ctx, before)
+}
+
+// AddPersonToCourse adds a person to multiple courses
+func (s *CachedStore) AddPersonToCourse(ctx context.Context, personID, version int, courseIDs []int) ([]EnrollmentResult, error) {
+	defer s.evict(personEviction(personID))
+	return s.Store.AddPersonToCourse(ctx, personID, version, courseIDs)
+}
+
+// RemovePersonFromCourses removes a person from multiple courses
+func (s *CachedStore) RemovePersonFromCourses(ctx context.Context, personID, version int, courseIDs []int) ([]EnrollmentResult, error) {
+	defer s.evict(personEviction(personID))
+	return s.Store.RemovePersonFromCourses(ctx, personID, version, courseIDs)
+}
+
+// SetPersonCourses replaces the courses a person is enrolled in
+func (s *CachedStore) SetPersonCourses(ctx context.Context, personID, version int, courseIDs []int) ([]EnrollmentResult, error) {
+	defer s.evict(personEviction(personID))
+	return s.Store.SetPersonCourses(ctx, personID, version, courseIDs)
+}
+
+// RunBatch runs a batch of writes. Any course or person may have changed, so
+// the whole cache goes.
+func (s *CachedStore) RunBatch(ctx context.Context, ops []BatchOp) ([]BatchResult, error) {
+	defer s.evict(Eviction{Prefixes: []string{coursesKeyPrefix, courseKeyPrefix, personKeyPrefix}})
+	return s.Store.RunBatch(ctx, ops)
+}
+
+// evict drops the entries of eviction. Writes evict even when they fail: one
+// refused as stale means the cached copy is stale too.
+func (s *CachedStore) evict(eviction Eviction) {
+	s.cache.evict(eviction.Keys, eviction.Prefixes)
+}
+
+func courseKey(id int) string {
+	return courseKeyPrefix + strconv.Itoa(id)
+}
+
+func personKey(id int) string {
+	return personKeyPrefix + strconv.Itoa(id)
+}
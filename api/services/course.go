@@ -3,18 +3,27 @@ package services
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 
 	"github.com/jacob-tech-challenge/api/models"
 )
 
-// GetAllCourses returns all courses
-func GetAllCourses(db *sql.DB) ([]models.Course, error) {
-	ctx := context.Background()
+// CourseFilter selects the courses returned by GetAllCourses
+type CourseFilter struct {
+	// IncludeDeleted lists soft deleted courses along with the live ones
+	IncludeDeleted bool
+}
 
-	rows, err := db.QueryContext(ctx, `SELECT * FROM "course"`)
+// GetAllCourses returns a page of courses ordered by id, and the cursor of the next page if there is one
+func GetAllCourses(ctx context.Context, db *sql.DB, filter CourseFilter, page Page) ([]models.Course, *Cursor, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT id, name, version, updated_at, deleted_at FROM "course" WHERE id > $1 AND ($3 OR deleted_at IS NULL) ORDER BY id LIMIT $2`,
+		page.afterID(), page.limitArg(), filter.IncludeDeleted,
+	)
 	if err != nil {
-		return []models.Course{}, err // Return early if there's an error in QueryContext
+		return []models.Course{}, nil, err // Return early if there's an error in QueryContext
 	}
 	defer func() {
 		if closeErr := rows.Close(); closeErr != nil {
@@ -27,79 +36,356 @@ func GetAllCourses(db *sql.DB) ([]models.Course, error) {
 	var courses []models.Course
 	for rows.Next() {
 		var course models.Course
-		if err := rows.Scan(&course.ID, &course.Name); err != nil {
-			return nil, err
+		if err := rows.Scan(&course.ID, &course.Name, &course.Version, &course.UpdatedAt, &course.DeletedAt); err != nil {
+			return nil, nil, err
 		}
 		courses = append(courses, course)
 	}
 	if err := rows.Err(); err != nil {
-		return nil, err // Check for errors after iteration
+		return nil, nil, err // Check for errors after iteration
 	}
-	return courses, nil
+	courses, next := nextCursor(courses, page.Limit, func(c models.Course) Cursor { return Cursor{ID: c.ID} })
+	return courses, next, nil
 }
 
-// GetCourseByID returns a course by id
-func GetCourseByID(db *sql.DB, id int) (models.Course, error) {
-
-	ctx := context.Background()
+// GetCourseByID returns a course by id, a soft deleted course is not found
+func GetCourseByID(ctx context.Context, db *sql.DB, id int) (models.Course, error) {
 
 	var course models.Course
 
 	if err := db.QueryRowContext(
 		ctx,
-		`SELECT * FROM "course" WHERE id = $1`,
+		`SELECT id, name, version, updated_at FROM "course" WHERE id = $1 AND deleted_at IS NULL`,
 		id,
-	).Scan(&course.ID, &course.Name); err != nil {
-		return models.Course{}, err
+	).Scan(&course.ID, &course.Name, &course.Version, &course.UpdatedAt); err != nil {
+		return models.Course{}, noRows(err, "course %d", id)
 	}
 	return course, nil
 }
 
-// UpdateCourse updates a course
-func UpdateCourse(db *sql.DB, id int, course models.Course) (models.Course, error) {
-	
-	ctx := context.Background()
+// UpdateCourse updates a course. A non-zero course.Version must be the
+// course's current version, or ErrPreconditionFailed is returned.
+func UpdateCourse(ctx context.Context, db *sql.DB, id int, course models.Course) (models.Course, error) {
+	return PatchCourse(ctx, db, id, course.Version, func(models.Course) (models.Course, error) {
+		return course, nil
+	})
+}
+
+// CreateCourse creates a course
+func CreateCourse(ctx context.Context, db *sql.DB, course models.Course) (models.Course, error) {
+	return inTx(ctx, db, func(tx *sql.Tx) (models.Course, error) {
+		return createCourse(ctx, tx, course)
+	})
+}
 
-	_, err := db.ExecContext(
+// createCourse creates a course in tx
+func createCourse(ctx context.Context, tx *sql.Tx, course models.Course) (models.Course, error) {
+	err := tx.QueryRowContext(
 		ctx,
-		`UPDATE "course" SET name = $1 WHERE id = $2`,
-		course.Name, id,
-	)
+		`INSERT INTO "course" (name) VALUES ($1) RETURNING id, version, updated_at`,
+		course.Name,
+	).Scan(&course.ID, &course.Version, &course.UpdatedAt)
 	if err != nil {
 		return models.Course{}, err
 	}
-	// return the updated course
+	if err := writeAudit(ctx, tx, courseChange(ctx, AuditCreate, nil, &course)); err != nil {
+		return models.Course{}, err
+	}
+	if err := publishEviction(ctx, tx, courseEviction(0)); err != nil {
+		return models.Course{}, err
+	}
 	return course, nil
 }
 
-// CreateCourse creates a course
-func CreateCourse(db *sql.DB, course models.Course) (models.Course, error) {
-	
-	ctx := context.Background()
+// DeleteMode is what DeleteCourse does with the enrollments of the live people
+// in the course. Enrollments of deleted people are kept either way, like the
+// course itself, for a restore.
+type DeleteMode string
 
-	err := db.QueryRowContext(
-		ctx,
-		`INSERT INTO "course" (name) VALUES ($1) RETURNING id`,
-		course.Name,
-	).Scan(&course.ID)
+const (
+	// DeleteRestrict refuses to delete a course anyone is enrolled in
+	DeleteRestrict DeleteMode = "restrict"
+	// DeleteCascade removes the enrollments along with the course
+	DeleteCascade DeleteMode = "cascade"
+	// DeleteReassign moves the enrollments to another course
+	DeleteReassign DeleteMode = "reassign"
+)
+
+// CourseDeletion says how DeleteCourse treats the enrollments in a course, To
+// is the course reassign moves them to. The zero value restricts.
+type CourseDeletion struct {
+	Mode DeleteMode
+	To   int
+}
+
+// Validate checks the deletion of course id
+func (d CourseDeletion) Validate(id int) error {
+	switch d.Mode {
+	case "", DeleteRestrict, DeleteCascade:
+		if d.To != 0 {
+			return &FilterError{Param: "to", Reason: "is only for mode reassign"}
+		}
+	case DeleteReassign:
+		if d.To <= 0 {
+			return &FilterError{Param: "to", Reason: "must be the id of the course to reassign to"}
+		}
+		if d.To == id {
+			return &FilterError{Param: "to", Reason: "must be another course"}
+		}
+	default:
+		return &FilterError{Param: "mode", Reason: "must be restrict, cascade or reassign"}
+	}
+	return nil
+}
+
+// restricts reports whether the deletion refuses to remove enrollments
+func (d CourseDeletion) restricts() bool {
+	return d.Mode == DeleteRestrict || d.Mode == ""
+}
+
+// CourseDeleted reports what a course delete did to the enrollments in it.
+// Affected counts the enrollments taken off the course, Moved the ones reassign
+// added to the other course, fewer when people were enrolled in both.
+type CourseDeleted struct {
+	Affected int
+	Moved    int
+}
+
+// EnrolledError is the ErrConflict of a restricted delete of a course that
+// still has live people enrolled, it lists them
+type EnrolledError struct {
+	CourseID  int
+	PersonIDs []int
+}
+
+func (e *EnrolledError) Error() string {
+	return fmt.Sprintf("course %d still has people enrolled", e.CourseID)
+}
+
+// Unwrap makes every EnrolledError an ErrConflict
+func (e *EnrolledError) Unwrap() error {
+	return ErrConflict
+}
+
+// DeleteCourse soft deletes a course and deals with the enrollments of the
+// live people in it as deletion says, all in one transaction. Restrict fails
+// with an *EnrolledError while anyone is enrolled, cascade and reassign give
+// everyone affected a new version. Reassigning to a course that does not exist
+// is ErrValidation. A non-zero version must be the course's current version.
+func DeleteCourse(ctx context.Context, db *sql.DB, id, version int, deletion CourseDeletion) (CourseDeleted, error) {
+	if err := deletion.Validate(id); err != nil {
+		return CourseDeleted{}, err
+	}
+	return inTx(ctx, db, func(tx *sql.Tx) (CourseDeleted, error) {
+		return deleteCourse(ctx, tx, id, version, deletion)
+	})
+}
+
+// deleteCourse soft deletes a course in tx, deletion has been validated
+func deleteCourse(ctx context.Context, tx *sql.Tx, id, version int, deletion CourseDeletion) (CourseDeleted, error) {
+	// the lock keeps new enrollments out until the course is gone, see enrollQuery
+	current, err := lockCourse(ctx, tx, id)
 	if err != nil {
-		return models.Course{}, err
+		return CourseDeleted{}, err
+	}
+	if err := checkVersion("course", id, current.Version, version); err != nil {
+		return CourseDeleted{}, err
+	}
+	if deletion.Mode == DeleteReassign {
+		// share locked like enrollQuery does, so the course stays until the move is done
+		var to int
+		if err := tx.QueryRowContext(ctx, `SELECT id FROM course WHERE id = $1 AND deleted_at IS NULL FOR SHARE`, deletion.To).Scan(&to); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return CourseDeleted{}, invalid(nil, "course %d to reassign to does not exist", deletion.To)
+			}
+			return CourseDeleted{}, err
+		}
+	}
+	enrolled, err := lockEnrolled(ctx, tx, id)
+	if err != nil {
+		return CourseDeleted{}, err
+	}
+
+	var result CourseDeleted
+	var entries []AuditEntry
+	eviction := courseEviction(id)
+	if len(enrolled) > 0 {
+		ids := make([]int, len(enrolled))
+		for i, person := range enrolled {
+			ids[i] = person.ID
+		}
+		if deletion.restricts() {
+			return CourseDeleted{}, &EnrolledError{CourseID: id, PersonIDs: ids}
+		}
+		if deletion.Mode == DeleteReassign {
+			moved, err := tx.ExecContext(ctx, `
+				INSERT INTO person_course (person_id, course_id)
+				SELECT unnest($1::int[]), $2
+				ON CONFLICT (person_id, course_id) DO NOTHING`, int64s(ids), deletion.To)
+			if err != nil {
+				return CourseDeleted{}, err
+			}
+			if result.Moved, err = rowsAffected(moved); err != nil {
+				return CourseDeleted{}, err
+			}
+		}
+		removed, err := tx.ExecContext(ctx, `DELETE FROM person_course WHERE course_id = $1 AND person_id = ANY($2)`, id, int64s(ids))
+		if err != nil {
+			return CourseDeleted{}, err
+		}
+		if result.Affected, err = rowsAffected(removed); err != nil {
+			return CourseDeleted{}, err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE person SET version = version + 1, updated_at = now() WHERE id = ANY($1)`, int64s(ids)); err != nil {
+			return CourseDeleted{}, err
+		}
+		if err := recordHistory(ctx, tx, ids...); err != nil {
+			return CourseDeleted{}, err
+		}
+		after, err := queryPeople(ctx, tx, `WHERE p.id = ANY($1)`, int64s(ids))
+		if err != nil {
+			return CourseDeleted{}, err
+		}
+		for i := range enrolled {
+			entries = append(entries, personChange(ctx, AuditEnrollment, &enrolled[i], &after[i]))
+		}
+		eviction = eviction.withPeople(ids...)
+	}
+
+	deleted := current
+	if err := tx.QueryRowContext(ctx, `
+		UPDATE course SET deleted_at = now(), version = version + 1, updated_at = now() WHERE id = $1
+		RETURNING version, updated_at, deleted_at`, id).Scan(&deleted.Version, &deleted.UpdatedAt, &deleted.DeletedAt); err != nil {
+		return CourseDeleted{}, err
+	}
+	entries = append(entries, courseChange(ctx, AuditDelete, &current, &deleted))
+	if err := writeAudit(ctx, tx, entries...); err != nil {
+		return CourseDeleted{}, err
+	}
+	if err := publishEviction(ctx, tx, eviction); err != nil {
+		return CourseDeleted{}, err
+	}
+	return result, nil
+}
+
+// lockEnrolled locks and returns the live people enrolled in a course, in id order
+func lockEnrolled(ctx context.Context, tx *sql.Tx, courseID int) ([]models.Person, error) {
+	// aggregates cannot be locked, so the rows are locked on their own first like lockPerson does
+	if _, err := tx.ExecContext(ctx, `
+		SELECT p.id FROM person p JOIN person_course pc ON pc.person_id = p.id
+		WHERE pc.course_id = $1 AND p.deleted_at IS NULL
+		FOR UPDATE OF p`, courseID); err != nil {
+		return nil, err
+	}
+	return queryPeople(ctx, tx, `WHERE p.deleted_at IS NULL AND p.id IN (SELECT person_id FROM person_course WHERE course_id = $1)`, courseID)
+}
+
+// rowsAffected is the row count of a statement as an int
+func rowsAffected(result sql.Result) (int, error) {
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// RestoreCourse undoes the soft delete of a course. The enrollments it had come
+// back with it, so every live person enrolled gets a new version as well, and
+// their grown course lists are audited like the ones a delete shrinks.
+// ErrNotFound is returned when there is no such course, ErrConflict when it is not deleted.
+func RestoreCourse(ctx context.Context, db *sql.DB, id int) (models.Course, error) {
+	return inTx(ctx, db, func(tx *sql.Tx) (models.Course, error) {
+		var deleted models.Course
+		if err := tx.QueryRowContext(ctx, `SELECT id, name, version, updated_at, deleted_at FROM course WHERE id = $1 FOR UPDATE`, id).
+			Scan(&deleted.ID, &deleted.Name, &deleted.Version, &deleted.UpdatedAt, &deleted.DeletedAt); err != nil {
+			return models.Course{}, noRows(err, "course %d", id)
+		}
+		if deleted.DeletedAt == nil {
+			return models.Course{}, conflict(nil, "course %d is not deleted", id)
+		}
+		// read while the course is still deleted, so their lists leave it out
+		enrolled, err := lockEnrolled(ctx, tx, id)
+		if err != nil {
+			return models.Course{}, err
+		}
+		course := models.Course{ID: id, Name: deleted.Name}
+		if err := tx.QueryRowContext(ctx, `
+			UPDATE course SET deleted_at = NULL, version = version + 1, updated_at = now() WHERE id = $1
+			RETURNING version, updated_at`, id).Scan(&course.Version, &course.UpdatedAt); err != nil {
+			return models.Course{}, err
+		}
+
+		entries := []AuditEntry{courseChange(ctx, AuditRestore, &deleted, &course)}
+		eviction := courseEviction(id)
+		if len(enrolled) > 0 {
+			ids := make([]int, len(enrolled))
+			for i, person := range enrolled {
+				ids[i] = person.ID
+			}
+			if _, err := tx.ExecContext(ctx, `UPDATE person SET version = version + 1, updated_at = now() WHERE id = ANY($1)`, int64s(ids)); err != nil {
+				return models.Course{}, err
+			}
+			if err := recordHistory(ctx, tx, ids...); err != nil {
+				return models.Course{}, err
+			}
+			after, err := queryPeople(ctx, tx, `WHERE p.id = ANY($1)`, int64s(ids))
+			if err != nil {
+				return models.Course{}, err
+			}
+			for i := range enrolled {
+				entries = append(entries, personChange(ctx, AuditEnrollment, &enrolled[i], &after[i]))
+			}
+			eviction = eviction.withPeople(ids...)
+		}
+		if err := writeAudit(ctx, tx, entries...); err != nil {
+			return models.Course{}, err
+		}
+		if err := publishEviction(ctx, tx, eviction); err != nil {
+			return models.Course{}, err
+		}
+		return course, nil
+	})
+}
+
+// lockCourse reads a live course and locks it for the rest of the transaction,
+// or returns ErrNotFound when there is no such course
+func lockCourse(ctx context.Context, tx *sql.Tx, id int) (models.Course, error) {
+	var course models.Course
+	if err := tx.QueryRowContext(ctx, `SELECT id, name, version, updated_at FROM course WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, id).
+		Scan(&course.ID, &course.Name, &course.Version, &course.UpdatedAt); err != nil {
+		return models.Course{}, noRows(err, "course %d", id)
 	}
 	return course, nil
 }
 
-// DeleteCourse deletes a course
-func DeleteCourse(db *sql.DB, id int) error {
-	
-	ctx := context.Background()
+// MissingCourseIDs returns the given ids that do not belong to a live course,
+// in ascending order, checking them all in one query
+func MissingCourseIDs(ctx context.Context, db *sql.DB, ids []int) ([]int, error) {
+	return missingCourseIDs(ctx, db, ids)
+}
 
-	_, err := db.ExecContext(
-		ctx,
-		`DELETE FROM "course" WHERE id = $1`,
-		id,
-	)
+// missingCourseIDs looks the ids up through db or a transaction
+func missingCourseIDs(ctx context.Context, q querier, ids []int) ([]int, error) {
+	if len(ids) == 0 {
+		return []int{}, nil
+	}
+	rows, err := q.QueryContext(ctx, `
+		SELECT DISTINCT ids.id
+		FROM unnest($1::int[]) AS ids(id)
+		WHERE NOT EXISTS (SELECT 1 FROM course c WHERE c.id = ids.id AND c.deleted_at IS NULL)
+		ORDER BY ids.id`, int64s(ids))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	defer rows.Close()
+
+	missing := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		missing = append(missing, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return missing, nil
 }
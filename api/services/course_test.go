@@ -1,15 +1,29 @@
 package services
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
 
 	"github.com/jacob-tech-challenge/api/models"
 )
 
+// courseColumns are the columns the course queries select
+var courseColumns = []string{"id", "name", "version", "updated_at"}
+
+// courseListColumns are the columns the course list selects, deleted ones included
+var courseListColumns = []string{"id", "name", "version", "updated_at", "deleted_at"}
+
+// testUpdatedAt is the updated_at the mocked rows hold
+var testUpdatedAt = time.Date(2024, 9, 1, 8, 30, 0, 0, time.UTC)
+
 func TestGetAllCourses(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -19,30 +33,35 @@ func TestGetAllCourses(t *testing.T) {
 
 	// Test case 1: Successful retrieval of multiple courses
 	courses := []models.Course{
-		{ID: 1, Name: "Course 1"},
-		{ID: 2, Name: "Course 2"},
+		{ID: 1, Name: "Course 1", Version: 1, UpdatedAt: testUpdatedAt},
+		{ID: 2, Name: "Course 2", Version: 3, UpdatedAt: testUpdatedAt},
 	}
 
-	rows := sqlmock.NewRows([]string{"id", "name"})
+	rows := sqlmock.NewRows(courseListColumns)
 	for _, course := range courses {
-		rows = rows.AddRow(course.ID, course.Name)
+		rows = rows.AddRow(course.ID, course.Name, course.Version, course.UpdatedAt, nil)
 	}
 
-	mock.ExpectQuery(`SELECT \* FROM "course"`).WillReturnRows(rows)
+	mock.ExpectQuery(`SELECT id, name, version, updated_at, deleted_at FROM "course" WHERE id > \$1 AND \(\$3 OR deleted_at IS NULL\) ORDER BY id LIMIT \$2`).
+		WithArgs(0, nil, false).
+		WillReturnRows(rows)
 
-	retrievedCourses, err := GetAllCourses(db)
+	retrievedCourses, next, err := GetAllCourses(context.Background(), db, CourseFilter{}, Page{})
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
 	if !reflect.DeepEqual(retrievedCourses, courses) {
 		t.Errorf("Returned courses do not match expected courses. Expected: %+v, Got: %+v", courses, retrievedCourses)
 	}
+	if next != nil {
+		t.Errorf("Expected no next cursor without a limit, got: %+v", next)
+	}
 
 
 	// Test case 2: No courses found
-	mock.ExpectQuery(`SELECT \* FROM "course"`).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+	mock.ExpectQuery(`SELECT id, name, version, updated_at, deleted_at FROM "course"`).WillReturnRows(sqlmock.NewRows(courseListColumns))
 
-	retrievedCourses, err = GetAllCourses(db)
+	retrievedCourses, _, err = GetAllCourses(context.Background(), db, CourseFilter{}, Page{})
 	if err != nil {
 		t.Errorf("Unexpected error when no courses are found: %v", err)
 	}
@@ -55,13 +74,43 @@ func TestGetAllCourses(t *testing.T) {
 
 
 	// Test case 3: Database error
-	mock.ExpectQuery(`SELECT \* FROM "course"`).WillReturnError(sql.ErrConnDone)
+	mock.ExpectQuery(`SELECT id, name, version, updated_at, deleted_at FROM "course"`).WillReturnError(sql.ErrConnDone)
 
-	_, err = GetAllCourses(db)
+	_, _, err = GetAllCourses(context.Background(), db, CourseFilter{}, Page{})
 	if err == nil {
 		t.Error("Expected an error, but got none")
 	}
 
+	// Test case 4: A page with more rows after it, one extra row is fetched to find out
+	mock.ExpectQuery(`SELECT id, name, version, updated_at, deleted_at FROM "course" WHERE id > \$1 AND \(\$3 OR deleted_at IS NULL\) ORDER BY id LIMIT \$2`).
+		WithArgs(1, 2, false).
+		WillReturnRows(sqlmock.NewRows(courseListColumns).AddRow(2, "Course 2", 1, testUpdatedAt, nil).AddRow(3, "Course 3", 1, testUpdatedAt, nil))
+
+	retrievedCourses, next, err = GetAllCourses(context.Background(), db, CourseFilter{}, Page{Limit: 1, After: &Cursor{ID: 1}})
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(retrievedCourses, []models.Course{{ID: 2, Name: "Course 2", Version: 1, UpdatedAt: testUpdatedAt}}) {
+		t.Errorf("Expected only the first row of the page, got: %+v", retrievedCourses)
+	}
+	if next == nil || next.ID != 2 {
+		t.Errorf("Expected a next cursor after course 2, got: %+v", next)
+	}
+
+	// Test case 5: Deleted courses are listed with their deletion time on request
+	deletedAt := testUpdatedAt.Add(time.Hour)
+	mock.ExpectQuery(`SELECT id, name, version, updated_at, deleted_at FROM "course"`).
+		WithArgs(0, nil, true).
+		WillReturnRows(sqlmock.NewRows(courseListColumns).AddRow(1, "Course 1", 2, deletedAt, deletedAt))
+
+	retrievedCourses, _, err = GetAllCourses(context.Background(), db, CourseFilter{IncludeDeleted: true}, Page{})
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(retrievedCourses, []models.Course{{ID: 1, Name: "Course 1", Version: 2, UpdatedAt: deletedAt, DeletedAt: &deletedAt}}) {
+		t.Errorf("Expected the deleted course, got: %+v", retrievedCourses)
+	}
+
 	// Ensure all expectations were met
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -76,15 +125,15 @@ func TestGetCourseByID(t *testing.T) {
 	defer db.Close()
 
 	testID := 1
-	testCourse := models.Course{ID: testID, Name: "Test Course"}
+	testCourse := models.Course{ID: testID, Name: "Test Course", Version: 2, UpdatedAt: testUpdatedAt}
 
 	// Test successful retrieval
-	rows := sqlmock.NewRows([]string{"id", "name"}).AddRow(testCourse.ID, testCourse.Name)
-	mock.ExpectQuery(`SELECT \* FROM "course" WHERE id = \$1`).
+	rows := sqlmock.NewRows(courseColumns).AddRow(testCourse.ID, testCourse.Name, testCourse.Version, testCourse.UpdatedAt)
+	mock.ExpectQuery(`SELECT id, name, version, updated_at FROM "course" WHERE id = \$1 AND deleted_at IS NULL`).
 		WithArgs(testID).
 		WillReturnRows(rows)
 
-	retrievedCourse, err := GetCourseByID(db, testID)
+	retrievedCourse, err := GetCourseByID(context.Background(), db, testID)
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
@@ -94,21 +143,21 @@ func TestGetCourseByID(t *testing.T) {
 	}
 
 	// Test when no course is found
-	mock.ExpectQuery(`SELECT \* FROM "course" WHERE id = \$1`).
+	mock.ExpectQuery(`SELECT id, name, version, updated_at FROM "course" WHERE id = \$1 AND deleted_at IS NULL`).
 		WithArgs(2).
 		WillReturnError(sql.ErrNoRows)
 
-	_, err = GetCourseByID(db, 2)
-	if err == nil {
-		t.Error("Expected sql.ErrNoRows, but got nil")
+	_, err = GetCourseByID(context.Background(), db, 2)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got: %v", err)
 	}
 
 	// Test with a database error
-	mock.ExpectQuery(`SELECT \* FROM "course" WHERE id = \$1`).
+	mock.ExpectQuery(`SELECT id, name, version, updated_at FROM "course" WHERE id = \$1 AND deleted_at IS NULL`).
 		WithArgs(3).
 		WillReturnError(sql.ErrConnDone)
 
-	_, err = GetCourseByID(db, 3)
+	_, err = GetCourseByID(context.Background(), db, 3)
 	if err == nil {
 		t.Error("Expected an error, but got none")
 	}
@@ -127,47 +176,101 @@ func TestUpdateCourse(t *testing.T) {
 	}
 	defer db.Close()
 
-	testCourse := models.Course{
-		ID:   1, // You might need to adjust this based on your model
-		Name: "Updated Course Name",
-	}
-
-	// Define the expected query
-	mock.ExpectExec(`UPDATE "course" SET name = \$1 WHERE id = \$2`).
-		WithArgs(testCourse.Name, testCourse.ID).
-		WillReturnResult(sqlmock.NewResult(1, 1)) // 1 row affected, last insert ID 1 (doesn't matter for UPDATE)
-
-
-	updatedCourse, err := UpdateCourse(db, testCourse.ID, testCourse)
-	if err != nil {
-		t.Errorf("Unexpected error: %v", err)
-	}
-
-	// Assert that the returned course is the same as the input course (after update)
-	if !reflect.DeepEqual(updatedCourse, testCourse) {
-		t.Errorf("Returned course does not match expected course. Expected: %+v, Got: %+v", testCourse, updatedCourse)
-
-	}
-
-	// Ensure that the query was executed
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("there were unfulfilled expectations: %s", err)
-	}
-
-	//Test with an error
-	mock.ExpectExec(`UPDATE "course" SET name = \$1 WHERE id = \$2`).
-		WithArgs("Another Course Name", 2).
-		WillReturnError(sql.ErrConnDone)
-
-	_, err = UpdateCourse(db, 2, models.Course{ID:2, Name: "Another Course Name"})
-	if err == nil {
-		t.Errorf("Expected an error, but got none")
-	}
-
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("there were unfulfilled expectations: %s", err)
+	const lockQuery = `SELECT id, name, version, updated_at FROM course WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`
+	const updateQuery = `UPDATE course SET name = \$1, version = version \+ 1, updated_at = now\(\) WHERE id = \$2 RETURNING version, updated_at`
+
+	tests := []struct {
+		name     string
+		course   models.Course
+		mock     func()
+		expected models.Course
+		wantErr  error
+	}{
+		{
+			name:   "matching version",
+			course: models.Course{Name: "Updated Course Name", Version: 2},
+			mock: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows(courseColumns).AddRow(1, "Math", 2, testUpdatedAt))
+				mock.ExpectQuery(updateQuery).
+					WithArgs("Updated Course Name", 1).
+					WillReturnRows(sqlmock.NewRows([]string{"version", "updated_at"}).AddRow(3, testUpdatedAt))
+				expectAudit(mock, "course", 1, AuditUpdate)
+				expectEviction(mock, courseEviction(1))
+				mock.ExpectCommit()
+			},
+			expected: models.Course{ID: 1, Name: "Updated Course Name", Version: 3, UpdatedAt: testUpdatedAt},
+		},
+		{
+			name:   "any version",
+			course: models.Course{Name: "Updated Course Name"},
+			mock: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows(courseColumns).AddRow(1, "Math", 4, testUpdatedAt))
+				mock.ExpectQuery(updateQuery).
+					WithArgs("Updated Course Name", 1).
+					WillReturnRows(sqlmock.NewRows([]string{"version", "updated_at"}).AddRow(5, testUpdatedAt))
+				expectAudit(mock, "course", 1, AuditUpdate)
+				expectEviction(mock, courseEviction(1))
+				mock.ExpectCommit()
+			},
+			expected: models.Course{ID: 1, Name: "Updated Course Name", Version: 5, UpdatedAt: testUpdatedAt},
+		},
+		{
+			name:   "stale version",
+			course: models.Course{Name: "Updated Course Name", Version: 1},
+			mock: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows(courseColumns).AddRow(1, "Math", 2, testUpdatedAt))
+				mock.ExpectRollback()
+			},
+			wantErr: ErrPreconditionFailed,
+		},
+		{
+			name:   "course not found",
+			course: models.Course{Name: "Updated Course Name", Version: 1},
+			mock: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+			},
+			wantErr: ErrNotFound,
+		},
+		{
+			name:   "database error",
+			course: models.Course{Name: "Another Course Name"},
+			mock: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows(courseColumns).AddRow(1, "Math", 2, testUpdatedAt))
+				mock.ExpectQuery(updateQuery).WithArgs("Another Course Name", 1).WillReturnError(sql.ErrConnDone)
+				mock.ExpectRollback()
+			},
+			wantErr: sql.ErrConnDone,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock()
+
+			updated, err := UpdateCourse(context.Background(), db, 1, tt.course)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Expected %v, got: %v", tt.wantErr, err)
+				}
+			} else {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				if !reflect.DeepEqual(updated, tt.expected) {
+					t.Errorf("Returned course does not match expected course. Expected: %+v, Got: %+v", tt.expected, updated)
+				}
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
 	}
-
 }
 
 func TestCreateCourse(t *testing.T) {
@@ -185,11 +288,15 @@ func TestCreateCourse(t *testing.T) {
 	expectedID := int64(1)
 
 	// Define the expected query
-	mock.ExpectQuery(`INSERT INTO "course" \(\w+\) VALUES \(\$1\) RETURNING id`).
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "course" \(\w+\) VALUES \(\$1\) RETURNING id, version, updated_at`).
 		WithArgs(testCourse.Name).
-		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(expectedID))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version", "updated_at"}).AddRow(expectedID, 1, testUpdatedAt))
+	expectAudit(mock, "course", 1, AuditCreate)
+	expectEviction(mock, courseEviction(0))
+	mock.ExpectCommit()
 
-	createdCourse, err := CreateCourse(db, testCourse)
+	createdCourse, err := CreateCourse(context.Background(), db, testCourse)
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
@@ -201,6 +308,9 @@ func TestCreateCourse(t *testing.T) {
 	if createdCourse.Name != testCourse.Name {
 		t.Errorf("Returned course name does not match expected name. Expected: %s, Got: %s", testCourse.Name, createdCourse.Name)
 	}
+	if createdCourse.Version != 1 || !createdCourse.UpdatedAt.Equal(testUpdatedAt) {
+		t.Errorf("Expected version 1 at %v, got version %d at %v", testUpdatedAt, createdCourse.Version, createdCourse.UpdatedAt)
+	}
 
 
 	// Ensure that the query was executed
@@ -210,11 +320,13 @@ func TestCreateCourse(t *testing.T) {
 
 
 	// Test with an error
+	mock.ExpectBegin()
 	mock.ExpectQuery(`INSERT INTO "course" \(\w+\) VALUES \(\$1\) RETURNING id`).
 		WithArgs("Error Course").
 		WillReturnError(sql.ErrConnDone)
+	mock.ExpectRollback()
 
-	_, err = CreateCourse(db, models.Course{Name: "Error Course"})
+	_, err = CreateCourse(context.Background(), db, models.Course{Name: "Error Course"})
 	if err == nil {
 		t.Errorf("Expected an error, but got none")
 	}
@@ -231,48 +343,329 @@ func TestDeleteCourse(t *testing.T) {
 	}
 	defer db.Close()
 
-	testID := 1
+	const lockQuery = `SELECT id, name, version, updated_at FROM course WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`
+	const lockEnrolledQuery = `SELECT p\.id FROM person p JOIN person_course pc ON pc\.person_id = p\.id\s+WHERE pc\.course_id = \$1 AND p\.deleted_at IS NULL\s+FOR UPDATE OF p`
+	const enrolledQuery = `WHERE p\.deleted_at IS NULL AND p\.id IN \(SELECT person_id FROM person_course WHERE course_id = \$1\)\s+GROUP BY p\.id\s+ORDER BY p\.id`
+	const targetQuery = `SELECT id FROM course WHERE id = \$1 AND deleted_at IS NULL FOR SHARE`
+	const moveQuery = `INSERT INTO person_course \(person_id, course_id\)\s+SELECT unnest\(\$1::int\[\]\), \$2\s+ON CONFLICT \(person_id, course_id\) DO NOTHING`
+	const removeQuery = `DELETE FROM person_course WHERE course_id = \$1 AND person_id = ANY\(\$2\)`
+	const touchQuery = `UPDATE person SET version = version \+ 1, updated_at = now\(\) WHERE id = ANY\(\$1\)`
+	const afterQuery = `WHERE p\.id = ANY\(\$1\)\s+GROUP BY p\.id\s+ORDER BY p\.id`
+	const deleteQuery = `UPDATE course SET deleted_at = now\(\), version = version \+ 1, updated_at = now\(\) WHERE id = \$1\s+RETURNING version, updated_at, deleted_at`
+
+	lockCourse := func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows(courseColumns).AddRow(1, "Math", 2, testUpdatedAt))
+	}
+	expectEnrolled := func(rows *sqlmock.Rows) {
+		mock.ExpectExec(lockEnrolledQuery).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(enrolledQuery).WithArgs(1).WillReturnRows(rows)
+	}
+	enrolled := func() *sqlmock.Rows {
+		return sqlmock.NewRows(personColumns).
+			AddRow(5, "John", "Doe", "student", 20, "{1,2}", 1, testUpdatedAt, nil).
+			AddRow(6, "Jane", "Doe", "student", 21, "{1}", 4, testUpdatedAt, nil)
+	}
+	expectDeleted := func(entities []string, ids pq.Int64Array, actions []string) {
+		mock.ExpectQuery(deleteQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"version", "updated_at", "deleted_at"}).AddRow(3, testUpdatedAt, testUpdatedAt))
+		mock.ExpectExec(auditQuery).
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), pq.StringArray(entities), ids, pq.StringArray(actions), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, int64(len(ids))))
+		var people []int
+		for _, id := range ids[:len(ids)-1] {
+			people = append(people, int(id))
+		}
+		expectEviction(mock, courseEviction(1).withPeople(people...))
+		mock.ExpectCommit()
+	}
+
+	tests := []struct {
+		name     string
+		version  int
+		deletion CourseDeletion
+		mock     func()
+		expected CourseDeleted
+		wantErr  error
+	}{
+		{
+			name:    "matching version",
+			version: 2,
+			mock: func() {
+				lockCourse()
+				expectEnrolled(sqlmock.NewRows(personColumns))
+				expectDeleted([]string{"course"}, pq.Int64Array{1}, []string{AuditDelete})
+			},
+		},
+		{
+			name: "course not found or already deleted",
+			mock: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+			},
+			wantErr: ErrNotFound,
+		},
+		{
+			name:    "stale version",
+			version: 1,
+			mock: func() {
+				lockCourse()
+				mock.ExpectRollback()
+			},
+			wantErr: ErrPreconditionFailed,
+		},
+		{
+			name:     "restrict with people enrolled",
+			deletion: CourseDeletion{Mode: DeleteRestrict},
+			mock: func() {
+				lockCourse()
+				expectEnrolled(enrolled())
+				mock.ExpectRollback()
+			},
+			wantErr: &EnrolledError{CourseID: 1, PersonIDs: []int{5, 6}},
+		},
+		{
+			name:     "cascade",
+			deletion: CourseDeletion{Mode: DeleteCascade},
+			mock: func() {
+				lockCourse()
+				expectEnrolled(enrolled())
+				mock.ExpectExec(removeQuery).WithArgs(1, pq.Int64Array{5, 6}).WillReturnResult(sqlmock.NewResult(0, 2))
+				mock.ExpectExec(touchQuery).WithArgs(pq.Int64Array{5, 6}).WillReturnResult(sqlmock.NewResult(0, 2))
+				expectHistory(mock, 5, 6)
+				mock.ExpectQuery(afterQuery).WithArgs(pq.Int64Array{5, 6}).
+					WillReturnRows(sqlmock.NewRows(personColumns).
+						AddRow(5, "John", "Doe", "student", 20, "{2}", 2, testUpdatedAt, nil).
+						AddRow(6, "Jane", "Doe", "student", 21, "{}", 5, testUpdatedAt, nil))
+				expectDeleted([]string{"person", "person", "course"}, pq.Int64Array{5, 6, 1}, []string{AuditEnrollment, AuditEnrollment, AuditDelete})
+			},
+			expected: CourseDeleted{Affected: 2},
+		},
+		{
+			name:     "reassign skips people already in the other course",
+			deletion: CourseDeletion{Mode: DeleteReassign, To: 2},
+			mock: func() {
+				lockCourse()
+				mock.ExpectQuery(targetQuery).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+				expectEnrolled(enrolled())
+				mock.ExpectExec(moveQuery).WithArgs(pq.Int64Array{5, 6}, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(removeQuery).WithArgs(1, pq.Int64Array{5, 6}).WillReturnResult(sqlmock.NewResult(0, 2))
+				mock.ExpectExec(touchQuery).WithArgs(pq.Int64Array{5, 6}).WillReturnResult(sqlmock.NewResult(0, 2))
+				expectHistory(mock, 5, 6)
+				mock.ExpectQuery(afterQuery).WithArgs(pq.Int64Array{5, 6}).
+					WillReturnRows(sqlmock.NewRows(personColumns).
+						AddRow(5, "John", "Doe", "student", 20, "{2}", 2, testUpdatedAt, nil).
+						AddRow(6, "Jane", "Doe", "student", 21, "{2}", 5, testUpdatedAt, nil))
+				expectDeleted([]string{"person", "person", "course"}, pq.Int64Array{5, 6, 1}, []string{AuditEnrollment, AuditEnrollment, AuditDelete})
+			},
+			expected: CourseDeleted{Affected: 2, Moved: 1},
+		},
+		{
+			name:     "reassign to a missing course",
+			deletion: CourseDeletion{Mode: DeleteReassign, To: 9},
+			mock: func() {
+				lockCourse()
+				mock.ExpectQuery(targetQuery).WithArgs(9).WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+			},
+			wantErr: ErrValidation,
+		},
+		{
+			name:     "unknown mode",
+			deletion: CourseDeletion{Mode: "orphan"},
+			mock:     func() {},
+			wantErr:  ErrValidation,
+		},
+		{
+			name: "database error",
+			mock: func() {
+				lockCourse()
+				expectEnrolled(sqlmock.NewRows(personColumns))
+				mock.ExpectQuery(deleteQuery).WithArgs(1).WillReturnError(sql.ErrConnDone)
+				mock.ExpectRollback()
+			},
+			wantErr: sql.ErrConnDone,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock()
+
+			deleted, err := DeleteCourse(context.Background(), db, 1, tt.version, tt.deletion)
+			var enrolledErr *EnrolledError
+			switch {
+			case errors.As(tt.wantErr, &enrolledErr):
+				assert.Equal(t, tt.wantErr, err)
+				assert.ErrorIs(t, err, ErrConflict)
+			case !errors.Is(err, tt.wantErr):
+				t.Errorf("Expected %v, got: %v", tt.wantErr, err)
+			}
+			assert.Equal(t, tt.expected, deleted)
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
 
-	//Expect a successful delete operation
-	mock.ExpectExec(`DELETE FROM "course" WHERE id = \$1`).
-		WithArgs(testID).
-		WillReturnResult(sqlmock.NewResult(1, 1)) // 1 row affected
+func TestCourseDeletionValidate(t *testing.T) {
+	tests := map[string]struct {
+		deletion      CourseDeletion
+		expectedParam string
+	}{
+		"default":             {},
+		"cascade":             {deletion: CourseDeletion{Mode: DeleteCascade}},
+		"reassign":            {deletion: CourseDeletion{Mode: DeleteReassign, To: 2}},
+		"unknown mode":        {deletion: CourseDeletion{Mode: "orphan"}, expectedParam: "mode"},
+		"reassign without to": {deletion: CourseDeletion{Mode: DeleteReassign}, expectedParam: "to"},
+		"reassign to itself":  {deletion: CourseDeletion{Mode: DeleteReassign, To: 1}, expectedParam: "to"},
+		"to without reassign": {deletion: CourseDeletion{Mode: DeleteCascade, To: 2}, expectedParam: "to"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.deletion.Validate(1)
+			if tc.expectedParam == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var filterErr *FilterError
+			if assert.ErrorAs(t, err, &filterErr) {
+				assert.Equal(t, tc.expectedParam, filterErr.Param)
+			}
+		})
+	}
+}
 
-	err = DeleteCourse(db, testID)
+func TestRestoreCourse(t *testing.T) {
+	db, mock, err := sqlmock.New()
 	if err != nil {
-		t.Errorf("Unexpected error: %v", err)
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
+	defer db.Close()
 
-	// Ensure that the query was executed
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("there were unfulfilled expectations: %s", err)
+	const lockQuery = `SELECT id, name, version, updated_at, deleted_at FROM course WHERE id = \$1 FOR UPDATE`
+	const restoreQuery = `UPDATE course SET deleted_at = NULL, version = version \+ 1, updated_at = now\(\) WHERE id = \$1\s+RETURNING version, updated_at`
+	const lockEnrolledQuery = `SELECT p\.id FROM person p JOIN person_course pc ON pc\.person_id = p\.id\s+WHERE pc\.course_id = \$1 AND p\.deleted_at IS NULL\s+FOR UPDATE OF p`
+	const enrolledQuery = `WHERE p\.deleted_at IS NULL AND p\.id IN \(SELECT person_id FROM person_course WHERE course_id = \$1\)\s+GROUP BY p\.id\s+ORDER BY p\.id`
+	const touchQuery = `UPDATE person SET version = version \+ 1, updated_at = now\(\) WHERE id = ANY\(\$1\)`
+	const afterQuery = `WHERE p\.id = ANY\(\$1\)\s+GROUP BY p\.id\s+ORDER BY p\.id`
+
+	expectRestored := func(enrolled *sqlmock.Rows) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(courseListColumns).AddRow(1, "Math", 3, testUpdatedAt, testUpdatedAt))
+		mock.ExpectExec(lockEnrolledQuery).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(enrolledQuery).WithArgs(1).WillReturnRows(enrolled)
+		mock.ExpectQuery(restoreQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"version", "updated_at"}).AddRow(4, testUpdatedAt))
+	}
+
+	tests := []struct {
+		name     string
+		mock     func()
+		expected models.Course
+		wantErr  error
+	}{
+		{
+			name: "deleted course",
+			mock: func() {
+				expectRestored(sqlmock.NewRows(personColumns))
+				expectAudit(mock, "course", 1, AuditRestore)
+				expectEviction(mock, courseEviction(1))
+				mock.ExpectCommit()
+			},
+			expected: models.Course{ID: 1, Name: "Math", Version: 4, UpdatedAt: testUpdatedAt},
+		},
+		{
+			name: "enrolled people get their course back",
+			mock: func() {
+				expectRestored(sqlmock.NewRows(personColumns).
+					AddRow(2, "John", "Doe", "student", 20, "{2}", 1, testUpdatedAt, nil).
+					AddRow(5, "Jane", "Doe", "student", 21, "{}", 4, testUpdatedAt, nil))
+				mock.ExpectExec(touchQuery).WithArgs(pq.Int64Array{2, 5}).WillReturnResult(sqlmock.NewResult(0, 2))
+				expectHistory(mock, 2, 5)
+				mock.ExpectQuery(afterQuery).WithArgs(pq.Int64Array{2, 5}).
+					WillReturnRows(sqlmock.NewRows(personColumns).
+						AddRow(2, "John", "Doe", "student", 20, "{1,2}", 2, testUpdatedAt, nil).
+						AddRow(5, "Jane", "Doe", "student", 21, "{1}", 5, testUpdatedAt, nil))
+				// the course restore and one enrollment entry per person, in one insert
+				mock.ExpectExec(auditQuery).
+					WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), pq.StringArray{"course", "person", "person"}, pq.Int64Array{1, 2, 5},
+						pq.StringArray{AuditRestore, AuditEnrollment, AuditEnrollment}, sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 3))
+				expectEviction(mock, courseEviction(1).withPeople(2, 5))
+				mock.ExpectCommit()
+			},
+			expected: models.Course{ID: 1, Name: "Math", Version: 4, UpdatedAt: testUpdatedAt},
+		},
+		{
+			name: "course not deleted",
+			mock: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows(courseListColumns).AddRow(1, "Math", 3, testUpdatedAt, nil))
+				mock.ExpectRollback()
+			},
+			wantErr: ErrConflict,
+		},
+		{
+			name: "course not found",
+			mock: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+			},
+			wantErr: ErrNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock()
+
+			restored, err := RestoreCourse(context.Background(), db, 1)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected %v, got: %v", tt.wantErr, err)
+			}
+			if !reflect.DeepEqual(restored, tt.expected) {
+				t.Errorf("Returned course does not match expected course. Expected: %+v, Got: %+v", tt.expected, restored)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
 	}
+}
 
-	// Test with an error - simulating no rows affected (though strictly not an error in SQL)
-    mock.ExpectExec(`DELETE FROM "course" WHERE id = \$1`).
-		WithArgs(2).
-		WillReturnResult(sqlmock.NewResult(0, 0))
+func TestMissingCourseIDs(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT DISTINCT ids\.id\s+FROM unnest\(\$1::int\[\]\) AS ids\(id\)`).
+		WithArgs(pq.Int64Array{9, 1, 3}).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3).AddRow(9))
 
-	err = DeleteCourse(db, 2)
+	missing, err := MissingCourseIDs(context.Background(), db, []int{9, 1, 3})
 	if err != nil {
-		t.Errorf("Unexpected error when 0 rows are deleted: %v", err)
+		t.Fatalf("unexpected error: %v", err)
 	}
-	
-    if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("there were unfulfilled expectations: %s", err)
+	if !reflect.DeepEqual(missing, []int{3, 9}) {
+		t.Errorf("expected [3 9], got %v", missing)
 	}
 
-	// Test with a database error
-	mock.ExpectExec(`DELETE FROM "course" WHERE id = \$1`).
-		WithArgs(3).
-		WillReturnError(sql.ErrConnDone)
-
-	err = DeleteCourse(db, 3)
-	if err == nil {
-		t.Error("Expected an error, but got none")
+	// no ids need no query
+	missing, err = MissingCourseIDs(context.Background(), db, nil)
+	if err != nil || len(missing) != 0 {
+		t.Errorf("expected no missing ids, got %v, %v", missing, err)
 	}
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// The kinds of error a store returns besides plain failures. Callers test for
+// them with errors.Is, every *Error and *FilterError matches one of them.
+var (
+	ErrNotFound           = errors.New("not found")
+	ErrConflict           = errors.New("conflict")
+	ErrValidation         = errors.New("validation failed")
+	ErrPreconditionFailed = errors.New("precondition failed")
+	ErrKeyReused          = errors.New("idempotency key reused")
+)
+
+// postgres error codes the stores turn into one of the kinds above
+const (
+	pqForeignKeyViolation pq.ErrorCode = "23503"
+	pqUniqueViolation     pq.ErrorCode = "23505"
+	pqCheckViolation      pq.ErrorCode = "23514"
+)
+
+// Error is an error of a known kind. Detail is written for API clients and
+// never contains driver output, the underlying error is kept in Err for logs.
+type Error struct {
+	Kind   error
+	Detail string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Detail + ": " + e.Err.Error()
+	}
+	return e.Detail
+}
+
+// Unwrap exposes both the kind and the underlying error to errors.Is and errors.As
+func (e *Error) Unwrap() []error {
+	if e.Err != nil {
+		return []error{e.Kind, e.Err}
+	}
+	return []error{e.Kind}
+}
+
+// details shared by the postgres and memory stores for the same constraint violations
+const (
+	detailInvalidType    = "type must be professor or student"
+	detailUnknownCourse  = "courses must all exist"
+	detailRepeatedCourse = "courses must not repeat"
+)
+
+// notFound returns an ErrNotFound error for the thing described by format
+func notFound(format string, args ...any) error {
+	return &Error{Kind: ErrNotFound, Detail: fmt.Sprintf(format, args...) + " does not exist"}
+}
+
+// conflict returns an ErrConflict error caused by err
+func conflict(err error, format string, args ...any) error {
+	return &Error{Kind: ErrConflict, Detail: fmt.Sprintf(format, args...), Err: err}
+}
+
+// invalid returns an ErrValidation error caused by err
+func invalid(err error, format string, args ...any) error {
+	return &Error{Kind: ErrValidation, Detail: fmt.Sprintf(format, args...), Err: err}
+}
+
+// stale returns an ErrPreconditionFailed error for a write that expected an older version
+func stale(format string, args ...any) error {
+	return &Error{Kind: ErrPreconditionFailed, Detail: fmt.Sprintf(format, args...) + " was changed since it was read"}
+}
+
+// pqCode returns the postgres error code of err, or "" when it did not come from postgres
+func pqCode(err error) pq.ErrorCode {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code
+	}
+	return ""
+}
+
+// noRows turns sql.ErrNoRows into a not found error for the thing described by
+// format and returns any other error unchanged
+func noRows(err error, format string, args ...any) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return notFound(format, args...)
+	}
+	return err
+}
+
+// constraintError turns the constraint violations a person write can hit into
+// validation errors, any other error is returned unchanged
+func constraintError(err error) error {
+	switch pqCode(err) {
+	case pqCheckViolation:
+		return invalid(err, detailInvalidType)
+	case pqForeignKeyViolation:
+		return invalid(err, detailUnknownCourse)
+	case pqUniqueViolation:
+		return invalid(err, detailRepeatedCourse)
+	}
+	return err
+}
+
+// checkVersion compares a row's current version with the one a write expects, zero expects any version
+func checkVersion(table string, id, current, expected int) error {
+	if expected != 0 && expected != current {
+		return stale(table+" %d", id)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorKinds(t *testing.T) {
+	driverErr := &pq.Error{Code: pqCheckViolation, Message: `new row for relation "person" violates check constraint "person_type_check"`}
+
+	tests := map[string]struct {
+		err            error
+		expectedKind   error
+		expectedDetail string
+	}{
+		"no rows": {
+			err:            noRows(sql.ErrNoRows, "person %d", 3),
+			expectedKind:   ErrNotFound,
+			expectedDetail: "person 3 does not exist",
+		},
+		"check violation": {
+			err:            constraintError(driverErr),
+			expectedKind:   ErrValidation,
+			expectedDetail: detailInvalidType,
+		},
+		"foreign key violation": {
+			err:            constraintError(&pq.Error{Code: pqForeignKeyViolation}),
+			expectedKind:   ErrValidation,
+			expectedDetail: detailUnknownCourse,
+		},
+		"unique violation": {
+			err:            constraintError(&pq.Error{Code: pqUniqueViolation}),
+			expectedKind:   ErrValidation,
+			expectedDetail: detailRepeatedCourse,
+		},
+		"filter": {
+			err:          &FilterError{Param: "age", Reason: "must be an integer"},
+			expectedKind: ErrValidation,
+		},
+		"cursor": {
+			err:            ErrInvalidCursor,
+			expectedKind:   ErrValidation,
+			expectedDetail: "invalid cursor",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorIs(t, tc.err, tc.expectedKind)
+
+			var storeErr *Error
+			if tc.expectedDetail != "" && assert.ErrorAs(t, tc.err, &storeErr) {
+				assert.Equal(t, tc.expectedDetail, storeErr.Detail)
+			}
+		})
+	}
+
+	// the driver error stays reachable for logging
+	var pqErr *pq.Error
+	assert.True(t, errors.As(constraintError(driverErr), &pqErr))
+
+	// errors that are not recognised pass through untouched
+	assert.Equal(t, sql.ErrConnDone, noRows(sql.ErrConnDone, "person %d", 3))
+	assert.Equal(t, sql.ErrConnDone, constraintError(sql.ErrConnDone))
+	assert.NoError(t, noRows(nil, "person %d", 3))
+}
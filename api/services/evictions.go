@@ -0,0 +1,116 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// EvictionChannel is the postgres channel writes announce their cache evictions on
+const EvictionChannel = "cache_evictions"
+
+// listenerPingInterval is how often an idle listener checks its connection is still alive
+const listenerPingInterval = 90 * time.Second
+
+// Eviction is the set of cache entries a write made stale, as sent to the
+// other instances sharing the database
+type Eviction struct {
+	Keys     []string `json:"keys,omitempty"`
+	Prefixes []string `json:"prefixes,omitempty"`
+}
+
+// publishEviction announces eviction to every instance listening on
+// EvictionChannel, this one included. Writes send it from their transaction,
+// so postgres delivers it once the write commits and drops it on a rollback.
+func publishEviction(ctx context.Context, tx execer, eviction Eviction) error {
+	payload, err := json.Marshal(eviction)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, EvictionChannel, string(payload))
+	return err
+}
+
+// courseEviction is what a write to a course makes stale: the course, or none
+// when id is 0, and every page of courses
+func courseEviction(id int) Eviction {
+	eviction := Eviction{Prefixes: []string{coursesKeyPrefix}}
+	if id != 0 {
+		eviction.Keys = []string{courseKey(id)}
+	}
+	return eviction
+}
+
+// enrolledEviction is what a write to a course that moves its enrollments makes
+// stale, the people enrolled included
+func enrolledEviction(id int) Eviction {
+	return Eviction{Keys: []string{courseKey(id)}, Prefixes: []string{coursesKeyPrefix, personKeyPrefix}}
+}
+
+// withPeople adds the people with ids to what eviction makes stale
+func (e Eviction) withPeople(ids ...int) Eviction {
+	for _, id := range ids {
+		e.Keys = append(e.Keys, personKey(id))
+	}
+	return e
+}
+
+// personEviction is what a write to a person makes stale, their version changes
+// with their enrollments too
+func personEviction(id int) Eviction {
+	return Eviction{Keys: []string{personKey(id)}}
+}
+
+// Listener is the part of *pq.Listener that ListenForEvictions uses
+type Listener interface {
+	Listen(channel string) error
+	Ping() error
+	NotificationChannel() <-chan *pq.Notification
+}
+
+// ListenForEvictions evicts from cache what other instances publish on
+// EvictionChannel, until ctx is done. The listener reconnects on its own when
+// its connection drops, but whatever was published meanwhile is lost, so the
+// whole cache is dropped after every reconnect. A payload that cannot be read
+// drops the whole cache too.
+func ListenForEvictions(ctx context.Context, listener Listener, cache *Cache) error {
+	if err := listener.Listen(EvictionChannel); err != nil {
+		return err
+	}
+	// anything cached before the LISTEN took effect may have missed an eviction
+	cache.evictAll()
+
+	ping := time.NewTicker(listenerPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ping.C:
+			// a failed ping means the connection is gone, the listener is already reconnecting
+			if err := listener.Ping(); err != nil {
+				log.Printf("Cache eviction listener is not connected. err: %v", err)
+			}
+		case notification, ok := <-listener.NotificationChannel():
+			if !ok {
+				return errors.New("cache eviction listener closed")
+			}
+			if notification == nil {
+				log.Println("Cache eviction listener reconnected, dropping the whole cache")
+				cache.evictAll()
+				continue
+			}
+			var eviction Eviction
+			if err := json.Unmarshal([]byte(notification.Extra), &eviction); err != nil {
+				log.Printf("Unreadable cache eviction %q, dropping the whole cache. err: %v", notification.Extra, err)
+				cache.evictAll()
+				continue
+			}
+			cache.evict(eviction.Keys, eviction.Prefixes)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeListener hands out notifications sent on its channel
+type fakeListener struct {
+	listenErr     error
+	notifications chan *pq.Notification
+}
+
+func (l *fakeListener) Listen(channel string) error { return l.listenErr }
+
+func (l *fakeListener) Ping() error { return nil }
+
+func (l *fakeListener) NotificationChannel() <-chan *pq.Notification { return l.notifications }
+
+// expectEviction expects a write to announce eviction from its transaction
+func expectEviction(mock sqlmock.Sqlmock, eviction Eviction) {
+	payload, _ := json.Marshal(eviction)
+	mock.ExpectExec(`SELECT pg_notify\(\$1, \$2\)`).WithArgs(EvictionChannel, string(payload)).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestPublishEviction(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(`SELECT pg_notify\(\$1, \$2\)`).
+		WithArgs(EvictionChannel, `{"keys":["course:1","person:2","person:3"],"prefixes":["courses:"]}`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err = publishEviction(context.Background(), db, courseEviction(1).withPeople(2, 3))
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListenForEvictions(t *testing.T) {
+	// fill caches the same three entries
+	fill := func(cache *Cache) {
+		for _, key := range []string{"course:1", "courses:10:", "person:1"} {
+			cached(context.Background(), cache, key, func(context.Context) (int, error) { return 1, nil })
+		}
+	}
+
+	tests := map[string]struct {
+		notification *pq.Notification
+		wantKept     []string
+	}{
+		"eviction": {
+			notification: &pq.Notification{Channel: EvictionChannel, Extra: `{"keys":["course:1"],"prefixes":["courses:"]}`},
+			wantKept:     []string{"person:1"},
+		},
+		"reconnected": {
+			notification: nil,
+			wantKept:     nil,
+		},
+		"unreadable": {
+			notification: &pq.Notification{Channel: EvictionChannel, Extra: `course:1`},
+			wantKept:     nil,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			cache := NewCache(time.Minute, 0)
+			listener := &fakeListener{notifications: make(chan *pq.Notification)}
+
+			done := make(chan error)
+			go func() { done <- ListenForEvictions(ctx, listener, cache) }()
+
+			// the first notification is only read once the startup eviction is over
+			listener.notifications <- &pq.Notification{Channel: EvictionChannel, Extra: `{}`}
+			fill(cache)
+			listener.notifications <- tc.notification
+			// and the second is handled once a third can be sent
+			listener.notifications <- &pq.Notification{Channel: EvictionChannel, Extra: `{}`}
+
+			var kept []string
+			for _, key := range []string{"course:1", "courses:10:", "person:1"} {
+				if _, ok := cache.lookup(key); ok {
+					kept = append(kept, key)
+				}
+			}
+			assert.Equal(t, tc.wantKept, kept)
+
+			cancel()
+			assert.NoError(t, <-done)
+		})
+	}
+}
+
+func TestListenForEvictionsFailures(t *testing.T) {
+	listenErr := errors.New("permission denied")
+	err := ListenForEvictions(context.Background(), &fakeListener{listenErr: listenErr}, NewCache(time.Minute, 0))
+	assert.ErrorIs(t, err, listenErr)
+
+	closed := make(chan *pq.Notification)
+	close(closed)
+	err = ListenForEvictions(context.Background(), &fakeListener{notifications: closed}, NewCache(time.Minute, 0))
+	assert.Error(t, err)
+}
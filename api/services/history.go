@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/jacob-tech-challenge/api/models"
+)
+
+// PersonRevision is a person as they were from ValidFrom until ValidTo, which
+// is nil for the current revision. A revision of a deleted person has DeletedAt set.
+type PersonRevision struct {
+	Person    models.Person
+	ValidFrom time.Time
+	ValidTo   *time.Time
+}
+
+// recordHistory closes the open revision of each person and opens one with
+// their state now. Every write to a person calls it in its transaction after
+// the change, so the history is kept exactly when the change is.
+func recordHistory(ctx context.Context, q execer, personIDs ...int) error {
+	if len(personIDs) == 0 {
+		return nil
+	}
+	// both statements see the table as it was before either ran, so the
+	// revision the INSERT opens is not closed by the UPDATE
+	_, err := q.ExecContext(ctx, `
+		WITH closed AS (
+			UPDATE person_history SET valid_to = now()
+			WHERE person_id = ANY($1) AND valid_to IS NULL
+		)
+		INSERT INTO person_history (person_id, first_name, last_name, type, age, courses, version, valid_from, deleted_at)
+		`+selectPeople+`
+		WHERE p.id = ANY($1)
+		GROUP BY p.id`, int64s(personIDs))
+	return err
+}
+
+// GetPersonHistory returns a page of the revisions of a person, oldest first,
+// or ErrNotFound when there never was such a person. Revisions page by version.
+func GetPersonHistory(ctx context.Context, db *sql.DB, id int, page Page) ([]PersonRevision, *Cursor, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT person_id, first_name, last_name, type, age, courses, version, valid_from, deleted_at, valid_to
+		FROM person_history
+		WHERE person_id = $1 AND version > $2
+		ORDER BY version
+		LIMIT $3`,
+		id, page.afterID(), page.limitArg())
+	if err != nil {
+		return []PersonRevision{}, nil, err
+	}
+	defer rows.Close()
+
+	var revisions []PersonRevision
+	for rows.Next() {
+		var revision PersonRevision
+		if err := scanRevision(rows, &revision); err != nil {
+			return nil, nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(revisions) == 0 && page.After == nil {
+		return []PersonRevision{}, nil, notFound("person %d", id)
+	}
+	revisions, next := nextCursor(revisions, page.Limit, func(r PersonRevision) Cursor { return Cursor{ID: r.Person.Version} })
+	return revisions, next, nil
+}
+
+// GetPersonAsOf returns a person and their course ids as they were at a point
+// in time, or ErrNotFound when they did not exist or were deleted then
+func GetPersonAsOf(ctx context.Context, db *sql.DB, id int, at time.Time) (models.Person, error) {
+	var revision PersonRevision
+	err := scanRevision(db.QueryRowContext(ctx, `
+		SELECT person_id, first_name, last_name, type, age, courses, version, valid_from, deleted_at, valid_to
+		FROM person_history
+		WHERE person_id = $1 AND valid_from <= $2 AND (valid_to IS NULL OR valid_to > $2)`,
+		id, at), &revision)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && revision.Person.DeletedAt != nil {
+		return models.Person{}, notExistedAt(id, at)
+	}
+	if err != nil {
+		return models.Person{}, err
+	}
+	return revision.Person, nil
+}
+
+// notExistedAt is the ErrNotFound of an as of read
+func notExistedAt(id int, at time.Time) error {
+	return &Error{Kind: ErrNotFound, Detail: fmt.Sprintf("person %d did not exist at %s", id, at.UTC().Format(time.RFC3339))}
+}
+
+// scanner is what *sql.Row and *sql.Rows have in common
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanRevision reads a person_history row
+func scanRevision(row scanner, revision *PersonRevision) error {
+	person := &revision.Person
+	var courses pq.Int64Array
+	if err := row.Scan(&person.ID, &person.FirstName, &person.LastName, &person.Type, &person.Age, &courses, &person.Version, &revision.ValidFrom, &person.DeletedAt, &revision.ValidTo); err != nil {
+		return err
+	}
+	person.Courses = courseIDs(courses)
+	person.UpdatedAt = revision.ValidFrom
+	return nil
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jacob-tech-challenge/api/models"
+)
+
+// expectHistory expects recordHistory to revise the given people
+func expectHistory(mock sqlmock.Sqlmock, personIDs ...int) {
+	mock.ExpectExec(`WITH closed AS \(\s+UPDATE person_history SET valid_to = now\(\)`).
+		WithArgs(int64s(personIDs)).
+		WillReturnResult(sqlmock.NewResult(0, int64(len(personIDs))))
+}
+
+var revisionColumns = []string{"person_id", "first_name", "last_name", "type", "age", "courses", "version", "valid_from", "deleted_at", "valid_to"}
+
+func TestRecordHistory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(`WHERE person_id = ANY\(\$1\) AND valid_to IS NULL\s+\)\s+INSERT INTO person_history \(person_id, first_name, last_name, type, age, courses, version, valid_from, deleted_at\)\s+` +
+		regexp.QuoteMeta(selectPeople) + `\s+WHERE p\.id = ANY\(\$1\)\s+GROUP BY p\.id`).
+		WithArgs(pq.Int64Array{1, 2}).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	assert.NoError(t, recordHistory(context.Background(), db, 1, 2))
+	// nobody to revise is no statement at all
+	assert.NoError(t, recordHistory(context.Background(), db))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetPersonHistory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	const query = `SELECT person_id, .+ FROM person_history\s+WHERE person_id = \$1 AND version > \$2\s+ORDER BY version\s+LIMIT \$3`
+	later := testUpdatedAt.Add(time.Hour)
+
+	t.Run("first page", func(t *testing.T) {
+		mock.ExpectQuery(query).WithArgs(3, 0, 3).
+			WillReturnRows(sqlmock.NewRows(revisionColumns).
+				AddRow(3, "Ada", "Lovelace", "professor", 36, "{}", 1, testUpdatedAt, nil, later).
+				AddRow(3, "Ada", "Lovelace", "professor", 36, "{1,2}", 2, later, nil, nil).
+				AddRow(3, "Ada", "Lovelace", "professor", 36, "{1,2}", 3, later, later, nil))
+
+		revisions, next, err := GetPersonHistory(context.Background(), db, 3, Page{Limit: 2})
+		assert.NoError(t, err)
+		assert.Equal(t, []PersonRevision{
+			{Person: models.Person{ID: 3, FirstName: "Ada", LastName: "Lovelace", Type: "professor", Age: 36, Version: 1, UpdatedAt: testUpdatedAt}, ValidFrom: testUpdatedAt, ValidTo: &later},
+			{Person: models.Person{ID: 3, FirstName: "Ada", LastName: "Lovelace", Type: "professor", Age: 36, Courses: []int{1, 2}, Version: 2, UpdatedAt: later}, ValidFrom: later},
+		}, revisions)
+		assert.Equal(t, &Cursor{ID: 2}, next)
+	})
+
+	t.Run("never existed", func(t *testing.T) {
+		mock.ExpectQuery(query).WithArgs(9, 0, nil).WillReturnRows(sqlmock.NewRows(revisionColumns))
+
+		_, _, err := GetPersonHistory(context.Background(), db, 9, Page{})
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("past the last page", func(t *testing.T) {
+		mock.ExpectQuery(query).WithArgs(3, 3, nil).WillReturnRows(sqlmock.NewRows(revisionColumns))
+
+		revisions, next, err := GetPersonHistory(context.Background(), db, 3, Page{After: &Cursor{ID: 3}})
+		assert.NoError(t, err)
+		assert.Empty(t, revisions)
+		assert.Nil(t, next)
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetPersonAsOf(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	const query = `FROM person_history\s+WHERE person_id = \$1 AND valid_from <= \$2 AND \(valid_to IS NULL OR valid_to > \$2\)`
+	at := time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		rows          *sqlmock.Rows
+		err           error
+		expected      models.Person
+		expectedError error
+	}{
+		"revision open at the time": {
+			rows:     sqlmock.NewRows(revisionColumns).AddRow(3, "Ada", "Lovelace", "professor", 36, "{1}", 2, testUpdatedAt, nil, nil),
+			expected: models.Person{ID: 3, FirstName: "Ada", LastName: "Lovelace", Type: "professor", Age: 36, Courses: []int{1}, Version: 2, UpdatedAt: testUpdatedAt},
+		},
+		"deleted at the time": {
+			rows:          sqlmock.NewRows(revisionColumns).AddRow(3, "Ada", "Lovelace", "professor", 36, "{1}", 3, testUpdatedAt, testUpdatedAt, nil),
+			expectedError: ErrNotFound,
+		},
+		"not created yet": {
+			rows:          sqlmock.NewRows(revisionColumns),
+			expectedError: ErrNotFound,
+		},
+		"database error": {
+			err:           sql.ErrConnDone,
+			expectedError: sql.ErrConnDone,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			expectation := mock.ExpectQuery(query).WithArgs(3, at)
+			if tc.err != nil {
+				expectation.WillReturnError(tc.err)
+			} else {
+				expectation.WillReturnRows(tc.rows)
+			}
+
+			person, err := GetPersonAsOf(context.Background(), db, 3, at)
+			if tc.expectedError != nil {
+				assert.ErrorIs(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, person)
+		})
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMemoryStoreHistory(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+
+	course, err := store.CreateCourse(ctx, models.Course{Name: "Math"})
+	assert.NoError(t, err)
+	person, err := store.CreatePerson(ctx, models.Person{FirstName: "Ada", LastName: "Lovelace", Type: "professor", Age: 36})
+	assert.NoError(t, err)
+	created := time.Now()
+	time.Sleep(time.Millisecond)
+	_, err = store.AddPersonToCourse(ctx, person.ID, 0, []int{course.ID})
+	assert.NoError(t, err)
+	enrolled := time.Now()
+	time.Sleep(time.Millisecond)
+	assert.NoError(t, store.DeletePerson(ctx, person.ID, 0))
+
+	revisions, next, err := store.GetPersonHistory(ctx, person.ID, Page{Limit: 2})
+	assert.NoError(t, err)
+	if assert.Len(t, revisions, 2) {
+		assert.Equal(t, []int{1, 2}, []int{revisions[0].Person.Version, revisions[1].Person.Version})
+		assert.Equal(t, revisions[1].ValidFrom, *revisions[0].ValidTo)
+		assert.Equal(t, []int{course.ID}, revisions[1].Person.Courses)
+	}
+	revisions, next, err = store.GetPersonHistory(ctx, person.ID, Page{Limit: 2, After: next})
+	assert.NoError(t, err)
+	if assert.Len(t, revisions, 1) {
+		assert.NotNil(t, revisions[0].Person.DeletedAt)
+		assert.Nil(t, revisions[0].ValidTo)
+	}
+	assert.Nil(t, next)
+
+	asOf, err := store.GetPersonAsOf(ctx, person.ID, created)
+	assert.NoError(t, err)
+	assert.Empty(t, asOf.Courses)
+	asOf, err = store.GetPersonAsOf(ctx, person.ID, enrolled)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{course.ID}, asOf.Courses)
+
+	_, err = store.GetPersonAsOf(ctx, person.ID, time.Now())
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = store.GetPersonAsOf(ctx, person.ID, created.Add(-time.Hour))
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, _, err = store.GetPersonHistory(ctx, 99, Page{})
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// IdempotentResponse is a response stored under an idempotency key, replayed
+// to retries of the request that made it
+type IdempotentResponse struct {
+	Status int
+	Header map[string][]string
+	Body   []byte
+}
+
+// ClaimIdempotencyKey claims key for the request with fingerprint until ttl
+// has passed. Keys are scoped by idempotencyScope, the same key sent by two
+// actors names two claims. It returns nil when the key is new or expired, and
+// the caller must then save or release it. A key whose request is done returns
+// its response. ErrKeyReused is returned when the key was claimed for a
+// different request, ErrConflict while the request that claimed it is still
+// running. A claim left without a response for longer than lease is taken to
+// belong to a request that died, and the same request may claim it again.
+func ClaimIdempotencyKey(ctx context.Context, db *sql.DB, key, fingerprint string, ttl, lease time.Duration) (*IdempotentResponse, error) {
+	actor := idempotencyScope(ctx)
+	return inTx(ctx, db, func(tx *sql.Tx) (*IdempotentResponse, error) {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM idempotency_key WHERE expires_at <= now()`); err != nil {
+			return nil, err
+		}
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO idempotency_key (actor, key, fingerprint, expires_at) VALUES ($1, $2, $3, now() + make_interval(secs => $4))
+			ON CONFLICT (actor, key) DO UPDATE SET claimed_at = now(), expires_at = EXCLUDED.expires_at
+			WHERE idempotency_key.status IS NULL AND idempotency_key.fingerprint = EXCLUDED.fingerprint
+				AND idempotency_key.claimed_at <= now() - make_interval(secs => $5)`,
+			actor, key, fingerprint, ttl.Seconds(), lease.Seconds())
+		if err != nil {
+			return nil, err
+		}
+		if claimed, err := result.RowsAffected(); err != nil || claimed == 1 {
+			return nil, err
+		}
+
+		var stored string
+		var status *int
+		var header, body []byte
+		if err := tx.QueryRowContext(ctx, `SELECT fingerprint, status, header, body FROM idempotency_key WHERE actor = $1 AND key = $2`, actor, key).
+			Scan(&stored, &status, &header, &body); err != nil {
+			return nil, err
+		}
+		if err := checkIdempotencyKey(key, stored, fingerprint, status != nil); err != nil {
+			return nil, err
+		}
+		response := &IdempotentResponse{Status: *status, Body: body}
+		if err := json.Unmarshal(header, &response.Header); err != nil {
+			return nil, err
+		}
+		return response, nil
+	})
+}
+
+// idempotencyScope returns whom the idempotency keys of ctx belong to: its
+// actor, or the address an anonymous request came from, so anonymous clients
+// do not share their keys. Names are only trusted when a proxy sets them, a
+// client setting its own could take any scope, this one included.
+func idempotencyScope(ctx context.Context) string {
+	actor := actorFrom(ctx)
+	if actor.Name != "" {
+		return actor.Name
+	}
+	return "anonymous@" + actor.Remote
+}
+
+// checkIdempotencyKey compares a claimed key with a request sent with it again
+func checkIdempotencyKey(key, stored, fingerprint string, done bool) error {
+	if stored != fingerprint {
+		return &Error{Kind: ErrKeyReused, Detail: "idempotency key " + key + " was used for a different request"}
+	}
+	if !done {
+		return conflict(nil, "the request with idempotency key %s is still running", key)
+	}
+	return nil
+}
+
+// SaveIdempotentResponse stores the response of the request that claimed key
+// for the actor of ctx
+func SaveIdempotentResponse(ctx context.Context, db *sql.DB, key string, response IdempotentResponse) error {
+	header, err := json.Marshal(response.Header)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `UPDATE idempotency_key SET status = $3, header = $4, body = $5 WHERE actor = $1 AND key = $2`,
+		idempotencyScope(ctx), key, response.Status, header, response.Body)
+	return err
+}
+
+// ReleaseIdempotencyKey gives up a claim of the actor of ctx without a
+// response, so a retry with key runs the request again
+func ReleaseIdempotencyKey(ctx context.Context, db *sql.DB, key string) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM idempotency_key WHERE actor = $1 AND key = $2 AND status IS NULL`, idempotencyScope(ctx), key)
+	return err
+}
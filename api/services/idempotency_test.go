@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClaimIdempotencyKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	const (
+		expireQuery = `DELETE FROM idempotency_key WHERE expires_at <= now\(\)`
+		claimQuery  = `INSERT INTO idempotency_key \(actor, key, fingerprint, expires_at\) VALUES \(\$1, \$2, \$3, now\(\) \+ make_interval\(secs => \$4\)\)\s+ON CONFLICT \(actor, key\) DO UPDATE SET claimed_at = now\(\), expires_at = EXCLUDED.expires_at\s+WHERE idempotency_key.status IS NULL AND idempotency_key.fingerprint = EXCLUDED.fingerprint\s+AND idempotency_key.claimed_at <= now\(\) - make_interval\(secs => \$5\)`
+		storedQuery = `SELECT fingerprint, status, header, body FROM idempotency_key WHERE actor = \$1 AND key = \$2`
+	)
+	columns := []string{"fingerprint", "status", "header", "body"}
+	expectClaim := func(claimed int64) {
+		mock.ExpectBegin()
+		mock.ExpectExec(expireQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(claimQuery).WithArgs("alice", "key-1", "abc", float64(60), float64(30)).WillReturnResult(sqlmock.NewResult(0, claimed))
+	}
+
+	tests := map[string]struct {
+		mock          func()
+		expected      *IdempotentResponse
+		expectedError error
+	}{
+		"new key or a claim past its lease": {
+			mock: func() {
+				expectClaim(1)
+				mock.ExpectCommit()
+			},
+		},
+		"replays the stored response": {
+			mock: func() {
+				expectClaim(0)
+				mock.ExpectQuery(storedQuery).WithArgs("alice", "key-1").
+					WillReturnRows(sqlmock.NewRows(columns).AddRow("abc", 201, `{"Content-Type": ["application/json"]}`, []byte(`{"id": 1}`)))
+				mock.ExpectCommit()
+			},
+			expected: &IdempotentResponse{Status: 201, Header: map[string][]string{"Content-Type": {"application/json"}}, Body: []byte(`{"id": 1}`)},
+		},
+		"different request": {
+			mock: func() {
+				expectClaim(0)
+				mock.ExpectQuery(storedQuery).WithArgs("alice", "key-1").
+					WillReturnRows(sqlmock.NewRows(columns).AddRow("def", 201, `{}`, []byte(`{}`)))
+				mock.ExpectRollback()
+			},
+			expectedError: ErrKeyReused,
+		},
+		"still running": {
+			mock: func() {
+				expectClaim(0)
+				mock.ExpectQuery(storedQuery).WithArgs("alice", "key-1").
+					WillReturnRows(sqlmock.NewRows(columns).AddRow("abc", nil, nil, nil))
+				mock.ExpectRollback()
+			},
+			expectedError: ErrConflict,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc.mock()
+			ctx := WithActor(context.Background(), Actor{Name: "alice"})
+			response, err := NewPostgresStore(db).ClaimIdempotencyKey(ctx, "key-1", "abc", time.Minute, 30*time.Second)
+			if tc.expectedError != nil {
+				assert.ErrorIs(t, err, tc.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expected, response)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestSaveIdempotentResponse(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(`UPDATE idempotency_key SET status = \$3, header = \$4, body = \$5 WHERE actor = \$1 AND key = \$2`).
+		WithArgs("alice", "key-1", 201, []byte(`{"Content-Type":["application/json"]}`), []byte(`{"id": 1}`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM idempotency_key WHERE actor = \$1 AND key = \$2 AND status IS NULL`).WithArgs("alice", "key-2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	ctx := WithActor(context.Background(), Actor{Name: "alice"})
+	store := NewPostgresStore(db)
+	response := IdempotentResponse{Status: 201, Header: map[string][]string{"Content-Type": {"application/json"}}, Body: []byte(`{"id": 1}`)}
+	assert.NoError(t, store.SaveIdempotentResponse(ctx, "key-1", response))
+	assert.NoError(t, store.ReleaseIdempotencyKey(ctx, "key-2"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestIdempotencyScope(t *testing.T) {
+	assert.Equal(t, "alice", idempotencyScope(WithActor(context.Background(), Actor{Name: "alice", Remote: "203.0.113.5"})))
+	// anonymous requests are kept apart by where they came from
+	assert.Equal(t, "anonymous@203.0.113.5", idempotencyScope(WithActor(context.Background(), Actor{Remote: "203.0.113.5"})))
+	assert.Equal(t, "anonymous@", idempotencyScope(context.Background()))
+}
+
+func TestMemoryStoreIdempotencyKeys(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	response := IdempotentResponse{Status: 201, Body: []byte(`{"id": 1}`)}
+
+	stored, err := store.ClaimIdempotencyKey(ctx, "key-1", "abc", time.Minute, time.Minute)
+	assert.NoError(t, err)
+	assert.Nil(t, stored)
+
+	_, err = store.ClaimIdempotencyKey(ctx, "key-1", "abc", time.Minute, time.Minute)
+	assert.ErrorIs(t, err, ErrConflict, "the first request is still running")
+
+	assert.NoError(t, store.SaveIdempotentResponse(ctx, "key-1", response))
+	stored, err = store.ClaimIdempotencyKey(ctx, "key-1", "abc", time.Minute, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, &response, stored)
+	_, err = store.ClaimIdempotencyKey(ctx, "key-1", "def", time.Minute, time.Minute)
+	assert.ErrorIs(t, err, ErrKeyReused)
+
+	// a released key can be claimed again, a stored response cannot be released
+	_, err = store.ClaimIdempotencyKey(ctx, "key-2", "abc", time.Minute, time.Minute)
+	assert.NoError(t, err)
+	assert.NoError(t, store.ReleaseIdempotencyKey(ctx, "key-2"))
+	stored, err = store.ClaimIdempotencyKey(ctx, "key-2", "def", time.Minute, time.Minute)
+	assert.NoError(t, err)
+	assert.Nil(t, stored)
+	assert.NoError(t, store.ReleaseIdempotencyKey(ctx, "key-1"))
+	stored, err = store.ClaimIdempotencyKey(ctx, "key-1", "abc", time.Minute, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, &response, stored)
+
+	// an expired key is forgotten
+	_, err = store.ClaimIdempotencyKey(ctx, "key-3", "abc", 0, time.Minute)
+	assert.NoError(t, err)
+	stored, err = store.ClaimIdempotencyKey(ctx, "key-3", "def", time.Minute, time.Minute)
+	assert.NoError(t, err)
+	assert.Nil(t, stored)
+
+	// a claim past its lease can be taken over by the same request only
+	_, err = store.ClaimIdempotencyKey(ctx, "key-4", "abc", time.Minute, 0)
+	assert.NoError(t, err)
+	_, err = store.ClaimIdempotencyKey(ctx, "key-4", "def", time.Minute, 0)
+	assert.ErrorIs(t, err, ErrKeyReused)
+	stored, err = store.ClaimIdempotencyKey(ctx, "key-4", "abc", time.Minute, 0)
+	assert.NoError(t, err)
+	assert.Nil(t, stored)
+
+	// keys are scoped by actor
+	bob := WithActor(ctx, Actor{Name: "bob"})
+	stored, err = store.ClaimIdempotencyKey(bob, "key-1", "def", time.Minute, time.Minute)
+	assert.NoError(t, err)
+	assert.Nil(t, stored)
+	assert.NoError(t, store.ReleaseIdempotencyKey(bob, "key-1"))
+	stored, err = store.ClaimIdempotencyKey(ctx, "key-1", "abc", time.Minute, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, &response, stored)
+}
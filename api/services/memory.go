@@ -0,0 +1,1016 @@
+package services
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jacob-tech-challenge/api/models"
+)
+
+// MemoryStore implements Store in memory. It follows the same rules as the
+// postgres schema (person type check, foreign keys on person_course) and soft
+// deletes like the postgres store, so it can stand in for the database in tests
+// and local runs. Like a query, every method fails with the context error once
+// ctx is done. Writes are audited, and people's history kept, like the postgres
+// store does.
+type MemoryStore struct {
+	mu           sync.RWMutex
+	courses      map[int]models.Course
+	people       map[int]models.Person
+	enrollments  map[int]map[int]struct{} // person id -> set of course ids
+	audit        []AuditEntry             // in id order, ids start at 1
+	history      map[int][]PersonRevision // person id -> revisions in version order
+	idempotency  map[idempotencyKey]idempotencyClaim
+	nextCourseID int
+	nextPersonID int
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		courses:      map[int]models.Course{},
+		people:       map[int]models.Person{},
+		enrollments:  map[int]map[int]struct{}{},
+		history:      map[int][]PersonRevision{},
+		idempotency:  map[idempotencyKey]idempotencyClaim{},
+		nextCourseID: 1,
+		nextPersonID: 1,
+	}
+}
+
+// GetAllCourses returns a page of the courses matching the filter, ordered by id
+func (s *MemoryStore) GetAllCourses(ctx context.Context, filter CourseFilter, page Page) ([]models.Course, *Cursor, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var courses []models.Course
+	for _, id := range sortedKeys(s.courses) {
+		if course := s.courses[id]; id > page.afterID() && (filter.IncludeDeleted || course.DeletedAt == nil) {
+			courses = append(courses, course)
+		}
+	}
+	courses, next := nextCursor(courses, page.Limit, func(c models.Course) Cursor { return Cursor{ID: c.ID} })
+	return courses, next, nil
+}
+
+// GetCourseByID returns a course by id
+func (s *MemoryStore) GetCourseByID(ctx context.Context, id int) (models.Course, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Course{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	course, ok := s.liveCourse(id)
+	if !ok {
+		return models.Course{}, notFound("course %d", id)
+	}
+	return course, nil
+}
+
+// UpdateCourse updates a course
+func (s *MemoryStore) UpdateCourse(ctx context.Context, id int, course models.Course) (models.Course, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Course{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.liveCourse(id)
+	if !ok {
+		return models.Course{}, notFound("course %d", id)
+	}
+	if err := checkVersion("course", id, existing.Version, course.Version); err != nil {
+		return models.Course{}, err
+	}
+	before := existing
+	existing.Name = course.Name
+	existing.Version++
+	existing.UpdatedAt = now()
+	s.courses[id] = existing
+	s.record(courseChange(ctx, AuditUpdate, &before, &existing))
+	return existing, nil
+}
+
+// CreateCourse creates a course
+func (s *MemoryStore) CreateCourse(ctx context.Context, course models.Course) (models.Course, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Course{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	course.ID = s.nextCourseID
+	s.nextCourseID++
+	course.Version = 1
+	course.UpdatedAt = now()
+	s.courses[course.ID] = course
+	s.record(courseChange(ctx, AuditCreate, nil, &course))
+	return course, nil
+}
+
+// DeleteCourse soft deletes a course and deals with the enrollments of the
+// live people in it as deletion says
+func (s *MemoryStore) DeleteCourse(ctx context.Context, id, version int, deletion CourseDeletion) (CourseDeleted, error) {
+	if err := ctx.Err(); err != nil {
+		return CourseDeleted{}, err
+	}
+	if err := deletion.Validate(id); err != nil {
+		return CourseDeleted{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.liveCourse(id)
+	if !ok {
+		return CourseDeleted{}, notFound("course %d", id)
+	}
+	if err := checkVersion("course", id, existing.Version, version); err != nil {
+		return CourseDeleted{}, err
+	}
+	if _, ok := s.liveCourse(deletion.To); deletion.Mode == DeleteReassign && !ok {
+		return CourseDeleted{}, invalid(nil, "course %d to reassign to does not exist", deletion.To)
+	}
+	var enrolled []int
+	for _, personID := range sortedKeys(s.enrollments) {
+		if _, ok := s.enrollments[personID][id]; ok && s.people[personID].DeletedAt == nil {
+			enrolled = append(enrolled, personID)
+		}
+	}
+	if len(enrolled) > 0 && deletion.restricts() {
+		return CourseDeleted{}, &EnrolledError{CourseID: id, PersonIDs: enrolled}
+	}
+
+	var result CourseDeleted
+	for _, personID := range enrolled {
+		before := s.withCourses(s.people[personID])
+		if _, ok := s.enrollments[personID][deletion.To]; deletion.Mode == DeleteReassign && !ok {
+			s.enroll(personID, deletion.To)
+			result.Moved++
+		}
+		delete(s.enrollments[personID], id)
+		result.Affected++
+		s.touchPerson(personID)
+		after := s.withCourses(s.people[personID])
+		s.record(personChange(ctx, AuditEnrollment, &before, &after))
+	}
+	before := existing
+	deletedAt := now()
+	existing.DeletedAt = &deletedAt
+	existing.Version++
+	existing.UpdatedAt = deletedAt
+	s.courses[id] = existing
+	s.record(courseChange(ctx, AuditDelete, &before, &existing))
+	return result, nil
+}
+
+// RestoreCourse undoes the soft delete of a course, the live people enrolled
+// in it get a new version and an enrollment audit entry as their enrollments
+// come back
+func (s *MemoryStore) RestoreCourse(ctx context.Context, id int) (models.Course, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Course{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.courses[id]
+	if !ok {
+		return models.Course{}, notFound("course %d", id)
+	}
+	if existing.DeletedAt == nil {
+		return models.Course{}, conflict(nil, "course %d is not deleted", id)
+	}
+	var enrolled []models.Person
+	for _, personID := range sortedKeys(s.enrollments) {
+		if _, ok := s.enrollments[personID][id]; ok && s.people[personID].DeletedAt == nil {
+			enrolled = append(enrolled, s.withCourses(s.people[personID]))
+		}
+	}
+	before := existing
+	existing.DeletedAt = nil
+	existing.Version++
+	existing.UpdatedAt = now()
+	s.courses[id] = existing
+	s.record(courseChange(ctx, AuditRestore, &before, &existing))
+	for i := range enrolled {
+		s.touchPerson(enrolled[i].ID)
+		after := s.withCourses(s.people[enrolled[i].ID])
+		s.record(personChange(ctx, AuditEnrollment, &enrolled[i], &after))
+	}
+	return existing, nil
+}
+
+// PatchCourse changes a course to what patch makes of it, or returns ErrNotFound
+// when there is no such course. Like PatchPerson it retries when the course
+// changes while patch runs.
+func (s *MemoryStore) PatchCourse(ctx context.Context, id, version int, patch CoursePatch) (models.Course, error) {
+	for {
+		current, err := s.GetCourseByID(ctx, id)
+		if err != nil {
+			return models.Course{}, err
+		}
+		if err := checkVersion("course", id, current.Version, version); err != nil {
+			return models.Course{}, err
+		}
+		patched, err := patch(current)
+		if err != nil {
+			return models.Course{}, err
+		}
+
+		s.mu.Lock()
+		if existing, ok := s.liveCourse(id); !ok || existing.Version != current.Version {
+			s.mu.Unlock()
+			continue
+		}
+		updated := models.Course{ID: id, Name: patched.Name, Version: current.Version + 1, UpdatedAt: now()}
+		s.courses[id] = updated
+		s.record(courseChange(ctx, AuditUpdate, &current, &updated))
+		s.mu.Unlock()
+		return updated, nil
+	}
+}
+
+// MissingCourseIDs returns the ids that do not belong to a live course, in ascending order
+func (s *MemoryStore) MissingCourseIDs(ctx context.Context, ids []int) ([]int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	missing := []int{}
+	seen := map[int]struct{}{}
+	for _, id := range ids {
+		if _, ok := s.liveCourse(id); ok {
+			continue
+		}
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			missing = append(missing, id)
+		}
+	}
+	sort.Ints(missing)
+	return missing, nil
+}
+
+// GetAllPeople returns a page of people matching the filter, in the filter's order
+func (s *MemoryStore) GetAllPeople(ctx context.Context, filter PersonFilter, page Page) ([]models.Person, *Cursor, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	if err := filter.Validate(); err != nil {
+		return nil, nil, err
+	}
+	if err := filter.checkCursor(page.After); err != nil {
+		return nil, nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var people []models.Person
+	for _, person := range s.people {
+		if person = s.withCourses(person); filter.matches(person) {
+			people = append(people, person)
+		}
+	}
+	filter.sortPeople(people)
+
+	var paged []models.Person
+	for _, person := range people {
+		if filter.after(person, page.After) {
+			paged = append(paged, person)
+		}
+	}
+	paged, next := nextCursor(paged, page.Limit, filter.cursor)
+	return paged, next, nil
+}
+
+// GetPersonByID returns a person and their course ids, or ErrNotFound when there is no such person or they were deleted
+func (s *MemoryStore) GetPersonByID(ctx context.Context, id int) (models.Person, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Person{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	person, ok := s.livePerson(id)
+	if !ok {
+		return models.Person{}, notFound("person %d", id)
+	}
+	return s.withCourses(person), nil
+}
+
+// UpdatePerson updates a person by id, or returns ErrNotFound when there is no such person
+func (s *MemoryStore) UpdatePerson(ctx context.Context, id int, person models.Person) (models.Person, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Person{}, err
+	}
+	if err := checkPersonType(person.Type); err != nil {
+		return models.Person{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.livePerson(id)
+	if !ok {
+		return models.Person{}, notFound("person %d", id)
+	}
+	if err := checkVersion("person", id, existing.Version, person.Version); err != nil {
+		return models.Person{}, err
+	}
+	before := s.withCourses(existing)
+	s.people[id] = models.Person{
+		ID:        id,
+		FirstName: person.FirstName,
+		LastName:  person.LastName,
+		Type:      person.Type,
+		Age:       person.Age,
+		Version:   existing.Version + 1,
+		UpdatedAt: now(),
+	}
+	after := s.withCourses(s.people[id])
+	s.revise(id)
+	s.record(personChange(ctx, AuditUpdate, &before, &after))
+	return after, nil
+}
+
+// CreatePerson creates a person and their course associations
+func (s *MemoryStore) CreatePerson(ctx context.Context, person models.Person) (models.Person, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Person{}, err
+	}
+	if err := checkPersonType(person.Type); err != nil {
+		return models.Person{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// validate everything up front so a failure leaves nothing behind, like the transaction does
+	seen, err := s.courseSet(person.Courses)
+	if err != nil {
+		return models.Person{}, err
+	}
+
+	person.ID = s.nextPersonID
+	s.nextPersonID++
+	person.Courses = append([]int(nil), person.Courses...)
+	person.Version = 1
+	person.UpdatedAt = now()
+	s.people[person.ID] = models.Person{
+		ID:        person.ID,
+		FirstName: person.FirstName,
+		LastName:  person.LastName,
+		Type:      person.Type,
+		Age:       person.Age,
+		Version:   person.Version,
+		UpdatedAt: person.UpdatedAt,
+	}
+	if len(seen) > 0 {
+		s.enrollments[person.ID] = seen
+	}
+	s.revise(person.ID)
+	s.record(personChange(ctx, AuditCreate, nil, &person))
+	return person, nil
+}
+
+// PatchPerson changes a person and their courses to what patch makes of them,
+// or returns ErrNotFound when there is no such person. patch runs without the
+// lock so it can read the store, the change is only applied if the person did
+// not change meanwhile and patch runs again otherwise.
+func (s *MemoryStore) PatchPerson(ctx context.Context, id, version int, patch PersonPatch) (models.Person, error) {
+	for {
+		current, err := s.GetPersonByID(ctx, id)
+		if err != nil {
+			return models.Person{}, err
+		}
+		if err := checkVersion("person", id, current.Version, version); err != nil {
+			return models.Person{}, err
+		}
+		patched, err := patch(current)
+		if err != nil {
+			return models.Person{}, err
+		}
+		if err := checkPersonType(patched.Type); err != nil {
+			return models.Person{}, err
+		}
+
+		s.mu.Lock()
+		if existing, ok := s.livePerson(id); !ok || existing.Version != current.Version {
+			s.mu.Unlock()
+			continue
+		}
+		courses, err := s.courseSet(patched.Courses)
+		if err != nil {
+			s.mu.Unlock()
+			return models.Person{}, err
+		}
+		// enrollments in deleted courses are out of patch's sight, they stay for a restore
+		for courseID := range s.enrollments[id] {
+			if _, ok := s.liveCourse(courseID); !ok {
+				courses[courseID] = struct{}{}
+			}
+		}
+		s.people[id] = models.Person{
+			ID:        id,
+			FirstName: patched.FirstName,
+			LastName:  patched.LastName,
+			Type:      patched.Type,
+			Age:       patched.Age,
+			Version:   current.Version + 1,
+			UpdatedAt: now(),
+		}
+		if len(courses) > 0 {
+			s.enrollments[id] = courses
+		} else {
+			delete(s.enrollments, id)
+		}
+		updated := s.withCourses(s.people[id])
+		s.revise(id)
+		s.record(personChange(ctx, AuditUpdate, &current, &updated))
+		s.mu.Unlock()
+		return updated, nil
+	}
+}
+
+// DeletePerson soft deletes a person, keeping their enrollments for a restore,
+// or returns ErrNotFound when there is no such person
+func (s *MemoryStore) DeletePerson(ctx context.Context, id, version int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.livePerson(id)
+	if !ok {
+		return notFound("person %d", id)
+	}
+	if err := checkVersion("person", id, existing.Version, version); err != nil {
+		return err
+	}
+	before := s.withCourses(existing)
+	deletedAt := now()
+	existing.DeletedAt = &deletedAt
+	existing.Version++
+	existing.UpdatedAt = deletedAt
+	s.people[id] = existing
+	after := s.withCourses(existing)
+	s.revise(id)
+	s.record(personChange(ctx, AuditDelete, &before, &after))
+	return nil
+}
+
+// RestorePerson undoes the soft delete of a person, their enrollments in live
+// courses come back with them
+func (s *MemoryStore) RestorePerson(ctx context.Context, id int) (models.Person, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Person{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.people[id]
+	if !ok {
+		return models.Person{}, notFound("person %d", id)
+	}
+	if existing.DeletedAt == nil {
+		return models.Person{}, conflict(nil, "person %d is not deleted", id)
+	}
+	before := s.withCourses(existing)
+	existing.DeletedAt = nil
+	existing.Version++
+	existing.UpdatedAt = now()
+	s.people[id] = existing
+	after := s.withCourses(existing)
+	s.revise(id)
+	s.record(personChange(ctx, AuditRestore, &before, &after))
+	return after, nil
+}
+
+// PurgeDeleted removes for good the people and courses soft deleted before
+// the cutoff, and every enrollment they had
+func (s *MemoryStore) PurgeDeleted(ctx context.Context, before time.Time) (Purged, error) {
+	if err := ctx.Err(); err != nil {
+		return Purged{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var purged Purged
+	for _, id := range sortedKeys(s.people) {
+		if person := s.people[id]; person.DeletedAt != nil && person.DeletedAt.Before(before) {
+			person = s.withCourses(person)
+			delete(s.enrollments, id)
+			delete(s.people, id)
+			purged.People++
+			s.record(personChange(ctx, AuditPurge, &person, nil))
+		}
+	}
+	for _, id := range sortedKeys(s.courses) {
+		if course := s.courses[id]; course.DeletedAt != nil && course.DeletedAt.Before(before) {
+			for _, courses := range s.enrollments {
+				delete(courses, id)
+			}
+			delete(s.courses, id)
+			purged.Courses++
+			s.record(courseChange(ctx, AuditPurge, &course, nil))
+		}
+	}
+	return purged, nil
+}
+
+// GetPersonHistory returns a page of the revisions of a person, oldest first,
+// or ErrNotFound when there never was such a person
+func (s *MemoryStore) GetPersonHistory(ctx context.Context, id int, page Page) ([]PersonRevision, *Cursor, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var revisions []PersonRevision
+	for _, revision := range s.history[id] {
+		if revision.Person.Version > page.afterID() {
+			revisions = append(revisions, revision)
+		}
+	}
+	if len(revisions) == 0 && page.After == nil {
+		return []PersonRevision{}, nil, notFound("person %d", id)
+	}
+	revisions, next := nextCursor(revisions, page.Limit, func(r PersonRevision) Cursor { return Cursor{ID: r.Person.Version} })
+	return revisions, next, nil
+}
+
+// GetPersonAsOf returns a person as they were at a point in time, or
+// ErrNotFound when they did not exist or were deleted then
+func (s *MemoryStore) GetPersonAsOf(ctx context.Context, id int, at time.Time) (models.Person, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Person{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, revision := range s.history[id] {
+		if !revision.ValidFrom.After(at) && (revision.ValidTo == nil || revision.ValidTo.After(at)) && revision.Person.DeletedAt == nil {
+			return revision.Person, nil
+		}
+	}
+	return models.Person{}, notExistedAt(id, at)
+}
+
+// CoursesModified returns when a course was last written
+func (s *MemoryStore) CoursesModified(ctx context.Context) (time.Time, error) {
+	return s.lastModified(ctx, "course")
+}
+
+// PeopleModified returns when a person was last written
+func (s *MemoryStore) PeopleModified(ctx context.Context) (time.Time, error) {
+	return s.lastModified(ctx, "person")
+}
+
+// lastModified returns the time of the latest audit entry of an entity, like LastModified
+func (s *MemoryStore) lastModified(ctx context.Context, entity string) (time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for i := len(s.audit) - 1; i >= 0; i-- {
+		if s.audit[i].Entity == entity {
+			return s.audit[i].At, nil
+		}
+	}
+	return time.Time{}, nil
+}
+
+// GetAuditLog returns a page of the audit entries matching the filter, oldest first
+func (s *MemoryStore) GetAuditLog(ctx context.Context, filter AuditFilter, page Page) ([]AuditEntry, *Cursor, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	if err := filter.Validate(); err != nil {
+		return nil, nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var entries []AuditEntry
+	for _, entry := range s.audit {
+		if entry.ID > page.afterID() && filter.matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	entries, next := nextCursor(entries, page.Limit, func(e AuditEntry) Cursor { return Cursor{ID: e.ID} })
+	return entries, next, nil
+}
+
+// GetCoursesByPersonID returns all course ids for a person
+func (s *MemoryStore) GetCoursesByPersonID(ctx context.Context, personID int) ([]int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.courseIDs(personID), nil
+}
+
+// GetPersonCourses returns the courses a person is enrolled in, or ErrNotFound when there is no such person
+func (s *MemoryStore) GetPersonCourses(ctx context.Context, personID int) ([]models.Course, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.livePerson(personID); !ok {
+		return nil, notFound("person %d", personID)
+	}
+	// only id and name, like the postgres query selects
+	courses := []models.Course{}
+	for _, id := range s.courseIDs(personID) {
+		courses = append(courses, models.Course{ID: id, Name: s.courses[id].Name})
+	}
+	return courses, nil
+}
+
+// AddPersonToCourse enrolls a person in the given courses and reports the outcome for each course
+func (s *MemoryStore) AddPersonToCourse(ctx context.Context, personID, version int, courseIDs []int) ([]EnrollmentResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	person, ok := s.livePerson(personID)
+	if !ok {
+		return nil, notFound("person %d", personID)
+	}
+	if err := checkVersion("person", personID, person.Version, version); err != nil {
+		return nil, err
+	}
+	before := s.withCourses(person)
+	return s.touchOnChange(ctx, before, s.changeEnrollments(personID, courseIDs, true)), nil
+}
+
+// RemovePersonFromCourses unenrolls a person from the given courses and reports the outcome for each course
+func (s *MemoryStore) RemovePersonFromCourses(ctx context.Context, personID, version int, courseIDs []int) ([]EnrollmentResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	person, ok := s.livePerson(personID)
+	if !ok {
+		return nil, notFound("person %d", personID)
+	}
+	if err := checkVersion("person", personID, person.Version, version); err != nil {
+		return nil, err
+	}
+	before := s.withCourses(person)
+	return s.touchOnChange(ctx, before, s.changeEnrollments(personID, courseIDs, false)), nil
+}
+
+// SetPersonCourses replaces a person's enrollments with the given courses, dropped
+// enrollments are reported as removed ahead of the requested courses
+func (s *MemoryStore) SetPersonCourses(ctx context.Context, personID, version int, courseIDs []int) ([]EnrollmentResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	person, ok := s.livePerson(personID)
+	if !ok {
+		return nil, notFound("person %d", personID)
+	}
+	if err := checkVersion("person", personID, person.Version, version); err != nil {
+		return nil, err
+	}
+	before := s.withCourses(person)
+	keep := map[int]struct{}{}
+	for _, id := range courseIDs {
+		keep[id] = struct{}{}
+	}
+	var results []EnrollmentResult
+	for _, id := range s.courseIDs(personID) {
+		if _, ok := keep[id]; !ok {
+			delete(s.enrollments[personID], id)
+			results = append(results, EnrollmentResult{CourseID: id, Status: EnrollmentRemoved})
+		}
+	}
+	return s.touchOnChange(ctx, before, append(results, s.changeEnrollments(personID, courseIDs, true)...)), nil
+}
+
+// GetCourseRoster returns a page of the people enrolled in a course, or ErrNotFound when there is no such course
+func (s *MemoryStore) GetCourseRoster(ctx context.Context, courseID int, personType string, page Page) (Roster, *Cursor, error) {
+	if err := ctx.Err(); err != nil {
+		return Roster{}, nil, err
+	}
+	filter := rosterFilter(courseID, personType)
+	if err := filter.Validate(); err != nil {
+		return Roster{}, nil, err
+	}
+
+	var roster Roster
+	s.mu.RLock()
+	_, ok := s.liveCourse(courseID)
+	for personID, courses := range s.enrollments {
+		if _, enrolled := courses[courseID]; !enrolled {
+			continue
+		}
+		person, live := s.livePerson(personID)
+		if !live {
+			continue
+		}
+		switch person.Type {
+		case "professor":
+			roster.Professors++
+		case "student":
+			roster.Students++
+		}
+	}
+	s.mu.RUnlock()
+	if !ok {
+		return Roster{}, nil, notFound("course %d", courseID)
+	}
+
+	people, next, err := s.GetAllPeople(ctx, filter, page)
+	if err != nil {
+		return Roster{}, nil, err
+	}
+	roster.People = people
+	return roster, next, nil
+}
+
+// changeEnrollments enrolls or unenrolls a person course by course, the caller must hold the write lock
+func (s *MemoryStore) changeEnrollments(personID int, courseIDs []int, enroll bool) []EnrollmentResult {
+	results := make([]EnrollmentResult, 0, len(courseIDs))
+	for _, id := range courseIDs {
+		_, enrolled := s.enrollments[personID][id]
+		result := EnrollmentResult{CourseID: id}
+		switch _, exists := s.liveCourse(id); {
+		case !exists:
+			result.Status = EnrollmentCourseNotFound
+		case enroll && enrolled:
+			result.Status = EnrollmentAlreadyEnrolled
+		case enroll:
+			s.enroll(personID, id)
+			result.Status = EnrollmentAdded
+		case enrolled:
+			delete(s.enrollments[personID], id)
+			result.Status = EnrollmentRemoved
+		default:
+			result.Status = EnrollmentNotEnrolled
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// touchOnChange bumps a person's version and audits the change when results
+// added or removed an enrollment, and returns results. before is the person
+// ahead of the change, the caller must hold the write lock.
+func (s *MemoryStore) touchOnChange(ctx context.Context, before models.Person, results []EnrollmentResult) []EnrollmentResult {
+	if enrollmentsChanged(results) {
+		s.touchPerson(before.ID)
+		after := s.withCourses(s.people[before.ID])
+		s.record(personChange(ctx, AuditEnrollment, &before, &after))
+	}
+	return results
+}
+
+// RunBatch runs ops in order on a copy of the store, which replaces the store
+// once they all succeed. The store stays locked meanwhile, so a batch is
+// applied whole or not at all, like in a postgres transaction.
+func (s *MemoryStore) RunBatch(ctx context.Context, ops []BatchOp) ([]BatchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := validateBatch(ops); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	scratch := s.clone()
+	results, err := runBatch(ctx, scratch, ops)
+	if err != nil {
+		return nil, err
+	}
+	s.courses, s.people, s.enrollments = scratch.courses, scratch.people, scratch.enrollments
+	s.audit, s.history = scratch.audit, scratch.history
+	s.nextCourseID, s.nextPersonID = scratch.nextCourseID, scratch.nextPersonID
+	return results, nil
+}
+
+// clone returns a store holding a copy of the data, which writes to it leave
+// alone. The caller must hold the lock.
+func (s *MemoryStore) clone() *MemoryStore {
+	clone := &MemoryStore{
+		courses:      maps.Clone(s.courses),
+		people:       maps.Clone(s.people),
+		enrollments:  make(map[int]map[int]struct{}, len(s.enrollments)),
+		audit:        slices.Clone(s.audit),
+		history:      make(map[int][]PersonRevision, len(s.history)),
+		nextCourseID: s.nextCourseID,
+		nextPersonID: s.nextPersonID,
+	}
+	for personID, courses := range s.enrollments {
+		clone.enrollments[personID] = maps.Clone(courses)
+	}
+	for personID, revisions := range s.history {
+		clone.history[personID] = slices.Clone(revisions)
+	}
+	return clone
+}
+
+// record appends an entry to the audit log, the caller must hold the write lock
+func (s *MemoryStore) record(entry AuditEntry) {
+	entry.ID = len(s.audit) + 1
+	entry.At = now()
+	s.audit = append(s.audit, entry)
+}
+
+// touchPerson bumps a person's version like the postgres store does after
+// their enrollments changed and opens a revision, the caller must hold the write lock
+func (s *MemoryStore) touchPerson(personID int) {
+	person := s.people[personID]
+	person.Version++
+	person.UpdatedAt = now()
+	s.people[personID] = person
+	s.revise(personID)
+}
+
+// revise closes the open revision of a person and opens one with their state
+// now, like recordHistory. The caller must hold the write lock.
+func (s *MemoryStore) revise(personID int) {
+	person := s.withCourses(s.people[personID])
+	revisions := s.history[personID]
+	if n := len(revisions); n > 0 {
+		validTo := person.UpdatedAt
+		revisions[n-1].ValidTo = &validTo
+	}
+	s.history[personID] = append(revisions, PersonRevision{Person: person, ValidFrom: person.UpdatedAt})
+}
+
+// enroll records an enrollment, the caller must hold the write lock
+func (s *MemoryStore) enroll(personID, courseID int) {
+	if s.enrollments[personID] == nil {
+		s.enrollments[personID] = map[int]struct{}{}
+	}
+	s.enrollments[personID][courseID] = struct{}{}
+}
+
+// courseSet checks course ids like the person_course foreign key and primary
+// key do and returns them as a set, the caller must hold the lock
+func (s *MemoryStore) courseSet(courseIDs []int) (map[int]struct{}, error) {
+	set := map[int]struct{}{}
+	for _, courseID := range courseIDs {
+		if _, ok := s.liveCourse(courseID); !ok {
+			return nil, invalid(nil, detailUnknownCourse)
+		}
+		if _, ok := set[courseID]; ok {
+			return nil, invalid(nil, detailRepeatedCourse)
+		}
+		set[courseID] = struct{}{}
+	}
+	return set, nil
+}
+
+// withCourses returns a copy of person with its course ids filled in, the caller must hold the lock
+func (s *MemoryStore) withCourses(person models.Person) models.Person {
+	person.Courses = s.courseIDs(person.ID)
+	return person
+}
+
+// courseIDs returns the sorted ids of a person's live courses, the caller must hold the lock
+func (s *MemoryStore) courseIDs(personID int) []int {
+	var ids []int
+	for _, id := range sortedKeys(s.enrollments[personID]) {
+		if _, ok := s.liveCourse(id); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// liveCourse returns a course unless there is no such course or it was
+// deleted, the caller must hold the lock
+func (s *MemoryStore) liveCourse(id int) (models.Course, bool) {
+	course, ok := s.courses[id]
+	return course, ok && course.DeletedAt == nil
+}
+
+// livePerson returns a person unless there is no such person or they were
+// deleted, the caller must hold the lock
+func (s *MemoryStore) livePerson(id int) (models.Person, bool) {
+	person, ok := s.people[id]
+	return person, ok && person.DeletedAt == nil
+}
+
+// now is the timestamp the memory store writes to updated_at, in microseconds like postgres keeps it
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// checkPersonType mirrors the CHECK constraint on person.type
+func checkPersonType(personType string) error {
+	if personType != "professor" && personType != "student" {
+		return invalid(nil, detailInvalidType)
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of m in ascending order
+func sortedKeys[V any](m map[int]V) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// idempotencyKey is an idempotency key of one actor
+type idempotencyKey struct {
+	actor, key string
+}
+
+// idempotencyClaim is a claimed idempotency key, response is nil while its request runs
+type idempotencyClaim struct {
+	fingerprint string
+	response    *IdempotentResponse
+	claimed     time.Time
+	expires     time.Time
+}
+
+// ClaimIdempotencyKey claims key of the actor of ctx for the request with
+// fingerprint until ttl has passed, or returns the response stored under it.
+// A claim left without a response for longer than lease can be claimed again.
+func (s *MemoryStore) ClaimIdempotencyKey(ctx context.Context, key, fingerprint string, ttl, lease time.Duration) (*IdempotentResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	maps.DeleteFunc(s.idempotency, func(_ idempotencyKey, claim idempotencyClaim) bool {
+		return !claim.expires.After(now)
+	})
+	id := idempotencyKey{actor: idempotencyScope(ctx), key: key}
+	claim, ok := s.idempotency[id]
+	abandoned := ok && claim.response == nil && claim.fingerprint == fingerprint && !claim.claimed.After(now.Add(-lease))
+	if !ok || abandoned {
+		s.idempotency[id] = idempotencyClaim{fingerprint: fingerprint, claimed: now, expires: now.Add(ttl)}
+		return nil, nil
+	}
+	if err := checkIdempotencyKey(key, claim.fingerprint, fingerprint, claim.response != nil); err != nil {
+		return nil, err
+	}
+	return claim.response, nil
+}
+
+// SaveIdempotentResponse stores the response of the request that claimed key
+func (s *MemoryStore) SaveIdempotentResponse(ctx context.Context, key string, response IdempotentResponse) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := idempotencyKey{actor: idempotencyScope(ctx), key: key}
+	if claim, ok := s.idempotency[id]; ok {
+		claim.response = &response
+		s.idempotency[id] = claim
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey gives up a claim without a response
+func (s *MemoryStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := idempotencyKey{actor: idempotencyScope(ctx), key: key}
+	if claim, ok := s.idempotency[id]; ok && claim.response == nil {
+		delete(s.idempotency, id)
+	}
+	return nil
+}
@@ -0,0 +1,500 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jacob-tech-challenge/api/models"
+)
+
+// newSeededMemoryStore returns a store with two courses and two people
+func newSeededMemoryStore(t *testing.T) *MemoryStore {
+	t.Helper()
+
+	ctx := context.Background()
+	store := NewMemoryStore()
+	for _, name := range []string{"Math", "Science"} {
+		if _, err := store.CreateCourse(ctx, models.Course{Name: name}); err != nil {
+			t.Fatalf("failed to seed course: %v", err)
+		}
+	}
+	people := []models.Person{
+		{FirstName: "John", LastName: "Doe", Type: "student", Age: 25, Courses: []int{1, 2}},
+		{FirstName: "Jane", LastName: "Smith", Type: "professor", Age: 30, Courses: []int{2}},
+	}
+	for _, person := range people {
+		if _, err := store.CreatePerson(ctx, person); err != nil {
+			t.Fatalf("failed to seed person: %v", err)
+		}
+	}
+	return store
+}
+
+// untimedCourses zeroes UpdatedAt so courses compare without the clock
+func untimedCourses(courses ...models.Course) []models.Course {
+	for i := range courses {
+		courses[i].UpdatedAt = time.Time{}
+	}
+	return courses
+}
+
+// untimedPerson zeroes UpdatedAt so a person compares without the clock
+func untimedPerson(person models.Person) models.Person {
+	person.UpdatedAt = time.Time{}
+	return person
+}
+
+func TestMemoryStoreCourses(t *testing.T) {
+	ctx := context.Background()
+	store := newSeededMemoryStore(t)
+
+	courses, _, err := store.GetAllCourses(ctx, CourseFilter{}, Page{})
+	assert.NoError(t, err)
+	assert.Equal(t, []models.Course{{ID: 1, Name: "Math", Version: 1}, {ID: 2, Name: "Science", Version: 1}}, untimedCourses(courses...))
+
+	created, err := store.CreateCourse(ctx, models.Course{Name: "History"})
+	assert.NoError(t, err)
+	assert.False(t, created.UpdatedAt.IsZero())
+	assert.Equal(t, []models.Course{{ID: 3, Name: "History", Version: 1}}, untimedCourses(created))
+
+	_, err = store.UpdateCourse(ctx, 3, models.Course{Name: "Art"})
+	assert.NoError(t, err)
+	course, err := store.GetCourseByID(ctx, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.Course{{ID: 3, Name: "Art", Version: 2}}, untimedCourses(course))
+
+	_, err = store.GetCourseByID(ctx, 42)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// course 2 still has people enrolled
+	assert.ErrorIs(t, restrictDeleteCourse(ctx, store, 2, 0), ErrConflict)
+
+	assert.NoError(t, restrictDeleteCourse(ctx, store, 3, 0))
+	_, err = store.GetCourseByID(ctx, 3)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, restrictDeleteCourse(ctx, store, 3, 0), ErrNotFound)
+	_, err = store.UpdateCourse(ctx, 3, models.Course{Name: "Art"})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	missing, err := store.MissingCourseIDs(ctx, []int{9, 1, 3, 9})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{3, 9}, missing)
+}
+
+func TestMemoryStoreGetAllPeople(t *testing.T) {
+	ctx := context.Background()
+	tests := map[string]struct {
+		filter   PersonFilter
+		expected []int
+	}{
+		"no filters":               {expected: []int{1, 2}},
+		"filter by name":           {filter: PersonFilter{FirstName: "Jane"}, expected: []int{2}},
+		"filter by age":            {filter: PersonFilter{Age: intPtr(25)}, expected: []int{1}},
+		"filter by type":           {filter: PersonFilter{Type: "professor"}, expected: []int{2}},
+		"filter by last name":      {filter: PersonFilter{LastName: "Doe"}, expected: []int{1}},
+		"age range":                {filter: PersonFilter{AgeGTE: intPtr(26), AgeLTE: intPtr(30)}, expected: []int{2}},
+		"enrolled in course":       {filter: PersonFilter{CourseID: 1}, expected: []int{1}},
+		"name prefix":              {filter: PersonFilter{FirstNamePrefix: "Ja"}, expected: []int{2}},
+		"case sensitive prefix":    {filter: PersonFilter{FirstNamePrefix: "ja"}},
+		"case insensitive prefix":  {filter: PersonFilter{FirstNamePrefix: "ja", IgnoreCase: true}, expected: []int{2}},
+		"case insensitive name":    {filter: PersonFilter{LastName: "SMITH", IgnoreCase: true}, expected: []int{2}},
+		"sorted by age descending": {filter: PersonFilter{Sort: []SortKey{{Field: "age", Desc: true}}}, expected: []int{2, 1}},
+		"no results":               {filter: PersonFilter{FirstName: "Nobody"}},
+	}
+
+	store := newSeededMemoryStore(t)
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			people, _, err := store.GetAllPeople(ctx, tc.filter, Page{})
+			assert.NoError(t, err)
+
+			var ids []int
+			for _, person := range people {
+				ids = append(ids, person.ID)
+			}
+			assert.Equal(t, tc.expected, ids)
+		})
+	}
+}
+
+func TestMemoryStorePeople(t *testing.T) {
+	ctx := context.Background()
+	store := newSeededMemoryStore(t)
+
+	person, err := store.GetPersonByID(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, models.Person{ID: 1, FirstName: "John", LastName: "Doe", Type: "student", Age: 25, Courses: []int{1, 2}, Version: 1}, untimedPerson(person))
+
+	_, err = store.GetPersonByID(ctx, 99)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	updated, err := store.UpdatePerson(ctx, 1, models.Person{FirstName: "Johnny", LastName: "Doe", Type: "student", Age: 26})
+	assert.NoError(t, err)
+	assert.Equal(t, models.Person{ID: 1, FirstName: "Johnny", LastName: "Doe", Type: "student", Age: 26, Courses: []int{1, 2}, Version: 2}, untimedPerson(updated))
+
+	_, err = store.UpdatePerson(ctx, 1, models.Person{FirstName: "Johnny", Type: "teacher"})
+	assert.Error(t, err)
+	_, err = store.UpdatePerson(ctx, 99, models.Person{FirstName: "Nobody", Type: "student"})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// only the addressed person changes, not everyone sharing the name
+	_, err = store.CreatePerson(ctx, models.Person{FirstName: "Johnny", LastName: "Appleseed", Type: "student", Age: 40})
+	assert.NoError(t, err)
+	assert.NoError(t, store.DeletePerson(ctx, 1, 0))
+	assert.ErrorIs(t, store.DeletePerson(ctx, 1, 0), ErrNotFound)
+	people, _, err := store.GetAllPeople(ctx, PersonFilter{FirstName: "Johnny"}, Page{})
+	assert.NoError(t, err)
+	assert.Len(t, people, 1)
+
+	// a deleted person no longer counts as enrolled, so the course can now be deleted
+	assert.NoError(t, restrictDeleteCourse(ctx, store, 1, 0))
+}
+
+func TestMemoryStoreSoftDelete(t *testing.T) {
+	ctx := context.Background()
+	store := newSeededMemoryStore(t)
+
+	// deleted people are only listed on request
+	assert.NoError(t, store.DeletePerson(ctx, 2, 0))
+	people, _, err := store.GetAllPeople(ctx, PersonFilter{}, Page{})
+	assert.NoError(t, err)
+	assert.Len(t, people, 1)
+	people, _, err = store.GetAllPeople(ctx, PersonFilter{IncludeDeleted: true}, Page{})
+	assert.NoError(t, err)
+	if assert.Len(t, people, 2) {
+		assert.NotNil(t, people[1].DeletedAt)
+	}
+
+	// once John is gone too nobody live is enrolled in Science
+	assert.NoError(t, store.DeletePerson(ctx, 1, 0))
+	assert.NoError(t, restrictDeleteCourse(ctx, store, 2, 0))
+	courses, _, err := store.GetAllCourses(ctx, CourseFilter{}, Page{})
+	assert.NoError(t, err)
+	assert.Len(t, courses, 1)
+	courses, _, err = store.GetAllCourses(ctx, CourseFilter{IncludeDeleted: true}, Page{})
+	assert.NoError(t, err)
+	assert.Len(t, courses, 2)
+
+	// a restored person gets back their enrollments in live courses only
+	person, err := store.RestorePerson(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1}, person.Courses)
+	assert.Nil(t, person.DeletedAt)
+	_, err = store.AddPersonToCourse(ctx, 1, 0, []int{2})
+	assert.NoError(t, err)
+	missing, err := store.MissingCourseIDs(ctx, []int{1, 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2}, missing)
+
+	// and the restored course brings back the rest
+	course, err := store.RestoreCourse(ctx, 2)
+	assert.NoError(t, err)
+	assert.Nil(t, course.DeletedAt)
+	restored, err := store.GetPersonByID(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, restored.Courses)
+	assert.Greater(t, restored.Version, person.Version)
+
+	_, err = store.RestoreCourse(ctx, 2)
+	assert.ErrorIs(t, err, ErrConflict)
+	_, err = store.RestorePerson(ctx, 99)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// only what was deleted before the cutoff is purged
+	purged, err := store.PurgeDeleted(ctx, time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, Purged{}, purged)
+	purged, err = store.PurgeDeleted(ctx, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, Purged{People: 1}, purged)
+	_, err = store.RestorePerson(ctx, 2)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestMemoryStoreDeleteCourseModes(t *testing.T) {
+	ctx := context.Background()
+	store := newSeededMemoryStore(t)
+
+	// restrict lists who is still enrolled
+	_, err := store.DeleteCourse(ctx, 2, 0, CourseDeletion{Mode: DeleteRestrict})
+	var enrolledErr *EnrolledError
+	if assert.ErrorAs(t, err, &enrolledErr) {
+		assert.Equal(t, []int{1, 2}, enrolledErr.PersonIDs)
+	}
+	_, err = store.DeleteCourse(ctx, 2, 0, CourseDeletion{Mode: DeleteReassign, To: 9})
+	assert.ErrorIs(t, err, ErrValidation)
+
+	// John is in Math already, so only Jane moves there
+	deleted, err := store.DeleteCourse(ctx, 2, 0, CourseDeletion{Mode: DeleteReassign, To: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, CourseDeleted{Affected: 2, Moved: 1}, deleted)
+	for _, id := range []int{1, 2} {
+		person, err := store.GetPersonByID(ctx, id)
+		assert.NoError(t, err)
+		assert.Equal(t, []int{1}, person.Courses)
+		assert.Equal(t, 2, person.Version)
+		revisions, _, err := store.GetPersonHistory(ctx, id, Page{})
+		assert.NoError(t, err)
+		assert.Len(t, revisions, 2)
+	}
+
+	deleted, err = store.DeleteCourse(ctx, 1, 0, CourseDeletion{Mode: DeleteCascade})
+	assert.NoError(t, err)
+	assert.Equal(t, CourseDeleted{Affected: 2}, deleted)
+	person, err := store.GetPersonByID(ctx, 2)
+	assert.NoError(t, err)
+	assert.Empty(t, person.Courses)
+
+	entries, _, err := store.GetAuditLog(ctx, AuditFilter{Entity: "person", EntityID: 2}, Page{})
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 3) {
+		assert.Equal(t, AuditEnrollment, entries[1].Action)
+		assert.Equal(t, AuditEnrollment, entries[2].Action)
+	}
+}
+
+func TestMemoryStoreVersions(t *testing.T) {
+	ctx := context.Background()
+	store := newSeededMemoryStore(t)
+
+	// writes expecting an older version fail and leave the row alone
+	_, err := store.UpdateCourse(ctx, 1, models.Course{Name: "Algebra", Version: 2})
+	assert.ErrorIs(t, err, ErrPreconditionFailed)
+	course, err := store.UpdateCourse(ctx, 1, models.Course{Name: "Algebra", Version: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, course.Version)
+	assert.ErrorIs(t, restrictDeleteCourse(ctx, store, 1, 1), ErrPreconditionFailed)
+
+	_, err = store.UpdatePerson(ctx, 2, models.Person{FirstName: "Jane", LastName: "Smith", Type: "professor", Age: 31, Version: 7})
+	assert.ErrorIs(t, err, ErrPreconditionFailed)
+	assert.ErrorIs(t, store.DeletePerson(ctx, 2, 7), ErrPreconditionFailed)
+
+	// enrollment changes are part of the person, so they change its version
+	_, err = store.AddPersonToCourse(ctx, 2, 0, []int{1})
+	assert.NoError(t, err)
+	person, err := store.GetPersonByID(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, person.Version)
+
+	// a change that changes nothing keeps the version
+	_, err = store.AddPersonToCourse(ctx, 2, 0, []int{1})
+	assert.NoError(t, err)
+	person, err = store.GetPersonByID(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, person.Version)
+
+	_, err = store.RemovePersonFromCourses(ctx, 2, 0, []int{1})
+	assert.NoError(t, err)
+	assert.NoError(t, store.DeletePerson(ctx, 2, 3))
+}
+
+func TestMemoryStoreCreatePerson(t *testing.T) {
+	ctx := context.Background()
+	tests := map[string]struct {
+		person      models.Person
+		expectedErr string
+	}{
+		"success": {
+			person: models.Person{FirstName: "Bill", LastName: "Gates", Type: "student", Age: 67, Courses: []int{1}},
+		},
+		"invalid type": {
+			person:      models.Person{FirstName: "Bill", LastName: "Gates", Type: "teacher", Age: 67},
+			expectedErr: "type must be professor or student",
+		},
+		"unknown course": {
+			person:      models.Person{FirstName: "Bill", LastName: "Gates", Type: "student", Age: 67, Courses: []int{1, 9}},
+			expectedErr: "courses must all exist",
+		},
+		"duplicate course": {
+			person:      models.Person{FirstName: "Bill", LastName: "Gates", Type: "student", Age: 67, Courses: []int{1, 1}},
+			expectedErr: "courses must not repeat",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			store := newSeededMemoryStore(t)
+
+			created, err := store.CreatePerson(ctx, tc.person)
+			if tc.expectedErr != "" {
+				assert.EqualError(t, err, tc.expectedErr)
+
+				// nothing should have been written
+				people, _, err := store.GetAllPeople(ctx, PersonFilter{FirstName: "Bill"}, Page{})
+				assert.NoError(t, err)
+				assert.Empty(t, people)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, 3, created.ID)
+
+			stored, err := store.GetPersonByID(ctx, created.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, created, stored)
+		})
+	}
+}
+
+func TestMemoryStoreEnrollments(t *testing.T) {
+	ctx := context.Background()
+	store := newSeededMemoryStore(t)
+
+	courses, err := store.GetCoursesByPersonID(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2}, courses)
+
+	results, err := store.AddPersonToCourse(ctx, 2, 0, []int{1, 2, 9})
+	assert.NoError(t, err)
+	assert.Equal(t, []EnrollmentResult{
+		{CourseID: 1, Status: EnrollmentAdded},
+		{CourseID: 2, Status: EnrollmentAlreadyEnrolled},
+		{CourseID: 9, Status: EnrollmentCourseNotFound},
+	}, results)
+
+	_, err = store.AddPersonToCourse(ctx, 42, 0, []int{1})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	courses, err = store.GetCoursesByPersonID(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, courses)
+
+	courses, err = store.GetCoursesByPersonID(ctx, 42)
+	assert.NoError(t, err)
+	assert.Nil(t, courses)
+}
+
+func TestMemoryStoreChangeEnrollments(t *testing.T) {
+	ctx := context.Background()
+	store := newSeededMemoryStore(t)
+
+	results, err := store.RemovePersonFromCourses(ctx, 1, 0, []int{2, 2, 9})
+	assert.NoError(t, err)
+	assert.Equal(t, []EnrollmentResult{
+		{CourseID: 2, Status: EnrollmentRemoved},
+		{CourseID: 2, Status: EnrollmentNotEnrolled},
+		{CourseID: 9, Status: EnrollmentCourseNotFound},
+	}, results)
+
+	results, err = store.SetPersonCourses(ctx, 2, 0, []int{1, 9})
+	assert.NoError(t, err)
+	assert.Equal(t, []EnrollmentResult{
+		{CourseID: 2, Status: EnrollmentRemoved},
+		{CourseID: 1, Status: EnrollmentAdded},
+		{CourseID: 9, Status: EnrollmentCourseNotFound},
+	}, results)
+
+	courses, err := store.GetPersonCourses(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.Course{{ID: 1, Name: "Math"}}, courses)
+
+	// replacing with nothing drops every enrollment
+	_, err = store.SetPersonCourses(ctx, 2, 0, nil)
+	assert.NoError(t, err)
+	courses, err = store.GetPersonCourses(ctx, 2)
+	assert.NoError(t, err)
+	assert.Empty(t, courses)
+
+	_, err = store.GetPersonCourses(ctx, 42)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = store.RemovePersonFromCourses(ctx, 42, 0, []int{1})
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = store.SetPersonCourses(ctx, 42, 0, []int{1})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// a stale version changes nothing
+	person, err := store.GetPersonByID(ctx, 2)
+	assert.NoError(t, err)
+	_, err = store.AddPersonToCourse(ctx, 2, person.Version-1, []int{1})
+	assert.ErrorIs(t, err, ErrPreconditionFailed)
+	_, err = store.AddPersonToCourse(ctx, 2, person.Version, []int{1})
+	assert.NoError(t, err)
+}
+
+func TestMemoryStoreGetCourseRoster(t *testing.T) {
+	ctx := context.Background()
+	store := newSeededMemoryStore(t)
+
+	roster, next, err := store.GetCourseRoster(ctx, 2, "", Page{Limit: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, roster.Professors)
+	assert.Equal(t, 1, roster.Students)
+	// professors are listed first
+	assert.Len(t, roster.People, 1)
+	assert.Equal(t, "Jane", roster.People[0].FirstName)
+
+	roster, next, err = store.GetCourseRoster(ctx, 2, "", Page{Limit: 1, After: next})
+	assert.NoError(t, err)
+	assert.Len(t, roster.People, 1)
+	assert.Equal(t, "John", roster.People[0].FirstName)
+	assert.Nil(t, next)
+
+	roster, _, err = store.GetCourseRoster(ctx, 1, "professor", Page{})
+	assert.NoError(t, err)
+	assert.Empty(t, roster.People)
+	assert.Equal(t, 1, roster.Students)
+
+	_, _, err = store.GetCourseRoster(ctx, 9, "", Page{})
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestMemoryStoreCancelledContext(t *testing.T) {
+	store := newSeededMemoryStore(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, _, err := store.GetAllCourses(ctx, CourseFilter{}, Page{})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, err = store.CreatePerson(ctx, models.Person{FirstName: "Bill", LastName: "Gates", Type: "student", Age: 67})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// nothing was written
+	people, _, err := store.GetAllPeople(context.Background(), PersonFilter{FirstName: "Bill"}, Page{})
+	assert.NoError(t, err)
+	assert.Empty(t, people)
+}
+
+func TestMemoryStorePagination(t *testing.T) {
+	ctx := context.Background()
+	store := newSeededMemoryStore(t)
+	_, err := store.CreatePerson(ctx, models.Person{FirstName: "Bill", LastName: "Gates", Type: "student", Age: 67})
+	assert.NoError(t, err)
+
+	// walk the list one page at a time, ordered by type then oldest first
+	filter := PersonFilter{Sort: []SortKey{{Field: "type"}, {Field: "age", Desc: true}}}
+	var ids []int
+	page := Page{Limit: 1}
+	for {
+		people, next, err := store.GetAllPeople(ctx, filter, page)
+		assert.NoError(t, err)
+		for _, person := range people {
+			ids = append(ids, person.ID)
+		}
+		if next == nil {
+			break
+		}
+		page.After = next
+	}
+	assert.Equal(t, []int{2, 3, 1}, ids)
+
+	// a cursor from another ordering is rejected
+	_, _, err = store.GetAllPeople(ctx, PersonFilter{}, Page{After: &Cursor{Keys: []string{"student"}, ID: 1}})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+
+	courses, next, err := store.GetAllCourses(ctx, CourseFilter{}, Page{Limit: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []models.Course{{ID: 1, Name: "Math", Version: 1}}, untimedCourses(courses...))
+	assert.Equal(t, &Cursor{ID: 1}, next)
+}
+
+// restrictDeleteCourse deletes a course in the default restrict mode, which
+// refuses a course people are enrolled in with ErrConflict. It is for tests
+// that only care about the error.
+func restrictDeleteCourse(ctx context.Context, store CourseStore, id, version int) error {
+	_, err := store.DeleteCourse(ctx, id, version, CourseDeletion{})
+	return err
+}
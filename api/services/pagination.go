@@ -0,0 +1,72 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+)
+
+// ErrInvalidCursor is returned when a cursor cannot be decoded
+var ErrInvalidCursor error = &Error{Kind: ErrValidation, Detail: "invalid cursor"}
+
+// Page asks for one page of a list. Lists are ordered by their sort columns
+// with id as the final tie breaker, and After is the position of the last row
+// of the previous page, nil for the first page.
+type Page struct {
+	Limit int
+	After *Cursor
+}
+
+// Cursor is the position of a row in an ordered list: the values of its sort
+// columns, in order, followed by its id
+type Cursor struct {
+	Keys []string `json:"k,omitempty"`
+	ID   int      `json:"id"`
+}
+
+// Encode returns the opaque form of the cursor handed to clients
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor parses a cursor produced by Cursor.Encode
+func DecodeCursor(s string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID <= 0 {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// afterID returns the id a keyset query should start after
+func (p Page) afterID() int {
+	if p.After == nil {
+		return 0
+	}
+	return p.After.ID
+}
+
+// limitArg is the LIMIT to query with: one row more than the page size, or
+// NULL (no limit) when the page size is not set
+func (p Page) limitArg() any {
+	if p.Limit <= 0 {
+		return nil
+	}
+	return p.Limit + 1
+}
+
+// nextCursor trims a result fetched with one extra row down to the page size.
+// The extra row only tells whether there is a next page, in which case the
+// cursor of the last kept row is returned.
+func nextCursor[T any](items []T, limit int, cursor func(T) Cursor) ([]T, *Cursor) {
+	if limit <= 0 || len(items) <= limit {
+		return items, nil
+	}
+	items = items[:limit]
+	next := cursor(items[limit-1])
+	return items, &next
+}
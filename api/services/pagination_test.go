@@ -0,0 +1,28 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	cursor := Cursor{Keys: []string{"Jobs", "56"}, ID: 7}
+
+	decoded, err := DecodeCursor(cursor.Encode())
+	assert.NoError(t, err)
+	assert.Equal(t, &cursor, decoded)
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	for name, input := range map[string]string{
+		"not base64": "%%%",
+		"not json":   "bm90IGpzb24",
+		"no id":      Cursor{}.Encode(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := DecodeCursor(input)
+			assert.ErrorIs(t, err, ErrInvalidCursor)
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+
+	"github.com/jacob-tech-challenge/api/models"
+)
+
+// PersonPatch computes the new state of a person from the current one. It runs
+// while the person is locked, an error aborts the patch and is returned as is.
+type PersonPatch func(current models.Person) (models.Person, error)
+
+// CoursePatch is the course counterpart of PersonPatch
+type CoursePatch func(current models.Course) (models.Course, error)
+
+// PatchPerson locks a person, hands them to patch and stores the result in the
+// same transaction. A changed course list replaces the person's enrollments,
+// and fails the patch with ErrValidation unless every course exists.
+// ErrNotFound is returned when there is no such person, ErrPreconditionFailed
+// when version is not zero and not the person's current version.
+func PatchPerson(ctx context.Context, db *sql.DB, id, version int, patch PersonPatch) (models.Person, error) {
+	return inTx(ctx, db, func(tx *sql.Tx) (models.Person, error) {
+		return patchPerson(ctx, tx, id, version, patch)
+	})
+}
+
+// patchPerson patches a person in tx
+func patchPerson(ctx context.Context, tx *sql.Tx, id, version int, patch PersonPatch) (models.Person, error) {
+	current, err := lockPerson(ctx, tx, id)
+	if err != nil {
+		return models.Person{}, err
+	}
+	if err := checkVersion("person", id, current.Version, version); err != nil {
+		return models.Person{}, err
+	}
+	patched, err := patch(current)
+	if err != nil {
+		return models.Person{}, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE person SET first_name = $1, last_name = $2, type = $3, age = $4, version = version + 1, updated_at = now() WHERE id = $5`,
+		patched.FirstName, patched.LastName, patched.Type, patched.Age, id)
+	if err != nil {
+		return models.Person{}, constraintError(err)
+	}
+	if !sameCourses(current.Courses, patched.Courses) {
+		missing, err := missingCourseIDs(ctx, tx, patched.Courses)
+		if err != nil {
+			return models.Person{}, err
+		}
+		if len(missing) > 0 {
+			return models.Person{}, invalid(nil, detailUnknownCourse)
+		}
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM person_course
+			WHERE person_id = $1 AND NOT (course_id = ANY($2))
+				AND course_id IN (SELECT id FROM course WHERE deleted_at IS NULL)`, id, int64s(patched.Courses)); err != nil {
+			return models.Person{}, err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO person_course (person_id, course_id)
+			SELECT $1, unnest($2::int[])
+			ON CONFLICT (person_id, course_id) DO NOTHING`, id, int64s(patched.Courses)); err != nil {
+			return models.Person{}, constraintError(err)
+		}
+	}
+
+	if err := recordHistory(ctx, tx, id); err != nil {
+		return models.Person{}, err
+	}
+	updated, err := personByID(ctx, tx, id)
+	if err != nil {
+		return models.Person{}, err
+	}
+	if err := writeAudit(ctx, tx, personChange(ctx, AuditUpdate, &current, &updated)); err != nil {
+		return models.Person{}, err
+	}
+	if err := publishEviction(ctx, tx, personEviction(id)); err != nil {
+		return models.Person{}, err
+	}
+	return updated, nil
+}
+
+// PatchCourse locks a course, hands it to patch and stores the result in the
+// same transaction. ErrNotFound is returned when there is no such course,
+// ErrPreconditionFailed when version is not zero and not its current version.
+func PatchCourse(ctx context.Context, db *sql.DB, id, version int, patch CoursePatch) (models.Course, error) {
+	return inTx(ctx, db, func(tx *sql.Tx) (models.Course, error) {
+		return patchCourse(ctx, tx, id, version, patch)
+	})
+}
+
+// patchCourse patches a course in tx
+func patchCourse(ctx context.Context, tx *sql.Tx, id, version int, patch CoursePatch) (models.Course, error) {
+	current, err := lockCourse(ctx, tx, id)
+	if err != nil {
+		return models.Course{}, err
+	}
+	if err := checkVersion("course", id, current.Version, version); err != nil {
+		return models.Course{}, err
+	}
+	patched, err := patch(current)
+	if err != nil {
+		return models.Course{}, err
+	}
+	updated := models.Course{ID: id, Name: patched.Name}
+	err = tx.QueryRowContext(ctx, `UPDATE course SET name = $1, version = version + 1, updated_at = now() WHERE id = $2 RETURNING version, updated_at`,
+		patched.Name, id).Scan(&updated.Version, &updated.UpdatedAt)
+	if err != nil {
+		return models.Course{}, err
+	}
+	if err := writeAudit(ctx, tx, courseChange(ctx, AuditUpdate, &current, &updated)); err != nil {
+		return models.Course{}, err
+	}
+	if err := publishEviction(ctx, tx, courseEviction(id)); err != nil {
+		return models.Course{}, err
+	}
+	return updated, nil
+}
+
+// sameCourses reports whether two course id lists hold the same courses, in any order
+func sameCourses(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]int(nil), a...)
+	b = append([]int(nil), b...)
+	sort.Ints(a)
+	sort.Ints(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jacob-tech-challenge/api/models"
+)
+
+var personColumns = []string{"id", "first_name", "last_name", "type", "age", "courses", "version", "updated_at", "deleted_at"}
+
+func TestPatchPerson(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	selectPerson := regexp.QuoteMeta(selectPeople)
+	missingCourses := `SELECT DISTINCT ids.id\s+FROM unnest`
+
+	t.Run("replaces changed courses", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockPersonQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(selectPerson).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(personColumns).AddRow(1, "John", "Doe", "student", 20, "{1,2}", 1, testUpdatedAt, nil))
+		mock.ExpectExec(`UPDATE person SET`).WithArgs("John", "Doe", "student", 30, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(missingCourses).WithArgs(pq.Int64Array{2, 3}).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectExec(`DELETE FROM person_course\s+WHERE person_id = \$1 AND NOT`).
+			WithArgs(1, pq.Int64Array{2, 3}).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO person_course \(person_id, course_id\)\s+SELECT \$1, unnest`).
+			WithArgs(1, pq.Int64Array{2, 3}).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectHistory(mock, 1)
+		mock.ExpectQuery(selectPerson).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(personColumns).AddRow(1, "John", "Doe", "student", 30, "{2,3}", 2, testUpdatedAt, nil))
+		expectAudit(mock, "person", 1, AuditUpdate)
+		expectEviction(mock, personEviction(1))
+		mock.ExpectCommit()
+
+		updated, err := PatchPerson(context.Background(), db, 1, 1, func(current models.Person) (models.Person, error) {
+			assert.Equal(t, []int{1, 2}, current.Courses)
+			current.Age = 30
+			current.Courses = []int{2, 3}
+			return current, nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, models.Person{ID: 1, FirstName: "John", LastName: "Doe", Type: "student", Age: 30, Courses: []int{2, 3}, Version: 2, UpdatedAt: testUpdatedAt}, updated)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("leaves unchanged courses alone", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockPersonQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(selectPerson).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(personColumns).AddRow(1, "John", "Doe", "student", 20, "{1,2}", 1, testUpdatedAt, nil))
+		mock.ExpectExec(`UPDATE person SET`).WithArgs("Johnny", "Doe", "student", 20, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectHistory(mock, 1)
+		mock.ExpectQuery(selectPerson).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(personColumns).AddRow(1, "Johnny", "Doe", "student", 20, "{1,2}", 2, testUpdatedAt, nil))
+		expectAudit(mock, "person", 1, AuditUpdate)
+		expectEviction(mock, personEviction(1))
+		mock.ExpectCommit()
+
+		_, err := PatchPerson(context.Background(), db, 1, 0, func(current models.Person) (models.Person, error) {
+			current.FirstName = "Johnny"
+			current.Courses = []int{2, 1}
+			return current, nil
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("unknown course", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockPersonQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(selectPerson).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(personColumns).AddRow(1, "John", "Doe", "student", 20, "{1}", 1, testUpdatedAt, nil))
+		mock.ExpectExec(`UPDATE person SET`).WithArgs("John", "Doe", "student", 20, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(missingCourses).WithArgs(pq.Int64Array{1, 9}).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+		mock.ExpectRollback()
+
+		_, err := PatchPerson(context.Background(), db, 1, 0, func(current models.Person) (models.Person, error) {
+			current.Courses = []int{1, 9}
+			return current, nil
+		})
+		assert.ErrorIs(t, err, ErrValidation)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("patch error rolls back", func(t *testing.T) {
+		patchErr := errors.New("invalid")
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockPersonQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(selectPerson).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(personColumns).AddRow(1, "John", "Doe", "student", 20, "{}", 1, testUpdatedAt, nil))
+		mock.ExpectRollback()
+
+		_, err := PatchPerson(context.Background(), db, 1, 0, func(current models.Person) (models.Person, error) {
+			return models.Person{}, patchErr
+		})
+		assert.Equal(t, patchErr, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("stale version", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockPersonQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(selectPerson).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(personColumns).AddRow(1, "John", "Doe", "student", 20, "{}", 4, testUpdatedAt, nil))
+		mock.ExpectRollback()
+
+		_, err := PatchPerson(context.Background(), db, 1, 3, func(current models.Person) (models.Person, error) {
+			t.Fatal("patch must not run for a stale version")
+			return current, nil
+		})
+		assert.ErrorIs(t, err, ErrPreconditionFailed)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("person not found", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockPersonQuery).WithArgs(9).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectRollback()
+
+		_, err := PatchPerson(context.Background(), db, 9, 0, func(current models.Person) (models.Person, error) {
+			t.Fatal("patch must not run for a missing person")
+			return current, nil
+		})
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPatchCourse(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	lockCourse := regexp.QuoteMeta(`SELECT id, name, version, updated_at FROM course WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(lockCourse).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(courseColumns).AddRow(1, "Math", 1, testUpdatedAt))
+	mock.ExpectQuery(regexp.QuoteMeta(`UPDATE course SET name = $1, version = version + 1, updated_at = now() WHERE id = $2 RETURNING version, updated_at`)).
+		WithArgs("Algebra", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "updated_at"}).AddRow(2, testUpdatedAt))
+	expectAudit(mock, "course", 1, AuditUpdate)
+	expectEviction(mock, courseEviction(1))
+	mock.ExpectCommit()
+
+	course, err := PatchCourse(context.Background(), db, 1, 1, func(current models.Course) (models.Course, error) {
+		current.Name = "Algebra"
+		return current, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, models.Course{ID: 1, Name: "Algebra", Version: 2, UpdatedAt: testUpdatedAt}, course)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(lockCourse).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(courseColumns).AddRow(1, "Algebra", 2, testUpdatedAt))
+	mock.ExpectRollback()
+
+	_, err = PatchCourse(context.Background(), db, 1, 1, func(current models.Course) (models.Course, error) {
+		return current, nil
+	})
+	assert.ErrorIs(t, err, ErrPreconditionFailed)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(lockCourse).WithArgs(9).
+		WillReturnRows(sqlmock.NewRows(courseColumns))
+	mock.ExpectRollback()
+
+	_, err = PatchCourse(context.Background(), db, 9, 0, func(current models.Course) (models.Course, error) {
+		return current, nil
+	})
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMemoryStorePatchPerson(t *testing.T) {
+	ctx := context.Background()
+	store := newSeededMemoryStore(t)
+
+	before, err := store.GetPersonByID(ctx, 1)
+	assert.NoError(t, err)
+
+	updated, err := store.PatchPerson(ctx, 1, 0, func(current models.Person) (models.Person, error) {
+		current.Age++
+		current.Courses = []int{2}
+		return current, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, before.Age+1, updated.Age)
+	assert.Equal(t, []int{2}, updated.Courses)
+
+	_, err = store.PatchPerson(ctx, 1, 0, func(current models.Person) (models.Person, error) {
+		current.Courses = []int{42}
+		return current, nil
+	})
+	assert.ErrorIs(t, err, ErrValidation)
+
+	_, err = store.PatchPerson(ctx, 99, 0, func(current models.Person) (models.Person, error) { return current, nil })
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// a concurrent change makes the patch run again on the new state
+	runs := 0
+	_, err = store.PatchPerson(ctx, 1, 0, func(current models.Person) (models.Person, error) {
+		runs++
+		if runs == 1 {
+			_, err := store.UpdatePerson(ctx, 1, models.Person{FirstName: "Changed", LastName: current.LastName, Type: current.Type, Age: current.Age})
+			assert.NoError(t, err)
+		}
+		return current, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, runs)
+	stored, err := store.GetPersonByID(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "Changed", stored.FirstName)
+
+	// a patch of an older version fails instead
+	_, err = store.PatchPerson(ctx, 1, stored.Version-1, func(current models.Person) (models.Person, error) {
+		return current, nil
+	})
+	assert.ErrorIs(t, err, ErrPreconditionFailed)
+}
@@ -3,141 +3,268 @@ package services
 import (
 	"context"
 	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
 
 	"github.com/jacob-tech-challenge/api/models"
 )
 
-// GetAllPeople returns all people, if query parameters are provided, it filters the results
-func GetAllPeople(db *sql.DB, name string, age int) ([]models.Person, error) {
-	ctx := context.Background()
-
-	var rows *sql.Rows
-	var err error
-
-	rows, err = db.QueryContext(ctx, `SELECT * FROM person WHERE ($1 = '' OR first_name = $1) AND ($2 = 0 OR age = $2)`, name, age)
+// selectPeople selects people with their course ids aggregated into an array,
+// callers add the WHERE clause and GROUP BY p.id. Enrollments in soft deleted
+// courses are kept for a restore but left out.
+const selectPeople = `SELECT p.id, p.first_name, p.last_name, p.type, p.age,
+			COALESCE(array_agg(c.id ORDER BY c.id) FILTER (WHERE c.id IS NOT NULL), '{}'),
+			p.version, p.updated_at, p.deleted_at
+		FROM person p
+		LEFT JOIN person_course pc ON pc.person_id = p.id
+		LEFT JOIN course c ON c.id = pc.course_id AND c.deleted_at IS NULL`
 
+// GetAllPeople returns a page of people matching the filter, in the filter's order.
+// Each person's course ids are aggregated in the same query, so listing people
+// costs one round trip no matter how many there are.
+func GetAllPeople(ctx context.Context, db *sql.DB, filter PersonFilter, page Page) ([]models.Person, *Cursor, error) {
+	if err := filter.Validate(); err != nil {
+		return []models.Person{}, nil, err
+	}
+	if err := filter.checkCursor(page.After); err != nil {
+		return []models.Person{}, nil, err
+	}
 
+	query, args := buildPersonQuery(filter, page)
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return []models.Person{}, err
+		return []models.Person{}, nil, err
 	}
 	defer rows.Close()
 
-
 	var people []models.Person
 	for rows.Next() {
 		var person models.Person
-		if err := rows.Scan(&person.ID, &person.FirstName, &person.LastName, &person.Type, &person.Age); err != nil {
-			return nil, err
-		}
-		person.Courses, err = GetCoursesByPersonID(db, person.ID)
-		if err != nil {
-			return nil, err
+		var courses pq.Int64Array
+		if err := rows.Scan(&person.ID, &person.FirstName, &person.LastName, &person.Type, &person.Age, &courses, &person.Version, &person.UpdatedAt, &person.DeletedAt); err != nil {
+			return nil, nil, err
 		}
+		person.Courses = courseIDs(courses)
 		people = append(people, person)
 	}
-	return people, nil
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	people, next := nextCursor(people, page.Limit, filter.cursor)
+	return people, next, nil
 }
 
-// GetPersonByName returns a person by name
-func GetPersonByName(db *sql.DB, name string) (models.Person, error) {
-	ctx := context.Background()
+// int64s converts course ids to a postgres array argument
+func int64s(ids []int) pq.Int64Array {
+	array := make(pq.Int64Array, len(ids))
+	for i, id := range ids {
+		array[i] = int64(id)
+	}
+	return array
+}
 
-	var person models.Person
-	err := db.QueryRowContext(ctx, `SELECT * FROM person WHERE first_name = $1`, name).Scan(&person.ID, &person.FirstName, &person.LastName, &person.Type, &person.Age)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return models.Person{}, nil
-		}
-		return models.Person{}, err
+// courseIDs converts an aggregated course id array, an empty array becomes nil like an empty course lookup
+func courseIDs(ids pq.Int64Array) []int {
+	var courses []int
+	for _, id := range ids {
+		courses = append(courses, int(id))
 	}
-	person.Courses, err = GetCoursesByPersonID(db, person.ID)
+	return courses
+}
+
+// rowQuerier is what *sql.DB and *sql.Tx have in common for single row reads
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// GetPersonByID returns a person and their course ids, or ErrNotFound when there is no such person or they were deleted
+func GetPersonByID(ctx context.Context, db *sql.DB, id int) (models.Person, error) {
+	return personByID(ctx, db, id)
+}
+
+// personByID reads a person through db or a transaction
+func personByID(ctx context.Context, q rowQuerier, id int) (models.Person, error) {
+	var person models.Person
+	var courses pq.Int64Array
+	err := q.QueryRowContext(ctx, selectPeople+`
+		WHERE p.id = $1 AND p.deleted_at IS NULL
+		GROUP BY p.id`, id).Scan(&person.ID, &person.FirstName, &person.LastName, &person.Type, &person.Age, &courses, &person.Version, &person.UpdatedAt, &person.DeletedAt)
 	if err != nil {
-		return models.Person{}, err
+		return models.Person{}, noRows(err, "person %d", id)
 	}
+	person.Courses = courseIDs(courses)
 	return person, nil
 }
 
-// UpdatePersonByName updates a person by name
-func UpdatePersonByName(db *sql.DB, name string, person models.Person) (models.Person, error) {
-	ctx := context.Background()
-
-	_, err := db.ExecContext(ctx, `UPDATE person SET first_name = $1, last_name = $2, type = $3, age = $4 WHERE first_name = $5`, person.FirstName, person.LastName, person.Type, person.Age, name)
+// queryPeople reads the people selectPeople finds with where, in id order
+func queryPeople(ctx context.Context, tx *sql.Tx, where string, args ...any) ([]models.Person, error) {
+	rows, err := tx.QueryContext(ctx, selectPeople+`
+		`+where+`
+		GROUP BY p.id
+		ORDER BY p.id`, args...)
 	if err != nil {
-		return models.Person{}, err
+		return nil, err
 	}
-	return GetPersonByName(db, person.FirstName)
+	defer rows.Close()
+
+	var people []models.Person
+	for rows.Next() {
+		var person models.Person
+		var courses pq.Int64Array
+		if err := rows.Scan(&person.ID, &person.FirstName, &person.LastName, &person.Type, &person.Age, &courses, &person.Version, &person.UpdatedAt, &person.DeletedAt); err != nil {
+			return nil, err
+		}
+		person.Courses = courseIDs(courses)
+		people = append(people, person)
+	}
+	return people, rows.Err()
 }
 
-// CreatePerson creates a person
-func CreatePerson(db *sql.DB, person models.Person) (models.Person, error) {
-    ctx := context.Background()
-
-    // Start a transaction
-    tx, err := db.BeginTx(ctx, nil)
-    if err != nil {
-        return models.Person{}, err
-    }
-    defer tx.Rollback()
-
-    // Insert person
-    err = tx.QueryRowContext(ctx, 
-        `INSERT INTO person (first_name, last_name, type, age) VALUES ($1, $2, $3, $4) RETURNING id`,
-        person.FirstName, person.LastName, person.Type, person.Age).Scan(&person.ID)
-    if err != nil {
-        return models.Person{}, err
-    }
-
-    // Insert course associations
-    for _, courseID := range person.Courses {
-        _, err = tx.ExecContext(ctx,
-            `INSERT INTO person_course (person_id, course_id) VALUES ($1, $2)`,
-            person.ID, courseID)
-        if err != nil {
-            return models.Person{}, err
-        }
-    }
-
-    // Commit transaction
-    if err = tx.Commit(); err != nil {
-        return models.Person{}, err
-    }
-
-    return person, nil
+// UpdatePerson updates a person by id, or returns ErrNotFound when there is no
+// such person. Their courses are left as they are. A non-zero person.Version
+// must be the person's current version, or ErrPreconditionFailed is returned.
+func UpdatePerson(ctx context.Context, db *sql.DB, id int, person models.Person) (models.Person, error) {
+	return PatchPerson(ctx, db, id, person.Version, func(current models.Person) (models.Person, error) {
+		person.Courses = current.Courses
+		return person, nil
+	})
 }
 
-// DeletePersonByName deletes a person by name
-func DeletePersonByName(db *sql.DB, name string) error {
-	ctx := context.Background()
+// lockPerson reads a live person and locks their row for the rest of the
+// transaction, or returns ErrNotFound when there is no such person
+func lockPerson(ctx context.Context, tx *sql.Tx, id int) (models.Person, error) {
+	// aggregates cannot be locked, so the person row is locked on its own first
+	var locked int
+	if err := tx.QueryRowContext(ctx, `SELECT id FROM person WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, id).Scan(&locked); err != nil {
+		return models.Person{}, noRows(err, "person %d", id)
+	}
+	return personByID(ctx, tx, id)
+}
 
-	// grab the person id
-	var person models.Person
-	err := db.QueryRowContext(ctx, `SELECT id FROM person WHERE first_name = $1`, name).Scan(&person.ID)
+// CreatePerson creates a person
+func CreatePerson(ctx context.Context, db *sql.DB, person models.Person) (models.Person, error) {
+	return inTx(ctx, db, func(tx *sql.Tx) (models.Person, error) {
+		return createPerson(ctx, tx, person)
+	})
+}
 
+// createPerson creates a person and their enrollments in tx. The courses are
+// checked in tx too, the foreign key alone would let in a deleted course.
+func createPerson(ctx context.Context, tx *sql.Tx, person models.Person) (models.Person, error) {
+	missing, err := missingCourseIDs(ctx, tx, person.Courses)
 	if err != nil {
-		return err
+		return models.Person{}, err
+	}
+	if len(missing) > 0 {
+		return models.Person{}, invalid(nil, detailUnknownCourse)
 	}
 
-	// delete the person from the person_course table
-	_, err = db.ExecContext(ctx, `DELETE FROM person_course WHERE person_id = $1`, person.ID)
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO person (first_name, last_name, type, age) VALUES ($1, $2, $3, $4) RETURNING id, version, updated_at`,
+		person.FirstName, person.LastName, person.Type, person.Age).Scan(&person.ID, &person.Version, &person.UpdatedAt)
 	if err != nil {
-		return err
+		return models.Person{}, constraintError(err)
 	}
 
-	_, err = db.ExecContext(ctx, `DELETE FROM person WHERE first_name = $1`, name)
+	for _, courseID := range person.Courses {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO person_course (person_id, course_id) VALUES ($1, $2)`,
+			person.ID, courseID)
+		if err != nil {
+			return models.Person{}, constraintError(err)
+		}
+	}
+
+	if err = recordHistory(ctx, tx, person.ID); err != nil {
+		return models.Person{}, err
+	}
+	if err = writeAudit(ctx, tx, personChange(ctx, AuditCreate, nil, &person)); err != nil {
+		return models.Person{}, err
+	}
+	// nothing can be cached under a new id yet, the write is announced like every other person write
+	if err = publishEviction(ctx, tx, personEviction(person.ID)); err != nil {
+		return models.Person{}, err
+	}
+	return person, nil
+}
+
+// DeletePerson soft deletes a person, or returns ErrNotFound when there is no
+// such person. Their enrollments are kept for a restore. A non-zero version
+// must be the person's current version.
+func DeletePerson(ctx context.Context, db *sql.DB, id, version int) error {
+	_, err := inTx(ctx, db, func(tx *sql.Tx) (struct{}, error) {
+		return struct{}{}, deletePerson(ctx, tx, id, version)
+	})
+	return err
+}
+
+// deletePerson soft deletes a person in tx
+func deletePerson(ctx context.Context, tx *sql.Tx, id, version int) error {
+	current, err := lockPerson(ctx, tx, id)
 	if err != nil {
 		return err
-	}	
-	return nil
+	}
+	if err := checkVersion("person", id, current.Version, version); err != nil {
+		return err
+	}
+	deleted := current
+	if err := tx.QueryRowContext(ctx, `
+		UPDATE person SET deleted_at = now(), version = version + 1, updated_at = now() WHERE id = $1
+		RETURNING version, updated_at, deleted_at`, id).Scan(&deleted.Version, &deleted.UpdatedAt, &deleted.DeletedAt); err != nil {
+		return err
+	}
+	if err := recordHistory(ctx, tx, id); err != nil {
+		return err
+	}
+	if err := writeAudit(ctx, tx, personChange(ctx, AuditDelete, &current, &deleted)); err != nil {
+		return err
+	}
+	return publishEviction(ctx, tx, personEviction(id))
 }
 
-// GetCoursesByPersonID returns all course ids for a person
-func GetCoursesByPersonID(db *sql.DB, personID int) ([]int, error) {
-	
-	ctx := context.Background()
+// RestorePerson undoes the soft delete of a person, their enrollments in live
+// courses come back with them. ErrNotFound is returned when there is no such
+// person, ErrConflict when they are not deleted.
+func RestorePerson(ctx context.Context, db *sql.DB, id int) (models.Person, error) {
+	return inTx(ctx, db, func(tx *sql.Tx) (models.Person, error) {
+		var version int
+		var updatedAt time.Time
+		var deletedAt *time.Time
+		if err := tx.QueryRowContext(ctx, `SELECT version, updated_at, deleted_at FROM person WHERE id = $1 FOR UPDATE`, id).Scan(&version, &updatedAt, &deletedAt); err != nil {
+			return models.Person{}, noRows(err, "person %d", id)
+		}
+		if deletedAt == nil {
+			return models.Person{}, conflict(nil, "person %d is not deleted", id)
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE person SET deleted_at = NULL, version = version + 1, updated_at = now() WHERE id = $1`, id); err != nil {
+			return models.Person{}, err
+		}
+		if err := recordHistory(ctx, tx, id); err != nil {
+			return models.Person{}, err
+		}
+		person, err := personByID(ctx, tx, id)
+		if err != nil {
+			return models.Person{}, err
+		}
+		// the person was the same before the restore but for these
+		deleted := person
+		deleted.Version, deleted.UpdatedAt, deleted.DeletedAt = version, updatedAt, deletedAt
+		if err := writeAudit(ctx, tx, personChange(ctx, AuditRestore, &deleted, &person)); err != nil {
+			return models.Person{}, err
+		}
+		if err := publishEviction(ctx, tx, personEviction(id)); err != nil {
+			return models.Person{}, err
+		}
+		return person, nil
+	})
+}
 
+// GetCoursesByPersonID returns all course ids for a person
+func GetCoursesByPersonID(ctx context.Context, db *sql.DB, personID int) ([]int, error) {
 	rows, err := db.QueryContext(
 		ctx,
-		`SELECT c.id, c.name FROM "course" c JOIN "person_course" pc ON c.id = pc.course_id WHERE pc.person_id = $1`,
+		`SELECT c.id FROM "course" c JOIN "person_course" pc ON c.id = pc.course_id WHERE pc.person_id = $1 AND c.deleted_at IS NULL`,
 		personID,
 	)
 	if err != nil {
@@ -147,11 +274,14 @@ func GetCoursesByPersonID(db *sql.DB, personID int) ([]int, error) {
 
 	var courses []int
 	for rows.Next() {
-		var course models.Course
-		if err := rows.Scan(&course.ID, &course.Name); err != nil {
+		var id int
+		if err := rows.Scan(&id); err != nil {
 			return nil, err
 		}
-		courses = append(courses, course.ID)
+		courses = append(courses, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 	return courses, nil
 }
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/jacob-tech-challenge/api/models"
+)
+
+// roundTrip stands in for the network latency of a real database. Every
+// query pays it once, so a list that issued a query per person would grow by
+// roundTrip for every person added to the roster.
+const roundTrip = 200 * time.Microsecond
+
+// BenchmarkGetAllPeople lists rosters of growing size. ns/op should stay close
+// to a single roundTrip plus scanning time, and queries/op must stay at 1.
+func BenchmarkGetAllPeople(b *testing.B) {
+	for _, size := range []int{10, 100, 1000, 10000} {
+		b.Run(fmt.Sprintf("people=%d", size), func(b *testing.B) {
+			// every query the list sends is matched once, so the matcher counts them
+			queries := 0
+			counting := sqlmock.QueryMatcherFunc(func(expectedSQL, actualSQL string) error {
+				queries++
+				return sqlmock.QueryMatcherRegexp.Match(expectedSQL, actualSQL)
+			})
+			db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(counting))
+			if err != nil {
+				b.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			ctx := context.Background()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				rows := sqlmock.NewRows(personColumns)
+				for id := 1; id <= size; id++ {
+					rows.AddRow(id, "First", "Last", "student", 20, "{1,2,3}", 1, testUpdatedAt, nil)
+				}
+				mock.ExpectQuery(`SELECT p\.id`).WillDelayFor(roundTrip).WillReturnRows(rows)
+				b.StartTimer()
+
+				people, _, err := GetAllPeople(ctx, db, PersonFilter{}, Page{Limit: size})
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(people) != size {
+					b.Fatalf("expected %d people, got %d", size, len(people))
+				}
+			}
+			b.StopTimer()
+
+			// any query beyond the expected one per list fails here
+			if err := mock.ExpectationsWereMet(); err != nil {
+				b.Fatal(err)
+			}
+			b.ReportMetric(float64(queries)/float64(b.N), "queries/op")
+		})
+	}
+}
+
+// BenchmarkMemoryStoreGetAllPeople is the same roster growth against the in-memory store
+func BenchmarkMemoryStoreGetAllPeople(b *testing.B) {
+	for _, size := range []int{10, 100, 1000, 10000} {
+		b.Run(fmt.Sprintf("people=%d", size), func(b *testing.B) {
+			ctx := context.Background()
+			store := NewMemoryStore()
+			for _, name := range []string{"Programming", "Databases", "UI Design"} {
+				if _, err := store.CreateCourse(ctx, models.Course{Name: name}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			for id := 1; id <= size; id++ {
+				person := models.Person{FirstName: "First", LastName: "Last", Type: "student", Age: 20, Courses: []int{1, 2, 3}}
+				if _, err := store.CreatePerson(ctx, person); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := store.GetAllPeople(ctx, PersonFilter{}, Page{Limit: size}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
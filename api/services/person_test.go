@@ -1,15 +1,24 @@
 package services
 
 import (
+	"context"
 	"database/sql"
+	"regexp"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/jacob-tech-challenge/api/models"
 )
 
+// missingCoursesQuery matches the course check of missingCourseIDs
+const missingCoursesQuery = `SELECT DISTINCT ids\.id\s+FROM unnest\(\$1::int\[\]\) AS ids\(id\)`
+
+// getAllPeopleQuery matches the aggregated person query of GetAllPeople
+const getAllPeopleQuery = `SELECT p\.id, p\.first_name, p\.last_name, p\.type, p\.age,\s+COALESCE\(array_agg\(c\.id ORDER BY c\.id\)(.+)p\.deleted_at\s+FROM person p\s+LEFT JOIN person_course pc ON pc\.person_id = p\.id\s+LEFT JOIN course c ON c\.id = pc\.course_id AND c\.deleted_at IS NULL`
+
 func TestGetAllPeople(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -19,36 +28,20 @@ func TestGetAllPeople(t *testing.T) {
 
 	tests := []struct {
 		name           string
-		inputName      string
-		inputAge       int
+		filter         PersonFilter
 		mockSetup      func(sqlmock.Sqlmock)
 		expectedPeople []models.Person
 		expectedError  bool
 	}{
 		{
 			name:      "Success - No filters",
-			inputName: "",
-			inputAge:  0,
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "type", "age"}).
-					AddRow(1, "John", "Doe", "student", 25).
-					AddRow(2, "Jane", "Smith", "teacher", 30)
-				mock.ExpectQuery(`SELECT \* FROM person WHERE \(\$1 = '' OR first_name = \$1\) AND \(\$2 = 0 OR age = \$2\)`).
-					WithArgs("", 0).
+				rows := sqlmock.NewRows(personColumns).
+					AddRow(1, "John", "Doe", "student", 25, "{1,2}", 1, testUpdatedAt, nil).
+					AddRow(2, "Jane", "Smith", "teacher", 30, "{3}", 1, testUpdatedAt, nil)
+				mock.ExpectQuery(getAllPeopleQuery).
+					WithArgs(nil).
 					WillReturnRows(rows)
-
-				courseRows1 := sqlmock.NewRows([]string{"id", "name"}).
-					AddRow(1, "Math").
-					AddRow(2, "Science")
-				mock.ExpectQuery(`SELECT c\.id, c\.name FROM "course" c JOIN "person_course" pc ON c\.id = pc\.course_id WHERE pc\.person_id = \$1`).
-					WithArgs(1).
-					WillReturnRows(courseRows1)
-
-				courseRows2 := sqlmock.NewRows([]string{"id", "name"}).
-					AddRow(3, "History")
-				mock.ExpectQuery(`SELECT c\.id, c\.name FROM "course" c JOIN "person_course" pc ON c\.id = pc\.course_id WHERE pc\.person_id = \$1`).
-					WithArgs(2).
-					WillReturnRows(courseRows2)
 			},
 			expectedPeople: []models.Person{
 				{
@@ -58,6 +51,8 @@ func TestGetAllPeople(t *testing.T) {
 					Type:      "student",
 					Age:       25,
 					Courses:   []int{1, 2},
+					Version:   1,
+					UpdatedAt: testUpdatedAt,
 				},
 				{
 					ID:        2,
@@ -66,27 +61,21 @@ func TestGetAllPeople(t *testing.T) {
 					Type:      "teacher",
 					Age:       30,
 					Courses:   []int{3},
+					Version:   1,
+					UpdatedAt: testUpdatedAt,
 				},
 			},
 			expectedError: false,
 		},
 		{
 			name:      "Success - Filter by name",
-			inputName: "John",
-			inputAge:  0,
+			filter:    PersonFilter{FirstName: "John"},
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "type", "age"}).
-					AddRow(1, "John", "Doe", "student", 25)
-				mock.ExpectQuery(`SELECT \* FROM person WHERE \(\$1 = '' OR first_name = \$1\) AND \(\$2 = 0 OR age = \$2\)`).
-					WithArgs("John", 0).
+				rows := sqlmock.NewRows(personColumns).
+					AddRow(1, "John", "Doe", "student", 25, "{1,2}", 1, testUpdatedAt, nil)
+				mock.ExpectQuery(getAllPeopleQuery).
+					WithArgs("John", nil).
 					WillReturnRows(rows)
-
-				courseRows := sqlmock.NewRows([]string{"id", "name"}).
-					AddRow(1, "Math").
-					AddRow(2, "Science")
-				mock.ExpectQuery(`SELECT c\.id, c\.name FROM "course" c JOIN "person_course" pc ON c\.id = pc\.course_id WHERE pc\.person_id = \$1`).
-					WithArgs(1).
-					WillReturnRows(courseRows)
 			},
 			expectedPeople: []models.Person{
 				{
@@ -96,26 +85,21 @@ func TestGetAllPeople(t *testing.T) {
 					Type:      "student",
 					Age:       25,
 					Courses:   []int{1, 2},
+					Version:   1,
+					UpdatedAt: testUpdatedAt,
 				},
 			},
 			expectedError: false,
 		},
 		{
 			name:      "Success - Filter by age",
-			inputName: "",
-			inputAge:  30,
+			filter:    PersonFilter{Age: intPtr(30)},
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "type", "age"}).
-					AddRow(2, "Jane", "Smith", "teacher", 30)
-				mock.ExpectQuery(`SELECT \* FROM person WHERE \(\$1 = '' OR first_name = \$1\) AND \(\$2 = 0 OR age = \$2\)`).
-					WithArgs("", 30).
+				rows := sqlmock.NewRows(personColumns).
+					AddRow(2, "Jane", "Smith", "teacher", 30, "{3}", 1, testUpdatedAt, nil)
+				mock.ExpectQuery(getAllPeopleQuery).
+					WithArgs(30, nil).
 					WillReturnRows(rows)
-
-				courseRows := sqlmock.NewRows([]string{"id", "name"}).
-					AddRow(3, "History")
-				mock.ExpectQuery(`SELECT c\.id, c\.name FROM "course" c JOIN "person_course" pc ON c\.id = pc\.course_id WHERE pc\.person_id = \$1`).
-					WithArgs(2).
-					WillReturnRows(courseRows)
 			},
 			expectedPeople: []models.Person{
 				{
@@ -125,19 +109,42 @@ func TestGetAllPeople(t *testing.T) {
 					Type:      "teacher",
 					Age:       30,
 					Courses:   []int{3},
+					Version:   1,
+					UpdatedAt: testUpdatedAt,
+				},
+			},
+			expectedError: false,
+		},
+		{
+			name:      "Success - Person without courses",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows(personColumns).
+					AddRow(3, "Bill", "Gates", "student", 67, "{}", 1, testUpdatedAt, nil)
+				mock.ExpectQuery(getAllPeopleQuery).
+					WithArgs(nil).
+					WillReturnRows(rows)
+			},
+			expectedPeople: []models.Person{
+				{
+					ID:        3,
+					FirstName: "Bill",
+					LastName:  "Gates",
+					Type:      "student",
+					Age:       67,
+					Version:   1,
+					UpdatedAt: testUpdatedAt,
 				},
 			},
 			expectedError: false,
 		},
 		{
 			name:      "Success - No results",
-			inputName: "NonExistent",
-			inputAge:  0,
+			filter:    PersonFilter{FirstName: "NonExistent"},
 			mockSetup: func(mock sqlmock.Sqlmock) {
 				// Return empty result set
-				rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "type", "age"})
-				mock.ExpectQuery(`SELECT \* FROM person WHERE \(\$1 = '' OR first_name = \$1\) AND \(\$2 = 0 OR age = \$2\)`).
-					WithArgs("NonExistent", 0).
+				rows := sqlmock.NewRows(personColumns)
+				mock.ExpectQuery(getAllPeopleQuery).
+					WithArgs("NonExistent", nil).
 					WillReturnRows(rows)
 			},
 			expectedPeople: nil, // Changed from nil to empty slice to match implementation
@@ -145,30 +152,23 @@ func TestGetAllPeople(t *testing.T) {
 		},
 		{
 			name:      "Error - Database query error",
-			inputName: "",
-			inputAge:  0,
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery(`SELECT \* FROM person WHERE \(\$1 = '' OR first_name = \$1\) AND \(\$2 = 0 OR age = \$2\)`).
-					WithArgs("", 0).
+				mock.ExpectQuery(getAllPeopleQuery).
+					WithArgs(nil).
 					WillReturnError(sql.ErrConnDone)
 			},
 			expectedPeople: []models.Person{},
 			expectedError: true,
 		},
 		{
-			name:      "Error - Course query error",
-			inputName: "",
-			inputAge:  0,
+			name:      "Error - Row iteration error",
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "type", "age"}).
-					AddRow(1, "John", "Doe", "student", 25)
-				mock.ExpectQuery(`SELECT \* FROM person WHERE \(\$1 = '' OR first_name = \$1\) AND \(\$2 = 0 OR age = \$2\)`).
-					WithArgs("", 0).
+				rows := sqlmock.NewRows(personColumns).
+					AddRow(1, "John", "Doe", "student", 25, "{1}", 1, testUpdatedAt, nil).
+					RowError(0, sql.ErrConnDone)
+				mock.ExpectQuery(getAllPeopleQuery).
+					WithArgs(nil).
 					WillReturnRows(rows)
-
-				mock.ExpectQuery(`SELECT c\.id, c\.name FROM "course" c JOIN "person_course" pc ON c\.id = pc\.course_id WHERE pc\.person_id = \$1`).
-					WithArgs(1).
-					WillReturnError(sql.ErrConnDone)
 			},
 			expectedPeople: nil, // This case can return nil since it's an error case
 			expectedError: true,
@@ -181,7 +181,7 @@ func TestGetAllPeople(t *testing.T) {
 			tt.mockSetup(mock)
 
 			// Execute the function
-			people, err := GetAllPeople(db, tt.inputName, tt.inputAge)
+			people, _, err := GetAllPeople(context.Background(), db, tt.filter, Page{})
 
 			// Check error expectations
 			if tt.expectedError {
@@ -199,137 +199,82 @@ func TestGetAllPeople(t *testing.T) {
 	}
 }
 
-func TestGetPersonByName(t *testing.T) {
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestGetPersonByID(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 	defer db.Close()
 
+	getPersonQuery := `SELECT p\.id, p\.first_name, p\.last_name, p\.type, p\.age,.*WHERE p\.id = \$1`
+	columns := personColumns
+
 	tests := []struct {
-		name          string
-		inputName     string
-		mockSetup     func(sqlmock.Sqlmock)
+		name           string
+		inputID        int
+		mockSetup      func(sqlmock.Sqlmock)
 		expectedPerson models.Person
-		expectedError  bool
+		expectedError  error
 	}{
 		{
-			name:      "Success - Person found with courses",
-			inputName: "John",
+			name:    "Success - Person found with courses",
+			inputID: 1,
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				// Mock the person query
-				personRows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "type", "age"}).
-					AddRow(1, "John", "Doe", "student", 25)
-				mock.ExpectQuery("SELECT \\* FROM person WHERE first_name = \\$1").
-					WithArgs("John").
-					WillReturnRows(personRows)
-
-				// Mock the courses join query
-				courseRows := sqlmock.NewRows([]string{"id", "name"}).
-					AddRow(1, "Math").
-					AddRow(2, "Science").
-					AddRow(3, "History")
-				mock.ExpectQuery(`SELECT c\.id, c\.name FROM "course" c JOIN "person_course" pc ON c\.id = pc\.course_id WHERE pc\.person_id = \$1`).
+				mock.ExpectQuery(getPersonQuery).
 					WithArgs(1).
-					WillReturnRows(courseRows)
-			},
-			expectedPerson: models.Person{
-				ID:        1,
-				FirstName: "John",
-				LastName:  "Doe",
-				Type:      "student",
-				Age:       25,
-				Courses:   []int{1, 2, 3},
+					WillReturnRows(sqlmock.NewRows(columns).AddRow(1, "John", "Doe", "student", 25, "{1,2,3}", 1, testUpdatedAt, nil))
 			},
-			expectedError: false,
+			expectedPerson: models.Person{ID: 1, FirstName: "John", LastName: "Doe", Type: "student", Age: 25, Courses: []int{1, 2, 3}, Version: 1, UpdatedAt: testUpdatedAt},
 		},
 		{
-			name:      "Success - Person found with no courses",
-			inputName: "Jane",
+			name:    "Success - Person found with no courses",
+			inputID: 2,
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				// Mock the person query
-				personRows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "type", "age"}).
-					AddRow(2, "Jane", "Smith", "student", 22)
-				mock.ExpectQuery("SELECT \\* FROM person WHERE first_name = \\$1").
-					WithArgs("Jane").
-					WillReturnRows(personRows)
-
-				// Mock empty courses result
-				courseRows := sqlmock.NewRows([]string{"id", "name"})
-				mock.ExpectQuery(`SELECT c\.id, c\.name FROM "course" c JOIN "person_course" pc ON c\.id = pc\.course_id WHERE pc\.person_id = \$1`).
+				mock.ExpectQuery(getPersonQuery).
 					WithArgs(2).
-					WillReturnRows(courseRows)
-			},
-			expectedPerson: models.Person{
-				ID:        2,
-				FirstName: "Jane",
-				LastName:  "Smith",
-				Type:      "student",
-				Age:       22,
-				Courses:   nil, // Changed from empty slice to nil to match actual behavior
+					WillReturnRows(sqlmock.NewRows(columns).AddRow(2, "Jane", "Smith", "student", 22, "{}", 1, testUpdatedAt, nil))
 			},
-			expectedError: false,
+			expectedPerson: models.Person{ID: 2, FirstName: "Jane", LastName: "Smith", Type: "student", Age: 22, Version: 1, UpdatedAt: testUpdatedAt},
 		},
 		{
-			name:      "Not Found - Empty Result",
-			inputName: "NonExistent",
+			name:    "Not Found",
+			inputID: 99,
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery("SELECT \\* FROM person WHERE first_name = \\$1").
-					WithArgs("NonExistent").
-					WillReturnError(sql.ErrNoRows) // Changed to return ErrNoRows instead of empty result
+				mock.ExpectQuery(getPersonQuery).
+					WithArgs(99).
+					WillReturnRows(sqlmock.NewRows(columns))
 			},
-			expectedPerson: models.Person{},
-			expectedError: false,
+			expectedError: ErrNotFound,
 		},
 		{
-			name:      "Error - Database Error",
-			inputName: "John",
+			name:    "Error - Database Error",
+			inputID: 1,
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery("SELECT \\* FROM person WHERE first_name = \\$1").
-					WithArgs("John").
-					WillReturnError(sql.ErrConnDone)
-			},
-			expectedPerson: models.Person{},
-			expectedError: true,
-		},
-		{
-			name:      "Error - Courses Query Failed",
-			inputName: "John",
-			mockSetup: func(mock sqlmock.Sqlmock) {
-				// Mock the person query success
-				personRows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "type", "age"}).
-					AddRow(1, "John", "Doe", "student", 25)
-				mock.ExpectQuery("SELECT \\* FROM person WHERE first_name = \\$1").
-					WithArgs("John").
-					WillReturnRows(personRows)
-
-				// Mock courses query error
-				mock.ExpectQuery(`SELECT c\.id, c\.name FROM "course" c JOIN "person_course" pc ON c\.id = pc\.course_id WHERE pc\.person_id = \$1`).
+				mock.ExpectQuery(getPersonQuery).
 					WithArgs(1).
 					WillReturnError(sql.ErrConnDone)
 			},
-			expectedPerson: models.Person{},
-			expectedError: true,
+			expectedError: sql.ErrConnDone,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// Setup mock expectations
 			tt.mockSetup(mock)
 
-			// Execute the function
-			person, err := GetPersonByName(db, tt.inputName)
+			person, err := GetPersonByID(context.Background(), db, tt.inputID)
 
-			// Check error expectations
-			if tt.expectedError {
-				assert.Error(t, err)
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
 			} else {
 				assert.NoError(t, err)
 				assert.Equal(t, tt.expectedPerson, person)
 			}
 
-			// Ensure all expectations were met
 			if err := mock.ExpectationsWereMet(); err != nil {
 				t.Errorf("there were unfulfilled expectations: %s", err)
 			}
@@ -337,106 +282,81 @@ func TestGetPersonByName(t *testing.T) {
 	}
 }
 
-
-func TestUpdatePersonByName(t *testing.T) {
-	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+func TestUpdatePerson(t *testing.T) {
+	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("Failed to create mock: %v", err)
 	}
 	defer db.Close()
 
-	t.Run("Successful Update", func(t *testing.T) {
-		// Test data
-		oldName := "John"
-		updatedPerson := models.Person{
-			FirstName: "Johnny",
-			LastName:  "Doe",
-			Type:     "Student",
-			Age:      25,
-			Courses:  []int{1, 2},
-		}
-		
-		sqlmock.NewRows([]string{"id", "first_name", "last_name", "type", "age"}).
-					AddRow(1, "John", "Doe", "student", 25)
-
-		// 1. Mock the UPDATE query
-		mock.ExpectExec(`UPDATE person SET first_name = $1, last_name = $2, type = $3, age = $4 WHERE first_name = $5`).
-			WithArgs(updatedPerson.FirstName, updatedPerson.LastName, updatedPerson.Type, updatedPerson.Age, oldName).
-			WillReturnResult(sqlmock.NewResult(0, 1))
+	updateQuery := regexp.QuoteMeta(`UPDATE person SET first_name = $1, last_name = $2, type = $3, age = $4, version = version + 1, updated_at = now() WHERE id = $5`)
 
-		// 2. Mock the SELECT query in GetPersonByName (using the NEW name)
-		personRows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "type", "age"}).
-			AddRow(1, updatedPerson.FirstName, updatedPerson.LastName, updatedPerson.Type, updatedPerson.Age)
+	updatedPerson := models.Person{
+		FirstName: "Johnny",
+		LastName:  "Doe",
+		Type:      "student",
+		Age:       25,
+		Version:   1,
+	}
 
-		mock.ExpectQuery(`SELECT * FROM person WHERE first_name = $1`).
-			WithArgs(updatedPerson.FirstName).  // Use the new name here
-			WillReturnRows(personRows)
+	t.Run("Successful Update", func(t *testing.T) {
+		mock.ExpectBegin()
+		expectLockedPerson(mock, 1, "{1,2}")
+		mock.ExpectExec(updateQuery).
+			WithArgs(updatedPerson.FirstName, updatedPerson.LastName, updatedPerson.Type, updatedPerson.Age, 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectHistory(mock, 1)
+		mock.ExpectQuery(`SELECT p\.id.*WHERE p\.id = \$1`).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(personColumns).
+				AddRow(1, "Johnny", "Doe", "student", 25, "{1,2}", 2, testUpdatedAt, nil))
+		expectAudit(mock, "person", 1, AuditUpdate)
+		expectEviction(mock, personEviction(1))
+		mock.ExpectCommit()
 
-		// 3. Mock the courses query
-		courseRows := sqlmock.NewRows([]string{"id", "name"}).
-			AddRow(1, "Mathematics").
-			AddRow(2, "Physics")
+		result, err := UpdatePerson(context.Background(), db, 1, updatedPerson)
+		assert.NoError(t, err)
+		assert.Equal(t, models.Person{ID: 1, FirstName: "Johnny", LastName: "Doe", Type: "student", Age: 25, Courses: []int{1, 2}, Version: 2, UpdatedAt: testUpdatedAt}, result)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
 
-		mock.ExpectQuery(`SELECT c.id, c.name FROM "course" c JOIN "person_course" pc ON c.id = pc.course_id WHERE pc.person_id = $1`).
-			WithArgs(1).
-			WillReturnRows(courseRows)
+	t.Run("Person Not Found", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockPersonQuery).WithArgs(99).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectRollback()
 
-		// Execute the update
-		result, err := UpdatePersonByName(db, oldName, updatedPerson)
+		_, err := UpdatePerson(context.Background(), db, 99, updatedPerson)
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
 
-		// Debug logging
-		if err != nil {
-			t.Logf("Error occurred: %v", err)
-		} else {
-			t.Logf("Update successful. Result: %+v", result)
-		}
+	t.Run("Stale Version", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockPersonQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		expectPersonRead(mock, 1, "{}", 3)
+		mock.ExpectRollback()
 
-        // Assertions
-		assert.NoError(t, err)
-		if err == nil {
-			assert.Equal(t, 1, result.ID)
-			assert.Equal(t, updatedPerson.FirstName, result.FirstName)
-			assert.Equal(t, updatedPerson.LastName, result.LastName)
-			assert.Equal(t, updatedPerson.Type, result.Type)
-			assert.Equal(t, updatedPerson.Age, result.Age)
-			assert.Equal(t, updatedPerson.Courses, result.Courses)
-		}
-
-		// Verify all expectations were met
-		if err := mock.ExpectationsWereMet(); err != nil {
-			t.Errorf("there were unfulfilled expectations: %s", err)
-		}
+		_, err := UpdatePerson(context.Background(), db, 1, updatedPerson)
+		assert.ErrorIs(t, err, ErrPreconditionFailed)
+		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 
 	t.Run("Update Error", func(t *testing.T) {
-		oldName := "John"
-		updatedPerson := models.Person{
-			FirstName: "Johnny",
-			LastName:  "Doe",
-			Type:     "Student",
-			Age:      25,
-		}
-
-		// Mock the UPDATE query to return an error
-		mock.ExpectExec(`UPDATE person SET first_name = $1, last_name = $2, type = $3, age = $4 WHERE first_name = $5`).
-			WithArgs(updatedPerson.FirstName, updatedPerson.LastName, updatedPerson.Type, updatedPerson.Age, oldName).
+		mock.ExpectBegin()
+		expectLockedPerson(mock, 1, "{}")
+		mock.ExpectExec(updateQuery).
+			WithArgs(updatedPerson.FirstName, updatedPerson.LastName, updatedPerson.Type, updatedPerson.Age, 1).
 			WillReturnError(sql.ErrConnDone)
+		mock.ExpectRollback()
 
-		// Execute the update
-		_, err := UpdatePersonByName(db, oldName, updatedPerson)
-
-		// Assertions
-		assert.Error(t, err)
+		_, err := UpdatePerson(context.Background(), db, 1, updatedPerson)
 		assert.Equal(t, sql.ErrConnDone, err)
-
-		if err := mock.ExpectationsWereMet(); err != nil {
-			t.Errorf("there were unfulfilled expectations: %s", err)
-		}
+		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 }
 
 func TestCreatePerson(t *testing.T) {
-    db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+    db, mock, err := sqlmock.New()
     if err != nil {
         t.Fatalf("Error creating mock database: %v", err)
     }
@@ -461,35 +381,62 @@ func TestCreatePerson(t *testing.T) {
                 // Expect transaction to begin
                 mock.ExpectBegin()
 
+                // Expect the courses to be checked in the transaction
+                mock.ExpectQuery(missingCoursesQuery).WithArgs(pq.Int64Array{1, 2}).
+                    WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
                 // Expect INSERT into person with RETURNING clause
-                mock.ExpectQuery("INSERT INTO person (first_name, last_name, type, age) VALUES ($1, $2, $3, $4) RETURNING id").
+                mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO person (first_name, last_name, type, age) VALUES ($1, $2, $3, $4) RETURNING id, version, updated_at")).
                     WithArgs("John", "Doe", "Student", 25).
-                    WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+                    WillReturnRows(sqlmock.NewRows([]string{"id", "version", "updated_at"}).AddRow(1, 1, testUpdatedAt))
 
                 // Expect INSERT into person_course for each course
-                mock.ExpectExec("INSERT INTO person_course (person_id, course_id) VALUES ($1, $2)").
+                mock.ExpectExec(regexp.QuoteMeta("INSERT INTO person_course (person_id, course_id) VALUES ($1, $2)")).
                     WithArgs(1, 1).
                     WillReturnResult(sqlmock.NewResult(1, 1))
 
-                mock.ExpectExec("INSERT INTO person_course (person_id, course_id) VALUES ($1, $2)").
+                mock.ExpectExec(regexp.QuoteMeta("INSERT INTO person_course (person_id, course_id) VALUES ($1, $2)")).
                     WithArgs(1, 2).
                     WillReturnResult(sqlmock.NewResult(1, 1))
 
+                // Expect the first revision and the creation to be audited
+                expectHistory(mock, 1)
+                expectAudit(mock, "person", 1, AuditCreate)
+                expectEviction(mock, personEviction(1))
+
                 // Expect transaction to commit
                 mock.ExpectCommit()
             },
             expectedError: false,
         },
+        {
+            name: "Deleted Course",
+            inputPerson: models.Person{
+                FirstName: "John",
+                LastName:  "Doe",
+                Type:     "Student",
+                Age:      25,
+                Courses:  []int{1, 3},
+            },
+            mockBehavior: func(mock sqlmock.Sqlmock) {
+                // a course deleted since the handler checked it fails the create before any insert
+                mock.ExpectBegin()
+                mock.ExpectQuery(missingCoursesQuery).WithArgs(pq.Int64Array{1, 3}).
+                    WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+                mock.ExpectRollback()
+            },
+            expectedError: true,
+        },
     }
 
     for _, tt := range tests {
         t.Run(tt.name, func(t *testing.T) {
             tt.mockBehavior(mock)
 
-            _, err := CreatePerson(db, tt.inputPerson)
+            _, err := CreatePerson(context.Background(), db, tt.inputPerson)
 
             if tt.expectedError {
-                assert.Error(t, err)
+                assert.ErrorIs(t, err, ErrValidation)
             } else {
                 assert.NoError(t, err)
             }
@@ -499,81 +446,174 @@ func TestCreatePerson(t *testing.T) {
     }
 }
 
-func TestDeletePersonByName(t *testing.T) {
+func TestDeletePerson(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("Error creating mock database: %v", err)
 	}
 	defer db.Close()
 
+	deleteQuery := `UPDATE person SET deleted_at = now\(\), version = version \+ 1, updated_at = now\(\) WHERE id = \$1\s+RETURNING version, updated_at, deleted_at`
+
 	tests := []struct {
 		name          string
-		inputName     string
-		mockBehavior  func(mock sqlmock.Sqlmock, name string)
-		expectedError bool
+		inputID       int
+		version       int
+		mockBehavior  func(mock sqlmock.Sqlmock, id int)
+		expectedError error
 	}{
 		{
-			name:      "Success",
-			inputName: "John",
-			mockBehavior: func(mock sqlmock.Sqlmock, name string) {
-				// Expect SELECT query for ID
-				rows := sqlmock.NewRows([]string{"id"}).AddRow(1)
-				mock.ExpectQuery("SELECT id FROM person").
-					WithArgs(name).
-					WillReturnRows(rows)
-
-				// Expect DELETE from person_course
-				mock.ExpectExec("DELETE FROM person_course").
-					WithArgs(1).
-					WillReturnResult(sqlmock.NewResult(1, 1))
-
-				// Expect DELETE from person
-				mock.ExpectExec("DELETE FROM person").
-					WithArgs(name).
-					WillReturnResult(sqlmock.NewResult(1, 1))
+			name:    "Success",
+			inputID: 1,
+			mockBehavior: func(mock sqlmock.Sqlmock, id int) {
+				mock.ExpectBegin()
+				expectLockedPerson(mock, id, "{1}")
+				mock.ExpectQuery(deleteQuery).
+					WithArgs(id).
+					WillReturnRows(sqlmock.NewRows([]string{"version", "updated_at", "deleted_at"}).AddRow(2, testUpdatedAt, testUpdatedAt))
+				expectHistory(mock, id)
+				expectAudit(mock, "person", id, AuditDelete)
+				expectEviction(mock, personEviction(id))
+				mock.ExpectCommit()
 			},
-			expectedError: false,
 		},
 		{
-			name:      "Person Not Found",
-			inputName: "NonExistent",
-			mockBehavior: func(mock sqlmock.Sqlmock, name string) {
-				mock.ExpectQuery("SELECT id FROM person").
-					WithArgs(name).
-					WillReturnError(sql.ErrNoRows)
+			name:    "Person Not Found",
+			inputID: 99,
+			mockBehavior: func(mock sqlmock.Sqlmock, id int) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockPersonQuery).WithArgs(id).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectRollback()
 			},
-			expectedError: true,
+			expectedError: ErrNotFound,
 		},
 		{
-			name:      "Error Deleting from person_course",
-			inputName: "John",
-			mockBehavior: func(mock sqlmock.Sqlmock, name string) {
-				rows := sqlmock.NewRows([]string{"id"}).AddRow(1)
-				mock.ExpectQuery("SELECT id FROM person").
-					WithArgs(name).
-					WillReturnRows(rows)
-
-				mock.ExpectExec("DELETE FROM person_course").
-					WithArgs(1).
+			name:    "Stale Version",
+			inputID: 1,
+			version: 3,
+			mockBehavior: func(mock sqlmock.Sqlmock, id int) {
+				mock.ExpectBegin()
+				expectLockedPerson(mock, id, "{1}")
+				mock.ExpectRollback()
+			},
+			expectedError: ErrPreconditionFailed,
+		},
+		{
+			name:    "Database Error",
+			inputID: 1,
+			mockBehavior: func(mock sqlmock.Sqlmock, id int) {
+				mock.ExpectBegin()
+				expectLockedPerson(mock, id, "{1}")
+				mock.ExpectQuery(deleteQuery).
+					WithArgs(id).
 					WillReturnError(sql.ErrConnDone)
+				mock.ExpectRollback()
 			},
-			expectedError: true,
+			expectedError: sql.ErrConnDone,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tt.mockBehavior(mock, tt.inputName)
+			tt.mockBehavior(mock, tt.inputID)
 
-			err := DeletePersonByName(db, tt.inputName)
-
-			if tt.expectedError {
-				assert.Error(t, err)
+			err := DeletePerson(context.Background(), db, tt.inputID, tt.version)
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
 			} else {
 				assert.NoError(t, err)
 			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
 
+func TestRestorePerson(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	lockQuery := regexp.QuoteMeta(`SELECT version, updated_at, deleted_at FROM person WHERE id = $1 FOR UPDATE`)
+	restoreQuery := regexp.QuoteMeta(`UPDATE person SET deleted_at = NULL, version = version + 1, updated_at = now() WHERE id = $1`)
+	lockColumns := []string{"version", "updated_at", "deleted_at"}
+
+	tests := []struct {
+		name          string
+		mockBehavior  func()
+		expected      models.Person
+		expectedError error
+	}{
+		{
+			name: "Success",
+			mockBehavior: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows(lockColumns).AddRow(2, testUpdatedAt, testUpdatedAt))
+				mock.ExpectExec(restoreQuery).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+				expectHistory(mock, 1)
+				mock.ExpectQuery(getAllPeopleQuery).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows(personColumns).AddRow(1, "John", "Doe", "student", 25, "{1,2}", 3, testUpdatedAt, nil))
+				expectAudit(mock, "person", 1, AuditRestore)
+				expectEviction(mock, personEviction(1))
+				mock.ExpectCommit()
+			},
+			expected: models.Person{ID: 1, FirstName: "John", LastName: "Doe", Type: "student", Age: 25, Courses: []int{1, 2}, Version: 3, UpdatedAt: testUpdatedAt},
+		},
+		{
+			name: "Not Deleted",
+			mockBehavior: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows(lockColumns).AddRow(2, testUpdatedAt, nil))
+				mock.ExpectRollback()
+			},
+			expectedError: ErrConflict,
+		},
+		{
+			name: "Person Not Found",
+			mockBehavior: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+			},
+			expectedError: ErrNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockBehavior()
+
+			restored, err := RestorePerson(context.Background(), db, 1)
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expected, restored)
 			assert.NoError(t, mock.ExpectationsWereMet())
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestGetCoursesByPersonID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	query := regexp.QuoteMeta(`SELECT c.id FROM "course" c JOIN "person_course" pc ON c.id = pc.course_id WHERE pc.person_id = $1`)
+	mock.ExpectQuery(query).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	courses, err := GetCoursesByPersonID(context.Background(), db, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, courses)
+
+	// an error while reading the rows is not a shorter list
+	mock.ExpectQuery(query).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2).RowError(1, sql.ErrConnDone))
+	_, err = GetCoursesByPersonID(context.Background(), db, 1)
+	assert.ErrorIs(t, err, sql.ErrConnDone)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
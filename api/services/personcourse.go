@@ -3,48 +3,278 @@ package services
 import (
 	"context"
 	"database/sql"
-	"fmt"
+	"sort"
+
+	"github.com/jacob-tech-challenge/api/models"
+)
+
+// EnrollmentStatus is the outcome of an enrollment change for one course
+type EnrollmentStatus string
+
+const (
+	EnrollmentAdded           EnrollmentStatus = "added"
+	EnrollmentAlreadyEnrolled EnrollmentStatus = "already_enrolled"
+	EnrollmentRemoved         EnrollmentStatus = "removed"
+	EnrollmentNotEnrolled     EnrollmentStatus = "not_enrolled"
+	EnrollmentCourseNotFound  EnrollmentStatus = "course_not_found"
 )
 
-// AddPersonToCourse adds a person to multiple courses, handling potential errors for individual courses.
-func AddPersonToCourse(db *sql.DB, personID int, courseIDs []int) error {
-    ctx := context.Background()
-    var errors []error
+// EnrollmentResult reports what an enrollment change did to one course
+type EnrollmentResult struct {
+	CourseID int
+	Status   EnrollmentStatus
+}
+
+// enrollQuery inserts one enrollment if the course exists and reports both
+// whether the course exists and whether a row was inserted, so a single round
+// trip tells added, already enrolled and course not found apart. The course is
+// share locked so a concurrent DeleteCourse either waits or is waited for.
+const enrollQuery = `
+	WITH c AS (SELECT id FROM course WHERE id = $2 AND deleted_at IS NULL FOR SHARE),
+	changed AS (
+		INSERT INTO person_course (person_id, course_id)
+		SELECT $1, id FROM c
+		ON CONFLICT (person_id, course_id) DO NOTHING
+		RETURNING 1
+	)
+	SELECT EXISTS (SELECT 1 FROM c), EXISTS (SELECT 1 FROM changed)`
+
+// unenrollQuery is the removal counterpart of enrollQuery, enrollments in a
+// soft deleted course are kept for its restore
+const unenrollQuery = `
+	WITH c AS (SELECT id FROM course WHERE id = $2 AND deleted_at IS NULL),
+	changed AS (
+		DELETE FROM person_course WHERE person_id = $1 AND course_id IN (SELECT id FROM c)
+		RETURNING 1
+	)
+	SELECT EXISTS (SELECT 1 FROM c), EXISTS (SELECT 1 FROM changed)`
+
+// GetPersonCourses returns the courses a person is enrolled in, or ErrNotFound when there is no such person
+func GetPersonCourses(ctx context.Context, db *sql.DB, personID int) ([]models.Course, error) {
+	// the person is left joined so a person without courses still returns one row
+	rows, err := db.QueryContext(ctx, `
+		SELECT c.id, c.name
+		FROM person p
+		LEFT JOIN person_course pc ON pc.person_id = p.id
+		LEFT JOIN course c ON c.id = pc.course_id AND c.deleted_at IS NULL
+		WHERE p.id = $1 AND p.deleted_at IS NULL
+		ORDER BY c.id`, personID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := false
+	courses := []models.Course{}
+	for rows.Next() {
+		found = true
+		var id sql.NullInt64
+		var name sql.NullString
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		if id.Valid {
+			courses = append(courses, models.Course{ID: int(id.Int64), Name: name.String})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, notFound("person %d", personID)
+	}
+	return courses, nil
+}
+
+// Roster is one page of the people enrolled in a course, with the number of
+// professors and students counted over the whole course
+type Roster struct {
+	People     []models.Person
+	Professors int
+	Students   int
+}
+
+// rosterFilter lists a course's people professors first, so grouping each page by type keeps the groups in order
+func rosterFilter(courseID int, personType string) PersonFilter {
+	return PersonFilter{CourseID: courseID, Type: personType, Sort: []SortKey{{Field: "type"}}}
+}
+
+// GetCourseRoster returns a page of the people enrolled in a course, optionally
+// only those of one type, or ErrNotFound when there is no such course
+func GetCourseRoster(ctx context.Context, db *sql.DB, courseID int, personType string, page Page) (Roster, *Cursor, error) {
+	filter := rosterFilter(courseID, personType)
+	if err := filter.Validate(); err != nil {
+		return Roster{}, nil, err
+	}
+
+	// the course is left joined so a course without people still returns its row
+	var roster Roster
+	err := db.QueryRowContext(ctx, `
+		SELECT count(p.id) FILTER (WHERE p.type = 'professor'), count(p.id) FILTER (WHERE p.type = 'student')
+		FROM course c
+		LEFT JOIN person_course pc ON pc.course_id = c.id
+		LEFT JOIN person p ON p.id = pc.person_id AND p.deleted_at IS NULL
+		WHERE c.id = $1 AND c.deleted_at IS NULL
+		GROUP BY c.id`, courseID).Scan(&roster.Professors, &roster.Students)
+	if err != nil {
+		return Roster{}, nil, noRows(err, "course %d", courseID)
+	}
+
+	people, next, err := GetAllPeople(ctx, db, filter, page)
+	if err != nil {
+		return Roster{}, nil, err
+	}
+	roster.People = people
+	return roster, next, nil
+}
+
+// AddPersonToCourse enrolls a person in the given courses in one transaction and
+// reports the outcome for each course. Courses that do not exist are reported
+// and skipped, ErrNotFound is returned when there is no such person. A non-zero
+// version must be the person's current version, like for every enrollment change.
+func AddPersonToCourse(ctx context.Context, db *sql.DB, personID, version int, courseIDs []int) ([]EnrollmentResult, error) {
+	return inEnrollmentTx(ctx, db, personID, version, func(tx *sql.Tx) ([]EnrollmentResult, error) {
+		return changeEnrollments(ctx, tx, enrollQuery, personID, courseIDs, EnrollmentAdded, EnrollmentAlreadyEnrolled)
+	})
+}
 
-    for _, id := range courseIDs {
-		// make sure the course exists
-		var courseID int
-		err := db.QueryRowContext(ctx, `SELECT id FROM course WHERE id = $1`, id).Scan(&courseID)
+// RemovePersonFromCourses unenrolls a person from the given courses in one
+// transaction and reports the outcome for each course
+func RemovePersonFromCourses(ctx context.Context, db *sql.DB, personID, version int, courseIDs []int) ([]EnrollmentResult, error) {
+	return inEnrollmentTx(ctx, db, personID, version, func(tx *sql.Tx) ([]EnrollmentResult, error) {
+		return changeEnrollments(ctx, tx, unenrollQuery, personID, courseIDs, EnrollmentRemoved, EnrollmentNotEnrolled)
+	})
+}
+
+// SetPersonCourses replaces a person's enrollments with the given courses in one
+// transaction. Dropped enrollments are reported as removed, followed by the
+// outcome for each requested course.
+func SetPersonCourses(ctx context.Context, db *sql.DB, personID, version int, courseIDs []int) ([]EnrollmentResult, error) {
+	return inEnrollmentTx(ctx, db, personID, version, func(tx *sql.Tx) ([]EnrollmentResult, error) {
+		rows, err := tx.QueryContext(ctx, `
+			DELETE FROM person_course
+			WHERE person_id = $1 AND NOT (course_id = ANY($2))
+				AND course_id IN (SELECT id FROM course WHERE deleted_at IS NULL)
+			RETURNING course_id`, personID, int64s(courseIDs))
 		if err != nil {
-			errors = append(errors, fmt.Errorf("failed to add course %d: course not found", id))
-			continue
+			return nil, err
+		}
+		var removed []int
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			removed = append(removed, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
 		}
 
-		// make sure the person exists
-		var personID int
-		err = db.QueryRowContext(ctx, `SELECT id FROM person WHERE id = $1`, personID).Scan(&personID)
+		sort.Ints(removed)
+		results := make([]EnrollmentResult, 0, len(removed)+len(courseIDs))
+		for _, id := range removed {
+			results = append(results, EnrollmentResult{CourseID: id, Status: EnrollmentRemoved})
+		}
+		added, err := changeEnrollments(ctx, tx, enrollQuery, personID, courseIDs, EnrollmentAdded, EnrollmentAlreadyEnrolled)
 		if err != nil {
-			errors = append(errors, fmt.Errorf("failed to add course %d: person not found", id))
-			continue
+			return nil, err
 		}
+		return append(results, added...), nil
+	})
+}
 
+// inEnrollmentTx runs fn in a transaction holding a lock on the person row, so
+// concurrent changes to the same person's enrollments are applied one at a time.
+// A non-zero version must be the person's current version, or
+// ErrPreconditionFailed is returned. When fn added or removed an enrollment the
+// person's version goes up and the change is audited and kept in their history.
+func inEnrollmentTx(ctx context.Context, db *sql.DB, personID, version int, fn func(tx *sql.Tx) ([]EnrollmentResult, error)) ([]EnrollmentResult, error) {
+	return inTx(ctx, db, func(tx *sql.Tx) ([]EnrollmentResult, error) {
+		return changeLocked(ctx, tx, personID, version, fn)
+	})
+}
 
+// changeLocked is inEnrollmentTx in a transaction that is already open
+func changeLocked(ctx context.Context, tx *sql.Tx, personID, version int, fn func(tx *sql.Tx) ([]EnrollmentResult, error)) ([]EnrollmentResult, error) {
+	before, err := lockPerson(ctx, tx, personID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVersion("person", personID, before.Version, version); err != nil {
+		return nil, err
+	}
+	results, err := fn(tx)
+	if err != nil {
+		return nil, err
+	}
+	if enrollmentsChanged(results) {
+		if err := touchPerson(ctx, tx, personID); err != nil {
+			return nil, err
+		}
+		if err := recordHistory(ctx, tx, personID); err != nil {
+			return nil, err
+		}
+		after, err := personByID(ctx, tx, personID)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeAudit(ctx, tx, personChange(ctx, AuditEnrollment, &before, &after)); err != nil {
+			return nil, err
+		}
+		if err := publishEviction(ctx, tx, personEviction(personID)); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// enrollmentsChanged reports whether results added or removed an enrollment
+func enrollmentsChanged(results []EnrollmentResult) bool {
+	for _, result := range results {
+		if result.Status == EnrollmentAdded || result.Status == EnrollmentRemoved {
+			return true
+		}
+	}
+	return false
+}
+
+// changeEnrollments runs enrollQuery or unenrollQuery once per course and maps
+// its two flags to a status
+func changeEnrollments(ctx context.Context, tx *sql.Tx, query string, personID int, courseIDs []int, changed, unchanged EnrollmentStatus) ([]EnrollmentResult, error) {
+	results := make([]EnrollmentResult, 0, len(courseIDs))
+	for _, courseID := range courseIDs {
+		var exists, done bool
+		if err := tx.QueryRowContext(ctx, query, personID, courseID).Scan(&exists, &done); err != nil {
+			return nil, err
+		}
+		result := EnrollmentResult{CourseID: courseID, Status: unchanged}
+		switch {
+		case !exists:
+			result.Status = EnrollmentCourseNotFound
+		case done:
+			result.Status = changed
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
 
-        _, err = db.ExecContext(
-            ctx,
-            `INSERT INTO person_course (person_id, course_id) VALUES ($1, $2)`,
-            personID, id,
-        )
-        if err != nil {
-            // Collect errors instead of just continuing
-            errors = append(errors, fmt.Errorf("failed to add course %d: %w", id, err))
-        }
-    }
+// execer is what *sql.DB and *sql.Tx have in common for statements
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
 
-    // If there were any errors, return them combined
-    if len(errors) > 0 {
-        return fmt.Errorf("failed to add some courses: %v", errors)
-    }
+// querier is what *sql.DB and *sql.Tx have in common for multi row reads
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
 
-    return nil
-}
\ No newline at end of file
+// touchPerson bumps a person's version after their enrollments changed, the
+// course list is part of the person so their ETag has to change with it
+func touchPerson(ctx context.Context, db execer, personID int) error {
+	_, err := db.ExecContext(ctx, `UPDATE person SET version = version + 1, updated_at = now() WHERE id = $1`, personID)
+	return err
+}
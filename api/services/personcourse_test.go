@@ -1,11 +1,50 @@
 package services
 
 import (
+	"context"
+	"database/sql"
+	"regexp"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jacob-tech-challenge/api/models"
 )
 
+var lockPersonQuery = regexp.QuoteMeta(`SELECT id FROM person WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`)
+
+var touchPersonQuery = regexp.QuoteMeta(`UPDATE person SET version = version + 1, updated_at = now() WHERE id = $1`)
+
+// expectLockedPerson sets up the lock and read of a person an enrollment change starts with
+func expectLockedPerson(mock sqlmock.Sqlmock, personID int, courses string) {
+	mock.ExpectQuery(lockPersonQuery).WithArgs(personID).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(personID))
+	expectPersonRead(mock, personID, courses, 1)
+}
+
+// expectPersonRead sets up one personByID of a student with the given courses
+func expectPersonRead(mock sqlmock.Sqlmock, personID int, courses string, version int) {
+	mock.ExpectQuery(regexp.QuoteMeta(selectPeople)).WithArgs(personID).
+		WillReturnRows(sqlmock.NewRows(personColumns).AddRow(personID, "John", "Doe", "student", 20, courses, version, testUpdatedAt, nil))
+}
+
+// expectEnrollmentAudit sets up the version bump, revision, read and audit that end a change of enrollments
+func expectEnrollmentAudit(mock sqlmock.Sqlmock, personID int, courses string) {
+	mock.ExpectExec(touchPersonQuery).WithArgs(personID).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectHistory(mock, personID)
+	expectPersonRead(mock, personID, courses, 2)
+	expectAudit(mock, "person", personID, AuditEnrollment)
+	expectEviction(mock, personEviction(personID))
+}
+
+// expectEnrollment sets up one run of enrollQuery or unenrollQuery
+func expectEnrollment(mock sqlmock.Sqlmock, query string, personID, courseID int, exists, done bool) {
+	mock.ExpectQuery(regexp.QuoteMeta(query)).
+		WithArgs(personID, courseID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists", "done"}).AddRow(exists, done))
+}
+
 func TestAddPersonToCourse(t *testing.T) {
 
 	db, mock, err := sqlmock.New()
@@ -13,40 +52,227 @@ func TestAddPersonToCourse(t *testing.T) {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 	defer db.Close()
-	
-	tests := map[string]struct{
-		personID int
-		courseID int
-		expectedErr string
+
+	tests := map[string]struct {
+		personID        int
+		courseIDs       []int
+		mockSetup       func(mock sqlmock.Sqlmock)
+		expectedResults []EnrollmentResult
+		expectedErr     error
 	}{
 		"success": {
-			personID: 1,
-			courseID: 1,
+			personID:  1,
+			courseIDs: []int{1, 2, 9},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectLockedPerson(mock, 1, "{2}")
+				expectEnrollment(mock, enrollQuery, 1, 1, true, true)
+				expectEnrollment(mock, enrollQuery, 1, 2, true, false)
+				expectEnrollment(mock, enrollQuery, 1, 9, false, false)
+				expectEnrollmentAudit(mock, 1, "{1,2}")
+				mock.ExpectCommit()
+			},
+			expectedResults: []EnrollmentResult{
+				{CourseID: 1, Status: EnrollmentAdded},
+				{CourseID: 2, Status: EnrollmentAlreadyEnrolled},
+				{CourseID: 9, Status: EnrollmentCourseNotFound},
+			},
+		},
+		"nothing changed": {
+			personID:  1,
+			courseIDs: []int{2},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectLockedPerson(mock, 1, "{2}")
+				expectEnrollment(mock, enrollQuery, 1, 2, true, false)
+				mock.ExpectCommit()
+			},
+			expectedResults: []EnrollmentResult{
+				{CourseID: 2, Status: EnrollmentAlreadyEnrolled},
+			},
 		},
 		"person not found": {
-			personID: 0,
-			courseID: 1,
-			expectedErr: "failed to add some courses: [failed to add course 1: course not found]",
+			personID:  42,
+			courseIDs: []int{1},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockPersonQuery).WithArgs(42).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectRollback()
+			},
+			expectedErr: ErrNotFound,
 		},
-		"course not found": {
-			personID: 1,
-			courseID: 0,
-			expectedErr: "failed to add some courses: [failed to add course 0: course not found]",
+		"insert fails": {
+			personID:  1,
+			courseIDs: []int{1, 2},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectLockedPerson(mock, 1, "{2}")
+				expectEnrollment(mock, enrollQuery, 1, 1, true, true)
+				mock.ExpectQuery(regexp.QuoteMeta(enrollQuery)).WithArgs(1, 2).WillReturnError(sql.ErrConnDone)
+				mock.ExpectRollback()
+			},
+			expectedErr: sql.ErrConnDone,
 		},
 	}
 
 	for name, tc := range tests {
-		t.Run(name, func(t *testing.T){
-			mock.ExpectQuery(`SELECT id FROM course WHERE id = \$1`).WithArgs(tc.courseID).WillReturnRows(sqlmock.NewRows([]string{"id"}))
-			mock.ExpectQuery(`SELECT id FROM person WHERE id = \$1`).WithArgs(tc.personID).WillReturnRows(sqlmock.NewRows([]string{"id"}))
-			mock.ExpectExec(`INSERT INTO person_course \(person_id, course_id\) VALUES \(\$1, \$2\)`).WithArgs(tc.personID, tc.courseID).WillReturnResult(sqlmock.NewResult(1, 1))
+		t.Run(name, func(t *testing.T) {
+			tc.mockSetup(mock)
 
-			err := AddPersonToCourse(db, tc.personID, []int{tc.courseID})
+			results, err := AddPersonToCourse(context.Background(), db, tc.personID, 0, tc.courseIDs)
 
-			if err != nil && err.Error() != tc.expectedErr {
-				t.Errorf("Expected error: %v, got: %v", tc.expectedErr, err)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedResults, results)
 			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRemovePersonFromCourses(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	expectLockedPerson(mock, 1, "{2}")
+	expectEnrollment(mock, unenrollQuery, 1, 1, true, true)
+	expectEnrollment(mock, unenrollQuery, 1, 3, true, false)
+	expectEnrollmentAudit(mock, 1, "{1,2}")
+	mock.ExpectCommit()
+
+	results, err := RemovePersonFromCourses(context.Background(), db, 1, 1, []int{1, 3})
+	assert.NoError(t, err)
+	assert.Equal(t, []EnrollmentResult{
+		{CourseID: 1, Status: EnrollmentRemoved},
+		{CourseID: 3, Status: EnrollmentNotEnrolled},
+	}, results)
+
+	// a stale version changes nothing
+	mock.ExpectBegin()
+	expectLockedPerson(mock, 1, "{2}")
+	mock.ExpectRollback()
+
+	_, err = RemovePersonFromCourses(context.Background(), db, 1, 3, []int{2})
+	assert.ErrorIs(t, err, ErrPreconditionFailed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSetPersonCourses(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	expectLockedPerson(mock, 1, "{2}")
+	mock.ExpectQuery(`DELETE FROM person_course\s+WHERE person_id = \$1 AND NOT \(course_id = ANY\(\$2\)\)`).
+		WithArgs(1, pq.Int64Array{2, 3}).
+		WillReturnRows(sqlmock.NewRows([]string{"course_id"}).AddRow(4).AddRow(1))
+	expectEnrollment(mock, enrollQuery, 1, 2, true, false)
+	expectEnrollment(mock, enrollQuery, 1, 3, true, true)
+	expectEnrollmentAudit(mock, 1, "{1,2}")
+	mock.ExpectCommit()
+
+	results, err := SetPersonCourses(context.Background(), db, 1, 0, []int{2, 3})
+	assert.NoError(t, err)
+	assert.Equal(t, []EnrollmentResult{
+		{CourseID: 1, Status: EnrollmentRemoved},
+		{CourseID: 4, Status: EnrollmentRemoved},
+		{CourseID: 2, Status: EnrollmentAlreadyEnrolled},
+		{CourseID: 3, Status: EnrollmentAdded},
+	}, results)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
 
+func TestGetPersonCourses(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	tests := map[string]struct {
+		rows            *sqlmock.Rows
+		expectedCourses []models.Course
+		expectedErr     error
+	}{
+		"enrolled": {
+			rows:            sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "Math").AddRow(2, "Science"),
+			expectedCourses: []models.Course{{ID: 1, Name: "Math"}, {ID: 2, Name: "Science"}},
+		},
+		"no courses": {
+			rows:            sqlmock.NewRows([]string{"id", "name"}).AddRow(nil, nil),
+			expectedCourses: []models.Course{},
+		},
+		"person not found": {
+			rows:        sqlmock.NewRows([]string{"id", "name"}),
+			expectedErr: ErrNotFound,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			mock.ExpectQuery(`SELECT c\.id, c\.name\s+FROM person p`).WithArgs(1).WillReturnRows(tc.rows)
+
+			courses, err := GetPersonCourses(context.Background(), db, 1)
+
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedCourses, courses)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestGetCourseRoster(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	countsQuery := `SELECT count\(p\.id\) FILTER \(WHERE p\.type = 'professor'\), count\(p\.id\) FILTER \(WHERE p\.type = 'student'\)\s+FROM course c`
+
+	t.Run("success", func(t *testing.T) {
+		mock.ExpectQuery(countsQuery).WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"professors", "students"}).AddRow(1, 2))
+		mock.ExpectQuery(getAllPeopleQuery).WithArgs("student", 2, 2).
+			WillReturnRows(sqlmock.NewRows(personColumns).
+				AddRow(1, "John", "Doe", "student", 25, "{1,2}", 1, testUpdatedAt, nil).
+				AddRow(4, "Ada", "Lovelace", "student", 28, "{2}", 1, testUpdatedAt, nil))
+
+		roster, next, err := GetCourseRoster(context.Background(), db, 2, "student", Page{Limit: 1})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, roster.Professors)
+		assert.Equal(t, 2, roster.Students)
+		assert.Equal(t, []models.Person{{ID: 1, FirstName: "John", LastName: "Doe", Type: "student", Age: 25, Courses: []int{1, 2}, Version: 1, UpdatedAt: testUpdatedAt}}, roster.People)
+		assert.Equal(t, &Cursor{Keys: []string{"student"}, ID: 1}, next)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("course not found", func(t *testing.T) {
+		mock.ExpectQuery(countsQuery).WithArgs(9).
+			WillReturnRows(sqlmock.NewRows([]string{"professors", "students"}))
+
+		_, _, err := GetCourseRoster(context.Background(), db, 9, "", Page{})
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("invalid type", func(t *testing.T) {
+		_, _, err := GetCourseRoster(context.Background(), db, 2, "teacher", Page{})
+		var filterErr *FilterError
+		assert.ErrorAs(t, err, &filterErr)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
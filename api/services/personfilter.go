@@ -0,0 +1,384 @@
+package services
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jacob-tech-challenge/api/models"
+)
+
+// PersonFilter selects and orders the people returned by GetAllPeople. Zero
+// values mean "no filter", the int pointers let an age of 0 be filtered on.
+type PersonFilter struct {
+	// FirstName and LastName match the whole name, the Prefix variants its beginning
+	FirstName       string
+	LastName        string
+	FirstNamePrefix string
+	LastNamePrefix  string
+	// IgnoreCase makes every name filter case-insensitive
+	IgnoreCase bool
+	Type       string
+	Age        *int
+	AgeGTE     *int
+	AgeLTE     *int
+	// CourseID keeps only people enrolled in that course
+	CourseID int
+	// IncludeDeleted lists soft deleted people along with the live ones
+	IncludeDeleted bool
+	// Sort orders the result, id is always appended as the final tie breaker
+	Sort []SortKey
+}
+
+// SortKey is one field of a sort order
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// FilterError reports an invalid list filter, Param is the query parameter at fault
+type FilterError struct {
+	Param  string
+	Reason string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Param, e.Reason)
+}
+
+// Unwrap makes every FilterError an ErrValidation
+func (e *FilterError) Unwrap() error {
+	return ErrValidation
+}
+
+// personSortField describes a column people can be sorted by
+type personSortField struct {
+	column  string
+	numeric bool
+	value   func(models.Person) string
+}
+
+// personSortFields is the whitelist of sortable fields, nothing else ever reaches the ORDER BY
+var personSortFields = map[string]personSortField{
+	"id":         {column: "p.id", numeric: true, value: func(p models.Person) string { return strconv.Itoa(p.ID) }},
+	"first_name": {column: "p.first_name", value: func(p models.Person) string { return p.FirstName }},
+	"last_name":  {column: "p.last_name", value: func(p models.Person) string { return p.LastName }},
+	"type":       {column: "p.type", value: func(p models.Person) string { return p.Type }},
+	"age":        {column: "p.age", numeric: true, value: func(p models.Person) string { return strconv.Itoa(p.Age) }},
+}
+
+// ParseSort parses a sort parameter such as "-age,last_name", a leading - sorts descending
+func ParseSort(s string) ([]SortKey, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var keys []SortKey
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ",") {
+		key := SortKey{Field: strings.TrimSpace(part)}
+		if strings.HasPrefix(key.Field, "-") {
+			key.Desc = true
+			key.Field = key.Field[1:]
+		}
+		if _, ok := personSortFields[key.Field]; !ok {
+			return nil, &FilterError{Param: "sort", Reason: fmt.Sprintf("unknown field %q, expected one of %s", key.Field, strings.Join(sortFieldNames(), ", "))}
+		}
+		if seen[key.Field] {
+			return nil, &FilterError{Param: "sort", Reason: fmt.Sprintf("field %q is listed twice", key.Field)}
+		}
+		seen[key.Field] = true
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Validate checks the values of the filter
+func (f PersonFilter) Validate() error {
+	if f.Type != "" && f.Type != "professor" && f.Type != "student" {
+		return &FilterError{Param: "type", Reason: `must be "professor" or "student"`}
+	}
+	for param, age := range map[string]*int{"age": f.Age, "age_gte": f.AgeGTE, "age_lte": f.AgeLTE} {
+		if age != nil && *age < 0 {
+			return &FilterError{Param: param, Reason: "must not be negative"}
+		}
+	}
+	if f.AgeGTE != nil && f.AgeLTE != nil && *f.AgeGTE > *f.AgeLTE {
+		return &FilterError{Param: "age_gte", Reason: "must not be greater than age_lte"}
+	}
+	if f.CourseID < 0 {
+		return &FilterError{Param: "course", Reason: "must be a positive integer"}
+	}
+	for _, key := range f.Sort {
+		if _, ok := personSortFields[key.Field]; !ok {
+			return &FilterError{Param: "sort", Reason: fmt.Sprintf("unknown field %q", key.Field)}
+		}
+	}
+	return nil
+}
+
+// sortKeys returns the full ordering of the list: the requested keys then id
+func (f PersonFilter) sortKeys() []SortKey {
+	keys := make([]SortKey, 0, len(f.Sort)+1)
+	for _, key := range f.Sort {
+		if key.Field != "id" {
+			keys = append(keys, key)
+		}
+	}
+	idDesc := false
+	for _, key := range f.Sort {
+		if key.Field == "id" {
+			idDesc = key.Desc
+		}
+	}
+	return append(keys, SortKey{Field: "id", Desc: idDesc})
+}
+
+// cursor returns the cursor of person for this filter's ordering
+func (f PersonFilter) cursor(person models.Person) Cursor {
+	c := Cursor{ID: person.ID}
+	for _, key := range f.sortKeys() {
+		if key.Field != "id" {
+			c.Keys = append(c.Keys, personSortFields[key.Field].value(person))
+		}
+	}
+	return c
+}
+
+// checkCursor makes sure a cursor was issued for this filter's ordering
+func (f PersonFilter) checkCursor(c *Cursor) error {
+	if c == nil {
+		return nil
+	}
+	keys := f.sortKeys()
+	if len(c.Keys) != len(keys)-1 {
+		return ErrInvalidCursor
+	}
+	for i, key := range keys[:len(keys)-1] {
+		if personSortFields[key.Field].numeric {
+			if _, err := strconv.Atoi(c.Keys[i]); err != nil {
+				return ErrInvalidCursor
+			}
+		}
+	}
+	return nil
+}
+
+// personQuery builds the SQL for GetAllPeople. Values only ever travel as
+// bind parameters and column names only come from personSortFields.
+type personQuery struct {
+	where []string
+	args  []interface{}
+}
+
+// arg adds a bind parameter and returns its placeholder
+func (q *personQuery) arg(v interface{}) string {
+	q.args = append(q.args, v)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+// nameCondition matches a name column exactly or by prefix
+func (q *personQuery) nameCondition(column, value string, prefix, ignoreCase bool) {
+	switch {
+	case prefix && ignoreCase:
+		q.where = append(q.where, fmt.Sprintf(`%s ILIKE %s`, column, q.arg(escapeLike(value)+"%")))
+	case prefix:
+		q.where = append(q.where, fmt.Sprintf(`%s LIKE %s`, column, q.arg(escapeLike(value)+"%")))
+	case ignoreCase:
+		q.where = append(q.where, fmt.Sprintf(`lower(%s) = lower(%s)`, column, q.arg(value)))
+	default:
+		q.where = append(q.where, fmt.Sprintf(`%s = %s`, column, q.arg(value)))
+	}
+}
+
+// buildPersonQuery returns the query and arguments listing one page of people
+func buildPersonQuery(f PersonFilter, page Page) (string, []interface{}) {
+	q := &personQuery{}
+
+	if !f.IncludeDeleted {
+		q.where = append(q.where, "p.deleted_at IS NULL")
+	}
+	if f.FirstName != "" {
+		q.nameCondition("p.first_name", f.FirstName, false, f.IgnoreCase)
+	}
+	if f.LastName != "" {
+		q.nameCondition("p.last_name", f.LastName, false, f.IgnoreCase)
+	}
+	if f.FirstNamePrefix != "" {
+		q.nameCondition("p.first_name", f.FirstNamePrefix, true, f.IgnoreCase)
+	}
+	if f.LastNamePrefix != "" {
+		q.nameCondition("p.last_name", f.LastNamePrefix, true, f.IgnoreCase)
+	}
+	if f.Type != "" {
+		q.where = append(q.where, "p.type = "+q.arg(f.Type))
+	}
+	if f.Age != nil {
+		q.where = append(q.where, "p.age = "+q.arg(*f.Age))
+	}
+	if f.AgeGTE != nil {
+		q.where = append(q.where, "p.age >= "+q.arg(*f.AgeGTE))
+	}
+	if f.AgeLTE != nil {
+		q.where = append(q.where, "p.age <= "+q.arg(*f.AgeLTE))
+	}
+	if f.CourseID != 0 {
+		q.where = append(q.where, "EXISTS (SELECT 1 FROM person_course f JOIN course fc ON fc.id = f.course_id WHERE f.person_id = p.id AND f.course_id = "+q.arg(f.CourseID)+" AND fc.deleted_at IS NULL)")
+	}
+
+	keys := f.sortKeys()
+	if page.After != nil {
+		q.where = append(q.where, q.keyset(keys, page.After))
+	}
+
+	var order []string
+	for _, key := range keys {
+		direction := "ASC"
+		if key.Desc {
+			direction = "DESC"
+		}
+		order = append(order, personSortFields[key.Field].column+" "+direction)
+	}
+
+	query := selectPeople
+	if len(q.where) > 0 {
+		query += "\n\t\tWHERE " + strings.Join(q.where, " AND ")
+	}
+	query += "\n\t\tGROUP BY p.id\n\t\tORDER BY " + strings.Join(order, ", ")
+	query += "\n\t\tLIMIT " + q.arg(page.limitArg())
+	return query, q.args
+}
+
+// keyset returns the condition selecting the rows after the cursor. With mixed
+// directions a row comparison does not work, so it is spelled out:
+// (k1 > v1) OR (k1 = v1 AND k2 < v2) OR ...
+func (q *personQuery) keyset(keys []SortKey, after *Cursor) string {
+	values := make([]interface{}, len(keys))
+	for i, key := range keys[:len(keys)-1] {
+		if personSortFields[key.Field].numeric {
+			n, _ := strconv.Atoi(after.Keys[i])
+			values[i] = n
+		} else {
+			values[i] = after.Keys[i]
+		}
+	}
+	values[len(keys)-1] = after.ID
+
+	placeholders := make([]string, len(keys))
+	for i := range keys {
+		placeholders[i] = q.arg(values[i])
+	}
+
+	var or []string
+	for i, key := range keys {
+		var and []string
+		for j := 0; j < i; j++ {
+			and = append(and, personSortFields[keys[j].Field].column+" = "+placeholders[j])
+		}
+		op := ">"
+		if key.Desc {
+			op = "<"
+		}
+		and = append(and, personSortFields[key.Field].column+" "+op+" "+placeholders[i])
+		or = append(or, "("+strings.Join(and, " AND ")+")")
+	}
+	return "(" + strings.Join(or, " OR ") + ")"
+}
+
+// matches reports whether person, with their live courses filled in, passes
+// the filter. It is the in-memory twin of buildPersonQuery.
+func (f PersonFilter) matches(person models.Person) bool {
+	if !f.IncludeDeleted && person.DeletedAt != nil {
+		return false
+	}
+	if f.FirstName != "" && !matchName(person.FirstName, f.FirstName, false, f.IgnoreCase) {
+		return false
+	}
+	if f.LastName != "" && !matchName(person.LastName, f.LastName, false, f.IgnoreCase) {
+		return false
+	}
+	if f.FirstNamePrefix != "" && !matchName(person.FirstName, f.FirstNamePrefix, true, f.IgnoreCase) {
+		return false
+	}
+	if f.LastNamePrefix != "" && !matchName(person.LastName, f.LastNamePrefix, true, f.IgnoreCase) {
+		return false
+	}
+	if f.Type != "" && person.Type != f.Type {
+		return false
+	}
+	if f.Age != nil && person.Age != *f.Age {
+		return false
+	}
+	if f.AgeGTE != nil && person.Age < *f.AgeGTE {
+		return false
+	}
+	if f.AgeLTE != nil && person.Age > *f.AgeLTE {
+		return false
+	}
+	if f.CourseID != 0 && !slices.Contains(person.Courses, f.CourseID) {
+		return false
+	}
+	return true
+}
+
+// sortPeople orders people by the filter's sort keys
+func (f PersonFilter) sortPeople(people []models.Person) {
+	keys := f.sortKeys()
+	sort.SliceStable(people, func(i, j int) bool {
+		return comparePeople(keys, f.cursor(people[i]), f.cursor(people[j])) < 0
+	})
+}
+
+// after reports whether person comes after the cursor in the filter's ordering
+func (f PersonFilter) after(person models.Person, c *Cursor) bool {
+	return c == nil || comparePeople(f.sortKeys(), f.cursor(person), *c) > 0
+}
+
+// comparePeople compares two positions in an ordering, taking each key's direction into account
+func comparePeople(keys []SortKey, a, b Cursor) int {
+	for i, key := range keys {
+		var cmp int
+		if key.Field == "id" {
+			cmp = a.ID - b.ID
+		} else if personSortFields[key.Field].numeric {
+			x, _ := strconv.Atoi(a.Keys[i])
+			y, _ := strconv.Atoi(b.Keys[i])
+			cmp = x - y
+		} else {
+			cmp = strings.Compare(a.Keys[i], b.Keys[i])
+		}
+		if key.Desc {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp
+		}
+	}
+	return 0
+}
+
+// matchName compares a name the way the SQL conditions do
+func matchName(name, value string, prefix, ignoreCase bool) bool {
+	if ignoreCase {
+		name, value = strings.ToLower(name), strings.ToLower(value)
+	}
+	if prefix {
+		return strings.HasPrefix(name, value)
+	}
+	return name == value
+}
+
+// escapeLike escapes the LIKE wildcards in a user supplied value
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func sortFieldNames() []string {
+	names := make([]string, 0, len(personSortFields))
+	for name := range personSortFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
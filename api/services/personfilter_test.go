@@ -0,0 +1,117 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSort(t *testing.T) {
+	tests := map[string]struct {
+		input       string
+		expected    []SortKey
+		expectedErr string
+	}{
+		"empty":      {input: ""},
+		"mixed":      {input: "-age,last_name", expected: []SortKey{{Field: "age", Desc: true}, {Field: "last_name"}}},
+		"whitespace": {input: " type , -id", expected: []SortKey{{Field: "type"}, {Field: "id", Desc: true}}},
+		"unknown field": {
+			input:       "age;DROP TABLE person",
+			expectedErr: `invalid sort: unknown field "age;DROP TABLE person", expected one of age, first_name, id, last_name, type`,
+		},
+		"duplicate field": {input: "age,-age", expectedErr: `invalid sort: field "age" is listed twice`},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			keys, err := ParseSort(tc.input)
+			if tc.expectedErr != "" {
+				assert.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, keys)
+		})
+	}
+}
+
+func TestPersonFilterValidate(t *testing.T) {
+	tests := map[string]struct {
+		filter        PersonFilter
+		expectedParam string
+	}{
+		"valid":              {filter: PersonFilter{Type: "student", AgeGTE: intPtr(18), AgeLTE: intPtr(30)}},
+		"bad type":           {filter: PersonFilter{Type: "teacher"}, expectedParam: "type"},
+		"negative age":       {filter: PersonFilter{AgeLTE: intPtr(-1)}, expectedParam: "age_lte"},
+		"empty age range":    {filter: PersonFilter{AgeGTE: intPtr(40), AgeLTE: intPtr(30)}, expectedParam: "age_gte"},
+		"unknown sort field": {filter: PersonFilter{Sort: []SortKey{{Field: "password"}}}, expectedParam: "sort"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.filter.Validate()
+			if tc.expectedParam == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var filterErr *FilterError
+			if assert.ErrorAs(t, err, &filterErr) {
+				assert.Equal(t, tc.expectedParam, filterErr.Param)
+			}
+		})
+	}
+}
+
+func TestBuildPersonQuery(t *testing.T) {
+	tests := map[string]struct {
+		filter        PersonFilter
+		page          Page
+		expectedWhere string
+		expectedOrder string
+		expectedArgs  []interface{}
+	}{
+		"no filters": {
+			expectedWhere: "WHERE p.deleted_at IS NULL",
+			expectedOrder: "ORDER BY p.id ASC",
+			expectedArgs:  []interface{}{nil},
+		},
+		"include deleted": {
+			filter:        PersonFilter{IncludeDeleted: true},
+			expectedOrder: "ORDER BY p.id ASC",
+			expectedArgs:  []interface{}{nil},
+		},
+		"every filter": {
+			filter: PersonFilter{
+				FirstName: "Steve", LastNamePrefix: "Jo_", IgnoreCase: true, Type: "professor",
+				AgeGTE: intPtr(30), AgeLTE: intPtr(60), CourseID: 2,
+			},
+			page:          Page{Limit: 10},
+			expectedWhere: `WHERE p.deleted_at IS NULL AND lower(p.first_name) = lower($1) AND p.last_name ILIKE $2 AND p.type = $3 AND p.age >= $4 AND p.age <= $5 AND EXISTS (SELECT 1 FROM person_course f JOIN course fc ON fc.id = f.course_id WHERE f.person_id = p.id AND f.course_id = $6 AND fc.deleted_at IS NULL)`,
+			expectedOrder: "ORDER BY p.id ASC",
+			expectedArgs:  []interface{}{"Steve", `Jo\_%`, "professor", 30, 60, 2, 11},
+		},
+		"keyset on mixed directions": {
+			filter:        PersonFilter{Sort: []SortKey{{Field: "age", Desc: true}, {Field: "last_name"}}},
+			page:          Page{Limit: 5, After: &Cursor{Keys: []string{"56", "Jobs"}, ID: 1}},
+			expectedWhere: `WHERE p.deleted_at IS NULL AND ((p.age < $1) OR (p.age = $1 AND p.last_name > $2) OR (p.age = $1 AND p.last_name = $2 AND p.id > $3))`,
+			expectedOrder: "ORDER BY p.age DESC, p.last_name ASC, p.id ASC",
+			expectedArgs:  []interface{}{56, "Jobs", 1, 6},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			query, args := buildPersonQuery(tc.filter, tc.page)
+			if tc.expectedWhere == "" {
+				assert.NotContains(t, query, "\n\t\tWHERE")
+			} else {
+				assert.Contains(t, query, tc.expectedWhere)
+			}
+			assert.Contains(t, query, tc.expectedOrder)
+			assert.True(t, strings.HasSuffix(query, fmt.Sprintf("LIMIT $%d", len(args))), query)
+			assert.Equal(t, tc.expectedArgs, args)
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/jacob-tech-challenge/api/models"
+)
+
+// Purged counts the rows a purge removed for good
+type Purged struct {
+	People  int64
+	Courses int64
+}
+
+// PurgeDeleted removes for good the people and courses soft deleted before
+// the cutoff, and every enrollment they had, in one transaction. Each purged
+// row is audited with its last state.
+func PurgeDeleted(ctx context.Context, db *sql.DB, before time.Time) (Purged, error) {
+	return inTx(ctx, db, func(tx *sql.Tx) (Purged, error) {
+		people, err := purgeablePeople(ctx, tx, before)
+		if err != nil {
+			return Purged{}, err
+		}
+		courses, err := purgeableCourses(ctx, tx, before)
+		if err != nil {
+			return Purged{}, err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM person_course
+			WHERE person_id IN (SELECT id FROM person WHERE deleted_at < $1)
+				OR course_id IN (SELECT id FROM course WHERE deleted_at < $1)`, before); err != nil {
+			return Purged{}, err
+		}
+		var purged Purged
+		var entries []AuditEntry
+		result, err := tx.ExecContext(ctx, `DELETE FROM person WHERE deleted_at < $1`, before)
+		if err != nil {
+			return Purged{}, err
+		}
+		if purged.People, err = result.RowsAffected(); err != nil {
+			return Purged{}, err
+		}
+		for i := range people {
+			entries = append(entries, personChange(ctx, AuditPurge, &people[i], nil))
+		}
+		result, err = tx.ExecContext(ctx, `DELETE FROM course WHERE deleted_at < $1`, before)
+		if err != nil {
+			return Purged{}, err
+		}
+		if purged.Courses, err = result.RowsAffected(); err != nil {
+			return Purged{}, err
+		}
+		for i := range courses {
+			entries = append(entries, courseChange(ctx, AuditPurge, &courses[i], nil))
+		}
+		if err := writeAudit(ctx, tx, entries...); err != nil {
+			return Purged{}, err
+		}
+		// only the course lists that include deleted courses may hold what was purged
+		if err := publishEviction(ctx, tx, Eviction{Prefixes: []string{coursesKeyPrefix}}); err != nil {
+			return Purged{}, err
+		}
+		return purged, nil
+	})
+}
+
+// purgeablePeople locks and returns the people PurgeDeleted removes
+func purgeablePeople(ctx context.Context, tx *sql.Tx, before time.Time) ([]models.Person, error) {
+	// aggregates cannot be locked, so the rows are locked on their own first
+	if _, err := tx.ExecContext(ctx, `SELECT id FROM person WHERE deleted_at < $1 FOR UPDATE`, before); err != nil {
+		return nil, err
+	}
+	return queryPeople(ctx, tx, `WHERE p.deleted_at < $1`, before)
+}
+
+// purgeableCourses locks and returns the courses PurgeDeleted removes
+func purgeableCourses(ctx context.Context, tx *sql.Tx, before time.Time) ([]models.Course, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT id, name, version, updated_at, deleted_at FROM course WHERE deleted_at < $1 ORDER BY id FOR UPDATE`, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var courses []models.Course
+	for rows.Next() {
+		var course models.Course
+		if err := rows.Scan(&course.ID, &course.Name, &course.Version, &course.UpdatedAt, &course.DeletedAt); err != nil {
+			return nil, err
+		}
+		courses = append(courses, course)
+	}
+	return courses, rows.Err()
+}
+
+// RunPurge purges what was soft deleted more than retention ago, once every
+// interval until ctx is done. A failed purge is logged and tried again at the
+// next tick.
+func RunPurge(ctx context.Context, purger Purger, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			purged, err := purger.PurgeDeleted(WithActor(ctx, Actor{Name: "purge"}), now.Add(-retention))
+			if err != nil {
+				log.Printf("Failed to purge deleted rows. err: %v", err)
+				continue
+			}
+			if purged.People > 0 || purged.Courses > 0 {
+				log.Printf("Purged %d people and %d courses deleted more than %s ago", purged.People, purged.Courses, retention)
+			}
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPurgeDeleted(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	before := time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC)
+	const enrollmentsQuery = `DELETE FROM person_course\s+WHERE person_id IN \(SELECT id FROM person WHERE deleted_at < \$1\)\s+OR course_id IN \(SELECT id FROM course WHERE deleted_at < \$1\)`
+
+	lockPeopleQuery := regexp.QuoteMeta(`SELECT id FROM person WHERE deleted_at < $1 FOR UPDATE`)
+	peopleQuery := regexp.QuoteMeta(selectPeople) + `\s+WHERE p\.deleted_at < \$1\s+GROUP BY p\.id\s+ORDER BY p\.id`
+	coursesQuery := regexp.QuoteMeta(`SELECT id, name, version, updated_at, deleted_at FROM course WHERE deleted_at < $1 ORDER BY id FOR UPDATE`)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(lockPeopleQuery).WithArgs(before).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery(peopleQuery).WithArgs(before).
+		WillReturnRows(sqlmock.NewRows(personColumns).
+			AddRow(1, "John", "Doe", "student", 20, "{1}", 2, testUpdatedAt, testUpdatedAt).
+			AddRow(2, "Jane", "Doe", "professor", 40, "{}", 3, testUpdatedAt, testUpdatedAt))
+	mock.ExpectQuery(coursesQuery).WithArgs(before).
+		WillReturnRows(sqlmock.NewRows(courseListColumns).AddRow(4, "Math", 2, testUpdatedAt, testUpdatedAt))
+	mock.ExpectExec(enrollmentsQuery).WithArgs(before).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(`DELETE FROM person WHERE deleted_at < \$1`).WithArgs(before).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`DELETE FROM course WHERE deleted_at < \$1`).WithArgs(before).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(auditQuery).
+		WithArgs(pq.StringArray{"purge", "purge", "purge"}, sqlmock.AnyArg(),
+			pq.StringArray{"person", "person", "course"}, pq.Int64Array{1, 2, 4}, pq.StringArray{AuditPurge, AuditPurge, AuditPurge},
+			sqlmock.AnyArg(), pq.StringArray{"", "", ""}).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	expectEviction(mock, Eviction{Prefixes: []string{coursesKeyPrefix}})
+	mock.ExpectCommit()
+
+	ctx := WithActor(context.Background(), Actor{Name: "purge"})
+	purged, err := NewPostgresStore(db).PurgeDeleted(ctx, before)
+	assert.NoError(t, err)
+	assert.Equal(t, Purged{People: 2, Courses: 1}, purged)
+
+	// a failure purges nothing
+	mock.ExpectBegin()
+	mock.ExpectExec(lockPeopleQuery).WithArgs(before).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(peopleQuery).WithArgs(before).WillReturnRows(sqlmock.NewRows(personColumns))
+	mock.ExpectQuery(coursesQuery).WithArgs(before).WillReturnRows(sqlmock.NewRows(courseListColumns))
+	mock.ExpectExec(enrollmentsQuery).WithArgs(before).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(`DELETE FROM person WHERE deleted_at < \$1`).WithArgs(before).WillReturnError(sql.ErrConnDone)
+	mock.ExpectRollback()
+
+	_, err = PurgeDeleted(context.Background(), db, before)
+	assert.ErrorIs(t, err, sql.ErrConnDone)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// recordingPurger keeps the cutoffs it is asked to purge before
+type recordingPurger struct {
+	cutoffs chan time.Time
+}
+
+func (p *recordingPurger) PurgeDeleted(ctx context.Context, before time.Time) (Purged, error) {
+	select {
+	case p.cutoffs <- before:
+	case <-ctx.Done():
+	}
+	return Purged{}, ctx.Err()
+}
+
+func TestRunPurge(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	purger := &recordingPurger{cutoffs: make(chan time.Time)}
+	done := make(chan struct{})
+	go func() {
+		RunPurge(ctx, purger, 24*time.Hour, time.Millisecond)
+		close(done)
+	}()
+
+	cutoff := <-purger.cutoffs
+	assert.WithinDuration(t, time.Now().Add(-24*time.Hour), cutoff, time.Minute)
+	cancel()
+	<-done
+}
@@ -0,0 +1,266 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jacob-tech-challenge/api/models"
+)
+
+// CourseStore is the set of operations the handlers need for courses
+type CourseStore interface {
+	GetAllCourses(ctx context.Context, filter CourseFilter, page Page) ([]models.Course, *Cursor, error)
+	GetCourseByID(ctx context.Context, id int) (models.Course, error)
+	UpdateCourse(ctx context.Context, id int, course models.Course) (models.Course, error)
+	CreateCourse(ctx context.Context, course models.Course) (models.Course, error)
+	DeleteCourse(ctx context.Context, id, version int, deletion CourseDeletion) (CourseDeleted, error)
+	RestoreCourse(ctx context.Context, id int) (models.Course, error)
+	PatchCourse(ctx context.Context, id, version int, patch CoursePatch) (models.Course, error)
+	MissingCourseIDs(ctx context.Context, ids []int) ([]int, error)
+	CoursesModified(ctx context.Context) (time.Time, error)
+}
+
+// PersonStore is the set of operations the handlers need for people
+type PersonStore interface {
+	GetAllPeople(ctx context.Context, filter PersonFilter, page Page) ([]models.Person, *Cursor, error)
+	GetPersonByID(ctx context.Context, id int) (models.Person, error)
+	UpdatePerson(ctx context.Context, id int, person models.Person) (models.Person, error)
+	PatchPerson(ctx context.Context, id, version int, patch PersonPatch) (models.Person, error)
+	CreatePerson(ctx context.Context, person models.Person) (models.Person, error)
+	DeletePerson(ctx context.Context, id, version int) error
+	RestorePerson(ctx context.Context, id int) (models.Person, error)
+	GetPersonHistory(ctx context.Context, id int, page Page) ([]PersonRevision, *Cursor, error)
+	GetPersonAsOf(ctx context.Context, id int, at time.Time) (models.Person, error)
+	PeopleModified(ctx context.Context) (time.Time, error)
+}
+
+// EnrollmentStore is the set of operations on the person_course relationship
+type EnrollmentStore interface {
+	GetCoursesByPersonID(ctx context.Context, personID int) ([]int, error)
+	GetPersonCourses(ctx context.Context, personID int) ([]models.Course, error)
+	AddPersonToCourse(ctx context.Context, personID, version int, courseIDs []int) ([]EnrollmentResult, error)
+	RemovePersonFromCourses(ctx context.Context, personID, version int, courseIDs []int) ([]EnrollmentResult, error)
+	SetPersonCourses(ctx context.Context, personID, version int, courseIDs []int) ([]EnrollmentResult, error)
+	GetCourseRoster(ctx context.Context, courseID int, personType string, page Page) (Roster, *Cursor, error)
+}
+
+// Purger removes for good what was soft deleted long enough ago
+type Purger interface {
+	PurgeDeleted(ctx context.Context, before time.Time) (Purged, error)
+}
+
+// AuditStore reads the audit log every write appends to
+type AuditStore interface {
+	GetAuditLog(ctx context.Context, filter AuditFilter, page Page) ([]AuditEntry, *Cursor, error)
+}
+
+// Batcher runs an ordered list of writes all or nothing
+type Batcher interface {
+	RunBatch(ctx context.Context, ops []BatchOp) ([]BatchResult, error)
+}
+
+// IdempotencyStore keeps the responses of requests sent with an idempotency
+// key, so retries of them can be answered without running them twice. Keys
+// are scoped by the actor of the context.
+type IdempotencyStore interface {
+	ClaimIdempotencyKey(ctx context.Context, key, fingerprint string, ttl, lease time.Duration) (*IdempotentResponse, error)
+	SaveIdempotentResponse(ctx context.Context, key string, response IdempotentResponse) error
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+}
+
+// Store groups every store interface, it is what the router is built from
+type Store interface {
+	CourseStore
+	PersonStore
+	EnrollmentStore
+	Purger
+	AuditStore
+	Batcher
+	IdempotencyStore
+}
+
+// PostgresStore implements Store on top of a postgres database
+type PostgresStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore returns a Store backed by the given database
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// inTx runs fn in a transaction, which is committed when fn succeeds and
+// rolled back otherwise
+func inTx[T any](ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) (T, error)) (T, error) {
+	var zero T
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return zero, err
+	}
+	defer tx.Rollback()
+
+	result, err := fn(tx)
+	if err != nil {
+		return zero, err
+	}
+	if err := tx.Commit(); err != nil {
+		return zero, err
+	}
+	return result, nil
+}
+
+// GetAllCourses returns a page of the courses matching the filter
+func (s *PostgresStore) GetAllCourses(ctx context.Context, filter CourseFilter, page Page) ([]models.Course, *Cursor, error) {
+	return GetAllCourses(ctx, s.db, filter, page)
+}
+
+// GetCourseByID returns a course by id
+func (s *PostgresStore) GetCourseByID(ctx context.Context, id int) (models.Course, error) {
+	return GetCourseByID(ctx, s.db, id)
+}
+
+// UpdateCourse updates a course
+func (s *PostgresStore) UpdateCourse(ctx context.Context, id int, course models.Course) (models.Course, error) {
+	return UpdateCourse(ctx, s.db, id, course)
+}
+
+// CreateCourse creates a course
+func (s *PostgresStore) CreateCourse(ctx context.Context, course models.Course) (models.Course, error) {
+	return CreateCourse(ctx, s.db, course)
+}
+
+// DeleteCourse soft deletes a course, dealing with its enrollments as deletion says
+func (s *PostgresStore) DeleteCourse(ctx context.Context, id, version int, deletion CourseDeletion) (CourseDeleted, error) {
+	return DeleteCourse(ctx, s.db, id, version, deletion)
+}
+
+// RestoreCourse undoes the soft delete of a course
+func (s *PostgresStore) RestoreCourse(ctx context.Context, id int) (models.Course, error) {
+	return RestoreCourse(ctx, s.db, id)
+}
+
+// PatchCourse changes a course to what patch makes of it, in one transaction
+func (s *PostgresStore) PatchCourse(ctx context.Context, id, version int, patch CoursePatch) (models.Course, error) {
+	return PatchCourse(ctx, s.db, id, version, patch)
+}
+
+// MissingCourseIDs returns the ids that do not belong to a course
+func (s *PostgresStore) MissingCourseIDs(ctx context.Context, ids []int) ([]int, error) {
+	return MissingCourseIDs(ctx, s.db, ids)
+}
+
+// CoursesModified returns when a course was last written
+func (s *PostgresStore) CoursesModified(ctx context.Context) (time.Time, error) {
+	return LastModified(ctx, s.db, "course")
+}
+
+// GetAllPeople returns a page of people matching the filter
+func (s *PostgresStore) GetAllPeople(ctx context.Context, filter PersonFilter, page Page) ([]models.Person, *Cursor, error) {
+	return GetAllPeople(ctx, s.db, filter, page)
+}
+
+// GetPersonByID returns a person by id
+func (s *PostgresStore) GetPersonByID(ctx context.Context, id int) (models.Person, error) {
+	return GetPersonByID(ctx, s.db, id)
+}
+
+// UpdatePerson updates a person by id
+func (s *PostgresStore) UpdatePerson(ctx context.Context, id int, person models.Person) (models.Person, error) {
+	return UpdatePerson(ctx, s.db, id, person)
+}
+
+// PatchPerson changes a person and their courses to what patch makes of them, in one transaction
+func (s *PostgresStore) PatchPerson(ctx context.Context, id, version int, patch PersonPatch) (models.Person, error) {
+	return PatchPerson(ctx, s.db, id, version, patch)
+}
+
+// CreatePerson creates a person
+func (s *PostgresStore) CreatePerson(ctx context.Context, person models.Person) (models.Person, error) {
+	return CreatePerson(ctx, s.db, person)
+}
+
+// DeletePerson soft deletes a person by id
+func (s *PostgresStore) DeletePerson(ctx context.Context, id, version int) error {
+	return DeletePerson(ctx, s.db, id, version)
+}
+
+// RestorePerson undoes the soft delete of a person
+func (s *PostgresStore) RestorePerson(ctx context.Context, id int) (models.Person, error) {
+	return RestorePerson(ctx, s.db, id)
+}
+
+// GetPersonHistory returns a page of the revisions of a person
+func (s *PostgresStore) GetPersonHistory(ctx context.Context, id int, page Page) ([]PersonRevision, *Cursor, error) {
+	return GetPersonHistory(ctx, s.db, id, page)
+}
+
+// GetPersonAsOf returns a person as they were at a point in time
+func (s *PostgresStore) GetPersonAsOf(ctx context.Context, id int, at time.Time) (models.Person, error) {
+	return GetPersonAsOf(ctx, s.db, id, at)
+}
+
+// PeopleModified returns when a person was last written
+func (s *PostgresStore) PeopleModified(ctx context.Context) (time.Time, error) {
+	return LastModified(ctx, s.db, "person")
+}
+
+// PurgeDeleted removes for good the people and courses soft deleted before the cutoff
+func (s *PostgresStore) PurgeDeleted(ctx context.Context, before time.Time) (Purged, error) {
+	return PurgeDeleted(ctx, s.db, before)
+}
+
+// GetAuditLog returns a page of the audit entries matching the filter
+func (s *PostgresStore) GetAuditLog(ctx context.Context, filter AuditFilter, page Page) ([]AuditEntry, *Cursor, error) {
+	return GetAuditLog(ctx, s.db, filter, page)
+}
+
+// GetCoursesByPersonID returns all course ids for a person
+func (s *PostgresStore) GetCoursesByPersonID(ctx context.Context, personID int) ([]int, error) {
+	return GetCoursesByPersonID(ctx, s.db, personID)
+}
+
+// GetPersonCourses returns the courses a person is enrolled in
+func (s *PostgresStore) GetPersonCourses(ctx context.Context, personID int) ([]models.Course, error) {
+	return GetPersonCourses(ctx, s.db, personID)
+}
+
+// AddPersonToCourse adds a person to multiple courses
+func (s *PostgresStore) AddPersonToCourse(ctx context.Context, personID, version int, courseIDs []int) ([]EnrollmentResult, error) {
+	return AddPersonToCourse(ctx, s.db, personID, version, courseIDs)
+}
+
+// RemovePersonFromCourses removes a person from multiple courses
+func (s *PostgresStore) RemovePersonFromCourses(ctx context.Context, personID, version int, courseIDs []int) ([]EnrollmentResult, error) {
+	return RemovePersonFromCourses(ctx, s.db, personID, version, courseIDs)
+}
+
+// SetPersonCourses replaces the courses a person is enrolled in
+func (s *PostgresStore) SetPersonCourses(ctx context.Context, personID, version int, courseIDs []int) ([]EnrollmentResult, error) {
+	return SetPersonCourses(ctx, s.db, personID, version, courseIDs)
+}
+
+// GetCourseRoster returns a page of the people enrolled in a course
+func (s *PostgresStore) GetCourseRoster(ctx context.Context, courseID int, personType string, page Page) (Roster, *Cursor, error) {
+	return GetCourseRoster(ctx, s.db, courseID, personType, page)
+}
+
+// RunBatch runs ops in one transaction
+func (s *PostgresStore) RunBatch(ctx context.Context, ops []BatchOp) ([]BatchResult, error) {
+	return RunBatch(ctx, s.db, ops)
+}
+
+// ClaimIdempotencyKey claims key for a request, or returns the response stored under it
+func (s *PostgresStore) ClaimIdempotencyKey(ctx context.Context, key, fingerprint string, ttl, lease time.Duration) (*IdempotentResponse, error) {
+	return ClaimIdempotencyKey(ctx, s.db, key, fingerprint, ttl, lease)
+}
+
+// SaveIdempotentResponse stores the response of the request that claimed key
+func (s *PostgresStore) SaveIdempotentResponse(ctx context.Context, key string, response IdempotentResponse) error {
+	return SaveIdempotentResponse(ctx, s.db, key, response)
+}
+
+// ReleaseIdempotencyKey gives up a claim without a response
+func (s *PostgresStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	return ReleaseIdempotencyKey(ctx, s.db, key)
+}
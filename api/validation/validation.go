@@ -0,0 +1,140 @@
+// Package validation checks person and course payloads before they reach a
+// store. Every rule runs, so a client gets all of its mistakes in one answer
+// instead of fixing them one request at a time.
+package validation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jacob-tech-challenge/api/models"
+	"github.com/jacob-tech-challenge/api/services"
+)
+
+// Bounds of the person and course fields
+const (
+	MinAge        = 1
+	MaxAge        = 150
+	MaxNameLength = 100
+)
+
+// FieldError is one rule a field breaks. Field is named as in the JSON body.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// Errors is every rule a payload breaks, it is an ErrValidation
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldErr := range e {
+		messages[i] = fieldErr.Field + " " + fieldErr.Message
+	}
+	return strings.Join(messages, ", ")
+}
+
+// Unwrap makes Errors an ErrValidation
+func (e Errors) Unwrap() error {
+	return services.ErrValidation
+}
+
+// add records a broken rule
+func (e *Errors) add(field, format string, args ...any) {
+	*e = append(*e, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// err returns the errors as an error, or nil when there are none
+func (e Errors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// CourseLookup finds which course ids do not exist, services.CourseStore is one
+type CourseLookup interface {
+	MissingCourseIDs(ctx context.Context, ids []int) ([]int, error)
+}
+
+// Person checks every field of a person, including that the courses exist. It
+// returns Errors when rules are broken, or the lookup's error when it fails. A
+// nil lookup leaves the courses to a store that checks them as it writes.
+func Person(ctx context.Context, courses CourseLookup, person models.Person) error {
+	var errs Errors
+	name(&errs, "firstName", person.FirstName)
+	name(&errs, "lastName", person.LastName)
+	if person.Type != "professor" && person.Type != "student" {
+		errs.add("type", "must be professor or student")
+	}
+	if person.Age < MinAge || person.Age > MaxAge {
+		errs.add("age", "must be between %d and %d", MinAge, MaxAge)
+	}
+
+	seen := map[int]struct{}{}
+	var positive []int
+	for i, id := range person.Courses {
+		field := fmt.Sprintf("courses.%d", i)
+		if id < 1 {
+			errs.add(field, "must be a positive integer")
+			continue
+		}
+		if _, ok := seen[id]; ok {
+			errs.add(field, "repeats course %d", id)
+			continue
+		}
+		seen[id] = struct{}{}
+		positive = append(positive, id)
+	}
+	if len(positive) > 0 && courses != nil {
+		missing, err := courses.MissingCourseIDs(ctx, positive)
+		if err != nil {
+			return err
+		}
+		missingSet := map[int]struct{}{}
+		for _, id := range missing {
+			missingSet[id] = struct{}{}
+		}
+		for i, id := range person.Courses {
+			if _, ok := missingSet[id]; ok {
+				errs.add(fmt.Sprintf("courses.%d", i), "course %d does not exist", id)
+				delete(missingSet, id)
+			}
+		}
+	}
+	return errs.err()
+}
+
+// Course checks every field of a course
+func Course(course models.Course) error {
+	var errs Errors
+	switch length := utf8.RuneCountInString(course.Name); {
+	case strings.TrimSpace(course.Name) == "":
+		errs.add("name", "is required")
+	case length > MaxNameLength:
+		errs.add("name", "must be at most %d characters", MaxNameLength)
+	}
+	return errs.err()
+}
+
+// name checks a first or last name: present, bounded and made of letters,
+// with spaces, hyphens, apostrophes and periods between them
+func name(errs *Errors, field, value string) {
+	if strings.TrimSpace(value) == "" {
+		errs.add(field, "is required")
+		return
+	}
+	if utf8.RuneCountInString(value) > MaxNameLength {
+		errs.add(field, "must be at most %d characters", MaxNameLength)
+	}
+	for _, r := range value {
+		if !unicode.IsLetter(r) && !strings.ContainsRune(" -'.", r) {
+			errs.add(field, "must only contain letters, spaces, hyphens, apostrophes and periods")
+			return
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package validation
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jacob-tech-challenge/api/models"
+	"github.com/jacob-tech-challenge/api/services"
+)
+
+// knownCourses is a CourseLookup over a fixed set of course ids
+type knownCourses map[int]bool
+
+func (k knownCourses) MissingCourseIDs(ctx context.Context, ids []int) ([]int, error) {
+	missing := []int{}
+	for _, id := range ids {
+		if !k[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing, nil
+}
+
+type failingLookup struct{}
+
+func (failingLookup) MissingCourseIDs(ctx context.Context, ids []int) ([]int, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestPerson(t *testing.T) {
+	valid := models.Person{FirstName: "Mary-Jane", LastName: "O'Neil", Type: "student", Age: 20, Courses: []int{1, 2}}
+
+	tests := map[string]struct {
+		change   func(p *models.Person)
+		expected Errors
+	}{
+		"valid": {
+			change: func(p *models.Person) {},
+		},
+		"unicode names": {
+			change: func(p *models.Person) { p.FirstName, p.LastName = "Zoë", "St. Brontë" },
+		},
+		"missing names": {
+			change: func(p *models.Person) { p.FirstName, p.LastName = "", "  " },
+			expected: Errors{
+				{Field: "firstName", Message: "is required"},
+				{Field: "lastName", Message: "is required"},
+			},
+		},
+		"long name": {
+			change:   func(p *models.Person) { p.LastName = strings.Repeat("a", MaxNameLength+1) },
+			expected: Errors{{Field: "lastName", Message: "must be at most 100 characters"}},
+		},
+		"bad characters": {
+			change:   func(p *models.Person) { p.FirstName = "R2D2" },
+			expected: Errors{{Field: "firstName", Message: "must only contain letters, spaces, hyphens, apostrophes and periods"}},
+		},
+		"type": {
+			change:   func(p *models.Person) { p.Type = "teacher" },
+			expected: Errors{{Field: "type", Message: "must be professor or student"}},
+		},
+		"too young": {
+			change:   func(p *models.Person) { p.Age = 0 },
+			expected: Errors{{Field: "age", Message: "must be between 1 and 150"}},
+		},
+		"too old": {
+			change:   func(p *models.Person) { p.Age = 151 },
+			expected: Errors{{Field: "age", Message: "must be between 1 and 150"}},
+		},
+		"courses": {
+			change: func(p *models.Person) { p.Courses = []int{1, 0, 1, 7} },
+			expected: Errors{
+				{Field: "courses.1", Message: "must be a positive integer"},
+				{Field: "courses.2", Message: "repeats course 1"},
+				{Field: "courses.3", Message: "course 7 does not exist"},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			person := valid
+			tc.change(&person)
+
+			err := Person(context.Background(), knownCourses{1: true, 2: true}, person)
+			if tc.expected == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, services.ErrValidation)
+			var errs Errors
+			assert.ErrorAs(t, err, &errs)
+			assert.Equal(t, tc.expected, errs)
+		})
+	}
+}
+
+func TestPersonWithoutLookup(t *testing.T) {
+	err := Person(context.Background(), nil, models.Person{FirstName: "A", LastName: "B", Type: "student", Age: 1, Courses: []int{7, 7}})
+	var errs Errors
+	assert.ErrorAs(t, err, &errs)
+	assert.Equal(t, Errors{{Field: "courses.1", Message: "repeats course 7"}}, errs)
+}
+
+func TestPersonLookupFails(t *testing.T) {
+	err := Person(context.Background(), failingLookup{}, models.Person{FirstName: "A", LastName: "B", Type: "student", Age: 1, Courses: []int{1}})
+	assert.EqualError(t, err, "connection refused")
+	assert.NotErrorIs(t, err, services.ErrValidation)
+}
+
+func TestCourse(t *testing.T) {
+	assert.NoError(t, Course(models.Course{Name: "Math"}))
+	assert.Equal(t, Errors{{Field: "name", Message: "is required"}}, Course(models.Course{Name: " "}))
+	assert.Equal(t, Errors{{Field: "name", Message: "must be at most 100 characters"}}, Course(models.Course{Name: strings.Repeat("a", 101)}))
+}
@@ -3,17 +3,28 @@ package main
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/jacob-tech-challenge/api"
+	"github.com/jacob-tech-challenge/api/services"
 	"github.com/jacob-tech-challenge/config"
 	"github.com/jacob-tech-challenge/database"
 )
 
 func main() {
 	ctx := context.Background()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(ctx, os.Args[2:]); err != nil {
+			log.Fatalf("Migration failed. err: %v", err)
+		}
+		return
+	}
 	if err := run(ctx); err != nil {
 		log.Fatalf("Startup failed. err: %v", err)
 	}
@@ -25,23 +36,57 @@ func run(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	// connect to database
-	db, err := database.Connect(cfg, sql.Open)
+	// pick the store backing the api
+	var store services.Store
+	switch cfg.Store_Driver {
+	case "memory":
+		log.Println("Using in-memory store, data will not be persisted")
+		store = services.NewMemoryStore()
+	case "postgres":
+		// connect to database
+		db, err := database.Connect(cfg, sql.Open)
 
-	if err != nil {
-		return err
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := db.Close(); err != nil {
+				log.Printf("Failed to close database connection. err: %v", err)
+			}
+		}()
+		if err := migrateOnStartup(ctx, cfg, db); err != nil {
+			return err
+		}
+		store = services.NewPostgresStore(db)
+	default:
+		return fmt.Errorf("unknown store driver %q", cfg.Store_Driver)
 	}
-	defer func() {
-		if err := db.Close(); err != nil {
-			log.Printf("Failed to close database connection. err: %v", err)
+	var cachedStore *services.CachedStore
+	if cfg.Cache_Enabled {
+		cache := services.NewCache(cfg.Cache_TTL, cfg.Cache_MaxEntries)
+		if _, ok := store.(*services.PostgresStore); ok {
+			// other instances may share the database, their writes evict here too
+			listener := database.NewListener(cfg)
+			defer listener.Close()
+			go func() {
+				if err := services.ListenForEvictions(ctx, listener, cache); err != nil {
+					log.Printf("Cache eviction listener stopped, cached reads may be stale until they expire. err: %v", err)
+				}
+			}()
 		}
-	}()
+		cachedStore = services.NewCachedStore(store, cache)
+		store = cachedStore
+	}
+	if cfg.Purge_Interval > 0 {
+		go services.RunPurge(ctx, store, cfg.Purge_Retention, cfg.Purge_Interval)
+	}
 
 	// initialize router
-	r := api.SetupRoutes(db)
+	r := api.SetupRoutes(cfg, store)
 
 	server := &http.Server{
-		Addr:    cfg.HTTP_Domain + cfg.HTTP_Port,
+		// a port written as :8000 is taken too
+		Addr:    net.JoinHostPort(cfg.HTTP_Domain, strings.TrimPrefix(cfg.HTTP_Port, ":")),
 		Handler: r,
 
 		// Good practice to set timeouts to avoid Slowloris attacks.
@@ -50,9 +95,18 @@ func run(ctx context.Context) error {
 		IdleTimeout:  60 * time.Second,
 	}
 
-	log.Printf("Server listening on %s", server.Addr)
+	if cfg.HTTP_AdminAddr != "" && cachedStore != nil {
+		// the counters stay off the api listener, the admin one is meant for internal networks only
+		admin := &http.Server{Addr: cfg.HTTP_AdminAddr, Handler: api.AdminRoutes(cachedStore), ReadTimeout: 15 * time.Second}
+		go func() {
+			log.Printf("Admin server listening on %s", admin.Addr)
+			if err := admin.ListenAndServe(); err != nil {
+				log.Printf("Admin server stopped. err: %v", err)
+			}
+		}()
+	}
 
 	// start api server
-	log.Println("Starting server on port 8080")
+	log.Printf("Starting server on %s", server.Addr)
 	return server.ListenAndServe()
 }
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/jacob-tech-challenge/config"
+	"github.com/jacob-tech-challenge/database"
+	"github.com/jacob-tech-challenge/database/migrations"
+)
+
+const migrateUsage = "usage: migrate up|down|status|redo|seed"
+
+// runMigrate implements the migrate subcommand
+func runMigrate(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New(migrateUsage)
+	}
+
+	cfg, err := config.New()
+	if err != nil {
+		return err
+	}
+	db, err := database.Connect(cfg, sql.Open)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			log.Printf("Applied migration %d_%s", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			log.Println("Database is up to date")
+		}
+	case "down":
+		reverted, err := migrator.Down(ctx)
+		if err != nil {
+			return err
+		}
+		log.Printf("Reverted migration %d_%s", reverted.Version, reverted.Name)
+	case "redo":
+		redone, err := migrator.Redo(ctx)
+		if err != nil {
+			return err
+		}
+		log.Printf("Redid migration %d_%s", redone.Version, redone.Name)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.Applied {
+				appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05 MST")
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		return w.Flush()
+	case "seed":
+		if err := migrations.Seed(ctx, db); err != nil {
+			return err
+		}
+		log.Println("Seeded database")
+	default:
+		return fmt.Errorf("unknown migrate command %q, %s", args[0], migrateUsage)
+	}
+	return nil
+}
+
+// migrateOnStartup applies pending migrations and seeds the database when the config asks for it
+func migrateOnStartup(ctx context.Context, cfg config.Config, db *sql.DB) error {
+	if cfg.DB_AutoMigrate {
+		migrator, err := migrations.New(db)
+		if err != nil {
+			return err
+		}
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			log.Printf("Applied migration %d_%s", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.DB_Seed {
+		if err := migrations.Seed(ctx, db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
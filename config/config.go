@@ -2,32 +2,79 @@ package config
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/sethvargo/go-envconfig"
 
 	"github.com/joho/godotenv"
 )
 
-// Config holds the configuration for the database and the server
+// Config holds the configuration for the database and the server. The database
+// settings are only required when the postgres store driver is used.
 
 type Config struct {
 	// Host is the database host
-	DB_Host string `env:"DATABASE_HOST,required"`
+	DB_Host string `env:"DATABASE_HOST"`
 	// Port is the database port
-	DB_Port int `env:"DATABASE_PORT,required"`
+	DB_Port int `env:"DATABASE_PORT"`
 	// User is the database user
-	DB_User string `env:"DATABASE_USER,required"`
+	DB_User string `env:"DATABASE_USER"`
 	// Password is the database password
-	DB_Password string `env:"DATABASE_PASSWORD,required"`
+	DB_Password string `env:"DATABASE_PASSWORD"`
 	// Name is the database name
-	DB_Name string `env:"DATABASE_NAME,required"`
+	DB_Name string `env:"DATABASE_NAME"`
 	// RetryDuration is the duration to wait before retrying to connect to the database
 	DB_RetryDuration string `env:"DATABASE_RETRY_DURATION,default=3s"`
+	// QueryTimeout bounds the database work of a single request, 0 disables it
+	DB_QueryTimeout time.Duration `env:"DATABASE_QUERY_TIMEOUT,default=5s"`
+	// AutoMigrate applies pending schema migrations at startup
+	DB_AutoMigrate bool `env:"DATABASE_AUTO_MIGRATE,default=false"`
+	// Seed loads the sample data at startup, after any migrations
+	DB_Seed bool `env:"DATABASE_SEED,default=false"`
+	// ListenerMinReconnect is the first wait before reconnecting a dropped LISTEN connection
+	DB_ListenerMinReconnect time.Duration `env:"DATABASE_LISTENER_MIN_RECONNECT,default=1s"`
+	// ListenerMaxReconnect caps the wait between reconnect attempts, which doubles after each failure
+	DB_ListenerMaxReconnect time.Duration `env:"DATABASE_LISTENER_MAX_RECONNECT,default=1m"`
 
 	// Domain is the server domain
 	HTTP_Domain string `env:"HTTP_DOMAIN,default=localhost"`
 	// Port is the server port
 	HTTP_Port string `env:"HTTP_PORT,default=8000"`
+	// DefaultPageSize is the page size of list endpoints when the client does not send a limit
+	HTTP_DefaultPageSize int `env:"HTTP_DEFAULT_PAGE_SIZE,default=50"`
+	// MaxPageSize caps the limit a client can ask for on list endpoints
+	HTTP_MaxPageSize int `env:"HTTP_MAX_PAGE_SIZE,default=200"`
+	// MaxBodyBytes caps the size of request bodies, larger bodies are answered with 413
+	HTTP_MaxBodyBytes int64 `env:"HTTP_MAX_BODY_BYTES,default=1048576"`
+	// CacheControl maps route patterns to the Cache-Control of their GET responses,
+	// written as /api/course:max-age=60, must-revalidate;/api/person/{id}:no-cache
+	HTTP_CacheControl map[string]string `env:"HTTP_CACHE_CONTROL,delimiter=;"`
+	// DefaultCacheControl is the Cache-Control of GET responses on routes missing from CacheControl
+	HTTP_DefaultCacheControl string `env:"HTTP_DEFAULT_CACHE_CONTROL,default=no-cache"`
+	// ActorHeader is the request header naming who a write is made for in the audit log, set by the proxy
+	HTTP_ActorHeader string `env:"HTTP_ACTOR_HEADER,default=X-Actor"`
+	// IdempotencyTTL is how long the response to a POST sent with an Idempotency-Key is replayed to retries
+	HTTP_IdempotencyTTL time.Duration `env:"HTTP_IDEMPOTENCY_TTL,default=24h"`
+	// AdminAddr is the address of the internal listener serving the cache counters, empty disables it
+	HTTP_AdminAddr string `env:"HTTP_ADMIN_ADDR"`
+	// IdempotencyLease is how long a claimed Idempotency-Key waits for its response before a retry may run the request again
+	HTTP_IdempotencyLease time.Duration `env:"HTTP_IDEMPOTENCY_LEASE,default=1m"`
+
+	// Driver selects the store backing the API, either postgres or memory
+	Store_Driver string `env:"STORE_DRIVER,default=postgres"`
+
+	// Enabled puts an in-process cache in front of course reads and person lookups
+	Cache_Enabled bool `env:"CACHE_ENABLED,default=false"`
+	// TTL is how long a cached read is served before the store is asked again
+	Cache_TTL time.Duration `env:"CACHE_TTL,default=30s"`
+	// MaxEntries caps the number of cached reads, the least recently used go first
+	Cache_MaxEntries int `env:"CACHE_MAX_ENTRIES,default=10000"`
+
+	// Retention is how long soft deleted people and courses can be restored before they are purged
+	Purge_Retention time.Duration `env:"PURGE_RETENTION,default=720h"`
+	// Interval is how often the purge runs, 0 disables it
+	Purge_Interval time.Duration `env:"PURGE_INTERVAL,default=1h"`
 }
 
 
@@ -37,5 +84,28 @@ func New() (Config, error){
 	if err := envconfig.Process(context.Background(), &c); err != nil {
 		return Config{}, err
 	}
+	if err := c.checkDatabase(); err != nil {
+		return Config{}, err
+	}
 	return c, nil
+}
+
+// checkDatabase checks the database settings are all set when the store is postgres
+func (c Config) checkDatabase() error {
+	if c.Store_Driver != "postgres" {
+		return nil
+	}
+	missing := map[string]bool{
+		"DATABASE_HOST":     c.DB_Host == "",
+		"DATABASE_PORT":     c.DB_Port == 0,
+		"DATABASE_USER":     c.DB_User == "",
+		"DATABASE_PASSWORD": c.DB_Password == "",
+		"DATABASE_NAME":     c.DB_Name == "",
+	}
+	for _, key := range []string{"DATABASE_HOST", "DATABASE_PORT", "DATABASE_USER", "DATABASE_PASSWORD", "DATABASE_NAME"} {
+		if missing[key] {
+			return fmt.Errorf("%s is required with the postgres store driver", key)
+		}
+	}
+	return nil
 }
\ No newline at end of file
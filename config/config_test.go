@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -28,8 +29,62 @@ func TestNewConfig(t *testing.T){
 				DB_Password: "password",
 				DB_Name: "name",
 				DB_RetryDuration: "3s",
+				DB_QueryTimeout: 5 * time.Second,
+				DB_ListenerMinReconnect: time.Second,
+				DB_ListenerMaxReconnect: time.Minute,
 				HTTP_Domain: "localhost",
 				HTTP_Port: "8000",
+				HTTP_DefaultPageSize: 50,
+				HTTP_MaxPageSize: 200,
+				HTTP_MaxBodyBytes: 1 << 20,
+				HTTP_DefaultCacheControl: "no-cache",
+				HTTP_ActorHeader: "X-Actor",
+				HTTP_IdempotencyTTL: 24 * time.Hour,
+				HTTP_IdempotencyLease: time.Minute,
+				Store_Driver: "postgres",
+				Cache_TTL: 30 * time.Second,
+				Cache_MaxEntries: 10000,
+				Purge_Retention: 720 * time.Hour,
+				Purge_Interval: time.Hour,
+			},
+		},
+		"cache control per route": {
+			envVars: map[string]string{
+				"DATABASE_HOST": "localhost",
+				"DATABASE_PORT": "5432",
+				"DATABASE_USER": "user",
+				"DATABASE_PASSWORD": "password",
+				"DATABASE_NAME": "name",
+				"HTTP_CACHE_CONTROL": "/api/course:max-age=60, must-revalidate;/api/course/{id}:no-store",
+			},
+			expected: Config{
+				DB_Host: "localhost",
+				DB_Port: 5432,
+				DB_User: "user",
+				DB_Password: "password",
+				DB_Name: "name",
+				DB_RetryDuration: "3s",
+				DB_QueryTimeout: 5 * time.Second,
+				DB_ListenerMinReconnect: time.Second,
+				DB_ListenerMaxReconnect: time.Minute,
+				HTTP_Domain: "localhost",
+				HTTP_Port: "8000",
+				HTTP_DefaultPageSize: 50,
+				HTTP_MaxPageSize: 200,
+				HTTP_MaxBodyBytes: 1 << 20,
+				HTTP_CacheControl: map[string]string{
+					"/api/course": "max-age=60, must-revalidate",
+					"/api/course/{id}": "no-store",
+				},
+				HTTP_DefaultCacheControl: "no-cache",
+				HTTP_ActorHeader: "X-Actor",
+				HTTP_IdempotencyTTL: 24 * time.Hour,
+				HTTP_IdempotencyLease: time.Minute,
+				Store_Driver: "postgres",
+				Cache_TTL: 30 * time.Second,
+				Cache_MaxEntries: 10000,
+				Purge_Retention: 720 * time.Hour,
+				Purge_Interval: time.Hour,
 			},
 		},
 		"missing env var": {
@@ -39,7 +94,32 @@ func TestNewConfig(t *testing.T){
 				"DATABASE_USER": "user",
 				"DATABASE_PASSWORD": "password",
 			},
-			expectedErr: "required key DATABASE_NAME missing value",
+			expectedErr: "DATABASE_NAME is required with the postgres store driver",
+		},
+		"memory store without a database": {
+			envVars: map[string]string{
+				"STORE_DRIVER": "memory",
+			},
+			expected: Config{
+				DB_RetryDuration: "3s",
+				DB_QueryTimeout: 5 * time.Second,
+				DB_ListenerMinReconnect: time.Second,
+				DB_ListenerMaxReconnect: time.Minute,
+				HTTP_Domain: "localhost",
+				HTTP_Port: "8000",
+				HTTP_DefaultPageSize: 50,
+				HTTP_MaxPageSize: 200,
+				HTTP_MaxBodyBytes: 1 << 20,
+				HTTP_DefaultCacheControl: "no-cache",
+				HTTP_ActorHeader: "X-Actor",
+				HTTP_IdempotencyTTL: 24 * time.Hour,
+				HTTP_IdempotencyLease: time.Minute,
+				Store_Driver: "memory",
+				Cache_TTL: 30 * time.Second,
+				Cache_MaxEntries: 10000,
+				Purge_Retention: 720 * time.Hour,
+				Purge_Interval: time.Hour,
+			},
 		},
 		"invalid port": {
 			envVars: map[string]string{
@@ -3,8 +3,9 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"log"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 
 	"github.com/jacob-tech-challenge/config"
 )
@@ -12,14 +13,16 @@ import (
 // OpenDBFunc is a function type that matches the signature of sql.Open
 type OpenDBFunc func(driverName, dataSourceName string) (*sql.DB, error)
 
-// Connect connects to the database using the provided OpenDBFunc
-func Connect(cfg config.Config, openDB OpenDBFunc) (*sql.DB, error) {
-	// create the data source name
-	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+// dsn returns the data source name of the configured database
+func dsn(cfg config.Config) string {
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
 		cfg.DB_Host, cfg.DB_Port, cfg.DB_User, cfg.DB_Password, cfg.DB_Name)
+}
 
+// Connect connects to the database using the provided OpenDBFunc
+func Connect(cfg config.Config, openDB OpenDBFunc) (*sql.DB, error) {
 	// connect to the database using the provided OpenDBFunc
-	db, err := openDB("postgres", dsn)
+	db, err := openDB("postgres", dsn(cfg))
 	if err != nil {
 		return nil, err
 	}
@@ -34,4 +37,25 @@ func Connect(cfg config.Config, openDB OpenDBFunc) (*sql.DB, error) {
 	}
 
 	return db, nil
-}
\ No newline at end of file
+}
+
+// NewListener returns a LISTEN connection to the configured database. It
+// connects in the background and, whenever the connection drops, reconnects
+// waiting between cfg.DB_ListenerMinReconnect and cfg.DB_ListenerMaxReconnect,
+// doubling the wait after each failed attempt. A nil notification is sent on
+// its channel after every reconnect, since notifications may have been missed.
+func NewListener(cfg config.Config) *pq.Listener {
+	return pq.NewListener(dsn(cfg), cfg.DB_ListenerMinReconnect, cfg.DB_ListenerMaxReconnect, logListenerEvent)
+}
+
+// logListenerEvent logs the connection changes of a listener
+func logListenerEvent(event pq.ListenerEventType, err error) {
+	switch event {
+	case pq.ListenerEventDisconnected:
+		log.Printf("Database listener disconnected. err: %v", err)
+	case pq.ListenerEventReconnected:
+		log.Println("Database listener reconnected")
+	case pq.ListenerEventConnectionAttemptFailed:
+		log.Printf("Database listener failed to reconnect. err: %v", err)
+	}
+}
@@ -0,0 +1,323 @@
+// Package migrations manages the database schema. Migrations are embedded SQL
+// files named NNNN_description.up.sql / NNNN_description.down.sql, applied in
+// version order and recorded, with a checksum of their contents, in the
+// schema_migrations table.
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed sql/*.sql
+var migrationFiles embed.FS
+
+//go:embed seed.sql
+var seedSQL string
+
+// lockKey is the postgres advisory lock held while migrating, so several
+// instances starting at once do not apply the same migration twice
+const lockKey = 7244101
+
+// ErrChecksumMismatch is returned when an applied migration was edited after the fact
+var ErrChecksumMismatch = errors.New("migration checksum mismatch")
+
+// ErrNoMigrations is returned by Down and Redo when nothing has been applied
+var ErrNoMigrations = errors.New("no migrations applied")
+
+// Migration is a single versioned schema change
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// Status describes a known migration and whether it has been applied
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator applies migrations to a database
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// New returns a Migrator for the migrations embedded in this package
+func New(db *sql.DB) (*Migrator, error) {
+	migrations, err := Load(migrationFiles)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Load reads the migrations in the sql directory of fsys and returns them ordered by version.
+// Every version needs both an up and a down file.
+func Load(fsys fs.FS) ([]Migration, error) {
+	paths, err := fs.Glob(fsys, "sql/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, p := range paths {
+		match := fileName.FindStringSubmatch(path.Base(p))
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", p)
+		}
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %q: %w", p, err)
+		}
+		contents, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %q and %q", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(contents)
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		sum := sha256.Sum256([]byte(m.Up + "\x00" + m.Down))
+		m.Checksum = hex.EncodeToString(sum[:])
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Up applies every pending migration and returns the ones it applied
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+	err := m.locked(ctx, func(conn *sql.Conn, records map[int]record) error {
+		for _, migration := range m.migrations {
+			if _, ok := records[migration.Version]; ok {
+				continue
+			}
+			if err := apply(ctx, conn, migration); err != nil {
+				return err
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the most recently applied migration and returns it
+func (m *Migrator) Down(ctx context.Context) (Migration, error) {
+	var reverted Migration
+	err := m.locked(ctx, func(conn *sql.Conn, records map[int]record) error {
+		latest, ok := m.latest(records)
+		if !ok {
+			return ErrNoMigrations
+		}
+		reverted = latest
+		return revert(ctx, conn, latest)
+	})
+	return reverted, err
+}
+
+// Redo reverts the most recently applied migration and applies it again
+func (m *Migrator) Redo(ctx context.Context) (Migration, error) {
+	var redone Migration
+	err := m.locked(ctx, func(conn *sql.Conn, records map[int]record) error {
+		latest, ok := m.latest(records)
+		if !ok {
+			return ErrNoMigrations
+		}
+		redone = latest
+		if err := revert(ctx, conn, latest); err != nil {
+			return err
+		}
+		return apply(ctx, conn, latest)
+	})
+	return redone, err
+}
+
+// Status returns every known migration and whether it has been applied
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.locked(ctx, func(conn *sql.Conn, records map[int]record) error {
+		for _, migration := range m.migrations {
+			rec, ok := records[migration.Version]
+			statuses = append(statuses, Status{Migration: migration, Applied: ok, AppliedAt: rec.appliedAt})
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// Seed loads the sample data into an already migrated database
+func Seed(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, seedSQL); err != nil {
+		return fmt.Errorf("seeding database: %w", err)
+	}
+	return tx.Commit()
+}
+
+// record is a row of schema_migrations
+type record struct {
+	checksum  string
+	appliedAt time.Time
+}
+
+// locked runs fn on a single connection holding the migration lock. Before fn
+// is called the schema_migrations table is created if needed and the applied
+// migrations are checked against the known ones.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn, records map[int]record) error) (err error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+		return fmt.Errorf("acquiring migration lock: %w", err)
+	}
+	defer func() {
+		// use a fresh context, the lock has to be released even if ctx is done
+		if _, unlockErr := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey); unlockErr != nil && err == nil {
+			err = fmt.Errorf("releasing migration lock: %w", unlockErr)
+		}
+	}()
+
+	if _, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations
+		(
+			version    INTEGER PRIMARY KEY,
+			name       TEXT        NOT NULL,
+			checksum   TEXT        NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`); err != nil {
+		return fmt.Errorf("creating schema_migrations: %w", err)
+	}
+
+	records, err := m.records(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return fn(conn, records)
+}
+
+// records loads schema_migrations and verifies it against the known migrations
+func (m *Migrator) records(ctx context.Context, conn *sql.Conn) (map[int]record, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, checksum, applied_at FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	known := map[int]Migration{}
+	for _, migration := range m.migrations {
+		known[migration.Version] = migration
+	}
+
+	records := map[int]record{}
+	for rows.Next() {
+		var version int
+		var rec record
+		if err := rows.Scan(&version, &rec.checksum, &rec.appliedAt); err != nil {
+			return nil, err
+		}
+		migration, ok := known[version]
+		if !ok {
+			return nil, fmt.Errorf("database has migration %d applied, which this build does not know about", version)
+		}
+		if migration.Checksum != rec.checksum {
+			return nil, fmt.Errorf("%w: %d_%s was changed after it was applied", ErrChecksumMismatch, version, migration.Name)
+		}
+		records[version] = rec
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// latest returns the applied migration with the highest version
+func (m *Migrator) latest(records map[int]record) (Migration, bool) {
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		if _, ok := records[m.migrations[i].Version]; ok {
+			return m.migrations[i], true
+		}
+	}
+	return Migration{}, false
+}
+
+// apply runs the up script of a migration and records it, in one transaction
+func apply(ctx context.Context, conn *sql.Conn, migration Migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+		return fmt.Errorf("applying migration %d_%s: %w", migration.Version, migration.Name, err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`,
+		migration.Version, migration.Name, migration.Checksum,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// revert runs the down script of a migration and removes its record, in one transaction
+func revert(ctx context.Context, conn *sql.Conn, migration Migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+		return fmt.Errorf("reverting migration %d_%s: %w", migration.Version, migration.Name, err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, migration.Version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
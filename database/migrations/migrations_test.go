@@ -0,0 +1,214 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	tests := map[string]struct {
+		files       fstest.MapFS
+		expected    []int
+		expectedErr string
+	}{
+		"ordered by version": {
+			files: fstest.MapFS{
+				"sql/0002_second.up.sql":   {Data: []byte("CREATE TABLE b ();")},
+				"sql/0002_second.down.sql": {Data: []byte("DROP TABLE b;")},
+				"sql/0001_first.up.sql":    {Data: []byte("CREATE TABLE a ();")},
+				"sql/0001_first.down.sql":  {Data: []byte("DROP TABLE a;")},
+			},
+			expected: []int{1, 2},
+		},
+		"missing down": {
+			files: fstest.MapFS{
+				"sql/0001_first.up.sql": {Data: []byte("CREATE TABLE a ();")},
+			},
+			expectedErr: "migration 1_first needs both an up and a down file",
+		},
+		"bad name": {
+			files: fstest.MapFS{
+				"sql/first.up.sql": {Data: []byte("CREATE TABLE a ();")},
+			},
+			expectedErr: `invalid migration file name "sql/first.up.sql"`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			migrations, err := Load(tc.files)
+			if tc.expectedErr != "" {
+				assert.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+
+			var versions []int
+			for _, m := range migrations {
+				versions = append(versions, m.Version)
+				assert.Len(t, m.Checksum, 64)
+			}
+			assert.Equal(t, tc.expected, versions)
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := Load(migrationFiles)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, migrations)
+
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version, "migration versions should have no gaps")
+	}
+}
+
+// expectLocked sets up the expectations shared by every Migrator call
+func expectLocked(mock sqlmock.Sqlmock, applied *sqlmock.Rows) {
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_lock($1)`)).WithArgs(lockKey).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT version, checksum, applied_at FROM schema_migrations`).WillReturnRows(applied)
+}
+
+func expectUnlock(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_unlock($1)`)).WithArgs(lockKey).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func testMigrations() []Migration {
+	return []Migration{
+		{Version: 1, Name: "first", Up: "CREATE TABLE a ()", Down: "DROP TABLE a", Checksum: "sum1"},
+		{Version: 2, Name: "second", Up: "CREATE TABLE b ()", Down: "DROP TABLE b", Checksum: "sum2"},
+	}
+}
+
+func TestMigratorUp(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	migrator := &Migrator{db: db, migrations: testMigrations()}
+
+	// migration 1 is already applied so only 2 should run
+	expectLocked(mock, sqlmock.NewRows([]string{"version", "checksum", "applied_at"}).AddRow(1, "sum1", time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE b ()")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO schema_migrations`).WithArgs(2, "second", "sum2").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	expectUnlock(mock)
+
+	applied, err := migrator.Up(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, applied, 1)
+	assert.Equal(t, 2, applied[0].Version)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigratorUpFailureRollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	migrator := &Migrator{db: db, migrations: testMigrations()}
+
+	expectLocked(mock, sqlmock.NewRows([]string{"version", "checksum", "applied_at"}))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE a ()")).WillReturnError(errors.New("syntax error"))
+	mock.ExpectRollback()
+	expectUnlock(mock)
+
+	applied, err := migrator.Up(context.Background())
+	assert.EqualError(t, err, "applying migration 1_first: syntax error")
+	assert.Empty(t, applied)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigratorChecksumMismatch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	migrator := &Migrator{db: db, migrations: testMigrations()}
+
+	expectLocked(mock, sqlmock.NewRows([]string{"version", "checksum", "applied_at"}).AddRow(1, "edited", time.Now()))
+	expectUnlock(mock)
+
+	_, err = migrator.Up(context.Background())
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigratorDown(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	migrator := &Migrator{db: db, migrations: testMigrations()}
+
+	expectLocked(mock, sqlmock.NewRows([]string{"version", "checksum", "applied_at"}).
+		AddRow(1, "sum1", time.Now()).
+		AddRow(2, "sum2", time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DROP TABLE b")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM schema_migrations WHERE version = $1`)).WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectUnlock(mock)
+
+	reverted, err := migrator.Down(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, reverted.Version)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigratorDownNothingApplied(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	migrator := &Migrator{db: db, migrations: testMigrations()}
+
+	expectLocked(mock, sqlmock.NewRows([]string{"version", "checksum", "applied_at"}))
+	expectUnlock(mock)
+
+	_, err = migrator.Down(context.Background())
+	assert.ErrorIs(t, err, ErrNoMigrations)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigratorStatus(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	migrator := &Migrator{db: db, migrations: testMigrations()}
+	appliedAt := time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	expectLocked(mock, sqlmock.NewRows([]string{"version", "checksum", "applied_at"}).AddRow(1, "sum1", appliedAt))
+	expectUnlock(mock)
+
+	statuses, err := migrator.Status(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, statuses, 2)
+	assert.True(t, statuses[0].Applied)
+	assert.Equal(t, appliedAt, statuses[0].AppliedAt)
+	assert.False(t, statuses[1].Applied)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,49 @@
+-- Sample data for local development. Rows are inserted with fixed ids and
+-- skipped when they already exist, so seeding more than once is harmless.
+
+INSERT INTO person (id, first_name, last_name, type, age)
+VALUES (1, 'Steve', 'Jobs', 'professor', 56),
+       (2, 'Jeff', 'Bezos', 'professor', 60),
+       (3, 'Larry', 'Page', 'student', 51),
+       (4, 'Bill', 'Gates', 'student', 67),
+       (5, 'Elon', 'Musk', 'student', 52)
+ON CONFLICT (id) DO NOTHING;
+
+INSERT INTO course (id, name)
+VALUES (1, 'Programming'),
+       (2, 'Databases'),
+       (3, 'UI Design')
+ON CONFLICT (id) DO NOTHING;
+
+INSERT INTO person_course (person_id, course_id)
+VALUES (1, 1),
+       (1, 2),
+       (1, 3),
+       (2, 1),
+       (2, 2),
+       (2, 3),
+       (3, 1),
+       (3, 2),
+       (3, 3),
+       (4, 1),
+       (4, 2),
+       (4, 3),
+       (5, 1),
+       (5, 2),
+       (5, 3)
+ON CONFLICT (person_id, course_id) DO NOTHING;
+
+-- the explicit ids above bypass the sequences, move them past the seeded rows
+SELECT setval(pg_get_serial_sequence('person', 'id'), GREATEST((SELECT MAX(id) FROM person), 1));
+SELECT setval(pg_get_serial_sequence('course', 'id'), GREATEST((SELECT MAX(id) FROM course), 1));
+
+-- seeded people get their first revision like people created through the API
+INSERT INTO person_history (person_id, first_name, last_name, type, age, courses, version, valid_from, deleted_at)
+SELECT p.id, p.first_name, p.last_name, p.type, p.age,
+       COALESCE(array_agg(c.id ORDER BY c.id) FILTER (WHERE c.id IS NOT NULL), '{}'),
+       p.version, p.updated_at, p.deleted_at
+FROM person p
+LEFT JOIN person_course pc ON pc.person_id = p.id
+LEFT JOIN course c ON c.id = pc.course_id AND c.deleted_at IS NULL
+WHERE NOT EXISTS (SELECT 1 FROM person_history h WHERE h.person_id = p.id)
+GROUP BY p.id;
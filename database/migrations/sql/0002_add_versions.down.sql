@@ -0,0 +1,7 @@
+ALTER TABLE course
+    DROP COLUMN IF EXISTS updated_at,
+    DROP COLUMN IF EXISTS version;
+
+ALTER TABLE person
+    DROP COLUMN IF EXISTS updated_at,
+    DROP COLUMN IF EXISTS version;
@@ -0,0 +1,8 @@
+-- every write bumps version, it is what ETags and If-Match compare
+ALTER TABLE person
+    ADD COLUMN version    INTEGER     NOT NULL DEFAULT 1,
+    ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+ALTER TABLE course
+    ADD COLUMN version    INTEGER     NOT NULL DEFAULT 1,
+    ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
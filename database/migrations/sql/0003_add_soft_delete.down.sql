@@ -0,0 +1,15 @@
+-- without deleted_at a deleted row would come back, so deleted rows go for good
+DELETE FROM person_course
+WHERE person_id IN (SELECT id FROM person WHERE deleted_at IS NOT NULL)
+   OR course_id IN (SELECT id FROM course WHERE deleted_at IS NOT NULL);
+DELETE FROM person WHERE deleted_at IS NOT NULL;
+DELETE FROM course WHERE deleted_at IS NOT NULL;
+
+DROP INDEX IF EXISTS course_deleted_at_idx;
+DROP INDEX IF EXISTS person_deleted_at_idx;
+
+ALTER TABLE course
+    DROP COLUMN IF EXISTS deleted_at;
+
+ALTER TABLE person
+    DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,11 @@
+-- deleting a person or course sets deleted_at, the row and its enrollments
+-- stay until the purge job removes them after the retention window
+ALTER TABLE person
+    ADD COLUMN deleted_at TIMESTAMPTZ;
+
+ALTER TABLE course
+    ADD COLUMN deleted_at TIMESTAMPTZ;
+
+-- the purge job looks rows up by deletion time
+CREATE INDEX person_deleted_at_idx ON person (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX course_deleted_at_idx ON course (deleted_at) WHERE deleted_at IS NOT NULL;
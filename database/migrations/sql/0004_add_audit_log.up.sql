@@ -0,0 +1,17 @@
+-- every write through the services layer appends a row in its own transaction,
+-- before and after hold the entity as the API shows it, NULL when it did not exist
+CREATE TABLE audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    actor TEXT NOT NULL DEFAULT '',
+    request_id TEXT NOT NULL DEFAULT '',
+    entity TEXT NOT NULL CHECK (entity IN ('person', 'course')),
+    entity_id INT NOT NULL,
+    action TEXT NOT NULL,
+    before JSONB,
+    after JSONB
+);
+
+-- the audit endpoint filters by entity and time and pages by id
+CREATE INDEX audit_log_entity_idx ON audit_log (entity, entity_id, id);
+CREATE INDEX audit_log_at_idx ON audit_log (at);
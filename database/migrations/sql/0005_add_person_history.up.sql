@@ -0,0 +1,30 @@
+-- every write to a person through the services layer closes their open revision
+-- and opens one with the new state, in the same transaction. A revision was the
+-- person's state from valid_from until valid_to, NULL for the current one. There
+-- is no foreign key, the history outlives the purge of the person.
+CREATE TABLE person_history (
+    person_id  INT         NOT NULL,
+    first_name TEXT        NOT NULL,
+    last_name  TEXT        NOT NULL,
+    type       TEXT        NOT NULL,
+    age        INT         NOT NULL,
+    courses    INT[]       NOT NULL,
+    version    INTEGER     NOT NULL,
+    valid_from TIMESTAMPTZ NOT NULL,
+    deleted_at TIMESTAMPTZ,
+    valid_to   TIMESTAMPTZ,
+    PRIMARY KEY (person_id, version)
+);
+
+-- as of reads look up the one revision open at a time
+CREATE INDEX person_history_valid_idx ON person_history (person_id, valid_from);
+
+-- people as they are now are the first revision anyone can ask for
+INSERT INTO person_history (person_id, first_name, last_name, type, age, courses, version, valid_from, deleted_at)
+SELECT p.id, p.first_name, p.last_name, p.type, p.age,
+       COALESCE(array_agg(c.id ORDER BY c.id) FILTER (WHERE c.id IS NOT NULL), '{}'),
+       p.version, p.updated_at, p.deleted_at
+FROM person p
+LEFT JOIN person_course pc ON pc.person_id = p.id
+LEFT JOIN course c ON c.id = pc.course_id AND c.deleted_at IS NULL
+GROUP BY p.id;
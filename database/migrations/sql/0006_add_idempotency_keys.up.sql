@@ -0,0 +1,21 @@
+-- a POST sent with an Idempotency-Key claims the key with a fingerprint of the
+-- request, status, header and body are NULL until its response is stored. Keys
+-- belong to the actor that sent them, so clients cannot replay each other's
+-- responses. A claim without a response may be taken over once claimed_at is
+-- older than the lease, its request is then taken to have died. Keys are
+-- forgotten once expires_at has passed.
+CREATE TABLE idempotency_key (
+    actor       TEXT        NOT NULL,
+    key         TEXT        NOT NULL,
+    fingerprint TEXT        NOT NULL,
+    status      INT,
+    header      JSONB,
+    body        BYTEA,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    claimed_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at  TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (actor, key)
+);
+
+-- every claim first deletes the expired keys
+CREATE INDEX idempotency_key_expires_idx ON idempotency_key (expires_at);
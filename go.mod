@@ -9,6 +9,7 @@ require (
 	github.com/lib/pq v1.10.9
 	github.com/sethvargo/go-envconfig v1.1.0
 	github.com/stretchr/testify v1.9.0
+	golang.org/x/sync v0.12.0
 )
 
 require github.com/stretchr/objx v0.5.2 // indirect